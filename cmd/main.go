@@ -33,22 +33,58 @@ func main() {
 	}
 
 	// Initialize services
+	presetService := services.NewPresetService(db)
+	if err := presetService.EnsureDefaultPreset(); err != nil {
+		log.Fatalf("Failed to create default encoding preset: %v", err)
+	}
 	videoService := services.NewVideoService(db)
+	videoService.SetRedisClient(redisClient) // Read live transcode progress
+	keyService := services.NewKeyService(db, cfg)
 	transcodeService := services.NewTranscodeService(db, cfg)
 	transcodeService.SetRedisClient(redisClient) // Set Redis client for transcoding
+	transcodeService.SetKeyService(keyService)   // Encrypt segments of encrypted videos
+	subtitleService := services.NewSubtitleService(db, cfg)
+	subtitleService.SetTranscodeService(transcodeService) // Rewrite master playlists when subtitles change
+	transcodeService.SetSubtitleService(subtitleService)  // Convert subtitles once renditions complete
+	audioService := services.NewAudioService(db, cfg, transcodeService)
+	transcodeService.SetAudioService(audioService) // Encode alternate audio tracks once renditions complete
 	playlistService := services.NewPlaylistService(db, cfg)
 	uploadService := services.NewUploadService(db, cfg)
 	uploadService.SetTranscodeService(transcodeService) // Set transcode service for upload service
+	uploadService.SetPresetService(presetService)       // Create profiles from encoding presets
+	uploadService.SetKeyService(keyService)             // Reject encryption without a master key
+	playoutService := services.NewPlayoutService(db, cfg)
+	playoutService.SetRedisClient(redisClient) // Set Redis client for channel playout state
+	asRunService := services.NewAsRunService(db)
+	playoutService.SetAsRunService(asRunService) // Record what airs on each channel
+	channelService := services.NewChannelService(db, cfg)
+	epgService := services.NewEPGService(db, playoutService)
+	adService := services.NewAdService(db, cfg, uploadService, playoutService)
+	playoutService.SetAdService(adService) // Stitch decided ads into ad breaks
+	slateService := services.NewSlateService(db, cfg, uploadService)
+	outputService := services.NewOutputService(db, cfg, playoutService)
+	outputService.SetRedisClient(redisClient) // Share output targets between pods
+	outputService.SetKeyService(keyService)   // Decrypt encrypted segments
 
 	// Initialize handlers
-	handlers := handlers.NewHandlers(videoService, transcodeService, playlistService, uploadService)
+	handlers := handlers.NewHandlers(videoService, transcodeService, playlistService, uploadService, playoutService, channelService, epgService, asRunService, adService, slateService, outputService, presetService, keyService, subtitleService, audioService)
 
 	// Start transcode workers
 	workerManager := worker.NewWorkerManager(transcodeService, redisClient, cfg)
 	go workerManager.Start()
 
+	// Start linear channel playout engine
+	go playoutService.Start()
+	go outputService.Start()
+
+	// Start server-side ad insertion
+	go adService.Start()
+
 	// Initialize FTP Watcher
 	ftpWatcher := services.NewFTPWatcher(cfg.Storage.UploadPath, uploadService, transcodeService, db)
+	ftpWatcher.SetPresetService(presetService)     // Create profiles from encoding presets
+	ftpWatcher.SetSubtitleService(subtitleService) // Attach sidecar subtitles
+	ftpWatcher.SetKeyService(keyService)           // Reject encryption without a master key
 	go func() {
 		if err := ftpWatcher.StartWatching(); err != nil {
 			log.Printf("FTP Watcher error: %v", err)
@@ -67,6 +103,9 @@ func main() {
 		log.Println("Shutting down server...")
 		ftpWatcher.Stop()
 		workerManager.Stop()
+		outputService.Stop()
+		playoutService.Stop()
+		adService.Stop()
 		os.Exit(0)
 	}()
 
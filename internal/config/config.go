@@ -15,6 +15,11 @@ type Config struct {
 	Transcode  TranscodeConfig
 	Logging    LoggingConfig
 	Kubernetes KubernetesConfig
+	AdDecision AdDecisionConfig
+	Output     OutputConfig
+	Encryption EncryptionConfig
+	Thumbnails ThumbnailConfig
+	Loudness   LoudnessConfig
 }
 
 type ServerConfig struct {
@@ -49,12 +54,59 @@ type StorageConfig struct {
 type FFmpegConfig struct {
 	FFmpegPath  string
 	FFprobePath string
+	AV1Encoder  string // libsvtav1 or libaom-av1, whichever the FFmpeg build provides
 }
 
 type TranscodeConfig struct {
-	Workers     int
-	HLSWindow   int
-	SegmentTime int
+	Workers           int
+	HLSWindow         int
+	SegmentTime       int
+	DVRWindow         int     // in seconds, how long aired channel segments stay available for catch-up
+	TimeoutMultiplier float64 // an encode may run this many times the source duration; 0 disables the limit
+	MinTimeout        int     // in seconds, the time limit of encodes of short or unprobed sources
+	StallTimeout      int     // in seconds without output progress before an encode is killed; 0 disables
+	RetryBaseDelay    int     // in seconds, the delay before the first retry of a failed job, doubled for each next
+	RetryMaxDelay     int     // in seconds, the longest delay between retries
+}
+
+// AdDecisionConfig configures server-side ad insertion. An empty URL disables it.
+// The URL may contain the [CHANNEL_ID], [BREAK_ID], [DURATION] and [CACHEBUSTING] macros.
+type AdDecisionConfig struct {
+	URL         string
+	Timeout     int // in seconds, per ad server request
+	Lookahead   int // in seconds, how far ahead breaks are prepared
+	MaxWrappers int
+}
+
+// OutputConfig configures the FFmpeg processes pushing channels to partners
+type OutputConfig struct {
+	RestartDelay    int // in seconds, before the first restart of a failed process
+	MaxRestartDelay int // in seconds, the restart delay doubles up to this
+}
+
+// EncryptionConfig configures HLS segment encryption. MasterKey (64 hex digits) seals
+// content keys at rest and signs the key URIs of served playlists; the signatures stay
+// valid for the playlist's duration plus TokenTTL.
+type EncryptionConfig struct {
+	MasterKey string
+	TokenTTL  int // in seconds
+}
+
+// ThumbnailConfig configures the previews extracted from each video: a thumbnail
+// every Interval seconds, scaled into Width x Height and tiled Columns x Rows per sprite
+type ThumbnailConfig struct {
+	Interval int // in seconds
+	Width    int
+	Height   int
+	Columns  int
+	Rows     int
+}
+
+// LoudnessConfig configures loudness normalisation; the integrated loudness target
+// comes from the encoding preset
+type LoudnessConfig struct {
+	TruePeak float64 // maximum true peak, in dBTP
+	LRA      float64 // target loudness range, in LU
 }
 
 type LoggingConfig struct {
@@ -98,11 +150,18 @@ func Load() *Config {
 		FFmpeg: FFmpegConfig{
 			FFmpegPath:  getEnv("FFMPEG_PATH", "/usr/bin/ffmpeg"),
 			FFprobePath: getEnv("FFPROBE_PATH", "/usr/bin/ffprobe"),
+			AV1Encoder:  getEnv("AV1_ENCODER", "libsvtav1"),
 		},
 		Transcode: TranscodeConfig{
-			Workers:     getEnvAsInt("TRANSCODE_WORKERS", 3),
-			HLSWindow:   getEnvAsInt("HLS_WINDOW", 15),
-			SegmentTime: getEnvAsInt("SEGMENT_TIME", 4),
+			Workers:           getEnvAsInt("TRANSCODE_WORKERS", 3),
+			HLSWindow:         getEnvAsInt("HLS_WINDOW", 15),
+			SegmentTime:       getEnvAsInt("SEGMENT_TIME", 4),
+			DVRWindow:         getEnvAsInt("DVR_WINDOW", 7200),
+			TimeoutMultiplier: getEnvAsFloat("TRANSCODE_TIMEOUT_MULTIPLIER", 10),
+			MinTimeout:        getEnvAsInt("TRANSCODE_MIN_TIMEOUT", 600),
+			StallTimeout:      getEnvAsInt("TRANSCODE_STALL_TIMEOUT", 300),
+			RetryBaseDelay:    getEnvAsInt("TRANSCODE_RETRY_BASE_DELAY", 30),
+			RetryMaxDelay:     getEnvAsInt("TRANSCODE_RETRY_MAX_DELAY", 1800),
 		},
 		Logging: LoggingConfig{
 			Level:  getEnv("LOG_LEVEL", "info"),
@@ -113,6 +172,31 @@ func Load() *Config {
 			PodName:   getEnv("POD_NAME", ""),
 			Namespace: getEnv("NAMESPACE", "default"),
 		},
+		AdDecision: AdDecisionConfig{
+			URL:         getEnv("AD_DECISION_URL", ""),
+			Timeout:     getEnvAsInt("AD_DECISION_TIMEOUT", 2),
+			Lookahead:   getEnvAsInt("AD_DECISION_LOOKAHEAD", 1800),
+			MaxWrappers: getEnvAsInt("AD_DECISION_MAX_WRAPPERS", 5),
+		},
+		Output: OutputConfig{
+			RestartDelay:    getEnvAsInt("OUTPUT_RESTART_DELAY", 2),
+			MaxRestartDelay: getEnvAsInt("OUTPUT_MAX_RESTART_DELAY", 60),
+		},
+		Encryption: EncryptionConfig{
+			MasterKey: getEnv("KEY_MASTER_KEY", ""),
+			TokenTTL:  getEnvAsInt("KEY_TOKEN_TTL", 300),
+		},
+		Thumbnails: ThumbnailConfig{
+			Interval: getEnvAsInt("THUMBNAIL_INTERVAL", 10),
+			Width:    getEnvAsInt("THUMBNAIL_WIDTH", 160),
+			Height:   getEnvAsInt("THUMBNAIL_HEIGHT", 90),
+			Columns:  getEnvAsInt("THUMBNAIL_SPRITE_COLUMNS", 10),
+			Rows:     getEnvAsInt("THUMBNAIL_SPRITE_ROWS", 10),
+		},
+		Loudness: LoudnessConfig{
+			TruePeak: getEnvAsFloat("LOUDNORM_TRUE_PEAK", -1),
+			LRA:      getEnvAsFloat("LOUDNORM_LRA", 11),
+		},
 	}
 }
 
@@ -132,6 +216,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsInt64(key string, defaultValue int64) int64 {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
@@ -41,21 +41,70 @@ func Initialize(cfg config.DatabaseConfig) (*gorm.DB, error) {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
+	// Playlists aired as channels until channels were introduced
+	migrateChannels := !db.Migrator().HasTable(&models.Channel{})
+
 	// Auto migrate models
 	if err := db.AutoMigrate(
 		&models.Video{},
 		&models.VideoProfile{},
+		&models.EncodingPreset{},
+		&models.EncodingRung{},
+		&models.EncryptionKey{},
+		&models.Subtitle{},
+		&models.AudioTrack{},
 		&models.Playlist{},
 		&models.PlaylistVideo{},
+		&models.AdBreak{},
+		&models.AdCreative{},
+		&models.AdDecision{},
+		&models.AdDecisionAd{},
+		&models.Slate{},
+		&models.Channel{},
+		&models.ScheduleEntry{},
+		&models.AsRunEntry{},
+		&models.OutputTarget{},
 		&models.TranscodeJob{},
 		&models.SystemConfig{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if migrateChannels {
+		if err := migratePlaylistChannels(db); err != nil {
+			return nil, fmt.Errorf("failed to migrate playlist channels: %w", err)
+		}
+	}
+
 	return db, nil
 }
 
+// migratePlaylistChannels keeps every active playlist on air as a channel looping it.
+// Channels take the IDs and creation times of their playlists, so their URLs, playout
+// state and position in the loop carry over.
+func migratePlaylistChannels(db *gorm.DB) error {
+	var playlists []models.Playlist
+	if err := db.Where("is_active = ?", true).Find(&playlists).Error; err != nil {
+		return err
+	}
+
+	for _, playlist := range playlists {
+		playlistID := playlist.ID
+		channel := &models.Channel{
+			ID:          playlist.ID,
+			Name:        playlist.Name,
+			Description: playlist.Description,
+			PlaylistID:  &playlistID,
+			IsActive:    true,
+			CreatedAt:   playlist.CreatedAt,
+		}
+		if err := db.Create(channel).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // InitializeRedis sets up the Redis connection
 func InitializeRedis(cfg config.RedisConfig) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
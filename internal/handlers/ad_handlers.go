@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Get ad decisions endpoint
+func (h *Handlers) GetAdDecisions(c *gin.Context) {
+	channelIdStr := c.DefaultQuery("channel_id", "0")
+	limitStr := c.DefaultQuery("limit", "50")
+
+	channelId, err := strconv.ParseUint(channelIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel_id parameter"})
+		return
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	decisions, err := h.adService.GetDecisions(uint(channelId), limit)
+	if err != nil {
+		logrus.Errorf("Failed to get ad decisions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ad decisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, decisions)
+}
+
+// Get ad creatives endpoint
+func (h *Handlers) GetAdCreatives(c *gin.Context) {
+	creatives, err := h.adService.GetCreatives()
+	if err != nil {
+		logrus.Errorf("Failed to get ad creatives: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ad creatives"})
+		return
+	}
+
+	c.JSON(http.StatusOK, creatives)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Get playlist ad breaks endpoint
+func (h *Handlers) GetAdBreaks(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	breaks, err := h.playlistService.GetAdBreaks(uint(id))
+	if err != nil {
+		logrus.Errorf("Failed to get ad breaks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ad breaks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ad_breaks": breaks})
+}
+
+// Add ad break to playlist video endpoint
+func (h *Handlers) AddAdBreak(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	videoIdStr := c.Param("videoId")
+	videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	var req models.AdBreakRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adBreak, err := h.playlistService.AddAdBreak(uint(id), uint(videoId), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to add ad break: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add ad break"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, adBreak)
+}
+
+// Delete playlist ad break endpoint
+func (h *Handlers) DeleteAdBreak(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	breakIdStr := c.Param("breakId")
+	breakId, err := strconv.ParseUint(breakIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ad break ID"})
+		return
+	}
+
+	if err := h.playlistService.DeleteAdBreak(uint(id), uint(breakId)); err != nil {
+		logrus.Errorf("Failed to delete ad break: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete ad break"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ad break deleted successfully"})
+}
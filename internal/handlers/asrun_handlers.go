@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"linier-channel/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Get as-run log endpoint
+func (h *Handlers) GetAsRunLog(c *gin.Context) {
+	query, ok := parseAsRunQuery(c)
+	if !ok {
+		return
+	}
+
+	limitStr := c.DefaultQuery("limit", "100")
+	offsetStr := c.DefaultQuery("offset", "0")
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset parameter"})
+		return
+	}
+
+	query.Limit = limit
+	query.Offset = offset
+
+	entries, err := h.asRunService.GetEntries(query)
+	if err != nil {
+		logrus.Errorf("Failed to get as-run log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get as-run log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// Export as-run log endpoint (CSV or JSON download)
+func (h *Handlers) ExportAsRunLog(c *gin.Context) {
+	query, ok := parseAsRunQuery(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format parameter, expected csv or json"})
+		return
+	}
+
+	entries, err := h.asRunService.GetEntries(query)
+	if err != nil {
+		logrus.Errorf("Failed to export as-run log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export as-run log"})
+		return
+	}
+
+	filename := fmt.Sprintf("asrun_%s_%s.%s", query.From.Format("20060102T150405"), query.To.Format("20060102T150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	content, err := h.asRunService.ExportCSV(entries)
+	if err != nil {
+		logrus.Errorf("Failed to render as-run CSV: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export as-run log"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", content)
+}
+
+// parseAsRunQuery reads the as-run filters, defaulting to the last 24 hours
+func parseAsRunQuery(c *gin.Context) (*models.AsRunQuery, bool) {
+	query := &models.AsRunQuery{}
+
+	if channelIdStr := c.Query("channel_id"); channelIdStr != "" {
+		channelId, err := strconv.ParseUint(channelIdStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return nil, false
+		}
+		query.ChannelID = uint(channelId)
+	}
+
+	if videoIdStr := c.Query("video_id"); videoIdStr != "" {
+		videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return nil, false
+		}
+		query.VideoID = uint(videoId)
+	}
+
+	if substitutedStr := c.Query("substituted"); substitutedStr != "" {
+		substituted, err := strconv.ParseBool(substitutedStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid substituted parameter"})
+			return nil, false
+		}
+		query.Substituted = substituted
+	}
+
+	from, to, err := parseTimeRange(c, time.Now().Add(-24*time.Hour), 24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	query.From = from
+	query.To = to
+
+	return query, true
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Get audio tracks endpoint
+func (h *Handlers) GetAudioTracks(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	tracks, err := h.audioService.GetTracks(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		logrus.Errorf("Failed to get audio tracks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audio tracks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"audio_tracks": tracks})
+}
+
+// Update audio track endpoint (rename, relabel, enable or disable, make default)
+func (h *Handlers) UpdateAudioTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	trackIdStr := c.Param("trackId")
+	trackId, err := strconv.ParseUint(trackIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid audio track ID"})
+		return
+	}
+
+	var req models.AudioTrackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	track, err := h.audioService.UpdateTrack(uint(id), uint(trackId), &req)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Audio track not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to update audio track: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update audio track"})
+		return
+	}
+
+	c.JSON(http.StatusOK, track)
+}
+
+// Reorder audio tracks endpoint
+func (h *Handlers) ReorderAudioTracks(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	var req models.AudioTrackOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tracks, err := h.audioService.ReorderTracks(uint(id), req.TrackIDs)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to reorder audio tracks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder audio tracks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"audio_tracks": tracks})
+}
+
+// Get audio file endpoint (audio rendition media playlist or segment)
+func (h *Handlers) GetAudioFile(c *gin.Context) {
+	videoIdStr := c.Param("videoId")
+	videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	trackIdStr := c.Param("trackId")
+	trackId, err := strconv.ParseUint(trackIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid audio track ID"})
+		return
+	}
+
+	data, contentType, err := h.audioService.GetAudioFile(uint(videoId), uint(trackId), c.Param("file"))
+	if err != nil {
+		logrus.Errorf("Failed to get audio file: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audio not found"})
+		return
+	}
+
+	if contentType == "application/vnd.apple.mpegurl" {
+		data = []byte(h.keyService.SignPlaylist(string(data)))
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
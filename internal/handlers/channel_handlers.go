@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Create channel endpoint
+func (h *Handlers) CreateChannel(c *gin.Context) {
+	var req models.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel, err := h.channelService.CreateChannel(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to create channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, channel)
+}
+
+// Get channels endpoint
+func (h *Handlers) GetChannels(c *gin.Context) {
+	channels, err := h.channelService.GetChannels()
+	if err != nil {
+		logrus.Errorf("Failed to get channels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get channels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, channels)
+}
+
+// Get channel endpoint
+func (h *Handlers) GetChannel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	channel, err := h.channelService.GetChannel(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		logrus.Errorf("Failed to get channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// Update channel endpoint
+func (h *Handlers) UpdateChannel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	var req models.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.channelService.UpdateChannel(uint(id), &req); err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to update channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Channel updated successfully"})
+}
+
+// Delete channel endpoint
+func (h *Handlers) DeleteChannel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	if err := h.channelService.DeleteChannel(uint(id)); err != nil {
+		logrus.Errorf("Failed to delete channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Channel deleted successfully"})
+}
+
+// Get channel schedule endpoint
+func (h *Handlers) GetChannelSchedule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c, time.Now(), 24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := h.channelService.GetSchedule(uint(id), from, to)
+	if err != nil {
+		logrus.Errorf("Failed to get channel schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get channel schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// Add schedule entry endpoint
+func (h *Handlers) AddScheduleEntry(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	var req models.ScheduleEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := h.channelService.AddScheduleEntry(uint(id), &req)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to add schedule entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add schedule entry"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// Update schedule entry endpoint
+func (h *Handlers) UpdateScheduleEntry(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	entryIdStr := c.Param("entryId")
+	entryId, err := strconv.ParseUint(entryIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule entry ID"})
+		return
+	}
+
+	var req models.ScheduleEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := h.channelService.UpdateScheduleEntry(uint(id), uint(entryId), &req)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule entry not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to update schedule entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// Delete schedule entry endpoint
+func (h *Handlers) DeleteScheduleEntry(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	entryIdStr := c.Param("entryId")
+	entryId, err := strconv.ParseUint(entryIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule entry ID"})
+		return
+	}
+
+	if err := h.channelService.DeleteScheduleEntry(uint(id), uint(entryId)); err != nil {
+		logrus.Errorf("Failed to delete schedule entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule entry deleted successfully"})
+}
+
+// Validate channel schedule endpoint
+func (h *Handlers) ValidateChannelSchedule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c, time.Now(), 24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.channelService.ValidateSchedule(uint(id), from, to)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		logrus.Errorf("Failed to validate channel schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate channel schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Get channel master playlist endpoint (live, or time-shifted with ?start= or ?program=)
+func (h *Handlers) GetChannelMasterPlaylist(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	shift, ok := parseTimeShift(c)
+	if !ok {
+		return
+	}
+
+	playlist, err := h.playoutService.GetChannelMasterPlaylist(uint(id), shift)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		logrus.Errorf("Failed to generate channel master playlist: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.String(http.StatusOK, playlist)
+}
+
+// Get channel DASH manifest endpoint (live window of fragmented MP4 content)
+func (h *Handlers) GetChannelManifest(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	manifest, err := h.playoutService.GetChannelManifest(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		logrus.Errorf("Failed to generate channel DASH manifest: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Type", "application/dash+xml")
+	c.String(http.StatusOK, manifest)
+}
+
+// Get channel media playlist endpoint (for specific resolution; live, or time-shifted
+// with ?start= or ?program=)
+func (h *Handlers) GetChannelPlaylistFile(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	shift, ok := parseTimeShift(c)
+	if !ok {
+		return
+	}
+
+	resolution := c.Param("resolution")
+	playlist, err := h.playoutService.GetChannelMediaPlaylist(uint(id), resolution, shift)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+			return
+		}
+		logrus.Errorf("Failed to generate channel playlist: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.String(http.StatusOK, h.keyService.SignPlaylist(playlist))
+}
+
+// parseTimeShift reads the optional start (RFC3339) or program (as-run entry ID) parameter
+func parseTimeShift(c *gin.Context) (*models.TimeShiftQuery, bool) {
+	startStr := c.Query("start")
+	programStr := c.Query("program")
+	if startStr == "" && programStr == "" {
+		return nil, true
+	}
+	if startStr != "" && programStr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either start or program, not both"})
+		return nil, false
+	}
+
+	if programStr != "" {
+		programId, err := strconv.ParseUint(programStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid program parameter"})
+			return nil, false
+		}
+		return &models.TimeShiftQuery{ProgramID: uint(programId)}, true
+	}
+
+	start, err := time.Parse(time.RFC3339, startStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start parameter, expected RFC3339"})
+		return nil, false
+	}
+	return &models.TimeShiftQuery{Start: start}, true
+}
+
+// parseTimeRange reads the RFC3339 from/to query parameters, defaulting to from and from+span
+func parseTimeRange(c *gin.Context, from time.Time, span time.Duration) (time.Time, time.Time, error) {
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid from parameter, expected RFC3339")
+		}
+		from = parsed
+	}
+
+	to := from.Add(span)
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid to parameter, expected RFC3339")
+		}
+		to = parsed
+	}
+
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("Invalid time range, to must be after from")
+	}
+
+	return from, to, nil
+}
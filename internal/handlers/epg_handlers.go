@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"linier-channel/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxEPGRange limits how far a single program guide request may span
+const maxEPGRange = 14 * 24 * time.Hour
+
+// Get channel program guide endpoint (JSON)
+func (h *Handlers) GetChannelEPG(c *gin.Context) {
+	guide, ok := h.channelGuide(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, guide)
+}
+
+// Get channel program guide endpoint (XMLTV)
+func (h *Handlers) GetChannelXMLTV(c *gin.Context) {
+	guide, ok := h.channelGuide(c)
+	if !ok {
+		return
+	}
+
+	h.writeXMLTV(c, guide)
+}
+
+// Get playlist program guide endpoint (JSON)
+func (h *Handlers) GetPlaylistEPG(c *gin.Context) {
+	guide, ok := h.playlistGuide(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, guide)
+}
+
+// Get playlist program guide endpoint (XMLTV)
+func (h *Handlers) GetPlaylistXMLTV(c *gin.Context) {
+	guide, ok := h.playlistGuide(c)
+	if !ok {
+		return
+	}
+
+	h.writeXMLTV(c, guide)
+}
+
+// channelGuide loads the program guide of the requested channel, writing any error response
+func (h *Handlers) channelGuide(c *gin.Context) (*models.EPGResponse, bool) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return nil, false
+	}
+
+	from, to, ok := parseEPGRange(c)
+	if !ok {
+		return nil, false
+	}
+
+	guide, err := h.epgService.GetChannelGuide(uint(id), from, to)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return nil, false
+		}
+		logrus.Errorf("Failed to generate channel program guide: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate program guide"})
+		return nil, false
+	}
+
+	return guide, true
+}
+
+// playlistGuide loads the program guide of the requested playlist, writing any error response
+func (h *Handlers) playlistGuide(c *gin.Context) (*models.EPGResponse, bool) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return nil, false
+	}
+
+	from, to, ok := parseEPGRange(c)
+	if !ok {
+		return nil, false
+	}
+
+	guide, err := h.epgService.GetPlaylistGuide(uint(id), from, to)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return nil, false
+		}
+		logrus.Errorf("Failed to generate playlist program guide: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate program guide"})
+		return nil, false
+	}
+
+	return guide, true
+}
+
+// writeXMLTV renders a program guide as an XMLTV response
+func (h *Handlers) writeXMLTV(c *gin.Context, guide *models.EPGResponse) {
+	content, err := h.epgService.GenerateXMLTV(guide)
+	if err != nil {
+		logrus.Errorf("Failed to render XMLTV: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate program guide"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", content)
+}
+
+// parseEPGRange reads the guide time range, writing a bad request response if it is invalid
+func parseEPGRange(c *gin.Context) (time.Time, time.Time, bool) {
+	from, to, err := parseTimeRange(c, time.Now(), 24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return time.Time{}, time.Time{}, false
+	}
+
+	if to.Sub(from) > maxEPGRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time range must not exceed 14 days"})
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
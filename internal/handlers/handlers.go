@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"linier-channel/internal/models"
 	"linier-channel/internal/services"
+	"linier-channel/internal/utils"
 	"net/http"
 	"strconv"
 
@@ -15,6 +17,17 @@ type Handlers struct {
 	transcodeService *services.TranscodeService
 	playlistService  *services.PlaylistService
 	uploadService    *services.UploadService
+	playoutService   *services.PlayoutService
+	channelService   *services.ChannelService
+	epgService       *services.EPGService
+	asRunService     *services.AsRunService
+	adService        *services.AdService
+	slateService     *services.SlateService
+	outputService    *services.OutputService
+	presetService    *services.PresetService
+	keyService       *services.KeyService
+	subtitleService  *services.SubtitleService
+	audioService     *services.AudioService
 }
 
 func NewHandlers(
@@ -22,12 +35,34 @@ func NewHandlers(
 	transcodeService *services.TranscodeService,
 	playlistService *services.PlaylistService,
 	uploadService *services.UploadService,
+	playoutService *services.PlayoutService,
+	channelService *services.ChannelService,
+	epgService *services.EPGService,
+	asRunService *services.AsRunService,
+	adService *services.AdService,
+	slateService *services.SlateService,
+	outputService *services.OutputService,
+	presetService *services.PresetService,
+	keyService *services.KeyService,
+	subtitleService *services.SubtitleService,
+	audioService *services.AudioService,
 ) *Handlers {
 	return &Handlers{
 		videoService:     videoService,
 		transcodeService: transcodeService,
 		playlistService:  playlistService,
 		uploadService:    uploadService,
+		playoutService:   playoutService,
+		channelService:   channelService,
+		epgService:       epgService,
+		asRunService:     asRunService,
+		adService:        adService,
+		slateService:     slateService,
+		outputService:    outputService,
+		presetService:    presetService,
+		keyService:       keyService,
+		subtitleService:  subtitleService,
+		audioService:     audioService,
 	}
 }
 
@@ -47,6 +82,12 @@ func (h *Handlers) SetupRoutes() *gin.Engine {
 			videos.GET("/", h.GetVideos)
 			videos.GET("/:id", h.GetVideo)
 			videos.GET("/:id/status", h.GetVideoStatus)
+			videos.POST("/:id/subtitles", h.AddSubtitle)
+			videos.GET("/:id/subtitles", h.GetSubtitles)
+			videos.DELETE("/:id/subtitles/:language", h.DeleteSubtitle)
+			videos.GET("/:id/audio-tracks", h.GetAudioTracks)
+			videos.PUT("/:id/audio-tracks/order", h.ReorderAudioTracks)
+			videos.PUT("/:id/audio-tracks/:trackId", h.UpdateAudioTrack)
 			videos.DELETE("/:id", h.DeleteVideo)
 		}
 
@@ -57,24 +98,105 @@ func (h *Handlers) SetupRoutes() *gin.Engine {
 			playlists.GET("/", h.GetPlaylists)
 			playlists.GET("/:id", h.GetPlaylist)
 			playlists.PUT("/:id", h.UpdatePlaylist)
+			playlists.PUT("/:id/playback", h.UpdatePlaylistPlayback)
 			playlists.DELETE("/:id", h.DeletePlaylist)
 			playlists.POST("/:id/videos", h.AddVideoToPlaylist)
 			playlists.DELETE("/:id/videos/:videoId", h.RemoveVideoFromPlaylist)
+			playlists.GET("/:id/breaks", h.GetAdBreaks)
+			playlists.POST("/:id/videos/:videoId/breaks", h.AddAdBreak)
+			playlists.DELETE("/:id/breaks/:breakId", h.DeleteAdBreak)
+			playlists.GET("/:id/epg", h.GetPlaylistEPG)
+			playlists.GET("/:id/epg.xml", h.GetPlaylistXMLTV)
 		}
 
 		// HLS streaming routes
 		streaming := v1.Group("/stream")
 		{
 			streaming.GET("/:videoId/master.m3u8", h.GetMasterPlaylist)
+			streaming.GET("/:videoId/manifest.mpd", h.GetDASHManifest)
+			streaming.GET("/:videoId/thumbnails/:file", h.GetThumbnail)
+			streaming.GET("/:videoId/subtitles/:language/:file", h.GetSubtitleFile)
+			streaming.GET("/:videoId/audio/:trackId/:file", h.GetAudioFile)
 			streaming.GET("/:videoId/:resolution/playlist.m3u8", h.GetPlaylistFile)
 			streaming.GET("/:videoId/:resolution/:segment", h.GetSegment)
 		}
 
+		// Linear channel routes
+		channels := v1.Group("/channels")
+		{
+			channels.POST("/", h.CreateChannel)
+			channels.GET("/", h.GetChannels)
+			channels.GET("/:id", h.GetChannel)
+			channels.PUT("/:id", h.UpdateChannel)
+			channels.DELETE("/:id", h.DeleteChannel)
+			channels.GET("/:id/schedule", h.GetChannelSchedule)
+			channels.POST("/:id/schedule", h.AddScheduleEntry)
+			channels.GET("/:id/schedule/validate", h.ValidateChannelSchedule)
+			channels.PUT("/:id/schedule/:entryId", h.UpdateScheduleEntry)
+			channels.DELETE("/:id/schedule/:entryId", h.DeleteScheduleEntry)
+			channels.GET("/:id/epg", h.GetChannelEPG)
+			channels.GET("/:id/epg.xml", h.GetChannelXMLTV)
+			channels.GET("/:id/master.m3u8", h.GetChannelMasterPlaylist)
+			channels.GET("/:id/manifest.mpd", h.GetChannelManifest)
+			channels.GET("/:id/:resolution/playlist.m3u8", h.GetChannelPlaylistFile)
+		}
+
+		// Slate routes
+		slates := v1.Group("/slates")
+		{
+			slates.POST("/", h.CreateSlate)
+			slates.GET("/", h.GetSlates)
+			slates.GET("/:id", h.GetSlate)
+			slates.DELETE("/:id", h.DeleteSlate)
+		}
+
+		// Encoding preset routes
+		presets := v1.Group("/presets")
+		{
+			presets.POST("/", h.CreatePreset)
+			presets.GET("/", h.GetPresets)
+			presets.GET("/:id", h.GetPreset)
+			presets.PUT("/:id", h.UpdatePreset)
+			presets.DELETE("/:id", h.DeletePreset)
+		}
+
+		// Output target routes
+		outputs := v1.Group("/outputs")
+		{
+			outputs.POST("/", h.CreateOutput)
+			outputs.GET("/", h.GetOutputs)
+			outputs.GET("/:id", h.GetOutput)
+			outputs.PUT("/:id", h.UpdateOutput)
+			outputs.DELETE("/:id", h.DeleteOutput)
+		}
+
+		// Encryption key delivery routes
+		keys := v1.Group("/keys")
+		{
+			keys.GET("/:id", h.GetKey)
+		}
+
+		// As-run log routes
+		asRun := v1.Group("/asrun")
+		{
+			asRun.GET("/", h.GetAsRunLog)
+			asRun.GET("/export", h.ExportAsRunLog)
+		}
+
 		// Admin routes
 		admin := v1.Group("/admin")
 		{
 			admin.GET("/transcode/queue", h.GetTranscodeQueue)
 			admin.GET("/transcode/status", h.GetTranscodeStatus)
+			admin.POST("/transcode/jobs/:id/cancel", h.CancelTranscodeJob)
+			admin.POST("/transcode/videos/:id/cancel", h.CancelVideoTranscode)
+			admin.POST("/transcode/profiles/:id/cancel", h.CancelProfileTranscode)
+			admin.GET("/transcode/dead-letter", h.GetDeadLetterJobs)
+			admin.GET("/transcode/dead-letter/:id", h.GetDeadLetterJob)
+			admin.POST("/transcode/dead-letter/:id/replay", h.ReplayDeadLetterJob)
+			admin.GET("/ads/decisions", h.GetAdDecisions)
+			admin.GET("/ads/creatives", h.GetAdCreatives)
+			admin.GET("/outputs", h.GetOutputStatus)
 		}
 	}
 
@@ -97,8 +219,34 @@ func (h *Handlers) UploadVideo(c *gin.Context) {
 		return
 	}
 
-	response, err := h.uploadService.UploadVideo(file)
+	// Optional encoding preset, the default one otherwise
+	var presetID *uint
+	if presetStr := c.PostForm("preset_id"); presetStr != "" {
+		id, err := strconv.ParseUint(presetStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+			return
+		}
+		preset := uint(id)
+		presetID = &preset
+	}
+
+	// Optional encryption, on top of what the preset asks for
+	encrypt := false
+	if encryptStr := c.PostForm("encrypt"); encryptStr != "" {
+		encrypt, err = strconv.ParseBool(encryptStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid encrypt flag"})
+			return
+		}
+	}
+
+	response, err := h.uploadService.UploadVideo(file, presetID, encrypt)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		logrus.Errorf("Failed to upload video: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -280,6 +428,34 @@ func (h *Handlers) UpdatePlaylist(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Playlist updated successfully"})
 }
 
+// Update playlist playback mode endpoint
+func (h *Handlers) UpdatePlaylistPlayback(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	var req models.PlaybackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.playlistService.UpdatePlayback(uint(id), &req); err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+		logrus.Errorf("Failed to update playlist playback: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update playlist playback"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Playlist playback updated successfully"})
+}
+
 // Delete playlist endpoint
 func (h *Handlers) DeletePlaylist(c *gin.Context) {
 	idStr := c.Param("id")
@@ -367,6 +543,30 @@ func (h *Handlers) GetMasterPlaylist(c *gin.Context) {
 	c.String(http.StatusOK, playlist.MasterPlaylist)
 }
 
+// Get DASH manifest endpoint (videos with fragmented MP4 renditions)
+func (h *Handlers) GetDASHManifest(c *gin.Context) {
+	videoIdStr := c.Param("videoId")
+	videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	manifest, err := h.playlistService.GetDASHManifest(uint(videoId))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		logrus.Errorf("Failed to get DASH manifest: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/dash+xml")
+	c.String(http.StatusOK, manifest)
+}
+
 // Get playlist file endpoint (for specific resolution)
 func (h *Handlers) GetPlaylistFile(c *gin.Context) {
 	videoIdStr := c.Param("videoId")
@@ -385,7 +585,7 @@ func (h *Handlers) GetPlaylistFile(c *gin.Context) {
 	}
 
 	c.Header("Content-Type", "application/vnd.apple.mpegurl")
-	c.String(http.StatusOK, playlist)
+	c.String(http.StatusOK, h.keyService.SignPlaylist(playlist))
 }
 
 // Get segment endpoint
@@ -407,8 +607,28 @@ func (h *Handlers) GetSegment(c *gin.Context) {
 		return
 	}
 
-	c.Header("Content-Type", "video/mp2t")
-	c.Data(http.StatusOK, "video/mp2t", segmentData)
+	contentType := utils.SegmentContentType(segment)
+	c.Header("Content-Type", contentType)
+	c.Data(http.StatusOK, contentType, segmentData)
+}
+
+// Get thumbnail endpoint (poster, thumbnails, sprites and their WebVTT track)
+func (h *Handlers) GetThumbnail(c *gin.Context) {
+	videoIdStr := c.Param("videoId")
+	videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	data, contentType, err := h.playlistService.GetThumbnailFile(uint(videoId), c.Param("file"))
+	if err != nil {
+		logrus.Errorf("Failed to get thumbnail file: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnail not found"})
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, data)
 }
 
 // Get transcode queue endpoint
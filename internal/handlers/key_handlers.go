@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Get encryption key endpoint (players follow the signed URI of the playlist's EXT-X-KEY)
+func (h *Handlers) GetKey(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if !h.keyService.Authorize(uint(id), expires, c.Query("token")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authorized to fetch keys"})
+		return
+	}
+
+	key, err := h.keyService.GetKey(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+			return
+		}
+		logrus.Errorf("Failed to get key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get key"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/octet-stream", key)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Create output target endpoint
+func (h *Handlers) CreateOutput(c *gin.Context) {
+	var req models.OutputTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, err := h.outputService.CreateTarget(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to create output target: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create output target"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, target)
+}
+
+// Get output targets endpoint
+func (h *Handlers) GetOutputs(c *gin.Context) {
+	targets, err := h.outputService.GetTargets()
+	if err != nil {
+		logrus.Errorf("Failed to get output targets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get output targets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"outputs": targets})
+}
+
+// Get single output target endpoint
+func (h *Handlers) GetOutput(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid output target ID"})
+		return
+	}
+
+	target, err := h.outputService.GetTarget(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Output target not found"})
+			return
+		}
+		logrus.Errorf("Failed to get output target: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get output target"})
+		return
+	}
+
+	c.JSON(http.StatusOK, target)
+}
+
+// Update output target endpoint
+func (h *Handlers) UpdateOutput(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid output target ID"})
+		return
+	}
+
+	var req models.OutputTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, err := h.outputService.UpdateTarget(uint(id), &req)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Output target not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to update output target: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update output target"})
+		return
+	}
+
+	c.JSON(http.StatusOK, target)
+}
+
+// Delete output target endpoint
+func (h *Handlers) DeleteOutput(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid output target ID"})
+		return
+	}
+
+	if err := h.outputService.DeleteTarget(uint(id)); err != nil {
+		logrus.Errorf("Failed to delete output target: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete output target"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Output target deleted successfully"})
+}
+
+// Get output status endpoint (admin)
+func (h *Handlers) GetOutputStatus(c *gin.Context) {
+	targets, err := h.outputService.GetTargets()
+	if err != nil {
+		logrus.Errorf("Failed to get output status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get output status"})
+		return
+	}
+
+	counts := map[string]int{}
+	for _, target := range targets {
+		counts[target.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outputs": targets,
+		"counts":  counts,
+	})
+}
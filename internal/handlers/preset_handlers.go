@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Create encoding preset endpoint
+func (h *Handlers) CreatePreset(c *gin.Context) {
+	var req models.EncodingPresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preset, err := h.presetService.CreatePreset(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to create encoding preset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create encoding preset"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, preset)
+}
+
+// Get encoding presets endpoint
+func (h *Handlers) GetPresets(c *gin.Context) {
+	presets, err := h.presetService.GetPresets()
+	if err != nil {
+		logrus.Errorf("Failed to get encoding presets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get encoding presets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presets": presets})
+}
+
+// Get single encoding preset endpoint
+func (h *Handlers) GetPreset(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+		return
+	}
+
+	preset, err := h.presetService.GetPreset(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encoding preset not found"})
+			return
+		}
+		logrus.Errorf("Failed to get encoding preset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get encoding preset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preset)
+}
+
+// Update encoding preset endpoint
+func (h *Handlers) UpdatePreset(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+		return
+	}
+
+	var req models.EncodingPresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preset, err := h.presetService.UpdatePreset(uint(id), &req)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encoding preset not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to update encoding preset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update encoding preset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preset)
+}
+
+// Delete encoding preset endpoint
+func (h *Handlers) DeletePreset(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+		return
+	}
+
+	if err := h.presetService.DeletePreset(uint(id)); err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encoding preset not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to delete encoding preset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete encoding preset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Encoding preset deleted successfully"})
+}
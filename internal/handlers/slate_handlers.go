@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Create slate endpoint (multipart form with an optional image)
+func (h *Handlers) CreateSlate(c *gin.Context) {
+	var req models.SlateRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image, err := c.FormFile("image")
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image upload"})
+		return
+	}
+
+	slate, err := h.slateService.CreateSlate(&req, image)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to create slate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create slate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, slate)
+}
+
+// Get slates endpoint
+func (h *Handlers) GetSlates(c *gin.Context) {
+	slates, err := h.slateService.GetSlates()
+	if err != nil {
+		logrus.Errorf("Failed to get slates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get slates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slates": slates})
+}
+
+// Get single slate endpoint
+func (h *Handlers) GetSlate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slate ID"})
+		return
+	}
+
+	slate, err := h.slateService.GetSlate(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Slate not found"})
+			return
+		}
+		logrus.Errorf("Failed to get slate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get slate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, slate)
+}
+
+// Delete slate endpoint
+func (h *Handlers) DeleteSlate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slate ID"})
+		return
+	}
+
+	if err := h.slateService.DeleteSlate(uint(id)); err != nil {
+		logrus.Errorf("Failed to delete slate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete slate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Slate deleted successfully"})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/models"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Add subtitle endpoint (multipart form with an SRT, WebVTT or ASS sidecar)
+func (h *Handlers) AddSubtitle(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	var req models.SubtitleRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+
+	subtitle, err := h.subtitleService.AddSubtitle(uint(id), &req, file)
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to add subtitle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add subtitle"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, subtitle)
+}
+
+// Get subtitles endpoint
+func (h *Handlers) GetSubtitles(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	subtitles, err := h.subtitleService.GetSubtitles(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		logrus.Errorf("Failed to get subtitles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subtitles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subtitles": subtitles})
+}
+
+// Delete subtitle endpoint
+func (h *Handlers) DeleteSubtitle(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	if err := h.subtitleService.DeleteSubtitle(uint(id), c.Param("language")); err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle not found"})
+			return
+		}
+		logrus.Errorf("Failed to delete subtitle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subtitle"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subtitle deleted successfully"})
+}
+
+// Get subtitle file endpoint (WebVTT media playlist or segment)
+func (h *Handlers) GetSubtitleFile(c *gin.Context) {
+	videoIdStr := c.Param("videoId")
+	videoId, err := strconv.ParseUint(videoIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	data, contentType, err := h.subtitleService.GetSubtitleFile(uint(videoId), c.Param("language"), c.Param("file"))
+	if err != nil {
+		logrus.Errorf("Failed to get subtitle file: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle not found"})
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"linier-channel/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Cancel transcode job endpoint
+func (h *Handlers) CancelTranscodeJob(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	profileIDs, err := h.transcodeService.CancelJob(uint(id))
+	h.respondCancelled(c, profileIDs, err, "Transcode job not found")
+}
+
+// Cancel video transcode endpoint
+func (h *Handlers) CancelVideoTranscode(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+
+	profileIDs, err := h.transcodeService.CancelVideo(uint(id))
+	h.respondCancelled(c, profileIDs, err, "Video not found")
+}
+
+// Cancel profile transcode endpoint
+func (h *Handlers) CancelProfileTranscode(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile ID"})
+		return
+	}
+
+	profileIDs, err := h.transcodeService.CancelProfile(uint(id))
+	h.respondCancelled(c, profileIDs, err, "Video profile not found")
+}
+
+// respondCancelled writes the response of a cancellation endpoint
+func (h *Handlers) respondCancelled(c *gin.Context, profileIDs []uint, err error, notFound string) {
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to cancel transcoding: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel transcoding"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Transcoding cancelled",
+		"profile_ids": profileIDs,
+	})
+}
+
+// Get dead-lettered transcode jobs endpoint
+func (h *Handlers) GetDeadLetterJobs(c *gin.Context) {
+	jobs, err := h.transcodeService.GetDeadLetterJobs()
+	if err != nil {
+		logrus.Errorf("Failed to get dead-lettered jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dead-lettered jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// Get single dead-lettered transcode job endpoint
+func (h *Handlers) GetDeadLetterJob(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := h.transcodeService.GetDeadLetterJob(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead-lettered job not found"})
+			return
+		}
+		logrus.Errorf("Failed to get dead-lettered job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dead-lettered job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Replay dead-lettered transcode job endpoint
+func (h *Handlers) ReplayDeadLetterJob(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := h.transcodeService.ReplayDeadLetterJob(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead-lettered job not found"})
+			return
+		}
+		logrus.Errorf("Failed to replay dead-lettered job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead-lettered job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
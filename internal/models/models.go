@@ -6,20 +6,48 @@ import (
 
 // Video represents the main video entity
 type Video struct {
-	ID               uint      `json:"id" gorm:"primaryKey;autoIncrement"`
-	OriginalFilename string    `json:"original_filename" gorm:"size:255;not null"`
-	FilePath         string    `json:"file_path" gorm:"size:500;not null"`
-	VideoPath        string    `json:"video_path" gorm:"size:500"` // Path to master.m3u8
-	FileSize         int64     `json:"file_size"`
-	Duration         int       `json:"duration"` // in seconds
-	Status           string    `json:"status" gorm:"type:enum('uploaded','processing','completed','failed');default:'uploaded'"`
-	ErrorMessage     string    `json:"error_message" gorm:"type:text"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	OriginalFilename string     `json:"original_filename" gorm:"size:255;not null"`
+	FilePath         string     `json:"file_path" gorm:"size:500;not null"`
+	VideoPath        string     `json:"video_path" gorm:"size:500"`    // Path to master.m3u8
+	ManifestPath     string     `json:"manifest_path" gorm:"size:500"` // Path to manifest.mpd, for fragmented MP4 renditions
+	FileSize         int64      `json:"file_size"`
+	Duration         int        `json:"duration"` // in seconds
+	Status           string     `json:"status" gorm:"type:enum('uploaded','processing','completed','failed');default:'uploaded'"`
+	ErrorMessage     string     `json:"error_message" gorm:"type:text"`
+	PresetID         *uint      `json:"preset_id" gorm:"index"`           // encoding preset the profiles were created from
+	SinglePass       bool       `json:"single_pass" gorm:"default:false"` // all profiles are encoded by one job
+	Encrypted        bool       `json:"encrypted" gorm:"default:false"`   // segments are AES-128 encrypted
+	KeyRotation      int        `json:"key_rotation"`                     // segments per encryption key, 0 for a single key
+	LoudnessTarget   float64    `json:"loudness_target"`                  // in LUFS, audio is normalised to; 0 leaves it as is
+	MeasuredI        *float64   `json:"measured_i"`                       // integrated loudness of the source, in LUFS; nil until measured
+	MeasuredTP       float64    `json:"measured_tp"`                      // true peak of the source, in dBTP
+	MeasuredLRA      float64    `json:"measured_lra"`                     // loudness range of the source, in LU
+	MeasuredThresh   float64    `json:"measured_thresh"`                  // gating threshold, in LUFS
+	LoudnessOffset   float64    `json:"loudness_offset"`                  // gain offset of the normalisation pass, in LU
+	LoudnessStatus   string     `json:"loudness_status" gorm:"type:enum('pending','measuring','measured','skipped');default:'pending'"`
+	MeasuringSince   *time.Time `json:"-"`                 // when a job claimed the measurement
+	ThumbnailPath    string     `json:"-" gorm:"size:500"` // directory of the poster, thumbnails and sprites
+	ThumbnailCount   int        `json:"thumbnail_count"`
+	SpriteCount      int        `json:"sprite_count"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 
 	// Relationships
 	VideoProfiles []VideoProfile `json:"video_profiles" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
+	Subtitles      []Subtitle      `json:"subtitles,omitempty" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
+	AudioTracks    []AudioTrack    `json:"audio_tracks,omitempty" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
 	PlaylistVideos []PlaylistVideo `json:"playlist_videos" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
+
+	Thumbnails *VideoThumbnails `json:"thumbnails,omitempty" gorm:"-"` // set when previews were extracted
+}
+
+// VideoThumbnails are the URLs of a video's previews
+type VideoThumbnails struct {
+	Poster     string   `json:"poster"`
+	Track      string   `json:"track"` // WebVTT track mapping playback time to sprite tiles
+	Sprites    []string `json:"sprites"`
+	Thumbnails []string `json:"thumbnails"`
 }
 
 // VideoProfile represents video transcoding profiles
@@ -27,14 +55,24 @@ type VideoProfile struct {
 	ID               uint       `json:"id" gorm:"primaryKey;autoIncrement"`
 	VideoID          uint       `json:"video_id" gorm:"not null;index"`
 	Resolution       string     `json:"resolution" gorm:"size:10;index"`
+	Width            int        `json:"width"`  // output width in pixels, after aspect ratio fitting
+	Height           int        `json:"height"` // output height in pixels
 	CodecVideo       string     `json:"codec_video" gorm:"size:20;default:'h264'"`
 	CodecAudio       string     `json:"codec_audio" gorm:"size:20;default:'aac'"`
 	Bitrate          int        `json:"bitrate"` // in kbps
 	AudioBitrate     int        `json:"audio_bitrate" gorm:"default:128"`
+	MaxRate          int        `json:"max_rate"`                      // in kbps, 0 for unconstrained
+	BufSize          int        `json:"buf_size"`                      // in kbits
+	FrameRate        float64    `json:"frame_rate"`                    // 0 keeps the source frame rate
+	EncoderPreset    string     `json:"encoder_preset" gorm:"size:20"` // x264 speed preset
+	EncoderProfile   string     `json:"encoder_profile" gorm:"size:20"`
+	EncoderLevel     string     `json:"encoder_level" gorm:"size:10"`
+	SegmentFormat    string     `json:"segment_format" gorm:"type:enum('mpegts','fmp4');default:'mpegts'"`
+	Codecs           string     `json:"codecs" gorm:"size:100"` // RFC 6381 codecs, probed from the output
 	SegmentTime      int        `json:"segment_time" gorm:"default:4"`
 	TotalSegments    int        `json:"total_segments"`
 	PlaylistPath     string     `json:"playlist_path" gorm:"size:500"`
-	Status           string     `json:"status" gorm:"type:enum('pending','processing','completed','failed');default:'pending';index"`
+	Status           string     `json:"status" gorm:"type:enum('pending','processing','completed','failed','cancelled');default:'pending';index"`
 	ProgressPercentage int      `json:"progress_percentage" gorm:"default:0"`
 	ErrorMessage     string     `json:"error_message" gorm:"type:text"`
 	StartedAt        *time.Time `json:"started_at"`
@@ -45,17 +83,116 @@ type VideoProfile struct {
 	Video Video `json:"video" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
 }
 
+// EncodingPreset represents a named encoding ladder that videos are transcoded with
+
+type EncodingPreset struct {
+	ID             uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name           string    `json:"name" gorm:"size:255;uniqueIndex;not null"`
+	Description    string    `json:"description" gorm:"type:text"`
+	IsDefault      bool      `json:"is_default" gorm:"default:false;index"`
+	SinglePass     bool      `json:"single_pass" gorm:"default:false"`                                  // decode once and encode every rung in one FFmpeg process
+	SegmentFormat  string    `json:"segment_format" gorm:"type:enum('mpegts','fmp4');default:'mpegts'"` // MPEG-TS, or fragmented MP4 (CMAF)
+	Encrypt        bool      `json:"encrypt" gorm:"default:false"`                                      // encrypt segments with AES-128
+	KeyRotation    int       `json:"key_rotation"`                                                      // segments per encryption key, 0 for a single key
+	LoudnessTarget float64   `json:"loudness_target"`                                                   // in LUFS, such as -23 (EBU R128) or -24 (ATSC A/85); 0 disables normalisation
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	// Relationships
+	Rungs []EncodingRung `json:"rungs" gorm:"foreignKey:PresetID;constraint:OnDelete:CASCADE"`
+}
+
+// EncodingRung represents one rendition of an encoding preset
+type EncodingRung struct {
+	ID             uint    `json:"id" gorm:"primaryKey;autoIncrement"`
+	PresetID       uint    `json:"preset_id" gorm:"not null;index"`
+	Resolution     string  `json:"resolution" gorm:"size:10;not null"`
+	CodecVideo     string  `json:"codec_video" gorm:"size:20;default:'h264'"`
+	CodecAudio     string  `json:"codec_audio" gorm:"size:20;default:'aac'"`
+	Bitrate        int     `json:"bitrate"`  // in kbps
+	MaxRate        int     `json:"max_rate"` // in kbps, 0 for unconstrained
+	BufSize        int     `json:"buf_size"` // in kbits
+	AudioBitrate   int     `json:"audio_bitrate" gorm:"default:128"`
+	FrameRate      float64 `json:"frame_rate"` // 0 keeps the source frame rate
+	SegmentTime    int     `json:"segment_time" gorm:"default:4"`
+	EncoderPreset  string  `json:"encoder_preset" gorm:"size:20"`
+	EncoderProfile string  `json:"encoder_profile" gorm:"size:20"`
+	EncoderLevel   string  `json:"encoder_level" gorm:"size:10"`
+	SortOrder      int     `json:"sort_order" gorm:"default:0"`
+}
+
+// EncryptionKey represents an AES-128 content key of a video. The key is stored
+// encrypted with the master key and only served to authenticated players.
+type EncryptionKey struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	VideoID    uint      `json:"video_id" gorm:"not null;index"`
+	Ciphertext string    `json:"-" gorm:"type:text;not null"` // nonce and sealed key, base64
+	IV         string    `json:"iv" gorm:"size:32"`           // hex, as written in EXT-X-KEY
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Relationships
+	Video Video `json:"-" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
+}
+
+// AudioTrack is an audio stream of a video's source with several, served as an
+// alternate audio rendition
+
+type AudioTrack struct {
+	ID             uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	VideoID        uint      `json:"video_id" gorm:"not null;index"`
+	StreamIndex    int       `json:"stream_index"`            // among the source's audio streams
+	Language       string    `json:"language" gorm:"size:35"` // from the source's language tag, "und" if unset
+	Name           string    `json:"name" gorm:"size:100"`
+	Channels       int       `json:"channels"`
+	Bitrate        int       `json:"bitrate"` // in kbps
+	SortOrder      int       `json:"sort_order" gorm:"default:0"`
+	Enabled        bool      `json:"enabled" gorm:"default:true"`
+	IsDefault      bool      `json:"is_default" gorm:"default:false"`
+	Status         string    `json:"status" gorm:"size:20;default:'pending'"`
+	PlaylistPath   string    `json:"playlist_path" gorm:"size:500"`
+	MeasuredI      *float64  `json:"measured_i"`      // integrated loudness of the stream, in LUFS; nil until measured
+	MeasuredTP     float64   `json:"measured_tp"`     // true peak of the stream, in dBTP
+	MeasuredLRA    float64   `json:"measured_lra"`    // loudness range of the stream, in LU
+	MeasuredThresh float64   `json:"measured_thresh"` // gating threshold, in LUFS
+	LoudnessOffset float64   `json:"loudness_offset"` // gain offset of the normalisation pass, in LU
+	ErrorMessage   string    `json:"error_message" gorm:"type:text"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Subtitle is a sidecar subtitle track of a video in one language, served as
+// segmented WebVTT
+type Subtitle struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	VideoID      uint      `json:"video_id" gorm:"not null;uniqueIndex:idx_subtitle_language"`
+	Language     string    `json:"language" gorm:"size:35;not null;uniqueIndex:idx_subtitle_language"` // BCP 47 tag
+	Name         string    `json:"name" gorm:"size:100"`
+	Format       string    `json:"format" gorm:"size:10"`    // format of the sidecar: srt, vtt or ass
+	FilePath     string    `json:"-" gorm:"size:500"`        // sidecar, relative to the upload path
+	PlaylistPath string    `json:"playlist_path" gorm:"size:500"`
+	IsDefault    bool      `json:"is_default" gorm:"default:false"`
+	Status       string    `json:"status" gorm:"size:20;default:'pending'"` // pending until the video's output directory exists
+	ErrorMessage string    `json:"error_message" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
 // Playlist represents video playlists
 type Playlist struct {
-	ID          uint           `json:"id" gorm:"primaryKey;autoIncrement"`
-	Name        string         `json:"name" gorm:"size:255;not null"`
-	Description string         `json:"description" gorm:"type:text"`
-	IsActive    bool           `json:"is_active" gorm:"default:true;index"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	ID              uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name            string    `json:"name" gorm:"size:255;not null"`
+	Description     string    `json:"description" gorm:"type:text"`
+	IsActive        bool      `json:"is_active" gorm:"default:true;index"`
+	PlaybackMode    string    `json:"playback_mode" gorm:"type:enum('sequential','loop','shuffle','weighted');default:'sequential'"`
+	ShuffleSeed     int64     `json:"shuffle_seed" gorm:"default:0"`      // 0 seeds with the playlist ID
+	NoRepeatItems   int       `json:"no_repeat_items" gorm:"default:0"`   // shuffle and weighted: items before a video may air again
+	NoRepeatMinutes int       `json:"no_repeat_minutes" gorm:"default:0"` // shuffle and weighted: minutes before a video may air again
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 
 	// Relationships
 	PlaylistVideos []PlaylistVideo `json:"playlist_videos" gorm:"foreignKey:PlaylistID;constraint:OnDelete:CASCADE"`
+	AdBreaks       []AdBreak       `json:"ad_breaks,omitempty" gorm:"foreignKey:PlaylistID;constraint:OnDelete:CASCADE"`
 }
 
 // PlaylistVideo represents the relationship between playlists and videos
@@ -63,6 +200,7 @@ type PlaylistVideo struct {
 	PlaylistID uint `json:"playlist_id" gorm:"primaryKey"`
 	VideoID    uint `json:"video_id" gorm:"primaryKey"`
 	SortOrder  int    `json:"sort_order" gorm:"default:0;index"`
+	Weight     int    `json:"weight" gorm:"default:1"` // airings per pass in weighted playlists
 	CreatedAt  time.Time `json:"created_at"`
 
 	// Relationships
@@ -70,16 +208,180 @@ type PlaylistVideo struct {
 	Video    Video    `json:"video" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
 }
 
+// AdBreak represents an ad break attached to a video in a playlist, either after
+// the video or as a mid-roll at an offset into it. A break with a placeholder video
+// reserves its duration on the timeline and plays the placeholder as the avail;
+// without one it is signalled as a splice point only.
+type AdBreak struct {
+	ID                 uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	PlaylistID         uint      `json:"playlist_id" gorm:"not null;index"`
+	VideoID            uint      `json:"video_id" gorm:"not null;index"`
+	Position           string    `json:"position" gorm:"type:enum('after','midroll');default:'after'"`
+	Offset             int       `json:"offset"`   // in seconds, mid-roll only
+	Duration           int       `json:"duration"` // in seconds
+	PlaceholderVideoID *uint     `json:"placeholder_video_id"`
+	CreatedAt          time.Time `json:"created_at"`
+
+	// Relationships
+	PlaceholderVideo *Video `json:"placeholder_video,omitempty" gorm:"foreignKey:PlaceholderVideoID;constraint:OnDelete:SET NULL"`
+}
+
+// Slate represents a "we'll be right back" card rendered once from an image and
+// silent or tone audio, then transcoded like any other video
+type Slate struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string    `json:"name" gorm:"size:255;not null"`
+	ImagePath string    `json:"image_path" gorm:"size:500"` // relative to the upload path, empty for a black card
+	Audio     string    `json:"audio" gorm:"type:enum('silence','tone');default:'silence'"`
+	Duration  int       `json:"duration"` // in seconds, the slate loops for as long as needed
+	VideoID   *uint     `json:"video_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Video *Video `json:"video,omitempty" gorm:"foreignKey:VideoID;constraint:OnDelete:SET NULL"`
+}
+
+// Channel represents a linear channel played out from its schedule
+type Channel struct {
+	ID               uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name             string    `json:"name" gorm:"size:255;not null"`
+	Description      string    `json:"description" gorm:"type:text"`
+	PlaylistID       *uint     `json:"playlist_id" gorm:"index"`        // Playlist looped whenever nothing is scheduled
+	FillerPlaylistID *uint     `json:"filler_playlist_id" gorm:"index"` // Playlist covering gaps and unavailable assets
+	SlateID          *uint     `json:"slate_id" gorm:"index"`           // Slate shown when no filler is playable
+	IsActive         bool      `json:"is_active" gorm:"default:true;index"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	// Relationships
+	Playlist        *Playlist       `json:"playlist,omitempty" gorm:"foreignKey:PlaylistID;constraint:OnDelete:SET NULL"`
+	FillerPlaylist  *Playlist       `json:"filler_playlist,omitempty" gorm:"foreignKey:FillerPlaylistID;constraint:OnDelete:SET NULL"`
+	Slate           *Slate          `json:"slate,omitempty" gorm:"foreignKey:SlateID;constraint:OnDelete:SET NULL"`
+	ScheduleEntries []ScheduleEntry `json:"schedule_entries,omitempty" gorm:"foreignKey:ChannelID;constraint:OnDelete:CASCADE"`
+}
+
+// ScheduleEntry represents a video or playlist airing on a channel at a wall-clock time
+type ScheduleEntry struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	ChannelID  uint      `json:"channel_id" gorm:"not null;index"`
+	StartTime  time.Time `json:"start_time" gorm:"not null;index"`
+	VideoID    *uint     `json:"video_id" gorm:"index"`
+	PlaylistID *uint     `json:"playlist_id" gorm:"index"`
+	InPoint    int       `json:"in_point" gorm:"default:0"`  // in seconds, video entries only
+	OutPoint   int       `json:"out_point" gorm:"default:0"` // in seconds, 0 plays to the end
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Relationships
+	Video    *Video    `json:"video,omitempty" gorm:"foreignKey:VideoID;constraint:OnDelete:CASCADE"`
+	Playlist *Playlist `json:"playlist,omitempty" gorm:"foreignKey:PlaylistID;constraint:OnDelete:CASCADE"`
+}
+
+// AsRunEntry records an item that actually aired on a channel
+type AsRunEntry struct {
+	ID                 uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	ChannelID          uint       `json:"channel_id" gorm:"not null;index"`
+	VideoID            uint       `json:"video_id" gorm:"not null;index"`
+	Title              string     `json:"title" gorm:"size:255"` // Kept so the record survives the video being deleted
+	ScheduledStart     time.Time  `json:"scheduled_start"`
+	ActualStart        time.Time  `json:"actual_start" gorm:"index"` // Air time of the first segment
+	EndedAt            *time.Time `json:"ended_at"`
+	DurationPlayed     float64    `json:"duration_played"` // in seconds
+	InterruptionReason string     `json:"interruption_reason" gorm:"size:50"`
+	ReplacedVideoID    *uint      `json:"replaced_video_id"` // Video that should have aired, when filler or slate stood in
+	SubstitutionReason string     `json:"substitution_reason" gorm:"size:50"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// OutputTarget represents a contribution feed pushed from a channel or playlist
+// to a distribution partner by a managed FFmpeg process
+type OutputTarget struct {
+	ID         uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string     `json:"name" gorm:"size:255;not null"`
+	ChannelID  *uint      `json:"channel_id" gorm:"index"`
+	PlaylistID *uint      `json:"playlist_id" gorm:"index"`
+	Protocol   string     `json:"protocol" gorm:"type:enum('rtmp','srt','udp');not null"`
+	URL        string     `json:"url" gorm:"type:text;not null"`
+	Resolution string     `json:"resolution" gorm:"size:20"` // rendition to push, empty for the highest
+	Enabled    bool       `json:"enabled" gorm:"default:true"`
+	Status     string     `json:"status" gorm:"type:enum('stopped','starting','running','restarting','failed');default:'stopped'"`
+	PodName    string     `json:"pod_name" gorm:"size:255"` // pod running the FFmpeg process
+	Restarts   int        `json:"restarts" gorm:"default:0"`
+	LastError  string     `json:"last_error" gorm:"type:text"`
+	StartedAt  *time.Time `json:"started_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// Relationships
+	Channel  *Channel  `json:"channel,omitempty" gorm:"foreignKey:ChannelID;constraint:OnDelete:CASCADE"`
+	Playlist *Playlist `json:"playlist,omitempty" gorm:"foreignKey:PlaylistID;constraint:OnDelete:CASCADE"`
+}
+
+// AdCreative represents an ad creative returned by the ad decision server, ingested
+// as a video so it is transcoded to the same ladder as the content it airs in
+type AdCreative struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	MediaHash    string    `json:"media_hash" gorm:"size:64;uniqueIndex;not null"` // SHA-256 of the media file URL
+	MediaURL     string    `json:"media_url" gorm:"type:text"`
+	AdSystem     string    `json:"ad_system" gorm:"size:255"`
+	AdTitle      string    `json:"ad_title" gorm:"size:255"`
+	Duration     float64   `json:"duration"` // in seconds, as declared by the ad server
+	VideoID      *uint     `json:"video_id"`
+	Status       string    `json:"status" gorm:"type:enum('pending','ingested','failed');default:'pending';index"`
+	ErrorMessage string    `json:"error_message" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Relationships
+	Video *Video `json:"video,omitempty" gorm:"foreignKey:VideoID;constraint:OnDelete:SET NULL"`
+}
+
+// AdDecision represents the ads chosen by the ad decision server for one airing of a break
+type AdDecision struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	ChannelID    uint      `json:"channel_id" gorm:"not null;uniqueIndex:idx_ad_decision_break"`
+	AdBreakID    uint      `json:"ad_break_id" gorm:"not null;uniqueIndex:idx_ad_decision_break"`
+	BreakStart   time.Time `json:"break_start" gorm:"uniqueIndex:idx_ad_decision_break"`
+	Duration     float64   `json:"duration"` // in seconds
+	Status       string    `json:"status" gorm:"type:enum('pending','decided','failed');default:'pending';index"`
+	Attempts     int       `json:"attempts" gorm:"default:1"` // requests to the ad decision server
+	ErrorMessage string    `json:"error_message" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Relationships
+	Ads []AdDecisionAd `json:"ads" gorm:"foreignKey:DecisionID;constraint:OnDelete:CASCADE"`
+}
+
+// AdDecisionAd represents one ad of a decision, in play order, with its beacons
+type AdDecisionAd struct {
+	ID         uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	DecisionID uint       `json:"decision_id" gorm:"not null;index"`
+	CreativeID uint       `json:"creative_id" gorm:"not null;index"`
+	Sequence   int        `json:"sequence"`
+	Duration   float64    `json:"duration"` // in seconds
+	Tracking   string     `json:"-" gorm:"type:text"` // JSON map of VAST event to beacon URLs
+	LastEvent  string     `json:"last_event" gorm:"size:20"`
+	AiredAt    *time.Time `json:"aired_at"`
+
+	// Relationships
+	Creative AdCreative `json:"creative" gorm:"foreignKey:CreativeID;constraint:OnDelete:CASCADE"`
+}
+
 // TranscodeJob represents transcoding job queue
 type TranscodeJob struct {
 	ID          uint       `json:"id" gorm:"primaryKey;autoIncrement"`
 	VideoID     uint       `json:"video_id" gorm:"not null"`
 	ProfileID   uint       `json:"profile_id" gorm:"not null"`
-	Status      string     `json:"status" gorm:"type:enum('queued','processing','completed','failed');default:'queued';index"`
+	Status      string     `json:"status" gorm:"type:enum('queued','processing','completed','failed','cancelled','retrying','dead');default:'queued';index"`
 	Priority    int        `json:"priority" gorm:"default:0;index"`
 	RetryCount  int        `json:"retry_count" gorm:"default:0"`
 	MaxRetries  int        `json:"max_retries" gorm:"default:3"`
 	ErrorMessage string    `json:"error_message" gorm:"type:text"`
+	FailureClass string    `json:"failure_class" gorm:"size:30"` // transient, missing_source or unsupported_codec
+	NextRetryAt *time.Time `json:"next_retry_at"`                // when a retrying job is queued again
 	StartedAt   *time.Time `json:"started_at"`
 	CompletedAt *time.Time `json:"completed_at"`
 	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
@@ -117,6 +419,7 @@ type VideoStatusResponse struct {
 	VideoID   uint                  `json:"video_id"`
 	Status    string                `json:"status"`
 	Progress  int                   `json:"progress"`
+	ETA       int                   `json:"eta_seconds,omitempty"` // until the slowest running profile finishes
 	Profiles  []VideoProfileStatus  `json:"profiles"`
 	CreatedAt time.Time             `json:"created_at"`
 }
@@ -127,30 +430,55 @@ type VideoProfileStatus struct {
 	Resolution  string `json:"resolution"`
 	Status      string `json:"status"`
 	Progress    int    `json:"progress_percentage"`
+	Speed       float64 `json:"speed,omitempty"`       // encode speed relative to real time
+	ETA         int     `json:"eta_seconds,omitempty"` // estimated seconds until the encode finishes
 	Error       string `json:"error_message,omitempty"`
 }
 
+// TranscodeProgress represents the live progress of a running encode, shared through Redis
+type TranscodeProgress struct {
+	Progress  int       `json:"progress_percentage"`
+	OutTime   float64   `json:"out_time"` // seconds of output written so far
+	Speed     float64   `json:"speed"`
+	ETA       int       `json:"eta_seconds"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // CreatePlaylistRequest represents playlist creation request
 type CreatePlaylistRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	VideoIDs    []uint `json:"video_ids"`
+	PlaybackRequest
+}
+
+// PlaybackRequest represents the playback mode settings of a playlist
+type PlaybackRequest struct {
+	PlaybackMode    string `json:"playback_mode" binding:"omitempty,oneof=sequential loop shuffle weighted"`
+	ShuffleSeed     int64  `json:"shuffle_seed"`
+	NoRepeatItems   int    `json:"no_repeat_items" binding:"min=0"`
+	NoRepeatMinutes int    `json:"no_repeat_minutes" binding:"min=0"`
 }
 
 // AddVideoToPlaylistRequest represents add video to playlist request
 type AddVideoToPlaylistRequest struct {
 	VideoID   uint `json:"video_id" binding:"required"`
 	SortOrder int    `json:"sort_order"`
+	Weight    int    `json:"weight" binding:"omitempty,min=1,max=100"`
 }
 
 // PlaylistResponse represents playlist response
 type PlaylistResponse struct {
-	ID          uint        `json:"id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	IsActive    bool        `json:"is_active"`
-	Videos      []VideoInfo `json:"videos"`
-	CreatedAt   time.Time   `json:"created_at"`
+	ID              uint        `json:"id"`
+	Name            string      `json:"name"`
+	Description     string      `json:"description"`
+	IsActive        bool        `json:"is_active"`
+	PlaybackMode    string      `json:"playback_mode"`
+	ShuffleSeed     int64       `json:"shuffle_seed"`
+	NoRepeatItems   int         `json:"no_repeat_items"`
+	NoRepeatMinutes int         `json:"no_repeat_minutes"`
+	Videos          []VideoInfo `json:"videos"`
+	CreatedAt       time.Time   `json:"created_at"`
 }
 
 // VideoInfo represents video information in playlist
@@ -160,6 +488,7 @@ type VideoInfo struct {
 	Duration  int    `json:"duration"`
 	Status    string `json:"status"`
 	SortOrder int    `json:"sort_order"`
+	Weight    int    `json:"weight"`
 }
 
 // HLSPlaylistResponse represents HLS playlist response
@@ -175,3 +504,151 @@ type HLSProfile struct {
 	PlaylistURL string `json:"playlist_url"`
 	Status     string `json:"status"`
 }
+
+// AsRunQuery represents as-run log filters
+type AsRunQuery struct {
+	ChannelID   uint
+	VideoID     uint
+	Substituted bool // only entries where filler or slate stood in
+	From        time.Time
+	To          time.Time
+	Limit       int
+	Offset      int
+}
+
+// TimeShiftQuery selects a catch-up view of a channel instead of its live window
+type TimeShiftQuery struct {
+	Start     time.Time // play from this wall-clock time up to the live edge
+	ProgramID uint      // as-run entry to play back from its start
+}
+
+// AdBreakRequest represents ad break creation request
+type AdBreakRequest struct {
+	Position           string `json:"position" binding:"required,oneof=after midroll"`
+	Offset             int    `json:"offset"`
+	Duration           int    `json:"duration" binding:"required,min=1"`
+	PlaceholderVideoID *uint  `json:"placeholder_video_id"`
+}
+
+// EncodingPresetRequest represents encoding preset creation and update request
+
+type EncodingPresetRequest struct {
+	Name           string                `json:"name" binding:"required"`
+	Description    string                `json:"description"`
+	IsDefault      bool                  `json:"is_default"`
+	SinglePass     bool                  `json:"single_pass"`
+	SegmentFormat  string                `json:"segment_format" binding:"omitempty,oneof=mpegts fmp4"`
+	Encrypt        bool                  `json:"encrypt"`
+	KeyRotation    int                   `json:"key_rotation" binding:"min=0"`
+	LoudnessTarget float64               `json:"loudness_target" binding:"omitempty,min=-70,max=-5"`
+	Rungs          []EncodingRungRequest `json:"rungs" binding:"required,min=1,dive"`
+}
+
+// EncodingRungRequest represents one rendition of an encoding preset request
+type EncodingRungRequest struct {
+	Resolution     string  `json:"resolution" binding:"required"`
+	CodecVideo     string  `json:"codec_video"`
+	CodecAudio     string  `json:"codec_audio"`
+	Bitrate        int     `json:"bitrate" binding:"required,min=1"`
+	MaxRate        int     `json:"max_rate" binding:"min=0"`
+	BufSize        int     `json:"buf_size" binding:"min=0"`
+	AudioBitrate   int     `json:"audio_bitrate" binding:"min=0"`
+	FrameRate      float64 `json:"frame_rate" binding:"min=0,max=120"`
+	SegmentTime    int     `json:"segment_time" binding:"min=0"`
+	EncoderPreset  string  `json:"encoder_preset" binding:"omitempty,oneof=ultrafast superfast veryfast faster fast medium slow slower veryslow placebo"`
+	EncoderProfile string  `json:"encoder_profile" binding:"omitempty,oneof=baseline main main10 high high10 high422 high444"`
+	EncoderLevel   string  `json:"encoder_level"`
+}
+
+// ChannelRequest represents channel creation and update request
+type ChannelRequest struct {
+	Name             string `json:"name" binding:"required"`
+	Description      string `json:"description"`
+	PlaylistID       *uint  `json:"playlist_id"`
+	FillerPlaylistID *uint  `json:"filler_playlist_id"`
+	SlateID          *uint  `json:"slate_id"`
+	IsActive         *bool  `json:"is_active"`
+}
+
+// OutputTargetRequest represents output target creation and update request
+type OutputTargetRequest struct {
+	Name       string `json:"name" binding:"required"`
+	ChannelID  *uint  `json:"channel_id"`
+	PlaylistID *uint  `json:"playlist_id"`
+	Protocol   string `json:"protocol" binding:"required,oneof=rtmp srt udp"`
+	URL        string `json:"url" binding:"required"`
+	Resolution string `json:"resolution"`
+	Enabled    *bool  `json:"enabled"`
+}
+
+// SubtitleRequest represents a subtitle upload (multipart form with the sidecar file)
+type SubtitleRequest struct {
+	Language string `form:"language" binding:"required"`
+	Name     string `form:"name" binding:"max=100"`
+	Default  bool   `form:"default"`
+}
+
+// AudioTrackRequest represents an audio track update; unset fields are kept
+type AudioTrackRequest struct {
+	Name     *string `json:"name" binding:"omitempty,max=100"`
+	Language *string `json:"language"`
+	Enabled  *bool   `json:"enabled"`
+	Default  *bool   `json:"default"`
+}
+
+// AudioTrackOrderRequest lists every audio track of a video in its new order
+type AudioTrackOrderRequest struct {
+	TrackIDs []uint `json:"track_ids" binding:"required,min=1"`
+}
+
+// SlateRequest represents slate creation request, sent as a multipart form with an optional image
+type SlateRequest struct {
+	Name     string `form:"name" binding:"required"`
+	Audio    string `form:"audio" binding:"omitempty,oneof=silence tone"`
+	Duration int    `form:"duration" binding:"omitempty,min=1,max=60"`
+}
+
+// ScheduleEntryRequest represents schedule entry creation and update request
+type ScheduleEntryRequest struct {
+	StartTime  time.Time `json:"start_time" binding:"required"`
+	VideoID    *uint     `json:"video_id"`
+	PlaylistID *uint     `json:"playlist_id"`
+	InPoint    int       `json:"in_point"`
+	OutPoint   int       `json:"out_point"`
+}
+
+// ScheduleIssue represents an overlap, gap or unplayable asset found in a schedule
+type ScheduleIssue struct {
+	Type     string    `json:"type"` // overlap, gap or asset_not_ready
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	EntryIDs []uint    `json:"entry_ids"`
+	Message  string    `json:"message"`
+}
+
+// ScheduleValidationResponse represents schedule validation response
+type ScheduleValidationResponse struct {
+	ChannelID uint            `json:"channel_id"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Valid     bool            `json:"valid"`
+	Issues    []ScheduleIssue `json:"issues"`
+}
+
+// EPGProgramme represents a programme in an electronic program guide
+type EPGProgramme struct {
+	VideoID  uint      `json:"video_id"`
+	Title    string    `json:"title"`
+	Start    time.Time `json:"start"`
+	Stop     time.Time `json:"stop"`
+	Duration int       `json:"duration"` // in seconds
+}
+
+// EPGResponse represents the program guide of a channel or playlist
+type EPGResponse struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Programmes []EPGProgramme `json:"programmes"`
+}
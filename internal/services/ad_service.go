@@ -0,0 +1,681 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxVASTResponseSize bounds how much of an ad server response is read
+const maxVASTResponseSize = 1 << 20
+
+// maxDecisionAttempts bounds how often the ad decision server is asked to fill one airing of a break
+const maxDecisionAttempts = 3
+
+// decisionRetryDelay is how long a failed decision waits before it is requested again
+const decisionRetryDelay = 30 * time.Second
+
+// creativeIngestTimeout is how long a creative may stay pending before its ingest is
+// taken over, as the pod downloading it went away; longer than any download
+const creativeIngestTimeout = 15 * time.Minute
+
+// adMilestones are the VAST linear tracking events fired as an ad airs, with the
+// fraction of the ad that must have aired before each one fires
+var adMilestones = []struct {
+	Event    string
+	Fraction float64
+}{
+	{"start", 0},
+	{"firstQuartile", 0.25},
+	{"midpoint", 0.5},
+	{"thirdQuartile", 0.75},
+	{"complete", 1},
+}
+
+// AdService inserts ads server-side: it asks the ad decision server to fill upcoming
+// breaks, ingests the returned creatives through the regular upload and transcode
+// pipeline, and fires tracking beacons as the ads air.
+type AdService struct {
+	db             *gorm.DB
+	config         *config.Config
+	uploadService  *UploadService
+	playoutService *PlayoutService
+	client         *http.Client
+	downloadClient *http.Client
+	stopChan       chan bool
+}
+
+// adCandidate is a linear ad returned by the ad decision server
+type adCandidate struct {
+	AdSystem string
+	AdTitle  string
+	MediaURL string
+	Duration float64
+	Tracking map[string][]string
+}
+
+// breakAd is a decided ad ready to air in a break
+type breakAd struct {
+	DecisionAdID uint    `json:"decision_ad_id"`
+	VideoID      uint    `json:"video_id"`
+	Duration     float64 `json:"duration"`
+}
+
+func NewAdService(db *gorm.DB, cfg *config.Config, uploadService *UploadService, playoutService *PlayoutService) *AdService {
+	return &AdService{
+		db:             db,
+		config:         cfg,
+		uploadService:  uploadService,
+		playoutService: playoutService,
+		client:         &http.Client{Timeout: time.Duration(cfg.AdDecision.Timeout) * time.Second},
+		downloadClient: &http.Client{Timeout: 10 * time.Minute},
+		stopChan:       make(chan bool),
+	}
+}
+
+// Start prepares upcoming ad breaks until Stop is called
+func (s *AdService) Start() {
+	if s.config.AdDecision.URL == "" {
+		logrus.Info("Server-side ad insertion disabled: no ad decision server configured")
+		return
+	}
+
+	logrus.Info("Starting ad decision loop...")
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	s.prepareUpcomingBreaks(time.Now())
+	for {
+		select {
+		case <-s.stopChan:
+			logrus.Info("Stopping ad decision loop...")
+			return
+		case <-ticker.C:
+			s.prepareUpcomingBreaks(time.Now())
+		}
+	}
+}
+
+// Stop stops the ad decision loop
+func (s *AdService) Stop() {
+	close(s.stopChan)
+}
+
+// prepareUpcomingBreaks requests ads for every break of an active channel airing within the lookahead
+func (s *AdService) prepareUpcomingBreaks(now time.Time) {
+	var channels []models.Channel
+	if err := s.db.Where("is_active = ?", true).Find(&channels).Error; err != nil {
+		logrus.Errorf("Failed to load channels for ad decisions: %v", err)
+		return
+	}
+
+	lookahead := time.Duration(s.config.AdDecision.Lookahead) * time.Second
+	for i := range channels {
+		channel := &channels[i]
+		items := s.playoutService.buildTimeline(channel, now, now.Add(lookahead))
+		for j := range items {
+			item := &items[j]
+			if item.Break == nil || !item.Start.After(now) {
+				continue
+			}
+			if err := s.prepareBreak(channel.ID, item, s.surroundingLadder(items, j)); err != nil {
+				logrus.Errorf("Failed to prepare ad break %d on channel %d: %v", item.Break.ID, channel.ID, err)
+			}
+		}
+	}
+}
+
+// prepareBreak fills one airing of a break from the ad decision server. The unique
+// decision per airing lets a single pod claim it. Failed decisions are claimed
+// again, up to maxDecisionAttempts times, while the break is still upcoming. A
+// decision whose creatives are not all ingested yet fails so that it is retried,
+// until its last attempt airs the ads that are ready.
+func (s *AdService) prepareBreak(channelID uint, item *timelineItem, ladder []models.VideoProfile) error {
+	decision := &models.AdDecision{
+		ChannelID:  channelID,
+		AdBreakID:  item.Break.ID,
+		BreakStart: breakAiring(item),
+		Duration:   item.Duration.Seconds(),
+		Status:     "pending",
+		Attempts:   1,
+	}
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(decision)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		claimed, err := s.claimFailedDecision(decision)
+		if err != nil || !claimed {
+			return err
+		}
+		logrus.Infof("Retrying ad decision %d for break %d on channel %d (attempt %d)",
+			decision.ID, item.Break.ID, channelID, decision.Attempts)
+	}
+
+	candidates, err := s.requestAds(channelID, item)
+	if err != nil {
+		s.db.Model(decision).Updates(map[string]interface{}{"status": "failed", "error_message": err.Error()})
+		return err
+	}
+
+	notReady := 0
+	for i, candidate := range candidates {
+		creative, err := s.ensureCreative(&candidate, ladder)
+		if err != nil {
+			logrus.Errorf("Failed to ingest ad creative %s: %v", candidate.MediaURL, err)
+			notReady++
+			continue
+		}
+
+		tracking, err := json.Marshal(candidate.Tracking)
+		if err != nil {
+			return err
+		}
+
+		ad := &models.AdDecisionAd{
+			DecisionID: decision.ID,
+			CreativeID: creative.ID,
+			Sequence:   i,
+			Duration:   candidate.Duration,
+			Tracking:   string(tracking),
+		}
+		if err := s.db.Create(ad).Error; err != nil {
+			s.db.Model(decision).Updates(map[string]interface{}{"status": "failed", "error_message": err.Error()})
+			return err
+		}
+	}
+
+	if notReady > 0 && decision.Attempts < maxDecisionAttempts {
+		message := fmt.Sprintf("%d of %d creatives are not ingested yet", notReady, len(candidates))
+		logrus.Infof("Ad decision %d for break %d on channel %d: %s, retrying", decision.ID, item.Break.ID, channelID, message)
+		return s.db.Model(decision).Updates(map[string]interface{}{"status": "failed", "error_message": message}).Error
+	}
+
+	logrus.Infof("Ad decision %d: %d ads for break %d on channel %d at %s",
+		decision.ID, len(candidates)-notReady, item.Break.ID, channelID, item.Start.Format(time.RFC3339))
+	return s.db.Model(decision).Update("status", "decided").Error
+}
+
+// claimFailedDecision claims a failed decision for another request once it has
+// waited decisionRetryDelay, loading it into decision. It reports false when the
+// decision is decided, being requested or out of attempts.
+func (s *AdService) claimFailedDecision(decision *models.AdDecision) (bool, error) {
+	airing := s.db.Where("channel_id = ? AND ad_break_id = ? AND break_start = ?",
+		decision.ChannelID, decision.AdBreakID, decision.BreakStart).Session(&gorm.Session{})
+
+	result := airing.Model(&models.AdDecision{}).
+		Where("status = ? AND attempts < ? AND updated_at < ?", "failed", maxDecisionAttempts, time.Now().Add(-decisionRetryDelay)).
+		Updates(map[string]interface{}{
+			"status":        "pending",
+			"error_message": "",
+			"attempts":      gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if err := airing.First(decision).Error; err != nil {
+		return false, err
+	}
+	// Ads stored by an attempt that failed part way are replaced
+	if err := s.db.Where("decision_id = ?", decision.ID).Delete(&models.AdDecisionAd{}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// requestAds asks the ad decision server for ads fitting a break
+func (s *AdService) requestAds(channelID uint, item *timelineItem) ([]adCandidate, error) {
+	duration := item.Duration.Seconds()
+	replacer := strings.NewReplacer(
+		"[CHANNEL_ID]", strconv.FormatUint(uint64(channelID), 10),
+		"[BREAK_ID]", strconv.FormatUint(uint64(item.Break.ID), 10),
+		"[DURATION]", strconv.Itoa(int(duration)),
+		"[CACHEBUSTING]", strconv.FormatInt(time.Now().UnixNano()%100000000, 10),
+	)
+
+	vast, err := s.fetchVAST(replacer.Replace(s.config.AdDecision.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := s.collectAds(vast, nil, 0)
+
+	// Keep ads in order while they fit in the break
+	var selected []adCandidate
+	remaining := duration
+	for _, candidate := range candidates {
+		if candidate.Duration > remaining {
+			continue
+		}
+		selected = append(selected, candidate)
+		remaining -= candidate.Duration
+	}
+	return selected, nil
+}
+
+// fetchVAST retrieves and parses a VAST document; an empty response means no ads
+func (s *AdService) fetchVAST(tagURL string) (*utils.VAST, error) {
+	resp, err := s.client.Get(tagURL)
+	if err != nil {
+		return nil, fmt.Errorf("ad server request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return &utils.VAST{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ad server returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxVASTResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ad server response: %w", err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return &utils.VAST{}, nil
+	}
+
+	return utils.ParseVAST(data)
+}
+
+// collectAds flattens a VAST response into linear ads in play order, following
+// wrappers and carrying their impressions and tracking events down to the inline ads
+func (s *AdService) collectAds(vast *utils.VAST, inherited map[string][]string, depth int) []adCandidate {
+	ads := make([]utils.VASTAd, len(vast.Ads))
+	copy(ads, vast.Ads)
+	// Ads of a pod play in sequence order; standalone ads follow
+	sort.SliceStable(ads, func(i, j int) bool {
+		if ads[i].Sequence == 0 || ads[j].Sequence == 0 {
+			return ads[j].Sequence == 0 && ads[i].Sequence != 0
+		}
+		return ads[i].Sequence < ads[j].Sequence
+	})
+
+	var candidates []adCandidate
+	for _, ad := range ads {
+		if ad.Wrapper != nil {
+			if depth >= s.config.AdDecision.MaxWrappers || ad.Wrapper.VASTAdTagURI == "" {
+				logrus.Warnf("Skipping VAST wrapper %s: too deep or missing ad tag", ad.ID)
+				continue
+			}
+
+			tracking := mergeTracking(inherited, ad.Wrapper.Impressions, ad.Wrapper.Creatives)
+			wrapped, err := s.fetchVAST(ad.Wrapper.VASTAdTagURI)
+			if err != nil {
+				logrus.Warnf("Skipping VAST wrapper %s: %v", ad.ID, err)
+				continue
+			}
+			candidates = append(candidates, s.collectAds(wrapped, tracking, depth+1)...)
+			continue
+		}
+
+		if ad.InLine == nil {
+			continue
+		}
+
+		for _, creative := range ad.InLine.Creatives {
+			if creative.Linear == nil {
+				continue
+			}
+
+			duration, err := utils.ParseVASTDuration(creative.Linear.Duration)
+			if err != nil || duration <= 0 {
+				logrus.Warnf("Skipping VAST ad %s: %v", ad.ID, err)
+				continue
+			}
+
+			mediaURL := selectMediaFile(creative.Linear.MediaFiles)
+			if mediaURL == "" {
+				logrus.Warnf("Skipping VAST ad %s: no progressive video media file", ad.ID)
+				continue
+			}
+
+			candidates = append(candidates, adCandidate{
+				AdSystem: strings.TrimSpace(ad.InLine.AdSystem),
+				AdTitle:  strings.TrimSpace(ad.InLine.AdTitle),
+				MediaURL: mediaURL,
+				Duration: duration,
+				Tracking: mergeTracking(inherited, ad.InLine.Impressions, []utils.VASTCreative{creative}),
+			})
+			// One linear creative per ad
+			break
+		}
+	}
+
+	return candidates
+}
+
+// mergeTracking adds impressions and linear tracking events to inherited beacons
+func mergeTracking(inherited map[string][]string, impressions []string, creatives []utils.VASTCreative) map[string][]string {
+	tracking := make(map[string][]string)
+	for event, urls := range inherited {
+		tracking[event] = append([]string{}, urls...)
+	}
+
+	for _, impression := range impressions {
+		if impression != "" {
+			tracking["impression"] = append(tracking["impression"], impression)
+		}
+	}
+	for _, creative := range creatives {
+		if creative.Linear == nil {
+			continue
+		}
+		for _, event := range creative.Linear.TrackingEvents {
+			if event.URL != "" {
+				tracking[event.Event] = append(tracking[event.Event], event.URL)
+			}
+		}
+	}
+	return tracking
+}
+
+// selectMediaFile picks the highest quality progressive video file to transcode from
+func selectMediaFile(files []utils.VASTMediaFile) string {
+	best := -1
+	for i, file := range files {
+		if file.URL == "" || (file.Delivery != "" && file.Delivery != "progressive") {
+			continue
+		}
+		if file.Type != "" && !strings.HasPrefix(file.Type, "video/") {
+			continue
+		}
+		if best < 0 || file.Height > files[best].Height ||
+			(file.Height == files[best].Height && file.Bitrate > files[best].Bitrate) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return files[best].URL
+}
+
+// ensureCreative returns the creative for a media file, downloading and ingesting it
+// the first time. Failed ingests are tried again, and ingests left pending past
+// creativeIngestTimeout are taken over; creatives another pod is still ingesting
+// are not ready yet.
+func (s *AdService) ensureCreative(candidate *adCandidate, ladder []models.VideoProfile) (*models.AdCreative, error) {
+	sum := sha256.Sum256([]byte(candidate.MediaURL))
+	hash := hex.EncodeToString(sum[:])
+
+	creative := &models.AdCreative{
+		MediaHash: hash,
+		MediaURL:  candidate.MediaURL,
+		AdSystem:  candidate.AdSystem,
+		AdTitle:   candidate.AdTitle,
+		Duration:  candidate.Duration,
+		Status:    "pending",
+	}
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(creative)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already known; the same creative is reused across breaks
+		if err := s.db.Where("media_hash = ?", hash).First(creative).Error; err != nil {
+			return nil, err
+		}
+		if creative.Status == "ingested" {
+			return creative, nil
+		}
+
+		claim := s.db.Model(&models.AdCreative{}).
+			Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))",
+				creative.ID, "failed", "pending", time.Now().Add(-creativeIngestTimeout)).
+			Updates(map[string]interface{}{"status": "pending", "error_message": ""})
+		if claim.Error != nil {
+			return nil, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return nil, fmt.Errorf("creative %d is not ready: %s", creative.ID, creative.Status)
+		}
+		creative.Status = "pending"
+		logrus.Infof("Retrying ingest of ad creative %d from %s", creative.ID, candidate.MediaURL)
+	}
+
+	filePath, err := s.downloadCreative(candidate.MediaURL, hash)
+	if err != nil {
+		s.db.Model(creative).Updates(map[string]interface{}{"status": "failed", "error_message": err.Error()})
+		return nil, err
+	}
+
+	video, err := s.uploadService.IngestFile(filePath, filepath.Base(filePath), ladder)
+	if err != nil {
+		os.Remove(filePath)
+		s.db.Model(creative).Updates(map[string]interface{}{"status": "failed", "error_message": err.Error()})
+		return nil, err
+	}
+
+	creative.VideoID = &video.ID
+	creative.Status = "ingested"
+	if err := s.db.Model(creative).Updates(map[string]interface{}{"video_id": video.ID, "status": "ingested"}).Error; err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Ingested ad creative %d as video %d from %s", creative.ID, video.ID, candidate.MediaURL)
+	return creative, nil
+}
+
+// downloadCreative stores an ad media file under the upload path
+func (s *AdService) downloadCreative(mediaURL, hash string) (string, error) {
+	ext := ".mp4"
+	if parsed, err := url.Parse(mediaURL); err == nil && path.Ext(parsed.Path) != "" {
+		ext = strings.ToLower(path.Ext(parsed.Path))
+	}
+
+	adsDir := filepath.Join(s.config.Storage.UploadPath, "ads")
+	if err := os.MkdirAll(adsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create ads directory: %w", err)
+	}
+
+	resp, err := s.downloadClient.Get(mediaURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download creative: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("creative download returned status %d", resp.StatusCode)
+	}
+
+	filePath := filepath.Join(adsDir, fmt.Sprintf("ad_%s%s", hash[:16], ext))
+	tmpPath := filePath + ".part"
+
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create creative file: %w", err)
+	}
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to download creative: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return filePath, nil
+}
+
+// surroundingLadder returns the profiles of the content around a break, so ads
+// are transcoded to the same renditions as what they are stitched into
+func (s *AdService) surroundingLadder(items []timelineItem, index int) []models.VideoProfile {
+	candidates := make([]uint, 0, 2)
+	for i := index - 1; i >= 0; i-- {
+		if items[i].Break == nil {
+			candidates = append(candidates, items[i].VideoID)
+			break
+		}
+	}
+	for i := index + 1; i < len(items); i++ {
+		if items[i].Break == nil {
+			candidates = append(candidates, items[i].VideoID)
+			break
+		}
+	}
+
+	for _, videoID := range candidates {
+		var profiles []models.VideoProfile
+		if err := s.db.Where("video_id = ? AND status = ?", videoID, "completed").
+			Order("bitrate DESC").
+			Find(&profiles).Error; err != nil || len(profiles) == 0 {
+			continue
+		}
+
+		ladder := make([]models.VideoProfile, 0, len(profiles))
+		for _, profile := range profiles {
+			ladder = append(ladder, models.VideoProfile{
+				Resolution:     profile.Resolution,
+				CodecVideo:     profile.CodecVideo,
+				CodecAudio:     profile.CodecAudio,
+				Bitrate:        profile.Bitrate,
+				MaxRate:        profile.MaxRate,
+				BufSize:        profile.BufSize,
+				AudioBitrate:   profile.AudioBitrate,
+				FrameRate:      profile.FrameRate,
+				SegmentTime:    profile.SegmentTime,
+				EncoderPreset:  profile.EncoderPreset,
+				EncoderProfile: profile.EncoderProfile,
+				EncoderLevel:   profile.EncoderLevel,
+				SegmentFormat:  profile.SegmentFormat,
+			})
+		}
+		return ladder
+	}
+
+	return nil
+}
+
+// BreakAds returns the decided ads of a break airing whose creatives finished transcoding
+func (s *AdService) BreakAds(channelID uint, item *timelineItem) []breakAd {
+	var decision models.AdDecision
+	if err := s.db.Preload("Ads", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence ASC")
+	}).Preload("Ads.Creative.Video").
+		Where("channel_id = ? AND ad_break_id = ? AND break_start = ? AND status = ?",
+			channelID, item.Break.ID, breakAiring(item), "decided").
+		First(&decision).Error; err != nil {
+		return nil
+	}
+
+	var ads []breakAd
+	for _, ad := range decision.Ads {
+		video := ad.Creative.Video
+		if video == nil || video.Status != "completed" {
+			logrus.Warnf("Ad creative %d is not ready for break %d on channel %d", ad.CreativeID, item.Break.ID, channelID)
+			continue
+		}
+		ads = append(ads, breakAd{DecisionAdID: ad.ID, VideoID: video.ID, Duration: ad.Duration})
+	}
+	return ads
+}
+
+// TrackAdEvent records a tracking event of an airing ad and fires its beacons.
+// The start event also counts as the ad's impression.
+func (s *AdService) TrackAdEvent(decisionAdID uint, event string) {
+	var ad models.AdDecisionAd
+	if err := s.db.First(&ad, decisionAdID).Error; err != nil {
+		logrus.Errorf("Failed to load ad %d for tracking: %v", decisionAdID, err)
+		return
+	}
+
+	updates := map[string]interface{}{"last_event": event}
+	if event == "start" {
+		now := time.Now()
+		updates["aired_at"] = &now
+	}
+	if err := s.db.Model(&ad).Updates(updates).Error; err != nil {
+		logrus.Errorf("Failed to record %s event for ad %d: %v", event, decisionAdID, err)
+	}
+
+	var tracking map[string][]string
+	if err := json.Unmarshal([]byte(ad.Tracking), &tracking); err != nil {
+		logrus.Errorf("Invalid tracking events for ad %d: %v", decisionAdID, err)
+		return
+	}
+
+	beacons := tracking[event]
+	if event == "start" {
+		beacons = append(append([]string{}, tracking["impression"]...), beacons...)
+	}
+
+	for _, beacon := range beacons {
+		go s.fireBeacon(beacon)
+	}
+}
+
+// fireBeacon sends a tracking request, logging failures
+func (s *AdService) fireBeacon(beaconURL string) {
+	resp, err := s.client.Get(beaconURL)
+	if err != nil {
+		logrus.Warnf("Ad beacon %s failed: %v", beaconURL, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		logrus.Warnf("Ad beacon %s returned status %d", beaconURL, resp.StatusCode)
+	}
+}
+
+// GetDecisions retrieves recent ad decisions of a channel, newest first
+func (s *AdService) GetDecisions(channelID uint, limit int) ([]models.AdDecision, error) {
+	decisions := []models.AdDecision{}
+	db := s.db.Preload("Ads", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence ASC")
+	}).Preload("Ads.Creative")
+	if channelID != 0 {
+		db = db.Where("channel_id = ?", channelID)
+	}
+
+	if err := db.Order("break_start DESC").Limit(limit).Find(&decisions).Error; err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// GetCreatives retrieves every known ad creative
+func (s *AdService) GetCreatives() ([]models.AdCreative, error) {
+	creatives := []models.AdCreative{}
+	if err := s.db.Preload("Video").Order("created_at DESC").Find(&creatives).Error; err != nil {
+		return nil, err
+	}
+	return creatives, nil
+}
+
+// breakAiring identifies one airing of a break by its start, at the precision stored in the database
+func breakAiring(item *timelineItem) time.Time {
+	return item.Start.Truncate(time.Millisecond)
+}
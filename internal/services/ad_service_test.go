@@ -0,0 +1,406 @@
+package services
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"linier-channel/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const vast3Inline = `<?xml version="1.0" encoding="UTF-8"?>
+<VAST version="3.0">
+  <Ad id="second" sequence="2">
+    <InLine>
+      <AdSystem>Test Server</AdSystem>
+      <AdTitle>Second</AdTitle>
+      <Impression><![CDATA[ http://beacons/second/impression ]]></Impression>
+      <Creatives>
+        <Creative>
+          <Linear>
+            <Duration>00:00:15</Duration>
+            <MediaFiles>
+              <MediaFile delivery="progressive" type="video/mp4" width="1280" height="720" bitrate="2000">http://media/second.mp4</MediaFile>
+            </MediaFiles>
+          </Linear>
+        </Creative>
+      </Creatives>
+    </InLine>
+  </Ad>
+  <Ad id="first" sequence="1">
+    <InLine>
+      <AdSystem>Test Server</AdSystem>
+      <AdTitle>First</AdTitle>
+      <Impression>http://beacons/first/impression</Impression>
+      <Creatives>
+        <Creative>
+          <Linear>
+            <Duration>00:00:10.500</Duration>
+            <TrackingEvents>
+              <Tracking event="start">http://beacons/first/start</Tracking>
+              <Tracking event="complete">http://beacons/first/complete</Tracking>
+            </TrackingEvents>
+            <MediaFiles>
+              <MediaFile delivery="streaming" type="application/x-mpegURL" height="1080">http://media/first.m3u8</MediaFile>
+              <MediaFile delivery="progressive" type="video/mp4" width="640" height="360" bitrate="800">http://media/first-360.mp4</MediaFile>
+              <MediaFile delivery="progressive" type="video/mp4" width="1920" height="1080" bitrate="5000">http://media/first-1080.mp4</MediaFile>
+            </MediaFiles>
+          </Linear>
+        </Creative>
+      </Creatives>
+    </InLine>
+  </Ad>
+</VAST>`
+
+const vast4Inline = `<VAST version="4.1" xmlns="http://www.iab.com/VAST">
+  <Ad id="only">
+    <InLine>
+      <AdSystem version="4.1">Test Server</AdSystem>
+      <AdServingId>a532d16d-4d7f-4440-bd29-2ec0e693fc80</AdServingId>
+      <AdTitle>Only</AdTitle>
+      <Impression id="imp">http://beacons/only/impression</Impression>
+      <Creatives>
+        <Creative id="companion">
+          <CompanionAds></CompanionAds>
+        </Creative>
+        <Creative id="linear" adId="only-linear">
+          <UniversalAdId idRegistry="Ad-ID">8465</UniversalAdId>
+          <Linear>
+            <Duration>00:00:30</Duration>
+            <TrackingEvents>
+              <Tracking event="midpoint">http://beacons/only/midpoint</Tracking>
+            </TrackingEvents>
+            <MediaFiles>
+              <Mezzanine delivery="progressive" type="video/mp4" width="1920" height="1080">http://media/only-mezzanine.mp4</Mezzanine>
+              <MediaFile delivery="progressive" type="video/mp4" width="1280" height="720" bitrate="3000">http://media/only-720.mp4</MediaFile>
+            </MediaFiles>
+          </Linear>
+        </Creative>
+      </Creatives>
+    </InLine>
+  </Ad>
+</VAST>`
+
+const vastWrapper = `<VAST version="3.0">
+  <Ad id="wrapper">
+    <Wrapper>
+      <AdSystem>Exchange</AdSystem>
+      <VASTAdTagURI>%s/inline</VASTAdTagURI>
+      <Impression>http://beacons/wrapper/impression</Impression>
+      <Creatives>
+        <Creative>
+          <Linear>
+            <TrackingEvents>
+              <Tracking event="complete">http://beacons/wrapper/complete</Tracking>
+            </TrackingEvents>
+          </Linear>
+        </Creative>
+      </Creatives>
+    </Wrapper>
+  </Ad>
+</VAST>`
+
+// newTestAdService returns an ad service asking the ad decision server at url
+func newTestAdService(url string) *AdService {
+	cfg := &config.Config{}
+	cfg.AdDecision.URL = url
+	cfg.AdDecision.Timeout = 5
+	cfg.AdDecision.MaxWrappers = 3
+	return NewAdService(nil, cfg, nil, nil)
+}
+
+func TestRequestAds(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		duration time.Duration
+		want     []adCandidate
+	}{
+		{
+			name:     "VAST 3 pod in sequence order",
+			status:   http.StatusOK,
+			body:     vast3Inline,
+			duration: 30 * time.Second,
+			want: []adCandidate{
+				{
+					AdSystem: "Test Server",
+					AdTitle:  "First",
+					MediaURL: "http://media/first-1080.mp4",
+					Duration: 10.5,
+					Tracking: map[string][]string{
+						"impression": {"http://beacons/first/impression"},
+						"start":      {"http://beacons/first/start"},
+						"complete":   {"http://beacons/first/complete"},
+					},
+				},
+				{
+					AdSystem: "Test Server",
+					AdTitle:  "Second",
+					MediaURL: "http://media/second.mp4",
+					Duration: 15,
+					Tracking: map[string][]string{"impression": {"http://beacons/second/impression"}},
+				},
+			},
+		},
+		{
+			name:     "VAST 3 pod trimmed to the break",
+			status:   http.StatusOK,
+			body:     vast3Inline,
+			duration: 20 * time.Second,
+			want: []adCandidate{
+				{
+					AdSystem: "Test Server",
+					AdTitle:  "First",
+					MediaURL: "http://media/first-1080.mp4",
+					Duration: 10.5,
+					Tracking: map[string][]string{
+						"impression": {"http://beacons/first/impression"},
+						"start":      {"http://beacons/first/start"},
+						"complete":   {"http://beacons/first/complete"},
+					},
+				},
+			},
+		},
+		{
+			name:     "VAST 4 inline",
+			status:   http.StatusOK,
+			body:     vast4Inline,
+			duration: 30 * time.Second,
+			want: []adCandidate{
+				{
+					AdSystem: "Test Server",
+					AdTitle:  "Only",
+					MediaURL: "http://media/only-720.mp4",
+					Duration: 30,
+					Tracking: map[string][]string{
+						"impression": {"http://beacons/only/impression"},
+						"midpoint":   {"http://beacons/only/midpoint"},
+					},
+				},
+			},
+		},
+		{
+			name:     "wrapper tracking carried to the inline ad",
+			status:   http.StatusOK,
+			body:     vastWrapper,
+			duration: 30 * time.Second,
+			want: []adCandidate{
+				{
+					AdSystem: "Test Server",
+					AdTitle:  "Only",
+					MediaURL: "http://media/only-720.mp4",
+					Duration: 30,
+					Tracking: map[string][]string{
+						"impression": {"http://beacons/wrapper/impression", "http://beacons/only/impression"},
+						"complete":   {"http://beacons/wrapper/complete"},
+						"midpoint":   {"http://beacons/only/midpoint"},
+					},
+				},
+			},
+		},
+		{name: "no content", status: http.StatusNoContent, duration: 30 * time.Second},
+		{name: "empty body", status: http.StatusOK, body: "  \n", duration: 30 * time.Second},
+		{name: "empty VAST", status: http.StatusOK, body: `<VAST version="4.0"/>`, duration: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			var query string
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/inline" {
+					w.Write([]byte(vast4Inline))
+					return
+				}
+				query = r.URL.RawQuery
+				w.WriteHeader(tt.status)
+				w.Write([]byte(strings.ReplaceAll(tt.body, "%s", server.URL)))
+			}))
+			defer server.Close()
+
+			s := newTestAdService(server.URL + "/vast?channel=[CHANNEL_ID]&break=[BREAK_ID]&duration=[DURATION]")
+			item := &timelineItem{Duration: tt.duration, Break: &breakInfo{ID: 7, Duration: tt.duration}}
+
+			got, err := s.requestAds(4, item)
+			if err != nil {
+				t.Fatalf("requestAds() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestAds() = %+v, want %+v", got, tt.want)
+			}
+			if want := fmt.Sprintf("channel=4&break=7&duration=%d", int(tt.duration.Seconds())); query != want {
+				t.Errorf("ad server query = %q, want %q", query, want)
+			}
+		})
+	}
+}
+
+func TestRequestAdsServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	s := newTestAdService(server.URL)
+	item := &timelineItem{Duration: 30 * time.Second, Break: &breakInfo{ID: 7}}
+	if _, err := s.requestAds(4, item); err == nil {
+		t.Error("requestAds() error = nil, want the ad server failure")
+	}
+}
+
+func TestEnsureCreativeSkipsCreativesNotReady(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		wantErr   bool
+		wantClaim bool
+	}{
+		{name: "ingested", status: "ingested"},
+		{name: "pending on another pod", status: "pending", wantErr: true, wantClaim: true},
+		{name: "failed and claimed elsewhere", status: "failed", wantErr: true, wantClaim: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, store := newTestDB(t)
+			store.answer("INSERT INTO `ad_creatives`", testResult{rowsAffected: 0})
+			store.answer("FROM `ad_creatives`", testResult{
+				columns: []string{"id", "media_hash", "status"},
+				rows:    [][]driver.Value{{int64(9), "hash", tt.status}},
+			})
+			// Another pod holds the ingest
+			store.answer("UPDATE `ad_creatives`", testResult{rowsAffected: 0})
+
+			s := newTestAdService("")
+			s.db = db
+			creative, err := s.ensureCreative(&adCandidate{MediaURL: "http://media/ad.mp4", Duration: 15}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureCreative() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && creative.ID != 9 {
+				t.Errorf("ensureCreative() = creative %d, want 9", creative.ID)
+			}
+
+			claims := store.statements("UPDATE `ad_creatives`")
+			if (len(claims) > 0) != tt.wantClaim {
+				t.Errorf("got %d claims, want claim %v", len(claims), tt.wantClaim)
+			}
+			for _, claim := range claims {
+				if !strings.Contains(claim.query, "status = ? OR (status = ? AND updated_at < ?)") {
+					t.Errorf("claim %q is not guarded by status", claim.query)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareBreakRetriesFailedDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		claimed  int64
+		wantCall bool
+	}{
+		{name: "failed decision due for retry", claimed: 1, wantCall: true},
+		{name: "decided, pending or out of attempts", claimed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			db, store := newTestDB(t)
+			store.answer("INSERT INTO `ad_decisions`", testResult{rowsAffected: 0})
+			store.answer("UPDATE `ad_decisions` SET `attempts`", testResult{rowsAffected: tt.claimed})
+			store.answer("FROM `ad_decisions`", testResult{
+				columns: []string{"id", "status", "attempts"},
+				rows:    [][]driver.Value{{int64(5), "pending", int64(2)}},
+			})
+
+			s := newTestAdService(server.URL)
+			s.db = db
+			item := &timelineItem{Start: time.Now().Add(time.Minute), Duration: 30 * time.Second, Break: &breakInfo{ID: 7}}
+			if err := s.prepareBreak(4, item, nil); err != nil {
+				t.Fatalf("prepareBreak() error = %v", err)
+			}
+			if called != tt.wantCall {
+				t.Errorf("ad server called = %v, want %v", called, tt.wantCall)
+			}
+
+			claims := store.statements("UPDATE `ad_decisions` SET `attempts`=attempts + 1")
+			if len(claims) != 1 || !strings.Contains(claims[0].query, "status = ? AND attempts < ?") {
+				t.Errorf("claims = %+v, want one guarded by status and attempts", claims)
+			}
+		})
+	}
+}
+
+func TestPrepareBreakWaitsForCreatives(t *testing.T) {
+	tests := []struct {
+		name       string
+		creative   string // status of the break's only creative
+		attempts   int64  // of a failed decision claimed again, 0 for a new decision
+		wantStatus string
+	}{
+		{name: "creative ingested", creative: "ingested", wantStatus: "decided"},
+		{name: "creative ingesting on another pod", creative: "pending", wantStatus: "failed"},
+		{name: "creative still ingesting on a retry", creative: "pending", attempts: maxDecisionAttempts - 1, wantStatus: "failed"},
+		{name: "creative still ingesting on the last attempt", creative: "pending", attempts: maxDecisionAttempts, wantStatus: "decided"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(vast4Inline))
+			}))
+			defer server.Close()
+
+			db, store := newTestDB(t)
+			if tt.attempts > 0 {
+				store.answer("INSERT INTO `ad_decisions`", testResult{rowsAffected: 0})
+				store.answer("FROM `ad_decisions`", testResult{
+					columns: []string{"id", "status", "attempts"},
+					rows:    [][]driver.Value{{int64(5), "pending", tt.attempts}},
+				})
+			}
+			store.answer("INSERT INTO `ad_creatives`", testResult{rowsAffected: 0})
+			store.answer("FROM `ad_creatives`", testResult{
+				columns: []string{"id", "media_hash", "status", "video_id"},
+				rows:    [][]driver.Value{{int64(9), "hash", tt.creative, int64(3)}},
+			})
+			// Another pod holds the ingest
+			store.answer("UPDATE `ad_creatives`", testResult{rowsAffected: 0})
+
+			s := newTestAdService(server.URL)
+			s.db = db
+			item := &timelineItem{Start: time.Now().Add(time.Minute), Duration: 30 * time.Second, Break: &breakInfo{ID: 7}}
+			if err := s.prepareBreak(4, item, nil); err != nil {
+				t.Fatalf("prepareBreak() error = %v", err)
+			}
+
+			status := ""
+			for _, update := range store.statements("UPDATE `ad_decisions` SET") {
+				if strings.Contains(update.query, "`attempts`") {
+					continue // the claim of the failed decision
+				}
+				for _, arg := range update.args {
+					if arg == "decided" || arg == "failed" {
+						status = arg.(string)
+					}
+				}
+			}
+			if status != tt.wantStatus {
+				t.Errorf("decision status = %q, want %q", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"linier-channel/internal/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Interruption reasons recorded on as-run entries
+const (
+	AsRunPreempted   = "preempted"           // cut off by the next item on the channel's timeline
+	AsRunInterrupted = "playout_interrupted" // playout stopped or fell behind and rejoined the timeline
+)
+
+// AsRunService records and reports what actually aired on each channel
+type AsRunService struct {
+	db *gorm.DB
+}
+
+func NewAsRunService(db *gorm.DB) *AsRunService {
+	return &AsRunService{db: db}
+}
+
+// StartEntry records an item going on air with its first segment, along with the
+// video it replaces when it is filler or slate standing in
+func (s *AsRunService) StartEntry(channelID, videoID uint, scheduledStart, actualStart time.Time, replacedVideoID uint, substitutionReason string) (uint, error) {
+	title := ""
+	var video models.Video
+	if err := s.db.First(&video, videoID).Error; err == nil {
+		title = programmeTitle(&video)
+	}
+
+	entry := &models.AsRunEntry{
+		ChannelID:      channelID,
+		VideoID:        videoID,
+		Title:          title,
+		ScheduledStart: scheduledStart,
+		ActualStart:    actualStart,
+	}
+	if substitutionReason != "" {
+		entry.SubstitutionReason = substitutionReason
+		if replacedVideoID != 0 {
+			entry.ReplacedVideoID = &replacedVideoID
+		}
+	}
+
+	if err := s.db.Create(entry).Error; err != nil {
+		return 0, err
+	}
+	return entry.ID, nil
+}
+
+// FinishEntry records when an aired item went off air and why, if it was cut short
+func (s *AsRunService) FinishEntry(id uint, endedAt time.Time, reason string) error {
+	var entry models.AsRunEntry
+	if err := s.db.First(&entry, id).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"ended_at":        &endedAt,
+		"duration_played": endedAt.Sub(entry.ActualStart).Seconds(),
+	}
+
+	if reason != "" {
+		updates["interruption_reason"] = reason
+	}
+
+	return s.db.Model(&models.AsRunEntry{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// GetEntries retrieves as-run entries that went on air within a time range
+func (s *AsRunService) GetEntries(query *models.AsRunQuery) ([]models.AsRunEntry, error) {
+	db := s.db.Where("actual_start >= ? AND actual_start < ?", query.From, query.To)
+
+	if query.ChannelID != 0 {
+		db = db.Where("channel_id = ?", query.ChannelID)
+	}
+	if query.VideoID != 0 {
+		db = db.Where("video_id = ?", query.VideoID)
+	}
+	if query.Substituted {
+		db = db.Where("substitution_reason <> ''")
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit).Offset(query.Offset)
+	}
+
+	entries := []models.AsRunEntry{}
+	if err := db.Order("actual_start ASC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ExportCSV renders as-run entries as CSV
+func (s *AsRunService) ExportCSV(entries []models.AsRunEntry) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	header := []string{
+		"id", "channel_id", "video_id", "title", "scheduled_start", "actual_start",
+		"ended_at", "duration_played", "interruption_reason", "replaced_video_id", "substitution_reason",
+	}
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		endedAt := ""
+		if entry.EndedAt != nil {
+			endedAt = entry.EndedAt.Format(time.RFC3339)
+		}
+		replacedVideoID := ""
+		if entry.ReplacedVideoID != nil {
+			replacedVideoID = strconv.FormatUint(uint64(*entry.ReplacedVideoID), 10)
+		}
+
+		record := []string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			strconv.FormatUint(uint64(entry.ChannelID), 10),
+			strconv.FormatUint(uint64(entry.VideoID), 10),
+			entry.Title,
+			entry.ScheduledStart.Format(time.RFC3339),
+			entry.ActualStart.Format(time.RFC3339),
+			endedAt,
+			fmt.Sprintf("%.3f", entry.DurationPlayed),
+			entry.InterruptionReason,
+			replacedVideoID,
+			entry.SubstitutionReason,
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
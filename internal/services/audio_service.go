@@ -0,0 +1,411 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// audioGroupID is the EXT-X-MEDIA group of a video's alternate audio renditions
+const audioGroupID = "audio"
+
+// maxAudioChannels caps the channel count audio renditions are encoded with (5.1)
+const maxAudioChannels = 6
+
+// AudioService encodes the audio streams of multi-track sources into alternate audio
+// renditions and manages how they are published
+type AudioService struct {
+	db               *gorm.DB
+	config           *config.Config
+	transcodeService *TranscodeService
+}
+
+func NewAudioService(db *gorm.DB, cfg *config.Config, transcodeService *TranscodeService) *AudioService {
+	return &AudioService{db: db, config: cfg, transcodeService: transcodeService}
+}
+
+// GetTracks returns the audio tracks of a video in their published order
+func (s *AudioService) GetTracks(videoID uint) ([]models.AudioTrack, error) {
+	if err := s.db.First(&models.Video{}, videoID).Error; err != nil {
+		return nil, err
+	}
+	var tracks []models.AudioTrack
+	if err := s.db.Where("video_id = ?", videoID).Order("sort_order, id").Find(&tracks).Error; err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// UpdateTrack renames, relabels, enables or disables an audio track, or makes it the default
+func (s *AudioService) UpdateTrack(videoID, trackID uint, req *models.AudioTrackRequest) (*models.AudioTrack, error) {
+	var track models.AudioTrack
+	if err := s.db.Where("id = ? AND video_id = ?", trackID, videoID).First(&track).Error; err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+		}
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Language != nil {
+		if !languagePattern.MatchString(*req.Language) {
+			return nil, fmt.Errorf("%w: invalid language tag %q", ErrInvalidInput, *req.Language)
+		}
+		updates["language"] = *req.Language
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+	if req.Default != nil {
+		updates["is_default"] = *req.Default
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&track).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		// Players start with a single default track
+		if req.Default != nil && *req.Default {
+			return tx.Model(&models.AudioTrack{}).
+				Where("video_id = ? AND id <> ?", videoID, trackID).
+				Update("is_default", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.republish(videoID)
+	if err := s.db.First(&track, trackID).Error; err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// ReorderTracks sets the order audio tracks are listed in; trackIDs must name every
+// track of the video exactly once
+func (s *AudioService) ReorderTracks(videoID uint, trackIDs []uint) ([]models.AudioTrack, error) {
+	tracks, err := s.GetTracks(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[uint]bool, len(tracks))
+	for _, track := range tracks {
+		known[track.ID] = true
+	}
+	if len(trackIDs) != len(tracks) {
+		return nil, fmt.Errorf("%w: expected the %d audio tracks of video %d", ErrInvalidInput, len(tracks), videoID)
+	}
+	for _, id := range trackIDs {
+		if !known[id] {
+			return nil, fmt.Errorf("%w: audio track %d is not a track of video %d or is listed twice", ErrInvalidInput, id, videoID)
+		}
+		delete(known, id)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for i, id := range trackIDs {
+			if err := tx.Model(&models.AudioTrack{}).Where("id = ?", id).Update("sort_order", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.republish(videoID)
+	return s.GetTracks(videoID)
+}
+
+// GetAudioFile returns the playlist or a segment of an audio rendition and its content type
+func (s *AudioService) GetAudioFile(videoID, trackID uint, name string) ([]byte, string, error) {
+	var track models.AudioTrack
+	if err := s.db.Where("id = ? AND video_id = ?", trackID, videoID).First(&track).Error; err != nil {
+		return nil, "", err
+	}
+	if track.PlaylistPath == "" {
+		return nil, "", fmt.Errorf("audio track %d of video %d is not encoded", trackID, videoID)
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, "", fmt.Errorf("invalid audio file %q", name)
+	}
+
+	dir := filepath.Dir(filepath.Join(s.config.Storage.TranscodedPath, track.PlaylistPath))
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, "", err
+	}
+	if filepath.Ext(name) == ".m3u8" {
+		return data, "application/vnd.apple.mpegurl", nil
+	}
+	return data, utils.SegmentContentType(name), nil
+}
+
+// EncodeTracks encodes every audio stream of a video's source into an audio-only
+// rendition under audio/<track ID>, when the source has more than one. The video
+// renditions keep their own copy of the first stream for channels and outputs.
+func (s *AudioService) EncodeTracks(videoID uint) {
+	var video models.Video
+	if err := s.db.Preload("VideoProfiles").Preload("AudioTracks").First(&video, videoID).Error; err != nil {
+		return
+	}
+	baseDir := videoOutputDir(video.VideoProfiles)
+	if baseDir == "" || len(video.VideoProfiles) == 0 {
+		return
+	}
+	sourcePath := filepath.Join(s.config.Storage.UploadPath, video.FilePath)
+
+	tracks := video.AudioTracks
+	if len(tracks) == 0 {
+		var err error
+		if tracks, err = s.createTracks(&video, sourcePath); err != nil {
+			logrus.Errorf("Failed to probe audio tracks of video %d: %v", videoID, err)
+			return
+		}
+	}
+
+	var pending []models.AudioTrack
+	for _, track := range tracks {
+		if track.Status != "completed" {
+			pending = append(pending, track)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	ids := make([]uint, len(pending))
+	for i, track := range pending {
+		ids[i] = track.ID
+	}
+	fail := func(err error) {
+		logrus.Errorf("Failed to encode audio tracks of video %d: %v", videoID, err)
+		s.db.Model(&models.AudioTrack{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": err.Error(),
+		})
+	}
+
+	// Audio segments follow the first rendition's segmenting, so they line up with video
+	profile := &video.VideoProfiles[0]
+	audioDir := filepath.Join(s.config.Storage.TranscodedPath, baseDir, "audio")
+	for _, track := range pending {
+		if err := os.MkdirAll(filepath.Join(audioDir, strconv.Itoa(int(track.ID))), 0755); err != nil {
+			fail(fmt.Errorf("failed to create output directory: %w", err))
+			return
+		}
+	}
+
+	if err := s.transcodeService.measureTrackLoudness(&video, sourcePath, pending); err != nil {
+		fail(err)
+		return
+	}
+
+	keys, err := s.transcodeService.keyRotation(&video, profile.SegmentTime)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if keys != nil {
+		defer keys.Close()
+	}
+
+	cmd := s.generateAudioCommand(sourcePath, audioDir, &video, profile, pending, keys)
+	if err := s.transcodeService.executeFFmpegCommand(cmd, nil, float64(video.Duration), keys); err != nil {
+		fail(err)
+		return
+	}
+
+	for _, track := range pending {
+		playlistPath := filepath.Join(baseDir, "audio", strconv.Itoa(int(track.ID)), "playlist.m3u8")
+		s.db.Model(&models.AudioTrack{}).Where("id = ?", track.ID).Updates(map[string]interface{}{
+			"status":        "completed",
+			"playlist_path": playlistPath,
+			"error_message": "",
+		})
+	}
+}
+
+// createTracks records the audio streams of a source with several; the stream flagged
+// as default, or else the first, becomes the default track
+func (s *AudioService) createTracks(video *models.Video, sourcePath string) ([]models.AudioTrack, error) {
+	streams, err := utils.ProbeAudioStreams(s.config.FFmpeg.FFprobePath, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) < 2 {
+		return nil, nil
+	}
+
+	bitrate := 0
+	for _, profile := range video.VideoProfiles {
+		if profile.AudioBitrate > bitrate {
+			bitrate = profile.AudioBitrate
+		}
+	}
+	if bitrate == 0 {
+		bitrate = 128
+	}
+
+	defaultIndex := 0
+	for i, stream := range streams {
+		if stream.Disposition.Default == 1 {
+			defaultIndex = i
+			break
+		}
+	}
+
+	tracks := make([]models.AudioTrack, len(streams))
+	for i, stream := range streams {
+		channels := stream.Channels
+		if channels <= 0 {
+			channels = 2
+		}
+		if channels > maxAudioChannels {
+			channels = maxAudioChannels
+		}
+		name := strings.TrimSpace(stream.Tags["title"])
+		if name == "" {
+			name = stream.Language()
+		}
+		language := stream.Language()
+		if !languagePattern.MatchString(language) {
+			language = "und"
+		}
+
+		tracks[i] = models.AudioTrack{
+			VideoID:     video.ID,
+			StreamIndex: i,
+			Language:    language,
+			Name:        name,
+			Channels:    channels,
+			Bitrate:     bitrate * channels / 2, // the rendition bitrate is for stereo
+			SortOrder:   i,
+			Enabled:     true,
+			IsDefault:   i == defaultIndex,
+			Status:      "pending",
+		}
+	}
+	if err := s.db.Create(&tracks).Error; err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// generateAudioCommand generates one FFmpeg command encoding audio tracks to AAC, each
+// written by the HLS muxer to <audioDir>/<track ID>/playlist.m3u8 and normalised to the
+// video's loudness target
+func (s *AudioService) generateAudioCommand(inputPath, audioDir string, video *models.Video, profile *models.VideoProfile, tracks []models.AudioTrack, keys *keyRotation) *exec.Cmd {
+	args := []string{
+		"-progress", "pipe:1",
+		"-nostats",
+		"-i", inputPath,
+	}
+
+	streams := make([]string, len(tracks))
+	for i, track := range tracks {
+		args = append(args,
+			"-map", fmt.Sprintf("0:a:%d", track.StreamIndex),
+			fmt.Sprintf("-c:a:%d", i), "aac",
+			fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", track.Bitrate),
+			fmt.Sprintf("-ac:a:%d", i), strconv.Itoa(track.Channels),
+		)
+		if filter := s.transcodeService.trackLoudnessFilter(video, &tracks[i]); filter != "" {
+			args = append(args, fmt.Sprintf("-filter:a:%d", i), filter)
+		}
+		streams[i] = fmt.Sprintf("a:%d,name:%d", i, track.ID)
+	}
+
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(profile.SegmentTime),
+		"-hls_list_size", "0",
+	)
+	args = append(args, segmentArgs(profile, filepath.Join(audioDir, "%v"))...)
+	args = append(args, hlsFlagArgs(keys)...)
+	args = append(args,
+		"-var_stream_map", strings.Join(streams, " "),
+		filepath.Join(audioDir, "%v", "playlist.m3u8"),
+	)
+
+	return exec.Command(s.config.FFmpeg.FFmpegPath, args...)
+}
+
+// republish rewrites the master playlist of a transcoded video after its tracks changed
+func (s *AudioService) republish(videoID uint) {
+	var video models.Video
+	if s.db.First(&video, videoID).Error == nil && video.Status == "completed" {
+		s.transcodeService.generateAndSaveMasterPlaylist(videoID)
+	}
+}
+
+// audioMedia returns the EXT-X-MEDIA tags of a video's enabled, encoded audio tracks in
+// order and the STREAM-INF attribute referencing their group, both empty without tracks.
+// The default track is the one flagged, or else the first listed.
+func audioMedia(tracks []models.AudioTrack) ([]string, string) {
+	var published []models.AudioTrack
+	defaultIndex := 0
+	for _, track := range sortedAudioTracks(tracks) {
+		if !track.Enabled || track.Status != "completed" {
+			continue
+		}
+		if track.IsDefault {
+			defaultIndex = len(published)
+		}
+		published = append(published, track)
+	}
+	if len(published) == 0 {
+		return nil, ""
+	}
+
+	tags := make([]string, len(published))
+	for i, track := range published {
+		isDefault := "NO"
+		if i == defaultIndex {
+			isDefault = "YES"
+		}
+		tags[i] = fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\",URI=\"audio/%d/playlist.m3u8\"",
+			audioGroupID, strings.ReplaceAll(track.Name, "\"", "'"), track.Language, isDefault, track.Channels, track.ID)
+	}
+	return tags, fmt.Sprintf(",AUDIO=\"%s\"", audioGroupID)
+}
+
+// sortedAudioTracks returns tracks by sort order, then ID
+func sortedAudioTracks(tracks []models.AudioTrack) []models.AudioTrack {
+	sorted := append([]models.AudioTrack(nil), tracks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].SortOrder != sorted[j].SortOrder {
+			return sorted[i].SortOrder < sorted[j].SortOrder
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// alternateMedia returns the EXT-X-MEDIA tags of a video's audio and subtitle renditions
+// and the STREAM-INF attributes referencing their groups
+func alternateMedia(video *models.Video) ([]string, string) {
+	audio, audioGroup := audioMedia(video.AudioTracks)
+	subtitles, subtitleGroup := subtitleMedia(video.Subtitles)
+	return append(audio, subtitles...), audioGroup + subtitleGroup
+}
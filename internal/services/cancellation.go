@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"linier-channel/internal/models"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// transcodeCancelChannel is the Redis channel cancellations are signalled to every
+// worker through; messages carry the ID of a cancelled profile
+const transcodeCancelChannel = "transcode:cancel"
+
+// runningEncode is an FFmpeg process encoding profiles on this worker
+type runningEncode struct {
+	mu         sync.Mutex
+	cmd        *exec.Cmd
+	err        error     // why the process was killed, nil while it runs
+	progressAt time.Time // when the output last grew
+}
+
+// start starts the process unless it was killed first
+func (e *runningEncode) start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.progressAt = time.Now()
+	return e.cmd.Start()
+}
+
+// kill stops the process, or keeps it from starting, for the given reason
+func (e *runningEncode) kill(reason error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return
+	}
+	e.err = reason
+	if e.cmd.Process != nil {
+		e.cmd.Process.Kill()
+	}
+}
+
+// progressed records that the output grew
+func (e *runningEncode) progressed() {
+	e.mu.Lock()
+	e.progressAt = time.Now()
+	e.mu.Unlock()
+}
+
+// stalledFor returns how long the output has not grown
+func (e *runningEncode) stalledFor() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Since(e.progressAt)
+}
+
+// CancelJob cancels a queued, running or retrying transcode job
+func (s *TranscodeService) CancelJob(jobID uint) ([]uint, error) {
+	var job models.TranscodeJob
+	if err := s.db.First(&job, jobID).Error; err != nil {
+		return nil, err
+	}
+	if job.Status != "queued" && job.Status != "processing" && job.Status != "retrying" {
+		return nil, fmt.Errorf("%w: job %d is %s", ErrInvalidInput, job.ID, job.Status)
+	}
+	return s.cancelProfiles(job.VideoID, []uint{job.ProfileID})
+}
+
+// CancelVideo cancels the transcoding of every unfinished profile of a video
+func (s *TranscodeService) CancelVideo(videoID uint) ([]uint, error) {
+	return s.cancelProfiles(videoID, nil)
+}
+
+// CancelProfile cancels the jobs of a profile
+func (s *TranscodeService) CancelProfile(profileID uint) ([]uint, error) {
+	var profile models.VideoProfile
+	if err := s.db.First(&profile, profileID).Error; err != nil {
+		return nil, err
+	}
+	return s.cancelProfiles(profile.VideoID, []uint{profileID})
+}
+
+// cancelProfiles marks the given pending or processing profiles of a video, or all of
+// them when none are given, and their jobs cancelled, removes their queued jobs and
+// scheduled retries and signals the workers running them. It returns the IDs of the cancelled profiles.
+func (s *TranscodeService) cancelProfiles(videoID uint, profileIDs []uint) ([]uint, error) {
+	var video models.Video
+	if err := s.db.First(&video, videoID).Error; err != nil {
+		return nil, err
+	}
+
+	// A single-pass job encodes every unfinished profile of its video
+	query := s.db.Where("video_id = ? AND status IN ?", videoID, []string{"pending", "processing"})
+	if len(profileIDs) > 0 && !video.SinglePass {
+		query = query.Where("id IN ?", profileIDs)
+	}
+	var profiles []models.VideoProfile
+	if err := query.Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: video %d has no pending or processing profiles to cancel", ErrInvalidInput, videoID)
+	}
+
+	ids := make([]uint, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.ID
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&models.TranscodeJob{}).
+		Where("video_id = ? AND profile_id IN ? AND status IN ?", videoID, ids, []string{"queued", "processing", "retrying"}).
+		Updates(map[string]interface{}{
+			"status":       "cancelled",
+			"completed_at": &now,
+		}).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&models.VideoProfile{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{
+			"status":              "cancelled",
+			"progress_percentage": 0,
+			"completed_at":        &now,
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	// Workers check the status after registering an encode and before starting it, so
+	// an encode starting now is either seen cancelled or killed by the signal
+	if s.redis != nil {
+		ctx := context.Background()
+		for _, id := range ids {
+			entry := fmt.Sprintf("%d:%d", videoID, id)
+			if err := s.redis.LRem(ctx, "transcode_queue", 0, entry).Err(); err != nil {
+				logrus.Warnf("Failed to remove queued job of profile %d: %v", id, err)
+			}
+			if err := s.redis.ZRem(ctx, transcodeRetrySet, entry).Err(); err != nil {
+				logrus.Warnf("Failed to remove scheduled retry of profile %d: %v", id, err)
+			}
+			if err := s.redis.Publish(ctx, transcodeCancelChannel, id).Err(); err != nil {
+				logrus.Warnf("Failed to signal the cancellation of profile %d: %v", id, err)
+			}
+		}
+	}
+
+	logrus.Infof("Cancelled transcoding of video %d profiles %v", videoID, ids)
+	return ids, nil
+}
+
+// WatchCancellations kills the encodes of this worker whose profiles are cancelled,
+// until stop is closed
+func (s *TranscodeService) WatchCancellations(stop <-chan bool) {
+	if s.redis == nil {
+		return
+	}
+
+	pubsub := s.redis.Subscribe(context.Background(), transcodeCancelChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			profileID, err := strconv.ParseUint(message.Payload, 10, 64)
+			if err != nil {
+				logrus.Warnf("Invalid transcode cancellation %q", message.Payload)
+				continue
+			}
+			s.killEncode(uint(profileID))
+		}
+	}
+}
+
+// killEncode stops the encode of a profile if it runs on this worker
+func (s *TranscodeService) killEncode(profileID uint) {
+	s.encodesMu.Lock()
+	encode, ok := s.encodes[profileID]
+	s.encodesMu.Unlock()
+	if ok {
+		logrus.Infof("Killing the encode of profile %d", profileID)
+		encode.kill(ErrCancelled)
+	}
+}
+
+// registerEncode records an FFmpeg process about to encode profiles so it can be
+// killed, returning ErrCancelled when one of them is already cancelled
+func (s *TranscodeService) registerEncode(cmd *exec.Cmd, profileIDs []uint) (*runningEncode, error) {
+	encode := &runningEncode{cmd: cmd}
+	if len(profileIDs) == 0 {
+		return encode, nil
+	}
+
+	s.encodesMu.Lock()
+	for _, id := range profileIDs {
+		s.encodes[id] = encode
+	}
+	s.encodesMu.Unlock()
+
+	cancelled, err := s.profilesCancelled(profileIDs)
+	if err != nil {
+		s.unregisterEncode(encode, profileIDs)
+		return nil, err
+	}
+	if cancelled {
+		s.unregisterEncode(encode, profileIDs)
+		return nil, ErrCancelled
+	}
+	return encode, nil
+}
+
+// profilesCancelled reports whether one of the given profiles is cancelled
+func (s *TranscodeService) profilesCancelled(profileIDs []uint) (bool, error) {
+	if len(profileIDs) == 0 {
+		return false, nil
+	}
+	var cancelled int64
+	if err := s.db.Model(&models.VideoProfile{}).
+		Where("id IN ? AND status = ?", profileIDs, "cancelled").
+		Count(&cancelled).Error; err != nil {
+		return false, err
+	}
+	return cancelled > 0, nil
+}
+
+// unregisterEncode forgets a finished encode, returning why it was killed, if it was
+func (s *TranscodeService) unregisterEncode(encode *runningEncode, profileIDs []uint) error {
+	s.encodesMu.Lock()
+	for _, id := range profileIDs {
+		if s.encodes[id] == encode {
+			delete(s.encodes, id)
+		}
+	}
+	s.encodesMu.Unlock()
+
+	encode.mu.Lock()
+	defer encode.mu.Unlock()
+	return encode.err
+}
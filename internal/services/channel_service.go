@@ -0,0 +1,457 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ChannelService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func NewChannelService(db *gorm.DB, cfg *config.Config) *ChannelService {
+	return &ChannelService{db: db, config: cfg}
+}
+
+// CreateChannel creates a new channel
+func (s *ChannelService) CreateChannel(req *models.ChannelRequest) (*models.Channel, error) {
+	if err := s.validateChannelRequest(req); err != nil {
+		return nil, err
+	}
+
+	channel := &models.Channel{
+		Name:             req.Name,
+		Description:      req.Description,
+		PlaylistID:       req.PlaylistID,
+		FillerPlaylistID: req.FillerPlaylistID,
+		SlateID:          req.SlateID,
+		IsActive:         true,
+	}
+
+	if err := s.db.Create(channel).Error; err != nil {
+		return nil, err
+	}
+
+	// is_active has a database default, so an explicit false must be written separately
+	if req.IsActive != nil && !*req.IsActive {
+		if err := s.db.Model(channel).Update("is_active", false).Error; err != nil {
+			return nil, err
+		}
+		channel.IsActive = false
+	}
+
+	return channel, nil
+}
+
+// GetChannels retrieves all channels
+func (s *ChannelService) GetChannels() ([]models.Channel, error) {
+	var channels []models.Channel
+	if err := s.db.Preload("Playlist").Preload("FillerPlaylist").Preload("Slate.Video").
+		Order("created_at DESC").
+		Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// GetChannel retrieves a channel by ID
+func (s *ChannelService) GetChannel(id uint) (*models.Channel, error) {
+	var channel models.Channel
+	if err := s.db.Preload("Playlist").Preload("FillerPlaylist").Preload("Slate.Video").First(&channel, id).Error; err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// UpdateChannel updates a channel
+func (s *ChannelService) UpdateChannel(id uint, req *models.ChannelRequest) error {
+	var channel models.Channel
+	if err := s.db.First(&channel, id).Error; err != nil {
+		return err
+	}
+
+	if err := s.validateChannelRequest(req); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"name":               req.Name,
+		"description":        req.Description,
+		"playlist_id":        req.PlaylistID,
+		"filler_playlist_id": req.FillerPlaylistID,
+		"slate_id":           req.SlateID,
+	}
+
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	return s.db.Model(&models.Channel{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteChannel deletes a channel and its schedule
+func (s *ChannelService) DeleteChannel(id uint) error {
+	return s.db.Delete(&models.Channel{}, id).Error
+}
+
+// GetSchedule retrieves the schedule entries of a channel starting within a time range
+func (s *ChannelService) GetSchedule(channelID uint, from, to time.Time) ([]models.ScheduleEntry, error) {
+	var entries []models.ScheduleEntry
+	if err := s.db.Preload("Video").Preload("Playlist").
+		Where("channel_id = ? AND start_time >= ? AND start_time < ?", channelID, from, to).
+		Order("start_time ASC").
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// AddScheduleEntry schedules a video or playlist on a channel
+func (s *ChannelService) AddScheduleEntry(channelID uint, req *models.ScheduleEntryRequest) (*models.ScheduleEntry, error) {
+	var channel models.Channel
+	if err := s.db.First(&channel, channelID).Error; err != nil {
+		return nil, err
+	}
+
+	entry := &models.ScheduleEntry{ChannelID: channelID}
+	applyScheduleEntryRequest(entry, req)
+
+	if err := s.validateEntry(entry, 0); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// UpdateScheduleEntry reschedules an existing schedule entry
+func (s *ChannelService) UpdateScheduleEntry(channelID, entryID uint, req *models.ScheduleEntryRequest) (*models.ScheduleEntry, error) {
+	var entry models.ScheduleEntry
+	if err := s.db.Where("id = ? AND channel_id = ?", entryID, channelID).First(&entry).Error; err != nil {
+		return nil, err
+	}
+
+	applyScheduleEntryRequest(&entry, req)
+	entry.Video = nil
+	entry.Playlist = nil
+
+	if err := s.validateEntry(&entry, entry.ID); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"start_time":  entry.StartTime,
+		"video_id":    entry.VideoID,
+		"playlist_id": entry.PlaylistID,
+		"in_point":    entry.InPoint,
+		"out_point":   entry.OutPoint,
+	}
+
+	if err := s.db.Model(&models.ScheduleEntry{}).Where("id = ?", entry.ID).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// DeleteScheduleEntry removes an entry from a channel's schedule
+func (s *ChannelService) DeleteScheduleEntry(channelID, entryID uint) error {
+	return s.db.Where("id = ? AND channel_id = ?", entryID, channelID).
+		Delete(&models.ScheduleEntry{}).Error
+}
+
+// ValidateSchedule reports overlaps, gaps and unplayable assets in a channel's schedule
+func (s *ChannelService) ValidateSchedule(channelID uint, from, to time.Time) (*models.ScheduleValidationResponse, error) {
+	var channel models.Channel
+	if err := s.db.First(&channel, channelID).Error; err != nil {
+		return nil, err
+	}
+
+	entries, err := loadScheduleWindow(s.db, channelID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []models.ScheduleIssue{}
+	gapMessage := "nothing scheduled"
+	if channel.PlaylistID != nil {
+		gapMessage = fmt.Sprintf("nothing scheduled, covered by loop playlist %d", *channel.PlaylistID)
+	} else if channel.FillerPlaylistID != nil {
+		gapMessage = fmt.Sprintf("nothing scheduled, covered by filler playlist %d", *channel.FillerPlaylistID)
+	} else if channel.SlateID != nil {
+		gapMessage = fmt.Sprintf("nothing scheduled, covered by slate %d", *channel.SlateID)
+	}
+
+	cursor := from
+	for i := range entries {
+		entry := &entries[i]
+		end := entry.StartTime.Add(scheduleEntryDuration(entry))
+		if loopsUntilNext(entry) && i+1 < len(entries) {
+			end = entries[i+1].StartTime
+		}
+
+		if err := checkScheduleEntryAssets(entry); err != nil {
+			issues = append(issues, models.ScheduleIssue{
+				Type:     "asset_not_ready",
+				Start:    entry.StartTime,
+				End:      end,
+				EntryIDs: []uint{entry.ID},
+				Message:  err.Error(),
+			})
+		}
+
+		if entry.StartTime.After(cursor) {
+			issues = append(issues, models.ScheduleIssue{
+				Type:     "gap",
+				Start:    cursor,
+				End:      entry.StartTime,
+				EntryIDs: []uint{entry.ID},
+				Message:  gapMessage,
+			})
+		}
+
+		if i+1 < len(entries) && end.After(entries[i+1].StartTime) {
+			issues = append(issues, models.ScheduleIssue{
+				Type:     "overlap",
+				Start:    entries[i+1].StartTime,
+				End:      end,
+				EntryIDs: []uint{entry.ID, entries[i+1].ID},
+				Message:  fmt.Sprintf("entry %d runs %s into entry %d", entry.ID, end.Sub(entries[i+1].StartTime), entries[i+1].ID),
+			})
+		}
+
+		if end.After(cursor) {
+			cursor = end
+		}
+	}
+
+	if cursor.Before(to) {
+		issues = append(issues, models.ScheduleIssue{
+			Type:     "gap",
+			Start:    cursor,
+			End:      to,
+			EntryIDs: []uint{},
+			Message:  gapMessage,
+		})
+	}
+
+	return &models.ScheduleValidationResponse{
+		ChannelID: channelID,
+		From:      from,
+		To:        to,
+		Valid:     len(issues) == 0,
+		Issues:    issues,
+	}, nil
+}
+
+// validateChannelRequest checks that the playlists and slate a channel refers to exist
+func (s *ChannelService) validateChannelRequest(req *models.ChannelRequest) error {
+	if err := s.validatePlaylist(req.PlaylistID); err != nil {
+		return err
+	}
+	if err := s.validatePlaylist(req.FillerPlaylistID); err != nil {
+		return err
+	}
+
+	if req.SlateID != nil {
+		var slate models.Slate
+		if err := s.db.First(&slate, *req.SlateID).Error; err != nil {
+			return fmt.Errorf("%w: slate %d not found", ErrInvalidInput, *req.SlateID)
+		}
+	}
+	return nil
+}
+
+// validatePlaylist checks that an optional playlist exists
+func (s *ChannelService) validatePlaylist(playlistID *uint) error {
+	if playlistID == nil {
+		return nil
+	}
+
+	var playlist models.Playlist
+	if err := s.db.First(&playlist, *playlistID).Error; err != nil {
+		return fmt.Errorf("%w: playlist %d not found", ErrInvalidInput, *playlistID)
+	}
+	return nil
+}
+
+// validateEntry checks an entry's asset, in/out points and that it does not overlap its neighbours
+func (s *ChannelService) validateEntry(entry *models.ScheduleEntry, excludeID uint) error {
+	if (entry.VideoID == nil) == (entry.PlaylistID == nil) {
+		return fmt.Errorf("%w: exactly one of video_id or playlist_id is required", ErrInvalidInput)
+	}
+
+	if entry.VideoID != nil {
+		var video models.Video
+		if err := s.db.First(&video, *entry.VideoID).Error; err != nil {
+			return fmt.Errorf("%w: video %d not found", ErrInvalidInput, *entry.VideoID)
+		}
+		entry.Video = &video
+
+		if entry.InPoint < 0 || entry.OutPoint < 0 {
+			return fmt.Errorf("%w: in and out points must not be negative", ErrInvalidInput)
+		}
+		if entry.OutPoint > 0 && entry.OutPoint <= entry.InPoint {
+			return fmt.Errorf("%w: out point must be after in point", ErrInvalidInput)
+		}
+		if video.Duration > 0 && (entry.InPoint >= video.Duration || entry.OutPoint > video.Duration) {
+			return fmt.Errorf("%w: in and out points must be within the video duration of %ds", ErrInvalidInput, video.Duration)
+		}
+	} else {
+		if entry.InPoint != 0 || entry.OutPoint != 0 {
+			return fmt.Errorf("%w: in and out points are only supported for videos", ErrInvalidInput)
+		}
+
+		var playlist models.Playlist
+		if err := s.db.Preload("PlaylistVideos.Video").Preload("AdBreaks.PlaceholderVideo").First(&playlist, *entry.PlaylistID).Error; err != nil {
+			return fmt.Errorf("%w: playlist %d not found", ErrInvalidInput, *entry.PlaylistID)
+		}
+		entry.Playlist = &playlist
+	}
+
+	if err := checkScheduleEntryAssets(entry); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	duration := scheduleEntryDuration(entry)
+	if duration <= 0 {
+		return fmt.Errorf("%w: scheduled asset has no duration", ErrInvalidInput)
+	}
+
+	// The entry must start after the previous one ends and end before the next one starts
+	var previous models.ScheduleEntry
+	err := s.db.Preload("Video").Preload("Playlist.PlaylistVideos.Video").Preload("Playlist.AdBreaks.PlaceholderVideo").
+		Where("channel_id = ? AND id <> ? AND start_time <= ?", entry.ChannelID, excludeID, entry.StartTime).
+		Order("start_time DESC").
+		First(&previous).Error
+	if err == nil {
+		if !loopsUntilNext(&previous) && previous.StartTime.Add(scheduleEntryDuration(&previous)).After(entry.StartTime) {
+			return fmt.Errorf("%w: overlaps schedule entry %d", ErrInvalidInput, previous.ID)
+		}
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	var next models.ScheduleEntry
+	err = s.db.Where("channel_id = ? AND id <> ? AND start_time > ?", entry.ChannelID, excludeID, entry.StartTime).
+		Order("start_time ASC").
+		First(&next).Error
+	if err == nil {
+		if !loopsUntilNext(entry) && entry.StartTime.Add(duration).After(next.StartTime) {
+			return fmt.Errorf("%w: overlaps schedule entry %d", ErrInvalidInput, next.ID)
+		}
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// applyScheduleEntryRequest copies request fields onto a schedule entry
+func applyScheduleEntryRequest(entry *models.ScheduleEntry, req *models.ScheduleEntryRequest) {
+	entry.StartTime = req.StartTime
+	entry.VideoID = req.VideoID
+	entry.PlaylistID = req.PlaylistID
+	entry.InPoint = req.InPoint
+	entry.OutPoint = req.OutPoint
+}
+
+// loadScheduleWindow returns the last entry starting at or before from, followed by
+// every entry starting before to, with their assets loaded and ordered by start time
+func loadScheduleWindow(db *gorm.DB, channelID uint, from, to time.Time) ([]models.ScheduleEntry, error) {
+	var entries []models.ScheduleEntry
+
+	var previous models.ScheduleEntry
+	err := db.Preload("Video").Preload("Playlist.PlaylistVideos.Video").Preload("Playlist.AdBreaks.PlaceholderVideo").
+		Where("channel_id = ? AND start_time <= ?", channelID, from).
+		Order("start_time DESC").
+		First(&previous).Error
+	if err == nil {
+		entries = append(entries, previous)
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var upcoming []models.ScheduleEntry
+	if err := db.Preload("Video").Preload("Playlist.PlaylistVideos.Video").Preload("Playlist.AdBreaks.PlaceholderVideo").
+		Where("channel_id = ? AND start_time > ? AND start_time < ?", channelID, from, to).
+		Order("start_time ASC").
+		Find(&upcoming).Error; err != nil {
+		return nil, err
+	}
+
+	return append(entries, upcoming...), nil
+}
+
+// loopsUntilNext reports whether an entry repeats its playlist until the next entry starts
+func loopsUntilNext(entry *models.ScheduleEntry) bool {
+	return entry.Playlist != nil && entry.Playlist.PlaybackMode == PlaybackLoop
+}
+
+// scheduleEntryDuration returns how long a schedule entry airs, based on Video.Duration
+func scheduleEntryDuration(entry *models.ScheduleEntry) time.Duration {
+	if entry.Video != nil {
+		end := entry.Video.Duration
+		if entry.OutPoint > 0 {
+			end = entry.OutPoint
+		}
+		return time.Duration(end-entry.InPoint) * time.Second
+	}
+
+	if entry.Playlist != nil {
+		total := 0
+		for _, pv := range entry.Playlist.PlaylistVideos {
+			if entry.Playlist.PlaybackMode == PlaybackWeighted && pv.Weight > 1 {
+				total += pv.Video.Duration * pv.Weight
+				continue
+			}
+			total += pv.Video.Duration
+		}
+		// Breaks with a placeholder reserve airtime; splice-only breaks do not
+		for _, adBreak := range entry.Playlist.AdBreaks {
+			if adBreak.PlaceholderVideo != nil && adBreak.PlaceholderVideo.Status == "completed" {
+				total += adBreak.Duration
+			}
+		}
+		return time.Duration(total) * time.Second
+	}
+
+	return 0
+}
+
+// checkScheduleEntryAssets checks that every asset of an entry finished transcoding
+func checkScheduleEntryAssets(entry *models.ScheduleEntry) error {
+	if entry.Video != nil {
+		if entry.Video.Status != "completed" {
+			return fmt.Errorf("video %d is %s, not completed", entry.Video.ID, entry.Video.Status)
+		}
+		return nil
+	}
+
+	if entry.Playlist != nil {
+		if len(entry.Playlist.PlaylistVideos) == 0 {
+			return fmt.Errorf("playlist %d has no videos", entry.Playlist.ID)
+		}
+
+		playlistVideos := entry.Playlist.PlaylistVideos
+		sort.SliceStable(playlistVideos, func(i, j int) bool {
+			return playlistVideos[i].SortOrder < playlistVideos[j].SortOrder
+		})
+		for _, pv := range playlistVideos {
+			if pv.Video.Status != "completed" {
+				return fmt.Errorf("video %d in playlist %d is %s, not completed", pv.VideoID, entry.Playlist.ID, pv.Video.Status)
+			}
+		}
+	}
+
+	return nil
+}
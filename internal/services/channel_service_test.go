@@ -0,0 +1,273 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"linier-channel/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	scheduleEntryColumns = []string{"id", "channel_id", "start_time", "video_id", "playlist_id", "in_point", "out_point"}
+	videoColumns         = []string{"id", "status", "duration"}
+)
+
+// scheduleRows returns the rows of schedule entries
+func scheduleRows(entries ...[]driver.Value) testResult {
+	return testResult{columns: scheduleEntryColumns, rows: entries}
+}
+
+// videoRows returns the rows of videos
+func videoRows(videos ...[]driver.Value) testResult {
+	return testResult{columns: videoColumns, rows: videos}
+}
+
+func TestUpdateChannelNotFound(t *testing.T) {
+	db, store := newTestDB(t)
+	s := NewChannelService(db, nil)
+
+	err := s.UpdateChannel(7, &models.ChannelRequest{Name: "News"})
+	if err == nil || err.Error() != "record not found" {
+		t.Fatalf("UpdateChannel() error = %v, want record not found", err)
+	}
+	if updates := store.statements("UPDATE `channels`"); len(updates) != 0 {
+		t.Errorf("updated a channel that does not exist: %q", updates[0].query)
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	videoID := uint(1)
+	playlistID := uint(4)
+	hourVideo := videoRows([]driver.Value{int64(1), "completed", int64(3600)})
+
+	tests := []struct {
+		name    string
+		entry   models.ScheduleEntry
+		answers map[string]testResult
+		wantErr string // empty when the entry is valid
+	}{
+		{
+			name:    "no asset",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start},
+			wantErr: "exactly one of video_id or playlist_id is required",
+		},
+		{
+			name:    "video and playlist",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID, PlaylistID: &playlistID},
+			wantErr: "exactly one of video_id or playlist_id is required",
+		},
+		{
+			name:    "video not found",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			wantErr: "video 1 not found",
+		},
+		{
+			name:    "negative in point",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID, InPoint: -1},
+			answers: map[string]testResult{"FROM `videos`": hourVideo},
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "out point before in point",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID, InPoint: 600, OutPoint: 600},
+			answers: map[string]testResult{"FROM `videos`": hourVideo},
+			wantErr: "out point must be after in point",
+		},
+		{
+			name:    "out point past the end",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID, OutPoint: 3601},
+			answers: map[string]testResult{"FROM `videos`": hourVideo},
+			wantErr: "within the video duration of 3600s",
+		},
+		{
+			name:  "video not transcoded",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			answers: map[string]testResult{
+				"FROM `videos`": videoRows([]driver.Value{int64(1), "processing", int64(3600)}),
+			},
+			wantErr: "video 1 is processing, not completed",
+		},
+		{
+			name:  "video without duration",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			answers: map[string]testResult{
+				"FROM `videos`": videoRows([]driver.Value{int64(1), "completed", int64(0)}),
+			},
+			wantErr: "scheduled asset has no duration",
+		},
+		{
+			name:  "overlaps the previous entry",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			answers: map[string]testResult{
+				"FROM `videos`":       hourVideo,
+				"AND start_time <= ?": scheduleRows([]driver.Value{int64(8), int64(1), start.Add(-30 * time.Minute), int64(1), nil, int64(0), int64(0)}),
+			},
+			wantErr: "overlaps schedule entry 8",
+		},
+		{
+			name:  "overlaps the next entry",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			answers: map[string]testResult{
+				"FROM `videos`":      hourVideo,
+				"AND start_time > ?": scheduleRows([]driver.Value{int64(9), int64(1), start.Add(30 * time.Minute), int64(1), nil, int64(0), int64(0)}),
+			},
+			wantErr: "overlaps schedule entry 9",
+		},
+		{
+			name:  "out point ends before the next entry",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID, InPoint: 600, OutPoint: 1800},
+			answers: map[string]testResult{
+				"FROM `videos`":      hourVideo,
+				"AND start_time > ?": scheduleRows([]driver.Value{int64(9), int64(1), start.Add(20 * time.Minute), int64(1), nil, int64(0), int64(0)}),
+			},
+		},
+		{
+			name:  "fits between its neighbours",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, VideoID: &videoID},
+			answers: map[string]testResult{
+				"FROM `videos`":       hourVideo,
+				"AND start_time <= ?": scheduleRows([]driver.Value{int64(8), int64(1), start.Add(-time.Hour), int64(1), nil, int64(0), int64(0)}),
+				"AND start_time > ?":  scheduleRows([]driver.Value{int64(9), int64(1), start.Add(time.Hour), int64(1), nil, int64(0), int64(0)}),
+			},
+		},
+		{
+			name:    "in point on a playlist",
+			entry:   models.ScheduleEntry{ChannelID: 1, StartTime: start, PlaylistID: &playlistID, InPoint: 10},
+			wantErr: "in and out points are only supported for videos",
+		},
+		{
+			name:  "playlist without videos",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, PlaylistID: &playlistID},
+			answers: map[string]testResult{
+				"FROM `playlists`": {columns: []string{"id", "playback_mode"}, rows: [][]driver.Value{{int64(4), PlaybackSequential}}},
+			},
+			wantErr: "playlist 4 has no videos",
+		},
+		{
+			name:  "playlist",
+			entry: models.ScheduleEntry{ChannelID: 1, StartTime: start, PlaylistID: &playlistID},
+			answers: map[string]testResult{
+				"FROM `playlists`":       {columns: []string{"id", "playback_mode"}, rows: [][]driver.Value{{int64(4), PlaybackSequential}}},
+				"FROM `playlist_videos`": {columns: []string{"playlist_id", "video_id", "sort_order"}, rows: [][]driver.Value{{int64(4), int64(1), int64(0)}}},
+				"FROM `videos`":          hourVideo,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, store := newTestDB(t)
+			for fragment, result := range tt.answers {
+				store.answer(fragment, result)
+			}
+			s := NewChannelService(db, nil)
+
+			entry := tt.entry
+			err := s.validateEntry(&entry, 0)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateEntry() error = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateEntry() error = %v, want invalid input %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+	hourVideo := []driver.Value{int64(1), "completed", int64(3600)}
+	unreadyVideo := []driver.Value{int64(2), "processing", int64(1800)}
+	entry := func(id int64, start time.Duration, videoID int64) []driver.Value {
+		return []driver.Value{id, int64(1), from.Add(start), videoID, nil, int64(0), int64(0)}
+	}
+
+	tests := []struct {
+		name       string
+		channel    []driver.Value // id, playlist_id, filler_playlist_id, slate_id
+		previous   testResult
+		upcoming   testResult
+		videos     testResult // of the scheduled entries
+		wantIssues []models.ScheduleIssue
+	}{
+		{
+			name:    "nothing scheduled",
+			channel: []driver.Value{int64(1), nil, nil, int64(3)},
+			wantIssues: []models.ScheduleIssue{
+				{Type: "gap", Start: from, End: to, EntryIDs: []uint{}, Message: "nothing scheduled, covered by slate 3"},
+			},
+		},
+		{
+			name:     "back to back from before the window",
+			channel:  []driver.Value{int64(1), nil, nil, nil},
+			previous: scheduleRows(entry(1, -30*time.Minute, 1)),
+			upcoming: scheduleRows(entry(2, 30*time.Minute, 1), entry(3, 90*time.Minute, 1), entry(4, 150*time.Minute, 1)),
+			videos:   videoRows(hourVideo),
+		},
+		{
+			name:     "gaps, overlap and unready asset",
+			channel:  []driver.Value{int64(1), int64(4), int64(5), nil},
+			upcoming: scheduleRows(entry(1, 10*time.Minute, 1), entry(2, 60*time.Minute, 1), entry(3, 150*time.Minute, 2)),
+			videos:   videoRows(hourVideo, unreadyVideo),
+			wantIssues: []models.ScheduleIssue{
+				{Type: "gap", Start: from, End: from.Add(10 * time.Minute), EntryIDs: []uint{1}, Message: "nothing scheduled, covered by loop playlist 4"},
+				{Type: "overlap", Start: from.Add(60 * time.Minute), End: from.Add(70 * time.Minute), EntryIDs: []uint{1, 2}, Message: "entry 1 runs 10m0s into entry 2"},
+				{Type: "asset_not_ready", Start: from.Add(150 * time.Minute), End: to, EntryIDs: []uint{3}, Message: "video 2 is processing, not completed"},
+				{Type: "gap", Start: from.Add(120 * time.Minute), End: from.Add(150 * time.Minute), EntryIDs: []uint{3}, Message: "nothing scheduled, covered by loop playlist 4"},
+			},
+		},
+		{
+			name:     "gap at the end covered by filler",
+			channel:  []driver.Value{int64(1), nil, int64(5), int64(3)},
+			upcoming: scheduleRows(entry(1, 0, 1)),
+			videos:   videoRows(hourVideo),
+			wantIssues: []models.ScheduleIssue{
+				{Type: "gap", Start: from.Add(time.Hour), End: to, EntryIDs: []uint{}, Message: "nothing scheduled, covered by filler playlist 5"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, store := newTestDB(t)
+			store.answer("FROM `channels`", testResult{
+				columns: []string{"id", "playlist_id", "filler_playlist_id", "slate_id"},
+				rows:    [][]driver.Value{tt.channel},
+			})
+			store.answer("AND start_time <= ?", tt.previous)
+			store.answer("AND start_time < ?", tt.upcoming)
+			store.answer("FROM `videos`", tt.videos)
+			s := NewChannelService(db, nil)
+
+			got, err := s.ValidateSchedule(1, from, to)
+			if err != nil {
+				t.Fatalf("ValidateSchedule() error = %v", err)
+			}
+			if got.Valid != (len(tt.wantIssues) == 0) {
+				t.Errorf("Valid = %v with %d issues", got.Valid, len(got.Issues))
+			}
+			wantIssues := tt.wantIssues
+			if wantIssues == nil {
+				wantIssues = []models.ScheduleIssue{}
+			}
+			if !reflect.DeepEqual(got.Issues, wantIssues) {
+				t.Errorf("Issues = %+v, want %+v", got.Issues, wantIssues)
+			}
+		})
+	}
+
+	t.Run("channel not found", func(t *testing.T) {
+		db, _ := newTestDB(t)
+		if _, err := NewChannelService(db, nil).ValidateSchedule(1, from, to); err == nil || err.Error() != "record not found" {
+			t.Errorf("ValidateSchedule() error = %v, want record not found", err)
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"strconv"
+	"strings"
+)
+
+// Video codecs a rung can be encoded with
+const (
+	CodecH264 = "h264"
+	CodecHEVC = "hevc"
+	CodecAV1  = "av1"
+	CodecVP9  = "vp9"
+)
+
+// videoCodecAliases maps the names accepted in presets to a video codec
+var videoCodecAliases = map[string]string{
+	"h264":       CodecH264,
+	"avc":        CodecH264,
+	"x264":       CodecH264,
+	"libx264":    CodecH264,
+	"hevc":       CodecHEVC,
+	"h265":       CodecHEVC,
+	"x265":       CodecHEVC,
+	"libx265":    CodecHEVC,
+	"av1":        CodecAV1,
+	"libaom-av1": CodecAV1,
+	"libsvtav1":  CodecAV1,
+	"svt-av1":    CodecAV1,
+	"vp9":        CodecVP9,
+	"libvpx-vp9": CodecVP9,
+}
+
+// encoderSpeeds maps x264 speed presets to the SVT-AV1 preset and the libaom and
+// libvpx cpu-used levels of similar speed
+var encoderSpeeds = map[string][3]int{
+	"ultrafast": {12, 8, 8},
+	"superfast": {11, 7, 6},
+	"veryfast":  {10, 6, 5},
+	"faster":    {9, 6, 4},
+	"fast":      {8, 5, 3},
+	"medium":    {7, 4, 2},
+	"slow":      {5, 3, 1},
+	"slower":    {4, 2, 1},
+	"veryslow":  {2, 1, 0},
+	"placebo":   {0, 0, 0},
+}
+
+// h264ProfileIDC maps H.264 profiles to profile_idc and constraint flags, as written
+// in avc1 codec strings
+var h264ProfileIDC = map[string]string{
+	"baseline":             "42C0",
+	"constrained baseline": "42C0",
+	"main":                 "4D40",
+	"high":                 "6400",
+	"high 10":              "6E00",
+	"high10":               "6E00",
+	"high 4:2:2":           "7A00",
+	"high422":              "7A00",
+}
+
+// normalizeVideoCodec returns the video codec named in a preset, false if unsupported
+func normalizeVideoCodec(name string) (string, bool) {
+	codec, ok := videoCodecAliases[strings.ToLower(strings.TrimSpace(name))]
+	return codec, ok
+}
+
+// videoCodec returns the codec of a profile; unknown names are treated as H.264, which
+// profiles created before codecs were validated used
+func videoCodec(profile *models.VideoProfile) string {
+	codec, ok := normalizeVideoCodec(profile.CodecVideo)
+	if !ok {
+		return CodecH264
+	}
+	return codec
+}
+
+// videoEncoder returns the FFmpeg encoder of a profile; av1Encoder selects the AV1
+// implementation the FFmpeg build provides
+func videoEncoder(profile *models.VideoProfile, av1Encoder string) string {
+	switch videoCodec(profile) {
+	case CodecHEVC:
+		return "libx265"
+	case CodecAV1:
+		return av1Encoder
+	case CodecVP9:
+		return "libvpx-vp9"
+	default:
+		return "libx264"
+	}
+}
+
+// codecEncoderArgs returns the speed, profile and level arguments of an encoder for
+// the output stream selected by stream. x264 speed presets are translated for the
+// AV1 and VP9 encoders, which only take the profile and level of the stream they make.
+func codecEncoderArgs(profile *models.VideoProfile, encoder, stream string) []string {
+	var args []string
+	speed, named := encoderSpeeds[strings.ToLower(profile.EncoderPreset)]
+
+	switch encoder {
+	case "libx264", "libx265":
+		if profile.EncoderPreset != "" {
+			args = append(args, "-preset:"+stream, profile.EncoderPreset)
+		}
+		if profile.EncoderProfile != "" {
+			args = append(args, "-profile:"+stream, profile.EncoderProfile)
+		}
+		if strings.HasSuffix(profile.EncoderProfile, "10") {
+			args = append(args, "-pix_fmt:"+stream, "yuv420p10le")
+		}
+		if encoder == "libx264" {
+			if profile.EncoderLevel != "" {
+				args = append(args, "-level:"+stream, profile.EncoderLevel)
+			}
+			break
+		}
+		if profile.EncoderLevel != "" {
+			args = append(args, "-x265-params:"+stream, "level-idc="+profile.EncoderLevel)
+		}
+		// Apple players only accept HEVC signalled as hvc1
+		args = append(args, "-tag:"+stream, "hvc1")
+	case "libsvtav1":
+		if named {
+			args = append(args, "-preset:"+stream, strconv.Itoa(speed[0]))
+		}
+	case "libaom-av1", "libvpx-vp9":
+		cpuUsed := speed[1]
+		if encoder == "libvpx-vp9" {
+			cpuUsed = speed[2]
+			args = append(args, "-deadline:"+stream, "good")
+		}
+		if named {
+			args = append(args, "-cpu-used:"+stream, strconv.Itoa(cpuUsed))
+		}
+		args = append(args, "-row-mt:"+stream, "1")
+	}
+	return args
+}
+
+// renditionName returns the name a profile's rendition is stored and served under: its
+// resolution, suffixed with the codec for codecs other than H.264 ("720p-hevc")
+func renditionName(profile *models.VideoProfile) string {
+	if codec := videoCodec(profile); codec != CodecH264 {
+		return profile.Resolution + "-" + codec
+	}
+	return profile.Resolution
+}
+
+// parseRenditionName splits a rendition name into its resolution and video codec
+func parseRenditionName(name string) (string, string) {
+	resolution, codec, ok := strings.Cut(name, "-")
+	if !ok {
+		return name, CodecH264
+	}
+	return resolution, codec
+}
+
+// codecsAttribute returns the RFC 6381 codecs of a profile's renditions, such as
+// "avc1.64001f,mp4a.40.2": as probed from the output, or else as configured
+func codecsAttribute(profile *models.VideoProfile) string {
+	if profile.Codecs != "" {
+		return profile.Codecs
+	}
+
+	level := 0
+	if value, err := strconv.ParseFloat(profile.EncoderLevel, 64); err == nil && value > 0 {
+		switch videoCodec(profile) {
+		case CodecHEVC:
+			level = int(value*30 + 0.5)
+		case CodecAV1:
+			major := int(value)
+			level = (major-2)*4 + int((value-float64(major))*10+0.5)
+		default:
+			level = int(value*10 + 0.5)
+		}
+	}
+
+	depth := 8
+	if strings.Contains(profile.EncoderProfile, "10") {
+		depth = 10
+	}
+	width, height := profileDimensions(profile)
+	return videoCodecString(videoCodec(profile), profile.EncoderProfile, "", level, depth, width, height) + ",mp4a.40.2"
+}
+
+// probedCodecs returns the RFC 6381 codecs of the streams of a rendition as reported
+// by ffprobe, empty when no video stream was found
+func probedCodecs(streams []utils.StreamInfo) string {
+	var video, audio string
+	for _, stream := range streams {
+		switch stream.CodecType {
+		case "video":
+			if video != "" {
+				continue
+			}
+			codec, ok := normalizeVideoCodec(stream.CodecName)
+			if !ok {
+				continue
+			}
+			video = videoCodecString(codec, stream.Profile, stream.CodecTag, stream.Level, stream.BitDepth(), stream.Width, stream.Height)
+		case "audio":
+			if audio == "" {
+				audio = audioCodecString(stream.CodecName, stream.Profile)
+			}
+		}
+	}
+	if video == "" || audio == "" {
+		return video
+	}
+	return video + "," + audio
+}
+
+// videoCodecString returns the RFC 6381 codec of a video stream. The level uses the
+// scale ffprobe reports for the codec and is estimated from the picture size when it
+// is unknown (0 or negative).
+func videoCodecString(codec, profile, tag string, level, depth, width, height int) string {
+	profile = strings.ToLower(profile)
+	macroblocks := ((width + 15) / 16) * ((height + 15) / 16)
+	samples := width * height
+
+	switch codec {
+	case CodecHEVC:
+		if tag != "hev1" {
+			tag = "hvc1"
+		}
+		if level <= 0 {
+			level = pickLevel(samples, []int{552960, 983040, 2228224, 8912896}, []int{90, 93, 123, 153, 183})
+		}
+		if strings.Contains(profile, "10") {
+			return fmt.Sprintf("%s.2.4.L%d.B0", tag, level)
+		}
+		return fmt.Sprintf("%s.1.6.L%d.B0", tag, level)
+	case CodecAV1:
+		if level <= 0 {
+			level = pickLevel(samples, []int{665856, 1065024, 2359296, 8912896}, []int{4, 5, 8, 12, 16})
+		}
+		avProfile := 0
+		switch profile {
+		case "high":
+			avProfile = 1
+		case "professional":
+			avProfile = 2
+		}
+		return fmt.Sprintf("av01.%d.%02dM.%02d", avProfile, level, depth)
+	case CodecVP9:
+		if level <= 0 {
+			level = pickLevel(samples, []int{552960, 983040, 2228224, 8912896}, []int{30, 31, 41, 51, 61})
+		}
+		vpProfile := 0
+		if n, err := strconv.Atoi(strings.TrimPrefix(profile, "profile ")); err == nil {
+			vpProfile = n
+		}
+		return fmt.Sprintf("vp09.%02d.%02d.%02d", vpProfile, level, depth)
+	default:
+		idc, ok := h264ProfileIDC[profile]
+		if !ok {
+			idc = h264ProfileIDC["high"]
+		}
+		if level <= 0 {
+			// Frame size limits of each level, in 16x16 macroblocks
+			level = pickLevel(macroblocks, []int{1620, 3600, 8192}, []int{30, 31, 40, 51})
+		}
+		return fmt.Sprintf("avc1.%s%02x", idc, level)
+	}
+}
+
+// pickLevel returns the first level whose limit size fits in, or the last level
+func pickLevel(size int, limits []int, levels []int) int {
+	for i, limit := range limits {
+		if size <= limit {
+			return levels[i]
+		}
+	}
+	return levels[len(levels)-1]
+}
+
+// audioCodecString returns the RFC 6381 codec of an audio stream, empty if unknown
+func audioCodecString(codec, profile string) string {
+	switch codec {
+	case "aac":
+		switch profile {
+		case "HE-AAC":
+			return "mp4a.40.5"
+		case "HE-AACv2":
+			return "mp4a.40.29"
+		default:
+			return "mp4a.40.2"
+		}
+	case "mp3":
+		return "mp4a.40.34"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	case "opus":
+		return "Opus"
+	case "flac":
+		return "fLaC"
+	}
+	return ""
+}
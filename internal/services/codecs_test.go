@@ -0,0 +1,128 @@
+package services
+
+import (
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"testing"
+)
+
+func TestCodecsAttribute(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile models.VideoProfile
+		want    string
+	}{
+		{
+			name:    "H.264 High@4.0",
+			profile: models.VideoProfile{Resolution: "1080p", CodecVideo: "libx264", EncoderProfile: "high", EncoderLevel: "4.0"},
+			want:    "avc1.640028,mp4a.40.2",
+		},
+		{
+			name:    "H.264 Main@3.1",
+			profile: models.VideoProfile{Resolution: "720p", CodecVideo: "libx264", EncoderProfile: "main", EncoderLevel: "3.1"},
+			want:    "avc1.4D401f,mp4a.40.2",
+		},
+		{
+			name:    "H.264 level estimated from the picture size",
+			profile: models.VideoProfile{Resolution: "1080p", CodecVideo: "libx264", EncoderProfile: "high"},
+			want:    "avc1.640028,mp4a.40.2",
+		},
+		{
+			name:    "HEVC Main10@4.0",
+			profile: models.VideoProfile{Resolution: "1080p", CodecVideo: "libx265", EncoderProfile: "main10", EncoderLevel: "4.0"},
+			want:    "hvc1.2.4.L120.B0,mp4a.40.2",
+		},
+		{
+			name:    "HEVC Main@3.1",
+			profile: models.VideoProfile{Resolution: "720p", CodecVideo: "hevc", EncoderProfile: "main", EncoderLevel: "3.1"},
+			want:    "hvc1.1.6.L93.B0,mp4a.40.2",
+		},
+		{
+			name:    "AV1 Main@4.0",
+			profile: models.VideoProfile{Resolution: "1080p", CodecVideo: "libsvtav1", EncoderLevel: "4.0"},
+			want:    "av01.0.08M.08,mp4a.40.2",
+		},
+		{
+			name:    "probed codecs win",
+			profile: models.VideoProfile{Resolution: "1080p", CodecVideo: "libx264", EncoderLevel: "4.0", Codecs: "avc1.64002a,mp4a.40.5"},
+			want:    "avc1.64002a,mp4a.40.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codecsAttribute(&tt.profile); got != tt.want {
+				t.Errorf("codecsAttribute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbedCodecs(t *testing.T) {
+	aacLC := utils.StreamInfo{CodecType: "audio", CodecName: "aac", Profile: "LC"}
+
+	tests := []struct {
+		name    string
+		streams []utils.StreamInfo
+		want    string
+	}{
+		{
+			name: "H.264 High@4.0 with AAC-LC",
+			streams: []utils.StreamInfo{
+				{CodecType: "video", CodecName: "h264", CodecTag: "avc1", Profile: "High", Level: 40, PixFmt: "yuv420p", Width: 1920, Height: 1080},
+				aacLC,
+			},
+			want: "avc1.640028,mp4a.40.2",
+		},
+		{
+			name: "HEVC Main10 with AAC-LC",
+			streams: []utils.StreamInfo{
+				{CodecType: "video", CodecName: "hevc", CodecTag: "hvc1", Profile: "Main 10", Level: 120, PixFmt: "yuv420p10le", Width: 1920, Height: 1080},
+				aacLC,
+			},
+			want: "hvc1.2.4.L120.B0,mp4a.40.2",
+		},
+		{
+			name: "HEVC signalled as hev1",
+			streams: []utils.StreamInfo{
+				{CodecType: "video", CodecName: "hevc", CodecTag: "hev1", Profile: "Main", Level: 93, PixFmt: "yuv420p", Width: 1280, Height: 720},
+				aacLC,
+			},
+			want: "hev1.1.6.L93.B0,mp4a.40.2",
+		},
+		{
+			name: "unknown level estimated from the picture size",
+			streams: []utils.StreamInfo{
+				{CodecType: "video", CodecName: "h264", Profile: "High", Level: -99, PixFmt: "yuv420p", Width: 1280, Height: 720},
+				aacLC,
+			},
+			want: "avc1.64001f,mp4a.40.2",
+		},
+		{
+			name: "HE-AAC",
+			streams: []utils.StreamInfo{
+				{CodecType: "video", CodecName: "h264", Profile: "Main", Level: 31, Width: 1280, Height: 720},
+				{CodecType: "audio", CodecName: "aac", Profile: "HE-AAC"},
+			},
+			want: "avc1.4D401f,mp4a.40.5",
+		},
+		{
+			name:    "video only",
+			streams: []utils.StreamInfo{{CodecType: "video", CodecName: "h264", Profile: "High", Level: 40, Width: 1920, Height: 1080}},
+			want:    "avc1.640028",
+		},
+		{
+			name:    "no video stream",
+			streams: []utils.StreamInfo{aacLC},
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probedCodecs(tt.streams); got != tt.want {
+				t.Errorf("probedCodecs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// videoManifest generates the static DASH manifest of a video from the media playlists
+// of its fragmented MP4 renditions. Segment URLs are relative to the directory of the
+// master playlist, where the manifest is stored and served from.
+func videoManifest(transcodedPath string, video *models.Video) (string, error) {
+	if video.Encrypted {
+		return "", fmt.Errorf("video %d is encrypted for HLS, which DASH players cannot decrypt", video.ID)
+	}
+
+	var representations []utils.DASHRepresentation
+	var duration float64
+	segmentTime := 0
+
+	for i := range video.VideoProfiles {
+		profile := &video.VideoProfiles[i]
+		if profile.Status != "completed" || profile.SegmentFormat != SegmentFormatFMP4 || profile.PlaylistPath == "" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(transcodedPath, profile.PlaylistPath))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s playlist: %v", renditionName(profile), err)
+		}
+		segments := utils.ParseMediaPlaylist(string(content))
+		if len(segments) == 0 {
+			continue
+		}
+		if total := utils.TotalDuration(segments); total > duration {
+			duration = total
+		}
+		if profile.SegmentTime > segmentTime {
+			segmentTime = profile.SegmentTime
+		}
+
+		width, height := profileDimensions(profile)
+		representations = append(representations, utils.DASHRepresentation{
+			ID:          renditionName(profile),
+			Bandwidth:   profile.Bitrate * 1000,
+			Width:       width,
+			Height:      height,
+			Codecs:      codecsAttribute(profile),
+			SegmentList: utils.NewDASHSegmentList(segments, renditionName(profile)+"/", 0),
+		})
+	}
+
+	if len(representations) == 0 {
+		return "", fmt.Errorf("video %d has no fragmented MP4 renditions", video.ID)
+	}
+
+	return utils.MarshalMPD(&utils.MPD{
+		Type:                      utils.DASHStatic,
+		MediaPresentationDuration: utils.DASHDuration(time.Duration(duration * float64(time.Second))),
+		MinBufferTime:             utils.DASHDuration(time.Duration(2*segmentTime) * time.Second),
+		Periods: []utils.DASHPeriod{{
+			ID:             "0",
+			AdaptationSets: codecAdaptationSets(representations),
+		}},
+	})
+}
+
+// codecAdaptationSets groups representations by video codec, so players pick a set
+// they can decode
+func codecAdaptationSets(representations []utils.DASHRepresentation) []utils.DASHAdaptationSet {
+	var sets []utils.DASHAdaptationSet
+	index := make(map[string]int)
+	for _, representation := range representations {
+		codec, _, _ := strings.Cut(representation.Codecs, ".")
+		i, ok := index[codec]
+		if !ok {
+			i = len(sets)
+			index[codec] = i
+			sets = append(sets, utils.DASHAdaptationSet{MimeType: "video/mp4", SegmentAlignment: true})
+		}
+		sets[i].Representations = append(sets[i].Representations, representation)
+	}
+	return sets
+}
+
+// hasFMP4Renditions reports whether any completed profile uses fragmented MP4 segments
+func hasFMP4Renditions(profiles []models.VideoProfile) bool {
+	for _, profile := range profiles {
+		if profile.Status == "completed" && profile.SegmentFormat == SegmentFormatFMP4 {
+			return true
+		}
+	}
+	return false
+}
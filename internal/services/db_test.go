@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB is an in-memory stand-in for MySQL that records the statements and queries
+// services run, answering them from canned results
+type testDB struct {
+	mu      sync.Mutex
+	execs   []testStatement
+	results map[string]testResult // by a fragment of the query they answer
+	lastID  int64                 // auto-increment ID handed to the last statement
+}
+
+// testStatement is a statement or query run against a testDB
+type testStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// testResult is the answer of a testDB to a query; rowsAffected applies to statements
+type testResult struct {
+	columns      []string
+	rows         [][]driver.Value
+	rowsAffected int64
+}
+
+// newTestDB returns a GORM connection backed by a new testDB
+func newTestDB(t *testing.T) (*gorm.DB, *testDB) {
+	t.Helper()
+	store := &testDB{results: make(map[string]testResult)}
+	sqlDB := sql.OpenDB(store)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return db, store
+}
+
+// answer sets the result of queries and statements containing fragment
+func (d *testDB) answer(fragment string, result testResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.results[fragment] = result
+}
+
+// statements returns the statements and queries run that contain fragment
+func (d *testDB) statements(fragment string) []testStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var matched []testStatement
+	for _, statement := range d.execs {
+		if strings.Contains(statement.query, fragment) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+func (d *testDB) result(query string) (testResult, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The longest fragment is the most specific answer
+	var best string
+	for fragment := range d.results {
+		if strings.Contains(query, fragment) && len(fragment) > len(best) {
+			best = fragment
+		}
+	}
+	result, ok := d.results[best]
+	return result, ok && best != ""
+}
+
+func (d *testDB) Connect(context.Context) (driver.Conn, error) { return &testConn{db: d}, nil }
+func (d *testDB) Driver() driver.Driver                        { return testDriver{} }
+
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) { return nil, driver.ErrSkip }
+
+type testConn struct {
+	db *testDB
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *testConn) Close() error                              { return nil }
+func (c *testConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *testConn) Commit() error                             { return nil }
+func (c *testConn) Rollback() error                           { return nil }
+
+func (c *testConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.db.mu.Lock()
+	c.db.execs = append(c.db.execs, testStatement{query: query, args: values})
+	c.db.mu.Unlock()
+
+	affected := int64(1)
+	if result, ok := c.db.result(query); ok {
+		affected = result.rowsAffected
+	}
+	c.db.mu.Lock()
+	c.db.lastID++
+	lastID := c.db.lastID
+	c.db.mu.Unlock()
+	return testExecResult{rowsAffected: affected, lastInsertID: lastID}, nil
+}
+
+// testExecResult is the result of a statement; every statement takes the next
+// auto-increment ID, as if it inserted a row
+type testExecResult struct {
+	rowsAffected int64
+	lastInsertID int64
+}
+
+func (r testExecResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r testExecResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+func (c *testConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.db.mu.Lock()
+	c.db.execs = append(c.db.execs, testStatement{query: query, args: values})
+	c.db.mu.Unlock()
+
+	result, _ := c.db.result(query)
+	return &testRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type testRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
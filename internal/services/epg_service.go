@@ -0,0 +1,189 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"linier-channel/internal/models"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// xmltvTimeFormat is the timestamp layout used by XMLTV
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// EPGService generates electronic program guides from channel and playlist timelines
+type EPGService struct {
+	db             *gorm.DB
+	playoutService *PlayoutService
+}
+
+type xmltvDocument struct {
+	XMLName       xml.Name         `xml:"tv"`
+	GeneratorName string           `xml:"generator-info-name,attr"`
+	Channels      []xmltvChannel   `xml:"channel"`
+	Programmes    []xmltvProgramme `xml:"programme"`
+}
+
+type xmltvChannel struct {
+	ID          string `xml:"id,attr"`
+	DisplayName string `xml:"display-name"`
+}
+
+type xmltvProgramme struct {
+	Start   string `xml:"start,attr"`
+	Stop    string `xml:"stop,attr"`
+	Channel string `xml:"channel,attr"`
+	Title   string `xml:"title"`
+	Length  struct {
+		Units string `xml:"units,attr"`
+		Value int    `xml:",chardata"`
+	} `xml:"length"`
+}
+
+func NewEPGService(db *gorm.DB, playoutService *PlayoutService) *EPGService {
+	return &EPGService{db: db, playoutService: playoutService}
+}
+
+// GetChannelGuide returns the programmes of a channel overlapping a time range
+func (s *EPGService) GetChannelGuide(channelID uint, from, to time.Time) (*models.EPGResponse, error) {
+	var channel models.Channel
+	if err := s.db.First(&channel, channelID).Error; err != nil {
+		return nil, err
+	}
+
+	items := s.playoutService.buildTimeline(&channel, from, to)
+	programmes, err := s.buildProgrammes(items, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EPGResponse{
+		ID:         fmt.Sprintf("channel-%d", channel.ID),
+		Name:       channel.Name,
+		From:       from,
+		To:         to,
+		Programmes: programmes,
+	}, nil
+}
+
+// GetPlaylistGuide returns the programmes of a playlist looping from its creation
+func (s *EPGService) GetPlaylistGuide(playlistID uint, from, to time.Time) (*models.EPGResponse, error) {
+	var playlist models.Playlist
+	if err := s.db.Preload("PlaylistVideos.Video").Preload("AdBreaks.PlaceholderVideo").First(&playlist, playlistID).Error; err != nil {
+		return nil, err
+	}
+
+	items := fillLoop(s.playoutService.playlistRotation(&playlist), playlist.CreatedAt, nil, from, to)
+	programmes, err := s.buildProgrammes(items, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EPGResponse{
+		ID:         fmt.Sprintf("playlist-%d", playlist.ID),
+		Name:       playlist.Name,
+		From:       from,
+		To:         to,
+		Programmes: programmes,
+	}, nil
+}
+
+// GenerateXMLTV renders a program guide as an XMLTV document
+func (s *EPGService) GenerateXMLTV(guide *models.EPGResponse) ([]byte, error) {
+	document := xmltvDocument{
+		GeneratorName: "linier-channel",
+		Channels: []xmltvChannel{{
+			ID:          guide.ID,
+			DisplayName: guide.Name,
+		}},
+	}
+
+	for _, programme := range guide.Programmes {
+		entry := xmltvProgramme{
+			Start:   programme.Start.Format(xmltvTimeFormat),
+			Stop:    programme.Stop.Format(xmltvTimeFormat),
+			Channel: guide.ID,
+			Title:   programme.Title,
+		}
+		entry.Length.Units = "seconds"
+		entry.Length.Value = programme.Duration
+		document.Programmes = append(document.Programmes, entry)
+	}
+
+	content, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	header := xml.Header + "<!DOCTYPE tv SYSTEM \"xmltv.dtd\">\n"
+	return append([]byte(header), content...), nil
+}
+
+// buildProgrammes turns timeline items overlapping [from, to) into titled programmes
+func (s *EPGService) buildProgrammes(items []timelineItem, from, to time.Time) ([]models.EPGProgramme, error) {
+	titles := make(map[uint]string)
+	var videoIDs []uint
+	for _, item := range items {
+		if _, ok := titles[item.VideoID]; !ok {
+			titles[item.VideoID] = ""
+			videoIDs = append(videoIDs, item.VideoID)
+		}
+	}
+
+	if len(videoIDs) > 0 {
+		var videos []models.Video
+		if err := s.db.Where("id IN ?", videoIDs).Find(&videos).Error; err != nil {
+			return nil, err
+		}
+		for _, video := range videos {
+			titles[video.ID] = programmeTitle(&video)
+		}
+	}
+
+	programmes := []models.EPGProgramme{}
+	var previous *timelineItem
+	for i := range items {
+		item := items[i]
+		// Slots of unplayable videos left uncovered by filler or slate air nothing
+		if item.VideoID == 0 || !item.End().After(from) || !item.Start.Before(to) {
+			previous = nil
+			continue
+		}
+
+		// Ad breaks and the rest of a video resumed after a mid-roll belong to
+		// the programme already on air rather than being programmes of their own
+		if last := len(programmes) - 1; last >= 0 && previous != nil && programmes[last].Stop.Equal(item.Start) {
+			resumed := item.VideoID == previous.VideoID && item.InPoint > 0 && item.InPoint == previous.OutPoint
+			if item.Break != nil || resumed {
+				programmes[last].Stop = item.End()
+				programmes[last].Duration = int(programmes[last].Stop.Sub(programmes[last].Start).Round(time.Second).Seconds())
+				if item.Break == nil {
+					previous = &items[i]
+				}
+				continue
+			}
+		}
+		if item.Break != nil {
+			continue
+		}
+
+		previous = &items[i]
+		programmes = append(programmes, models.EPGProgramme{
+			VideoID:  item.VideoID,
+			Title:    titles[item.VideoID],
+			Start:    item.Start,
+			Stop:     item.End(),
+			Duration: int(item.Duration.Round(time.Second).Seconds()),
+		})
+	}
+
+	return programmes, nil
+}
+
+// programmeTitle derives a programme title from a video's original filename
+func programmeTitle(video *models.Video) string {
+	return strings.TrimSuffix(video.OriginalFilename, filepath.Ext(video.OriginalFilename))
+}
@@ -0,0 +1,15 @@
+package services
+
+import "errors"
+
+// ErrInvalidInput marks errors caused by a request that fails validation
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrCancelled is returned by transcodes stopped because their job was cancelled
+var ErrCancelled = errors.New("transcode cancelled")
+
+// ErrTimedOut and ErrStalled are returned by transcodes killed by the watchdog
+var (
+	ErrTimedOut = errors.New("transcode timed out")
+	ErrStalled  = errors.New("transcode stalled")
+)
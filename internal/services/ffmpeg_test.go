@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary stand in for FFmpeg: tests point FFmpegPath at
+// os.Args[0] and set FAKE_FFMPEG for the processes they start
+func TestMain(m *testing.M) {
+	if os.Getenv("FAKE_FFMPEG") != "" {
+		os.Exit(fakeFFmpeg(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeFFmpeg pushes the name of the output format to the output URL (the last
+// argument) until it is interrupted. With FAKE_FFMPEG_FAIL_ONCE set to a path that
+// does not exist yet, it creates it and fails like an unreachable receiver instead.
+// Called like ffprobe, it describes a source with a video and two audio streams, the
+// video stream taken from FAKE_FFPROBE_VIDEO when set. For a loudnorm analysis it
+// reports -20 LUFS less the index of the measured stream, or with FAKE_FFMPEG_SLOW
+// set keeps reporting progress until it is killed; set to "stall", it runs without
+// progress instead. Converting SRT subtitles to WebVTT, it prints them with WebVTT
+// timings. Every run is logged to FAKE_FFMPEG_LOG when set.
+func fakeFFmpeg(args []string) int {
+	if logPath := os.Getenv("FAKE_FFMPEG_LOG"); logPath != "" {
+		if file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			fmt.Fprintln(file, strings.Join(args, " "))
+			file.Close()
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "-print_format" {
+			video := os.Getenv("FAKE_FFPROBE_VIDEO")
+			if video == "" {
+				video = `{"codec_type":"video","width":1280,"height":720}`
+			}
+			fmt.Printf(`{"streams":[%s,{"codec_type":"audio"},{"codec_type":"audio"}],"format":{"duration":"60.0"}}`+"\n", video)
+			return 0
+		}
+		if strings.HasPrefix(arg, "loudnorm=") && strings.Contains(arg, "print_format=json") {
+			return fakeLoudnorm(args)
+		}
+	}
+
+	if args[len(args)-1] == "pipe:1" {
+		return fakeWebVTT(args)
+	}
+
+	if marker := os.Getenv("FAKE_FFMPEG_FAIL_ONCE"); marker != "" {
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			os.WriteFile(marker, nil, 0644)
+			fmt.Fprintln(os.Stderr, "Connection to tcp://receiver failed: Connection refused")
+			fmt.Fprintln(os.Stderr, "Conversion failed!")
+			return 1
+		}
+	}
+
+	format := ""
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-f" {
+			format = args[i+1]
+		}
+	}
+	target, err := url.Parse(args[len(args)-1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	network := "udp"
+	if target.Scheme == "rtmp" {
+		network = "tcp"
+	}
+	conn, err := net.Dial(network, target.Host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return 0
+		case <-ticker.C:
+			if _, err := conn.Write([]byte(format)); err != nil && network == "tcp" {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+	}
+}
+
+// fakeLoudnorm prints loudnorm's summary for the mapped audio stream
+func fakeLoudnorm(args []string) int {
+	slow := os.Getenv("FAKE_FFMPEG_SLOW")
+	if slow == "stall" {
+		time.Sleep(time.Hour)
+		return 1
+	}
+	if slow != "" {
+		for outTime := 0; ; outTime += 100000 {
+			fmt.Printf("out_time_us=%d\nprogress=continue\n", outTime)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	var stream int
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-map" {
+			fmt.Sscanf(args[i+1], "0:a:%d", &stream)
+		}
+	}
+	fmt.Fprintf(os.Stderr, `[Parsed_loudnorm_0 @ 0x55d0c5a0]
+{
+	"input_i" : "%d.00",
+	"input_tp" : "-1.50",
+	"input_lra" : "6.20",
+	"input_thresh" : "-31.00",
+	"output_i" : "-23.00",
+	"output_tp" : "-2.00",
+	"output_lra" : "5.90",
+	"output_thresh" : "-33.00",
+	"normalization_type" : "linear",
+	"target_offset" : "0.10"
+}
+`, -20-stream)
+	return 0
+}
+
+// fakeWebVTT prints the SRT input as WebVTT, keeping its CRLF line endings and cue
+// numbers as FFmpeg keeps them
+func fakeWebVTT(args []string) int {
+	var input string
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-i" {
+			input = args[i+1]
+		}
+	}
+	content, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if strings.Contains(line, "-->") {
+			lines[i] = strings.ReplaceAll(line, ",", ".")
+		}
+	}
+	fmt.Print("WEBVTT\r\n\r\n" + strings.Join(lines, "\n"))
+	return 0
+}
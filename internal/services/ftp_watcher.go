@@ -1,11 +1,10 @@
 package services
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -13,12 +12,16 @@ import (
 	"gorm.io/gorm"
 
 	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
 )
 
 type FTPWatcher struct {
 	watchPath        string
 	uploadService    *UploadService
 	transcodeService *TranscodeService
+	presetService    *PresetService
+	subtitleService  *SubtitleService
+	keyService       *KeyService
 	db               *gorm.DB
 	watcher          *fsnotify.Watcher
 	stopChan         chan bool
@@ -34,6 +37,21 @@ func NewFTPWatcher(watchPath string, uploadService *UploadService, transcodeServ
 	}
 }
 
+// SetPresetService sets the encoding preset service the profiles of new videos come from
+func (fw *FTPWatcher) SetPresetService(presetService *PresetService) {
+	fw.presetService = presetService
+}
+
+// SetKeyService sets the key service encrypted videos are checked against
+func (fw *FTPWatcher) SetKeyService(keyService *KeyService) {
+	fw.keyService = keyService
+}
+
+// SetSubtitleService sets the service sidecar subtitles dropped next to videos are attached with
+func (fw *FTPWatcher) SetSubtitleService(subtitleService *SubtitleService) {
+	fw.subtitleService = subtitleService
+}
+
 func (fw *FTPWatcher) StartWatching() error {
 	log.Printf("FTP Watcher: Starting watcher initialization...")
 
@@ -119,6 +137,9 @@ func (fw *FTPWatcher) StartWatching() error {
 				if fw.isVideoFile(event.Name) {
 					log.Printf("FTP Watcher: New video file detected: %s", event.Name)
 					go fw.processNewVideo(event.Name)
+				} else if fw.isSubtitleFile(event.Name) {
+					log.Printf("FTP Watcher: New subtitle file detected: %s", event.Name)
+					go fw.processNewSubtitle(event.Name)
 				} else {
 					log.Printf("FTP Watcher: Non-video file ignored: %s", event.Name)
 				}
@@ -162,6 +183,76 @@ func (fw *FTPWatcher) isVideoFile(filename string) bool {
 	return false
 }
 
+func (fw *FTPWatcher) isSubtitleFile(filename string) bool {
+	_, ok := subtitleFormats[strings.ToLower(filepath.Ext(filename))]
+	return ok
+}
+
+// sidecarName splits a sidecar file name such as "movie.en.srt" into the name of the
+// video it belongs to without extension ("movie") and its language, "und" if unnamed
+func sidecarName(filename string) (string, string) {
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if i := strings.LastIndex(base, "."); i > 0 && languagePattern.MatchString(base[i+1:]) {
+		return base[:i], base[i+1:]
+	}
+	return base, "und"
+}
+
+// processNewSubtitle attaches a sidecar to the video dropped with the same name; sidecars
+// dropped first are attached when their video arrives
+func (fw *FTPWatcher) processNewSubtitle(filePath string) {
+	if fw.subtitleService == nil {
+		return
+	}
+	stem, language := sidecarName(filePath)
+
+	var videos []models.Video
+	if err := fw.db.Where("original_filename LIKE ?", stem+".%").Order("id DESC").Find(&videos).Error; err != nil {
+		log.Printf("Failed to find video for subtitle %s: %v", filePath, err)
+		return
+	}
+	for _, video := range videos {
+		name := video.OriginalFilename
+		if strings.TrimSuffix(name, filepath.Ext(name)) != stem || !fw.isVideoFile(name) {
+			continue
+		}
+		fw.attachSidecar(filePath, language, video.ID)
+		return
+	}
+	log.Printf("No video found yet for subtitle file: %s", filePath)
+}
+
+// attachSidecars attaches the sidecars next to a newly dropped video
+func (fw *FTPWatcher) attachSidecars(videoPath string, videoID uint) {
+	if fw.subtitleService == nil {
+		return
+	}
+	dir := filepath.Dir(videoPath)
+	videoStem := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !fw.isSubtitleFile(entry.Name()) {
+			continue
+		}
+		if stem, language := sidecarName(entry.Name()); stem == videoStem {
+			fw.attachSidecar(filepath.Join(dir, entry.Name()), language, videoID)
+		}
+	}
+}
+
+func (fw *FTPWatcher) attachSidecar(filePath, language string, videoID uint) {
+	relativeFilePath := strings.TrimPrefix(filePath, fw.watchPath+"/")
+	if _, err := fw.subtitleService.AttachSubtitle(videoID, language, "", relativeFilePath, false); err != nil {
+		log.Printf("Failed to attach subtitle %s to video %d: %v", filePath, videoID, err)
+		return
+	}
+	log.Printf("Subtitle %s (%s) attached to video %d", filePath, language, videoID)
+}
+
 func (fw *FTPWatcher) processNewVideo(filePath string) {
 	// Check if file exists and is readable
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -189,6 +280,9 @@ func (fw *FTPWatcher) processNewVideo(filePath string) {
 		return
 	}
 
+	// Attach subtitles dropped before the video
+	fw.attachSidecars(filePath, video.ID)
+
 	// Queue transcoding jobs
 	err = fw.queueTranscodingJobs(video.ID)
 	if err != nil {
@@ -211,57 +305,45 @@ func (fw *FTPWatcher) createVideoFromFile(filePath string, fileInfo os.FileInfo)
 		UpdatedAt:        time.Now(),
 	}
 
-	// Get video duration using ffprobe
-	duration, err := fw.getVideoDuration(filePath)
+	// Get video duration and picture size using ffprobe
+	media, err := utils.ProbeMedia("ffprobe", filePath)
 	if err != nil {
-		log.Printf("Failed to get video duration: %v", err)
-		duration = 0
+		log.Printf("Failed to probe video: %v", err)
+	} else {
+		video.Duration = int(media.Duration + 0.5)
 	}
-	video.Duration = int(duration)
 
-	// Save to database
-	if err := fw.db.Create(video).Error; err != nil {
+	// Watch folder drops are transcoded with the default preset
+	preset, ladder, err := fw.presetService.Ladder(nil)
+	if err != nil {
 		return nil, err
 	}
+	// Never ingest content meant to be encrypted in the clear
+	if preset.Encrypt {
+		if fw.keyService == nil {
+			return nil, fmt.Errorf("preset %d encrypts, but encryption is not available", preset.ID)
+		}
+		if err := fw.keyService.CheckConfigured(); err != nil {
+			return nil, fmt.Errorf("preset %d encrypts: %w", preset.ID, err)
+		}
+	}
 
-	// Create video profiles
-	profiles := []models.VideoProfile{
-		{
-			VideoID:      video.ID,
-			Resolution:   "720p",
-			CodecVideo:   "h264",
-			CodecAudio:   "aac",
-			Bitrate:      2000,
-			AudioBitrate: 128,
-			SegmentTime:  4,
-			Status:       "pending",
-			CreatedAt:    time.Now(),
-		},
-		{
-			VideoID:      video.ID,
-			Resolution:   "480p",
-			CodecVideo:   "h264",
-			CodecAudio:   "aac",
-			Bitrate:      1000,
-			AudioBitrate: 128,
-			SegmentTime:  4,
-			Status:       "pending",
-			CreatedAt:    time.Now(),
-		},
-		{
-			VideoID:      video.ID,
-			Resolution:   "360p",
-			CodecVideo:   "h264",
-			CodecAudio:   "aac",
-			Bitrate:      500,
-			AudioBitrate: 128,
-			SegmentTime:  4,
-			Status:       "pending",
-			CreatedAt:    time.Now(),
-		},
+	video.PresetID = &preset.ID
+	video.SinglePass = preset.SinglePass
+	video.Encrypted = preset.Encrypt
+	video.KeyRotation = preset.KeyRotation
+	video.LoudnessTarget = preset.LoudnessTarget
+
+	// Save to database
+	if err := fw.db.Create(video).Error; err != nil {
+		return nil, err
 	}
 
-	for _, profile := range profiles {
+	// Create video profiles; never upscale, and keep the source's aspect ratio
+	for _, profile := range fitLadder(ladder, media, false) {
+		profile.VideoID = video.ID
+		profile.Status = "pending"
+		profile.CreatedAt = time.Now()
 		if err := fw.db.Create(&profile).Error; err != nil {
 			log.Printf("Failed to create video profile: %v", err)
 		}
@@ -270,43 +352,7 @@ func (fw *FTPWatcher) createVideoFromFile(filePath string, fileInfo os.FileInfo)
 	return video, nil
 }
 
-func (fw *FTPWatcher) getVideoDuration(filePath string) (int64, error) {
-	// Use ffprobe to get video duration
-	cmd := exec.Command("ffprobe",
-		"-v", "quiet",
-		"-show_entries", "format=duration",
-		"-of", "csv=p=0",
-		filePath)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
-	}
-
-	durationStr := strings.TrimSpace(string(output))
-	duration, err := strconv.ParseFloat(durationStr, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	// Round to nearest integer
-	return int64(duration + 0.5), nil
-}
-
 func (fw *FTPWatcher) queueTranscodingJobs(videoID uint) error {
-	// Get all pending profiles for this video
-	var profiles []models.VideoProfile
-	if err := fw.db.Where("video_id = ? AND status = ?", videoID, "pending").Find(&profiles).Error; err != nil {
-		return err
-	}
-
-	// Queue each profile for transcoding
-	for _, profile := range profiles {
-		err := fw.transcodeService.QueueTranscodeJob(videoID, profile.ID, 1)
-		if err != nil {
-			log.Printf("Failed to queue transcoding job for video %d, profile %d: %v", videoID, profile.ID, err)
-		}
-	}
-
-	return nil
+	// Queue the pending profiles, in one job when the preset encodes in a single pass
+	return fw.transcodeService.QueueVideoJobs(videoID, 1)
 }
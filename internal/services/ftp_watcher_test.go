@@ -0,0 +1,66 @@
+package services
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateVideoFromFileChecksEncryption(t *testing.T) {
+	tests := []struct {
+		name       string
+		encrypt    bool
+		keyService *KeyService
+		wantErr    string // empty when the video is created
+	}{
+		{name: "clear preset", encrypt: false},
+		{name: "encrypting preset", encrypt: true, keyService: newTestKeyService(testMasterKey)},
+		{name: "encrypting preset without a master key", encrypt: true, keyService: newTestKeyService(""), wantErr: "preset 1 encrypts"},
+		{name: "encrypting preset without a key service", encrypt: true, wantErr: "encryption is not available"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watchPath := t.TempDir()
+			filePath := filepath.Join(watchPath, "drop.mp4")
+			if err := os.WriteFile(filePath, []byte("video"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fileInfo, err := os.Stat(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, store := newTestDB(t)
+			store.answer("FROM `encoding_presets`", testResult{
+				columns: []string{"id", "is_default", "encrypt"},
+				rows:    [][]driver.Value{{int64(1), true, tt.encrypt}},
+			})
+			fw := NewFTPWatcher(watchPath, nil, nil, db)
+			fw.SetPresetService(NewPresetService(db))
+			if tt.keyService != nil {
+				fw.SetKeyService(tt.keyService)
+			}
+
+			video, err := fw.createVideoFromFile(filePath, fileInfo)
+			created := len(store.statements("INSERT INTO `videos`"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("createVideoFromFile() error = %v, want %q", err, tt.wantErr)
+				}
+				if created != 0 {
+					t.Error("created a video that cannot be encrypted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createVideoFromFile() error = %v", err)
+			}
+			if created != 1 || video.Encrypted != tt.encrypt {
+				t.Errorf("created %d videos, encrypted %v; want 1, encrypted %v", created, video.Encrypted, tt.encrypt)
+			}
+		})
+	}
+}
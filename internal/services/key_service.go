@@ -0,0 +1,234 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// keyURIFormat is the key delivery URL written into EXT-X-KEY
+const keyURIFormat = "/api/v1/keys/%d"
+
+type KeyService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func NewKeyService(db *gorm.DB, cfg *config.Config) *KeyService {
+	return &KeyService{db: db, config: cfg}
+}
+
+// sealer returns the AEAD sealing content keys with the configured master key
+func (s *KeyService) sealer() (cipher.AEAD, error) {
+	if s.config.Encryption.MasterKey == "" {
+		return nil, fmt.Errorf("encryption is not configured: KEY_MASTER_KEY is not set")
+	}
+	master, err := hex.DecodeString(s.config.Encryption.MasterKey)
+	if err != nil || len(master) != 32 {
+		return nil, fmt.Errorf("KEY_MASTER_KEY must be 64 hex digits")
+	}
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CheckConfigured returns an error when content keys cannot be created
+func (s *KeyService) CheckConfigured() error {
+	_, err := s.sealer()
+	return err
+}
+
+// CreateKey generates a content key and IV for a video and stores the key sealed.
+// The plain key is returned for FFmpeg.
+func (s *KeyService) CreateKey(videoID uint) (*models.EncryptionKey, []byte, error) {
+	aead, err := s.sealer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := make([]byte, 16)
+	iv := make([]byte, 16)
+	nonce := make([]byte, aead.NonceSize())
+	for _, buf := range [][]byte{key, iv, nonce} {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	record := &models.EncryptionKey{
+		VideoID:    videoID,
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, nil)),
+		IV:         hex.EncodeToString(iv),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, nil, err
+	}
+	return record, key, nil
+}
+
+// GetKey returns the plain content key with the given ID
+func (s *KeyService) GetKey(id uint) ([]byte, error) {
+	var record models.EncryptionKey
+	if err := s.db.First(&record, id).Error; err != nil {
+		return nil, err
+	}
+
+	aead, err := s.sealer()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(record.Ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid sealed key %d", id)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal key %d: %w", id, err)
+	}
+	return key, nil
+}
+
+// keyToken signs access to a key until expires (Unix time) with a key derived from
+// the master key
+func (s *KeyService) keyToken(id uint, expires int64) string {
+	derive := hmac.New(sha256.New, []byte(s.config.Encryption.MasterKey))
+	derive.Write([]byte("key delivery"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize reports whether a key URI's token grants the key with the given ID
+func (s *KeyService) Authorize(id uint, expires int64, token string) bool {
+	if s.config.Encryption.MasterKey == "" || token == "" || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(s.keyToken(id, expires)))
+}
+
+// SignPlaylist adds a token to the key URIs of a media playlist, so players fetch
+// keys with nothing but the URI. Tokens expire TokenTTL seconds after the playlist's
+// content would have played out.
+func (s *KeyService) SignPlaylist(content string) string {
+	if !strings.Contains(content, "#EXT-X-KEY:") {
+		return content
+	}
+
+	ttl := time.Duration(s.config.Encryption.TokenTTL) * time.Second
+	duration := time.Duration(utils.TotalDuration(utils.ParseMediaPlaylist(content)) * float64(time.Second))
+	expires := time.Now().Add(duration + ttl).Unix()
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "#EXT-X-KEY:") {
+			continue
+		}
+		uri := utils.AttributeValue(strings.TrimPrefix(line, "#EXT-X-KEY:"), "URI")
+		var id uint
+		if _, err := fmt.Sscanf(uri, keyURIFormat, &id); err != nil || uri != fmt.Sprintf(keyURIFormat, id) {
+			continue
+		}
+		signed := fmt.Sprintf(keyURIFormat+"?expires=%d&token=%s", id, expires, s.keyToken(id, expires))
+		lines[i] = strings.Replace(line, `URI="`+uri+`"`, `URI="`+signed+`"`, 1)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// keyRotation maintains the key info file FFmpeg encrypts a video's segments with,
+// replacing the key every interval seconds of output when rotation is enabled. With
+// the periodic_rekey flag FFmpeg rereads the file at every segment.
+type keyRotation struct {
+	keys     *KeyService
+	videoID  uint
+	dir      string
+	interval float64
+	next     float64
+}
+
+// newKeyRotation creates the first key of an encrypted video's transcode; keys rotate
+// every KeyRotation segments of segmentTime seconds
+func (s *KeyService) newKeyRotation(video *models.Video, segmentTime int) (*keyRotation, error) {
+	dir, err := os.MkdirTemp("", fmt.Sprintf("keys_%d_*", video.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &keyRotation{
+		keys:     s,
+		videoID:  video.ID,
+		dir:      dir,
+		interval: float64(video.KeyRotation * segmentTime),
+	}
+	if err := rotation.rotate(); err != nil {
+		rotation.Close()
+		return nil, err
+	}
+	rotation.next = rotation.interval
+	return rotation, nil
+}
+
+// InfoFile returns the path of the key info file passed to -hls_key_info_file
+func (k *keyRotation) InfoFile() string {
+	return filepath.Join(k.dir, "key_info")
+}
+
+// Rotates reports whether the key changes during the transcode
+func (k *keyRotation) Rotates() bool {
+	return k.interval > 0
+}
+
+// rotate creates a key and points the key info file at it. The file is replaced
+// atomically so FFmpeg never reads it half written.
+func (k *keyRotation) rotate() error {
+	record, key, err := k.keys.CreateKey(k.videoID)
+	if err != nil {
+		return err
+	}
+
+	keyPath := filepath.Join(k.dir, fmt.Sprintf("%d.key", record.ID))
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+	info := fmt.Sprintf(keyURIFormat+"\n%s\n%s\n", record.ID, keyPath, record.IV)
+	tmp := k.InfoFile() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(info), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.InfoFile())
+}
+
+// advance rotates the key once the output reaches the next rotation point
+func (k *keyRotation) advance(outTime float64) {
+	if !k.Rotates() || outTime < k.next {
+		return
+	}
+	for k.next <= outTime {
+		k.next += k.interval
+	}
+	if err := k.rotate(); err != nil {
+		logrus.Errorf("Failed to rotate the encryption key of video %d: %v", k.videoID, err)
+	}
+}
+
+// Close removes the plain keys from disk
+func (k *keyRotation) Close() {
+	os.RemoveAll(k.dir)
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/config"
+	"linier-channel/internal/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMasterKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func newTestKeyService(masterKey string) *KeyService {
+	cfg := &config.Config{}
+	cfg.Encryption.MasterKey = masterKey
+	cfg.Encryption.TokenTTL = 300
+	return NewKeyService(nil, cfg)
+}
+
+func TestSignPlaylist(t *testing.T) {
+	s := newTestKeyService(testMasterKey)
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"/api/v1/keys/12\",IV=0x000102030405060708090a0b0c0d0e0f\n" +
+		"#EXTINF:6.000000,\nsegment_000.ts\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"/api/v1/keys/13\",IV=0x0f0e0d0c0b0a09080706050403020100\n" +
+		"#EXTINF:6.000000,\nsegment_001.ts\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/14\"\n" +
+		"#EXTINF:6.000000,\nsegment_002.ts\n#EXT-X-ENDLIST\n"
+
+	before := time.Now()
+	signed := s.SignPlaylist(playlist)
+	segments := utils.ParseMediaPlaylist(signed)
+	if len(segments) != 3 {
+		t.Fatalf("signed playlist has %d segments, want 3", len(segments))
+	}
+
+	for i, id := range []uint{12, 13} {
+		key := segments[i].Key
+		if !strings.HasPrefix(utils.AttributeValue(key, "IV"), "0x") || utils.AttributeValue(key, "METHOD") != "AES-128" {
+			t.Errorf("segment %d key lost its attributes: %s", i, key)
+		}
+
+		uri, err := url.Parse(utils.AttributeValue(key, "URI"))
+		if err != nil || uri.Path != fmt.Sprintf(keyURIFormat, id) {
+			t.Fatalf("segment %d key URI = %q, want a signed %s", i, utils.AttributeValue(key, "URI"), fmt.Sprintf(keyURIFormat, id))
+		}
+		expires, _ := strconv.ParseInt(uri.Query().Get("expires"), 10, 64)
+		// Valid while the playlist plays out, plus the TTL
+		if min := before.Add(18*time.Second + 300*time.Second).Unix(); expires < min {
+			t.Errorf("segment %d key expires at %d, want at least %d", i, expires, min)
+		}
+		if !s.Authorize(id, expires, uri.Query().Get("token")) {
+			t.Errorf("segment %d key URI is not authorized", i)
+		}
+	}
+
+	if got := utils.AttributeValue(segments[2].Key, "URI"); got != "https://keys.example.com/14" {
+		t.Errorf("foreign key URI = %q, want it untouched", got)
+	}
+
+	clear := "#EXTM3U\n#EXTINF:6.000000,\nsegment_000.ts\n"
+	if got := s.SignPlaylist(clear); got != clear {
+		t.Errorf("SignPlaylist() changed a playlist in the clear: %q", got)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := newTestKeyService(testMasterKey)
+	valid := time.Now().Add(time.Minute).Unix()
+	expired := time.Now().Add(-time.Second).Unix()
+
+	tests := []struct {
+		name    string
+		service *KeyService
+		id      uint
+		expires int64
+		token   string
+		want    bool
+	}{
+		{name: "valid", service: s, id: 12, expires: valid, token: s.keyToken(12, valid), want: true},
+		{name: "expired", service: s, id: 12, expires: expired, token: s.keyToken(12, expired)},
+		{name: "other key", service: s, id: 13, expires: valid, token: s.keyToken(12, valid)},
+		{name: "extended expiry", service: s, id: 12, expires: valid + 3600, token: s.keyToken(12, valid)},
+		{name: "missing token", service: s, id: 12, expires: valid},
+		{name: "other master key", service: newTestKeyService(strings.Repeat("ff", 32)), id: 12, expires: valid, token: s.keyToken(12, valid)},
+		{name: "no master key", service: newTestKeyService(""), id: 12, expires: valid, token: newTestKeyService("").keyToken(12, valid)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.service.Authorize(tt.id, tt.expires, tt.token); got != tt.want {
+				t.Errorf("Authorize(%d, %d, %q) = %v, want %v", tt.id, tt.expires, tt.token, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"math"
+)
+
+// defaultAspect is assumed for sources whose geometry could not be probed
+const defaultAspect = 16.0 / 9.0
+
+// fitLadder sizes the rungs of an encoding ladder to a source. The nominal size of
+// a rung ("720p") is the shorter side of the picture, so portrait sources keep the
+// same detail as landscape ones, and the longer side follows the source's display
+// aspect ratio. Rungs larger than the source are dropped unless upscale is set; a
+// source smaller than every rung gets a single rung at its own size.
+func fitLadder(ladder []models.VideoProfile, source *utils.MediaInfo, upscale bool) []models.VideoProfile {
+	width, height := 0, 0
+	if source != nil {
+		width, height = source.DisplaySize()
+	}
+
+	aspect := defaultAspect
+	portrait := false
+	shortSide := 0
+	if width > 0 && height > 0 {
+		aspect = float64(width) / float64(height)
+		portrait = height > width
+		shortSide = height
+		if portrait {
+			shortSide = width
+		}
+	} else {
+		// Without a probe nothing is known about the source, so nothing can be dropped
+		upscale = true
+	}
+
+	fitted := make([]models.VideoProfile, 0, len(ladder))
+	for _, rung := range ladder {
+		size := resolutionHeight(rung.Resolution)
+		if size == 0 {
+			size = shortSide
+		}
+		if size == 0 || (size > shortSide && !upscale) {
+			continue
+		}
+
+		rung.Width, rung.Height = rungDimensions(size, aspect, portrait)
+		fitted = append(fitted, rung)
+	}
+
+	if len(fitted) == 0 && len(ladder) > 0 {
+		// Keep the cheapest rung, at the size of the source
+		rung := ladder[len(ladder)-1]
+		for _, candidate := range ladder {
+			if candidate.Bitrate < rung.Bitrate {
+				rung = candidate
+			}
+		}
+		size := evenDimension(float64(shortSide))
+		rung.Resolution = fmt.Sprintf("%dp", size)
+		rung.Width, rung.Height = rungDimensions(size, aspect, portrait)
+		fitted = append(fitted, rung)
+	}
+
+	return fitted
+}
+
+// rungDimensions returns the even output width and height of a rung whose shorter side is size
+func rungDimensions(size int, aspect float64, portrait bool) (int, int) {
+	if portrait {
+		return evenDimension(float64(size)), evenDimension(float64(size) / aspect)
+	}
+	return evenDimension(float64(size) * aspect), evenDimension(float64(size))
+}
+
+// evenDimension rounds a picture dimension to the nearest even number, as 4:2:0 chroma requires
+func evenDimension(value float64) int {
+	even := int(math.Round(value/2)) * 2
+	if even < 2 {
+		return 2
+	}
+	return even
+}
+
+// profileDimensions returns the output size of a profile, falling back to the 16:9
+// size of its nominal resolution for profiles transcoded before sizes were recorded
+func profileDimensions(profile *models.VideoProfile) (int, int) {
+	if profile.Width > 0 && profile.Height > 0 {
+		return profile.Width, profile.Height
+	}
+	return rungDimensions(resolutionHeight(profile.Resolution), defaultAspect, false)
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFitLadder(t *testing.T) {
+	ladder := []models.VideoProfile{
+		{Resolution: "1080p", Bitrate: 5000},
+		{Resolution: "720p", Bitrate: 3000},
+		{Resolution: "480p", Bitrate: 1500},
+		{Resolution: "360p", Bitrate: 800},
+	}
+
+	tests := []struct {
+		name    string
+		video   string // video stream reported by ffprobe, empty when the source was not probed
+		upscale bool
+		want    []string // resolution, size and bitrate of each fitted rung
+	}{
+		{
+			name:  "landscape source",
+			video: `{"codec_type":"video","width":1920,"height":1080,"sample_aspect_ratio":"1:1"}`,
+			want:  []string{"1080p 1920x1080 5000", "720p 1280x720 3000", "480p 854x480 1500", "360p 640x360 800"},
+		},
+		{
+			name:  "rotated phone source",
+			video: `{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":-90}]}`,
+			want:  []string{"1080p 1080x1920 5000", "720p 720x1280 3000", "480p 480x854 1500", "360p 360x640 800"},
+		},
+		{
+			name:  "phone source rotated by an older muxer",
+			video: `{"codec_type":"video","width":1280,"height":720,"tags":{"rotate":"90"}}`,
+			want:  []string{"720p 720x1280 3000", "480p 480x854 1500", "360p 360x640 800"},
+		},
+		{
+			name:  "anamorphic source",
+			video: `{"codec_type":"video","width":720,"height":576,"sample_aspect_ratio":"16:15"}`,
+			want:  []string{"480p 640x480 1500", "360p 480x360 800"},
+		},
+		{
+			name:    "anamorphic source upscaled",
+			video:   `{"codec_type":"video","width":720,"height":576,"sample_aspect_ratio":"16:15"}`,
+			upscale: true,
+			want:    []string{"1080p 1440x1080 5000", "720p 960x720 3000", "480p 640x480 1500", "360p 480x360 800"},
+		},
+		{
+			name:  "source smaller than the lowest rung",
+			video: `{"codec_type":"video","width":320,"height":240}`,
+			want:  []string{"240p 320x240 800"},
+		},
+		{
+			name:  "odd-sized source smaller than the lowest rung",
+			video: `{"codec_type":"video","width":426,"height":241}`,
+			want:  []string{"242p 428x242 800"},
+		},
+		{
+			name: "source not probed",
+			want: []string{"1080p 1920x1080 5000", "720p 1280x720 3000", "480p 854x480 1500", "360p 640x360 800"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var source *utils.MediaInfo
+			if tt.video != "" {
+				t.Setenv("FAKE_FFMPEG", "1")
+				t.Setenv("FAKE_FFPROBE_VIDEO", tt.video)
+				var err error
+				if source, err = utils.ProbeMedia(os.Args[0], "source.mp4"); err != nil {
+					t.Fatalf("ProbeMedia() error = %v", err)
+				}
+			}
+
+			var got []string
+			for _, rung := range fitLadder(ladder, source, tt.upscale) {
+				got = append(got, fmt.Sprintf("%s %dx%d %d", rung.Resolution, rung.Width, rung.Height, rung.Bitrate))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fitLadder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRungDimensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		aspect     float64
+		portrait   bool
+		wantWidth  int
+		wantHeight int
+	}{
+		{name: "16:9", size: 720, aspect: 16.0 / 9.0, wantWidth: 1280, wantHeight: 720},
+		{name: "16:9 rounded to even", size: 480, aspect: 16.0 / 9.0, wantWidth: 854, wantHeight: 480},
+		{name: "4:3", size: 576, aspect: 4.0 / 3.0, wantWidth: 768, wantHeight: 576},
+		{name: "9:16 portrait", size: 720, aspect: 9.0 / 16.0, portrait: true, wantWidth: 720, wantHeight: 1280},
+		{name: "odd size", size: 241, aspect: 1, wantWidth: 242, wantHeight: 242},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := rungDimensions(tt.size, tt.aspect, tt.portrait)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("rungDimensions(%d, %.3f, %v) = %dx%d, want %dx%d",
+					tt.size, tt.aspect, tt.portrait, width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestEvenDimension(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  int
+	}{
+		{value: 720, want: 720},
+		{value: 853.33, want: 854},
+		{value: 241, want: 242},
+		{value: 242.9, want: 242},
+		{value: 1, want: 2},
+		{value: 0, want: 2},
+	}
+
+	for _, tt := range tests {
+		if got := evenDimension(tt.value); got != tt.want {
+			t.Errorf("evenDimension(%v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
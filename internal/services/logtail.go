@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// logTailSize is how much of the end of FFmpeg's log is kept
+const logTailSize = 8192
+
+// logTail keeps the end of a process's log, however long the process runs. When
+// onLine is set, it is called with every line as it is written.
+type logTail struct {
+	mu      sync.Mutex
+	buf     []byte
+	partial []byte // unterminated last line, for onLine
+	onLine  func(line string)
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > logTailSize {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-logTailSize:]...)
+	}
+
+	if t.onLine != nil {
+		t.partial = append(t.partial, p...)
+		for {
+			end := bytes.IndexByte(t.partial, '\n')
+			if end < 0 {
+				break
+			}
+			if line := strings.TrimSpace(string(t.partial[:end])); line != "" {
+				t.onLine(line)
+			}
+			t.partial = t.partial[end+1:]
+		}
+		if len(t.partial) > logTailSize {
+			t.partial = append([]byte(nil), t.partial[len(t.partial)-logTailSize:]...)
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the kept end of the log
+func (t *logTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// lastLines returns the last count non-empty lines of the log
+func (t *logTail) lastLines(count int) string {
+	var lines []string
+	for _, line := range strings.Split(t.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Loudness measurement states of a video
+const (
+	loudnessPending   = "pending"
+	loudnessMeasuring = "measuring"
+	loudnessMeasured  = "measured"
+	loudnessSkipped   = "skipped" // no audible audio to normalise
+)
+
+// loudnessPollInterval is how often jobs check on the measurement another job claimed
+const loudnessPollInterval = 2 * time.Second
+
+// loudnessClaimTimeout is how long a measurement stays claimed when encodes are not
+// time limited, before another job takes it over
+const loudnessClaimTimeout = time.Hour
+
+// loudnessFilter returns the audio filter normalising the first audio track of a video
+// to its loudness target, empty when the video is not normalised or has no audible
+// audio. The first job that needs it claims the measurement of the source; the others
+// wait for the measurements it stores on the video for the second, linear pass. The
+// measurement runs as an encode of the given profiles, and both it and the wait stop
+// with ErrCancelled when they are cancelled.
+func (s *TranscodeService) loudnessFilter(video *models.Video, sourcePath string, profileIDs []uint) (string, error) {
+	if video.LoudnessTarget == 0 {
+		return "", nil
+	}
+
+	for video.MeasuredI == nil && video.LoudnessStatus != loudnessSkipped {
+		claimed, err := s.claimLoudness(video)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			if err := s.measureVideoLoudness(video, sourcePath, profileIDs); err != nil {
+				// Release the claim so the next job measures again
+				s.db.Model(&models.Video{}).Where("id = ? AND loudness_status = ?", video.ID, loudnessMeasuring).
+					Update("loudness_status", loudnessPending)
+				return "", err
+			}
+			break
+		}
+
+		time.Sleep(loudnessPollInterval)
+		if cancelled, err := s.profilesCancelled(profileIDs); err != nil {
+			return "", err
+		} else if cancelled {
+			return "", ErrCancelled
+		}
+		if err := s.db.Select("measured_i", "measured_tp", "measured_lra", "measured_thresh", "loudness_offset", "loudness_status").
+			First(video, video.ID).Error; err != nil {
+			return "", err
+		}
+	}
+
+	measurement := videoLoudness(video)
+	if measurement == nil {
+		return "", nil
+	}
+	return s.measuredLoudnorm(video.LoudnessTarget, measurement), nil
+}
+
+// claimLoudness claims the measurement of a video for this job. Claims left by jobs
+// that went away expire once the measurement would have been killed.
+func (s *TranscodeService) claimLoudness(video *models.Video) (bool, error) {
+	expiry := s.encodeTimeLimit(float64(video.Duration))
+	if expiry == 0 {
+		expiry = loudnessClaimTimeout
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.Video{}).
+		Where("id = ? AND measured_i IS NULL AND (loudness_status = ? OR (loudness_status = ? AND measuring_since < ?))",
+			video.ID, loudnessPending, loudnessMeasuring, now.Add(-expiry-time.Minute)).
+		Updates(map[string]interface{}{"loudness_status": loudnessMeasuring, "measuring_since": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// measureVideoLoudness measures the first audio stream of a video's source and stores
+// the measurements, or marks the video skipped when it has no audible audio
+func (s *TranscodeService) measureVideoLoudness(video *models.Video, sourcePath string, profileIDs []uint) error {
+	media, err := utils.ProbeMedia(s.config.FFmpeg.FFprobePath, sourcePath)
+	if err != nil {
+		return err
+	}
+
+	var measurement *utils.LoudnessMeasurement
+	if media.Audio > 0 {
+		measurement, err = s.measureLoudness(video, sourcePath, 0, profileIDs)
+		if errors.Is(err, utils.ErrSilentAudio) {
+			logrus.Warnf("Audio of video %d is silent, skipping loudness normalisation", video.ID)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if measurement == nil {
+		video.LoudnessStatus = loudnessSkipped
+		return s.db.Model(&models.Video{}).Where("id = ?", video.ID).Update("loudness_status", loudnessSkipped).Error
+	}
+
+	video.MeasuredI = &measurement.Integrated
+	video.MeasuredTP = measurement.TruePeak
+	video.MeasuredLRA = measurement.LRA
+	video.MeasuredThresh = measurement.Threshold
+	video.LoudnessOffset = measurement.Offset
+	video.LoudnessStatus = loudnessMeasured
+	if err := s.db.Model(&models.Video{}).Where("id = ?", video.ID).Updates(map[string]interface{}{
+		"measured_i":      measurement.Integrated,
+		"measured_tp":     measurement.TruePeak,
+		"measured_lra":    measurement.LRA,
+		"measured_thresh": measurement.Threshold,
+		"loudness_offset": measurement.Offset,
+		"loudness_status": loudnessMeasured,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to store loudness measurements: %w", err)
+	}
+	logrus.Infof("Measured loudness of video %d: %.1f LUFS, %.1f dBTP, %.1f LU",
+		video.ID, measurement.Integrated, measurement.TruePeak, measurement.LRA)
+	return nil
+}
+
+// measureTrackLoudness measures the audio streams of alternate tracks for the second,
+// linear pass of their encode and stores the measurements on the tracks. The stream
+// the video's renditions carry reuses the video's measurements; silent streams are
+// left unmeasured and are not normalised.
+func (s *TranscodeService) measureTrackLoudness(video *models.Video, sourcePath string, tracks []models.AudioTrack) error {
+	if video.LoudnessTarget == 0 {
+		return nil
+	}
+
+	for i := range tracks {
+		track := &tracks[i]
+		if track.MeasuredI != nil {
+			continue
+		}
+
+		measurement := videoLoudness(video)
+		if track.StreamIndex != 0 || measurement == nil {
+			var err error
+			measurement, err = s.measureLoudness(video, sourcePath, track.StreamIndex, nil)
+			if errors.Is(err, utils.ErrSilentAudio) {
+				logrus.Warnf("Audio track %d of video %d is silent, skipping loudness normalisation", track.ID, video.ID)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to measure loudness of audio track %d: %w", track.ID, err)
+			}
+		}
+
+		track.MeasuredI = &measurement.Integrated
+		track.MeasuredTP = measurement.TruePeak
+		track.MeasuredLRA = measurement.LRA
+		track.MeasuredThresh = measurement.Threshold
+		track.LoudnessOffset = measurement.Offset
+		if err := s.db.Model(&models.AudioTrack{}).Where("id = ?", track.ID).Updates(map[string]interface{}{
+			"measured_i":      measurement.Integrated,
+			"measured_tp":     measurement.TruePeak,
+			"measured_lra":    measurement.LRA,
+			"measured_thresh": measurement.Threshold,
+			"loudness_offset": measurement.Offset,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to store loudness measurements: %w", err)
+		}
+	}
+	return nil
+}
+
+// measureLoudness runs loudnorm's analysis of an audio stream of a video's source.
+// It decodes the whole stream, so it runs like an encode of the given profiles: it is
+// time limited, and killed when they are cancelled.
+func (s *TranscodeService) measureLoudness(video *models.Video, sourcePath string, stream int, profileIDs []uint) (*utils.LoudnessMeasurement, error) {
+	loudness := s.config.Loudness
+	cmd := utils.LoudnessCommand(s.config.FFmpeg.FFmpegPath, sourcePath, stream, video.LoudnessTarget, loudness.TruePeak, loudness.LRA)
+	log, err := s.runFFmpeg(cmd, profileIDs, float64(video.Duration), nil)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseLoudnormOutput(log)
+}
+
+// trackLoudnessFilter returns the audio filter normalising an alternate audio track
+// with its measurements, empty when it is not normalised
+func (s *TranscodeService) trackLoudnessFilter(video *models.Video, track *models.AudioTrack) string {
+	if video.LoudnessTarget == 0 || track.MeasuredI == nil {
+		return ""
+	}
+	return s.measuredLoudnorm(video.LoudnessTarget, &utils.LoudnessMeasurement{
+		Integrated: *track.MeasuredI,
+		TruePeak:   track.MeasuredTP,
+		LRA:        track.MeasuredLRA,
+		Threshold:  track.MeasuredThresh,
+		Offset:     track.LoudnessOffset,
+	})
+}
+
+// videoLoudness returns the stored measurements of a video's first audio stream, nil
+// until it is measured
+func videoLoudness(video *models.Video) *utils.LoudnessMeasurement {
+	if video.MeasuredI == nil {
+		return nil
+	}
+	return &utils.LoudnessMeasurement{
+		Integrated: *video.MeasuredI,
+		TruePeak:   video.MeasuredTP,
+		LRA:        video.MeasuredLRA,
+		Threshold:  video.MeasuredThresh,
+		Offset:     video.LoudnessOffset,
+	}
+}
+
+// measuredLoudnorm returns the second loudnorm pass, applying the measurements of an
+// audio stream as one linear gain
+func (s *TranscodeService) measuredLoudnorm(target float64, measurement *utils.LoudnessMeasurement) string {
+	return fmt.Sprintf("%s:measured_I=%g:measured_TP=%g:measured_LRA=%g:measured_thresh=%g:offset=%g:linear=true,aresample=48000",
+		s.loudnormTarget(target), measurement.Integrated, measurement.TruePeak, measurement.LRA, measurement.Threshold, measurement.Offset)
+}
+
+// loudnormTarget returns the loudnorm filter with a loudness target; loudnorm
+// resamples to 192 kHz, so callers resample its output back
+func (s *TranscodeService) loudnormTarget(target float64) string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, s.config.Loudness.TruePeak, s.config.Loudness.LRA)
+}
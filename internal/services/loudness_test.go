@@ -0,0 +1,222 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	measuredVideoFilter   = "loudnorm=I=-23:TP=-1:LRA=11:measured_I=-20:measured_TP=-1.5:measured_LRA=6.2:measured_thresh=-31:offset=0.1:linear=true,aresample=48000"
+	measuredStream1Filter = "loudnorm=I=-23:TP=-1:LRA=11:measured_I=-21:measured_TP=-1.5:measured_LRA=6.2:measured_thresh=-31:offset=0.1:linear=true,aresample=48000"
+)
+
+// newTestTranscodeService returns a transcode service running the fake FFmpeg and
+// ffprobe, and the path of the log of their runs
+func newTestTranscodeService(t *testing.T) (*TranscodeService, *testDB, string) {
+	t.Helper()
+	runs := filepath.Join(t.TempDir(), "runs")
+	t.Setenv("FAKE_FFMPEG", "1")
+	t.Setenv("FAKE_FFMPEG_LOG", runs)
+
+	db, store := newTestDB(t)
+	cfg := &config.Config{}
+	cfg.FFmpeg.FFmpegPath = os.Args[0]
+	cfg.FFmpeg.FFprobePath = os.Args[0]
+	cfg.Loudness.TruePeak = -1
+	cfg.Loudness.LRA = 11
+	cfg.Transcode.TimeoutMultiplier = 10
+	cfg.Transcode.MinTimeout = 60
+	return NewTranscodeService(db, cfg), store, runs
+}
+
+// measurementRuns returns the loudness analyses the fake FFmpeg ran
+func measurementRuns(t *testing.T, runs string) []string {
+	t.Helper()
+	content, err := os.ReadFile(runs)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var measured []string
+	for _, run := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if strings.Contains(run, "print_format=json") {
+			measured = append(measured, run)
+		}
+	}
+	return measured
+}
+
+func TestLoudnessFilter(t *testing.T) {
+	measuredI := -20.0
+
+	tests := []struct {
+		name         string
+		video        models.Video
+		claimed      int64
+		other        []driver.Value // the video's measurements as stored by the job holding the claim
+		want         string
+		wantMeasured int
+	}{
+		{
+			name:  "not normalised",
+			video: models.Video{ID: 1, LoudnessStatus: loudnessPending},
+		},
+		{
+			name: "already measured",
+			video: models.Video{ID: 1, LoudnessTarget: -23, LoudnessStatus: loudnessMeasured,
+				MeasuredI: &measuredI, MeasuredTP: -1.5, MeasuredLRA: 6.2, MeasuredThresh: -31, LoudnessOffset: 0.1},
+			want: measuredVideoFilter,
+		},
+		{
+			name:         "claimed and measured by this job",
+			video:        models.Video{ID: 1, Duration: 60, LoudnessTarget: -23, LoudnessStatus: loudnessPending},
+			claimed:      1,
+			want:         measuredVideoFilter,
+			wantMeasured: 1,
+		},
+		{
+			name:    "measured by the job holding the claim",
+			video:   models.Video{ID: 1, Duration: 60, LoudnessTarget: -23, LoudnessStatus: loudnessMeasuring},
+			claimed: 0,
+			other:   []driver.Value{-20.0, -1.5, 6.2, -31.0, 0.1, loudnessMeasured},
+			want:    measuredVideoFilter,
+		},
+		{
+			name:    "skipped by the job holding the claim",
+			video:   models.Video{ID: 1, Duration: 60, LoudnessTarget: -23, LoudnessStatus: loudnessMeasuring},
+			claimed: 0,
+			other:   []driver.Value{nil, 0.0, 0.0, 0.0, 0.0, loudnessSkipped},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, store, runs := newTestTranscodeService(t)
+			store.answer("`measuring_since`=?", testResult{rowsAffected: tt.claimed})
+			if tt.other != nil {
+				store.answer("FROM `videos`", testResult{
+					columns: []string{"measured_i", "measured_tp", "measured_lra", "measured_thresh", "loudness_offset", "loudness_status"},
+					rows:    [][]driver.Value{tt.other},
+				})
+			}
+
+			video := tt.video
+			got, err := s.loudnessFilter(&video, "source.mp4", []uint{5})
+			if err != nil {
+				t.Fatalf("loudnessFilter() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("loudnessFilter() = %q, want %q", got, tt.want)
+			}
+			if measured := measurementRuns(t, runs); len(measured) != tt.wantMeasured {
+				t.Errorf("measured %d times, want %d", len(measured), tt.wantMeasured)
+			}
+
+			// Only a job holding the claim stores measurements
+			stored := store.statements("`loudness_status`=?,`measured_i`=?")
+			if len(stored) != tt.wantMeasured {
+				t.Errorf("stored measurements %d times, want %d", len(stored), tt.wantMeasured)
+			}
+		})
+	}
+}
+
+func TestClaimLoudnessIsGuarded(t *testing.T) {
+	s, store, _ := newTestTranscodeService(t)
+	store.answer("`measuring_since`=?", testResult{rowsAffected: 0})
+
+	claimed, err := s.claimLoudness(&models.Video{ID: 1, Duration: 60})
+	if err != nil || claimed {
+		t.Fatalf("claimLoudness() = %v, %v; want false as another job holds it", claimed, err)
+	}
+
+	claims := store.statements("`measuring_since`=?")
+	if len(claims) != 1 {
+		t.Fatalf("got %d claims, want 1", len(claims))
+	}
+	want := "measured_i IS NULL AND (loudness_status = ? OR (loudness_status = ? AND measuring_since < ?))"
+	if !strings.Contains(claims[0].query, want) {
+		t.Errorf("claim %q is not guarded by %q", claims[0].query, want)
+	}
+}
+
+func TestMeasureTrackLoudness(t *testing.T) {
+	s, store, runs := newTestTranscodeService(t)
+	measuredI := -20.0
+	video := &models.Video{ID: 1, Duration: 60, LoudnessTarget: -23, LoudnessStatus: loudnessMeasured,
+		MeasuredI: &measuredI, MeasuredTP: -1.5, MeasuredLRA: 6.2, MeasuredThresh: -31, LoudnessOffset: 0.1}
+	tracks := []models.AudioTrack{{ID: 10, StreamIndex: 0}, {ID: 11, StreamIndex: 1}}
+
+	if err := s.measureTrackLoudness(video, "source.mp4", tracks); err != nil {
+		t.Fatalf("measureTrackLoudness() error = %v", err)
+	}
+
+	// The first stream reuses the video's measurement; only the second is analysed
+	measured := measurementRuns(t, runs)
+	if len(measured) != 1 || !strings.Contains(measured[0], "-map 0:a:1") {
+		t.Errorf("measurements = %q, want one of stream 1", measured)
+	}
+	if got := len(store.statements("UPDATE `audio_tracks`")); got != 2 {
+		t.Errorf("stored %d track measurements, want 2", got)
+	}
+
+	for i, want := range []string{measuredVideoFilter, measuredStream1Filter} {
+		if got := s.trackLoudnessFilter(video, &tracks[i]); got != want {
+			t.Errorf("trackLoudnessFilter(track %d) = %q, want %q", tracks[i].ID, got, want)
+		}
+	}
+}
+
+func TestMeasureLoudnessIsKilledWhenCancelled(t *testing.T) {
+	s, _, runs := newTestTranscodeService(t)
+	t.Setenv("FAKE_FFMPEG_SLOW", "1")
+	video := &models.Video{ID: 1, Duration: 60, LoudnessTarget: -23}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.measureLoudness(video, "source.mp4", 0, []uint{5})
+		result <- err
+	}()
+
+	// Cancel once the measurement runs as the profile's encode
+	deadline := time.Now().Add(5 * time.Second)
+	for len(measurementRuns(t, runs)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("measurement did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.killEncode(5)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("measureLoudness() error = %v, want ErrCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("measurement was not killed")
+	}
+}
+
+func TestLoudnessFilterStopsWaitingWhenCancelled(t *testing.T) {
+	s, store, runs := newTestTranscodeService(t)
+	store.answer("`measuring_since`=?", testResult{rowsAffected: 0})
+	store.answer("FROM `video_profiles`", testResult{columns: []string{"count(*)"}, rows: [][]driver.Value{{int64(1)}}})
+
+	video := &models.Video{ID: 1, Duration: 60, LoudnessTarget: -23, LoudnessStatus: loudnessMeasuring}
+	if _, err := s.loudnessFilter(video, "source.mp4", []uint{5}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("loudnessFilter() error = %v, want ErrCancelled", err)
+	}
+	if measured := measurementRuns(t, runs); len(measured) != 0 {
+		t.Errorf("measured %d times while another job holds the claim", len(measured))
+	}
+}
@@ -0,0 +1,583 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Output target statuses
+const (
+	OutputStopped    = "stopped"
+	OutputStarting   = "starting"
+	OutputRunning    = "running"
+	OutputRestarting = "restarting"
+	OutputFailed     = "failed"
+)
+
+// outputLockTTL is how long a pod keeps ownership of an output target without refreshing it
+const outputLockTTL = 15 * time.Second
+
+// outputConcatSpan is how much of a playlist is queued up for each FFmpeg process;
+// the process is restarted from the current position once it has all aired
+const outputConcatSpan = 6 * time.Hour
+
+// outputLogLines is how many lines of FFmpeg output are kept to report failures
+const outputLogLines = 20
+
+// OutputService pushes channels and playlists to partners as contribution feeds.
+// Each enabled target gets one FFmpeg process on a single pod (guarded by a Redis
+// lock), which is restarted with backoff whenever it exits.
+type OutputService struct {
+	db             *gorm.DB
+	config         *config.Config
+	redis          *redis.Client
+	playoutService *PlayoutService
+	keyService     *KeyService
+	podID          string
+	stopChan       chan bool
+
+	mu        sync.Mutex
+	processes map[uint]*outputProcess
+}
+
+// outputProcess is the supervisor of a target's FFmpeg process on this pod
+type outputProcess struct {
+	updatedAt time.Time // target revision the process was started with
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewOutputService(db *gorm.DB, cfg *config.Config, playoutService *PlayoutService) *OutputService {
+	podID := cfg.Kubernetes.PodName
+	if podID == "" {
+		podID, _ = os.Hostname()
+	}
+
+	return &OutputService{
+		db:             db,
+		config:         cfg,
+		playoutService: playoutService,
+		podID:          podID,
+		stopChan:       make(chan bool),
+		processes:      make(map[uint]*outputProcess),
+	}
+}
+
+// SetRedisClient sets the Redis client used to share targets between pods
+func (s *OutputService) SetRedisClient(redis *redis.Client) {
+	s.redis = redis
+}
+
+// SetKeyService sets the key service encrypted content is decrypted with
+func (s *OutputService) SetKeyService(keyService *KeyService) {
+	s.keyService = keyService
+}
+
+// Start runs the output supervisor until Stop is called
+func (s *OutputService) Start() {
+	if s.redis == nil {
+		logrus.Warn("Output targets disabled: Redis client is not configured")
+		return
+	}
+
+	logrus.Info("Starting output supervisor...")
+
+	ticker := time.NewTicker(outputLockTTL / 3)
+	defer ticker.Stop()
+
+	s.reconcile()
+	for {
+		select {
+		case <-s.stopChan:
+			logrus.Info("Stopping output supervisor...")
+			s.stopAll()
+			return
+		case <-ticker.C:
+			s.reconcile()
+		}
+	}
+}
+
+// Stop stops the output supervisor and every FFmpeg process of this pod
+func (s *OutputService) Stop() {
+	close(s.stopChan)
+}
+
+// CreateTarget creates an output target
+func (s *OutputService) CreateTarget(req *models.OutputTargetRequest) (*models.OutputTarget, error) {
+	if err := s.validateTargetRequest(req); err != nil {
+		return nil, err
+	}
+
+	target := &models.OutputTarget{Status: OutputStopped}
+	applyOutputTargetRequest(target, req)
+	enabled := target.Enabled
+	if err := s.db.Create(target).Error; err != nil {
+		return nil, err
+	}
+
+	// enabled has a database default, so an explicit false must be written separately
+	if !enabled {
+		if err := s.db.Model(target).Update("enabled", false).Error; err != nil {
+			return nil, err
+		}
+		target.Enabled = false
+	}
+	return target, nil
+}
+
+// GetTargets retrieves all output targets with their status
+func (s *OutputService) GetTargets() ([]models.OutputTarget, error) {
+	targets := []models.OutputTarget{}
+	if err := s.db.Order("id ASC").Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// GetTarget retrieves an output target by ID
+func (s *OutputService) GetTarget(id uint) (*models.OutputTarget, error) {
+	var target models.OutputTarget
+	if err := s.db.First(&target, id).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// UpdateTarget updates an output target; a running process restarts with the new settings
+func (s *OutputService) UpdateTarget(id uint, req *models.OutputTargetRequest) (*models.OutputTarget, error) {
+	var target models.OutputTarget
+	if err := s.db.First(&target, id).Error; err != nil {
+		return nil, err
+	}
+	if err := s.validateTargetRequest(req); err != nil {
+		return nil, err
+	}
+
+	applyOutputTargetRequest(&target, req)
+	if err := s.db.Model(&target).Select("name", "channel_id", "playlist_id", "protocol", "url", "resolution", "enabled").
+		Updates(&target).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// DeleteTarget deletes an output target; its process stops on the next reconcile
+func (s *OutputService) DeleteTarget(id uint) error {
+	return s.db.Delete(&models.OutputTarget{}, id).Error
+}
+
+// validateTargetRequest checks the source and destination of an output target
+func (s *OutputService) validateTargetRequest(req *models.OutputTargetRequest) error {
+	if (req.ChannelID == nil) == (req.PlaylistID == nil) {
+		return fmt.Errorf("%w: exactly one of channel_id or playlist_id is required", ErrInvalidInput)
+	}
+
+	if req.ChannelID != nil {
+		var channel models.Channel
+		if err := s.db.First(&channel, *req.ChannelID).Error; err != nil {
+			return fmt.Errorf("%w: channel %d not found", ErrInvalidInput, *req.ChannelID)
+		}
+	} else {
+		var playlist models.Playlist
+		if err := s.db.First(&playlist, *req.PlaylistID).Error; err != nil {
+			return fmt.Errorf("%w: playlist %d not found", ErrInvalidInput, *req.PlaylistID)
+		}
+	}
+
+	schemes := map[string][]string{
+		"rtmp": {"rtmp://", "rtmps://"},
+		"srt":  {"srt://"},
+		"udp":  {"udp://"},
+	}
+	for _, scheme := range schemes[req.Protocol] {
+		if strings.HasPrefix(req.URL, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s targets need a %s URL", ErrInvalidInput, req.Protocol, strings.Join(schemes[req.Protocol], " or "))
+}
+
+// applyOutputTargetRequest copies request fields onto an output target
+func applyOutputTargetRequest(target *models.OutputTarget, req *models.OutputTargetRequest) {
+	target.Name = req.Name
+	target.ChannelID = req.ChannelID
+	target.PlaylistID = req.PlaylistID
+	target.Protocol = req.Protocol
+	target.URL = req.URL
+	target.Resolution = req.Resolution
+	target.Enabled = true
+	if req.Enabled != nil {
+		target.Enabled = *req.Enabled
+	}
+}
+
+// reconcile starts a process for every enabled target this pod owns and stops
+// the ones that were disabled, changed, deleted or taken over by another pod
+func (s *OutputService) reconcile() {
+	var targets []models.OutputTarget
+	if err := s.db.Find(&targets).Error; err != nil {
+		logrus.Errorf("Output supervisor failed to load targets: %v", err)
+		return
+	}
+
+	wanted := make(map[uint]models.OutputTarget)
+	for _, target := range targets {
+		if target.Enabled && s.acquireLock(target.ID) {
+			wanted[target.ID] = target
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, process := range s.processes {
+		target, ok := wanted[id]
+		if ok && target.UpdatedAt.Equal(process.updatedAt) {
+			continue
+		}
+
+		close(process.stop)
+		<-process.done
+		delete(s.processes, id)
+		if !ok {
+			s.releaseLock(id)
+			s.setStatus(id, map[string]interface{}{"status": OutputStopped, "pod_name": ""})
+		}
+	}
+
+	for id, target := range wanted {
+		if _, ok := s.processes[id]; ok {
+			continue
+		}
+
+		process := &outputProcess{
+			updatedAt: target.UpdatedAt,
+			stop:      make(chan struct{}),
+			done:      make(chan struct{}),
+		}
+		s.processes[id] = process
+		go s.supervise(target, process)
+	}
+}
+
+// stopAll stops every process of this pod and hands its targets over to other pods
+func (s *OutputService) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, process := range s.processes {
+		close(process.stop)
+		<-process.done
+		delete(s.processes, id)
+		s.releaseLock(id)
+		s.setStatus(id, map[string]interface{}{"status": OutputStopped, "pod_name": ""})
+	}
+}
+
+// supervise runs a target's FFmpeg process, restarting it with backoff until stopped
+func (s *OutputService) supervise(target models.OutputTarget, process *outputProcess) {
+	defer close(process.done)
+
+	delay := time.Duration(s.config.Output.RestartDelay) * time.Second
+	maxDelay := time.Duration(s.config.Output.MaxRestartDelay) * time.Second
+	first := true
+
+	for {
+		if !first {
+			select {
+			case <-process.stop:
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		s.setStatus(target.ID, map[string]interface{}{"status": OutputStarting, "pod_name": s.podID})
+		started := time.Now()
+		err := s.run(&target, process.stop)
+
+		select {
+		case <-process.stop:
+			return
+		default:
+		}
+
+		// A process that ran for a while starts over with the initial delay
+		if !first && time.Since(started) > maxDelay {
+			delay = time.Duration(s.config.Output.RestartDelay) * time.Second
+		} else if !first {
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		first = false
+
+		status := OutputRestarting
+		message := ""
+		if err != nil {
+			message = err.Error()
+			if errors.Is(err, errOutputSource) {
+				status = OutputFailed
+			}
+			logrus.Warnf("Output target %d (%s) exited: %v", target.ID, target.Name, err)
+		}
+		s.db.Model(&models.OutputTarget{}).Where("id = ?", target.ID).UpdateColumns(map[string]interface{}{
+			"status":     status,
+			"last_error": message,
+			"restarts":   gorm.Expr("restarts + 1"),
+		})
+	}
+}
+
+// errOutputSource marks failures to prepare a target's source, as opposed to FFmpeg exiting
+var errOutputSource = errors.New("output source unavailable")
+
+// run runs the FFmpeg process of a target until it exits or stop is closed
+func (s *OutputService) run(target *models.OutputTarget, stop chan struct{}) error {
+	input, cleanup, err := s.inputArgs(target)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errOutputSource, err)
+	}
+	defer cleanup()
+
+	args := append([]string{"-hide_banner", "-nostats", "-loglevel", "warning"}, input...)
+	args = append(args, "-c", "copy")
+	switch target.Protocol {
+	case "rtmp":
+		args = append(args, "-bsf:a", "aac_adtstoasc", "-f", "flv", target.URL)
+	default:
+		args = append(args, "-f", "mpegts", target.URL)
+	}
+
+	// FFmpeg's warnings are logged as they come; the last ones are reported when it exits
+	stderr := &logTail{onLine: func(line string) {
+		logrus.Warnf("Output target %d (%s): %s", target.ID, target.Name, line)
+	}}
+	cmd := exec.Command(s.config.FFmpeg.FFmpegPath, args...)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start FFmpeg: %w", err)
+	}
+
+	now := time.Now()
+	s.setStatus(target.ID, map[string]interface{}{"status": OutputRunning, "started_at": &now, "last_error": ""})
+	logrus.Infof("Output target %d (%s) pushing to %s", target.ID, target.Name, target.URL)
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if output := stderr.lastLines(outputLogLines); err != nil && output != "" {
+			err = fmt.Errorf("%v: %s", err, output)
+		}
+		exited <- err
+	}()
+
+	select {
+	case err := <-exited:
+		if err == nil {
+			return nil
+		}
+		return err
+	case <-stop:
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+		}
+		return nil
+	}
+}
+
+// inputArgs returns the FFmpeg input arguments reading a target's source. Channels
+// are read from their live HLS output; playlists from a list of their segments
+// laid out from the current position of the playlist's guide.
+func (s *OutputService) inputArgs(target *models.OutputTarget) ([]string, func(), error) {
+	noop := func() {}
+
+	if target.ChannelID != nil {
+		base := fmt.Sprintf("http://127.0.0.1:%s/api/v1/channels/%d", s.config.Server.Port, *target.ChannelID)
+		if target.Resolution != "" {
+			return []string{"-i", fmt.Sprintf("%s/%s/playlist.m3u8", base, target.Resolution)}, noop, nil
+		}
+		// Variants are listed by descending bandwidth; the first program is the best one
+		return []string{"-i", base + "/master.m3u8", "-map", "0:p:0"}, noop, nil
+	}
+
+	if target.PlaylistID == nil {
+		return nil, noop, fmt.Errorf("target has no source")
+	}
+
+	listPath, err := s.writeConcatList(target)
+	if err != nil {
+		return nil, noop, err
+	}
+	cleanup := func() { os.Remove(listPath) }
+	return []string{"-re", "-f", "concat", "-safe", "0", "-protocol_whitelist", "file,concat,crypto", "-i", listPath}, cleanup, nil
+}
+
+// writeConcatList writes an FFmpeg concat list of the segments a playlist airs
+// from now on, joining the item on air at its current position
+func (s *OutputService) writeConcatList(target *models.OutputTarget) (string, error) {
+	var playlist models.Playlist
+	if err := s.db.Preload("PlaylistVideos.Video").Preload("AdBreaks.PlaceholderVideo").
+		First(&playlist, *target.PlaylistID).Error; err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	items := fillLoop(s.playoutService.playlistRotation(&playlist), playlist.CreatedAt, nil, now, now.Add(outputConcatSpan))
+
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	entries := 0
+	keys := make(map[string][]byte)
+	renditions := s.playoutService.newRenditionResolver(target.Resolution)
+	for i, item := range items {
+		if item.VideoID == 0 {
+			continue
+		}
+
+		rendition := renditions.rendition(item.VideoID)
+		if rendition.err != nil {
+			continue
+		}
+		segments := rendition.segments
+		dir := filepath.Dir(filepath.Join(s.config.Storage.TranscodedPath, rendition.profile.PlaylistPath))
+
+		position := item.InPoint
+		if i == 0 && now.After(item.Start) {
+			position += now.Sub(item.Start)
+		}
+		remaining := item.Duration - (position - item.InPoint)
+
+		index := segmentIndexAt(segments, position.Seconds())
+		if item.Loop {
+			index = segmentIndexAt(segments, math.Mod(position.Seconds(), utils.TotalDuration(segments)))
+		}
+		for remaining > 0 {
+			if index >= len(segments) {
+				if !item.Loop {
+					break
+				}
+				index = 0
+			}
+			segment := segments[index]
+			entry, err := s.concatEntry(dir, segment, keys)
+			if err != nil {
+				logrus.Warnf("Output target %d skips video %d: %v", target.ID, item.VideoID, err)
+				break
+			}
+			list.WriteString(fmt.Sprintf("%sduration %.6f\n", entry, segment.Duration))
+			remaining -= time.Duration(segment.Duration * float64(time.Second))
+			entries++
+			index++
+		}
+	}
+
+	if entries == 0 {
+		return "", fmt.Errorf("playlist %d has no playable segments", playlist.ID)
+	}
+
+	file, err := os.CreateTemp("", fmt.Sprintf("output_%d_*.ffconcat", target.ID))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(list.String()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// concatEntry returns the concat list lines reading a segment. Encrypted segments are
+// read through FFmpeg's crypto protocol with their key, cached in keys by URI.
+func (s *OutputService) concatEntry(dir string, segment utils.HLSSegment, keys map[string][]byte) (string, error) {
+	file := filepath.Join(dir, segment.URI)
+	if segment.Key == "" {
+		if segment.Map != "" {
+			// Fragmented MP4 segments only decode behind their initialization section
+			file = "concat:" + filepath.Join(dir, segment.Map) + "|" + file
+		}
+		return fmt.Sprintf("file '%s'\n", file), nil
+	}
+	if segment.Map != "" {
+		return "", fmt.Errorf("encrypted fragmented MP4 cannot be pushed")
+	}
+
+	uri := utils.AttributeValue(segment.Key, "URI")
+	key, ok := keys[uri]
+	if !ok {
+		var id uint
+		if _, err := fmt.Sscanf(uri, keyURIFormat, &id); err != nil || s.keyService == nil {
+			return "", fmt.Errorf("key %s is not available", uri)
+		}
+		var err error
+		if key, err = s.keyService.GetKey(id); err != nil {
+			return "", err
+		}
+		keys[uri] = key
+	}
+
+	iv := strings.TrimPrefix(strings.ToLower(utils.AttributeValue(segment.Key, "IV")), "0x")
+	return fmt.Sprintf("file 'crypto:%s'\noption decryption_key %x\noption decryption_iv %s\n", file, key, iv), nil
+}
+
+// acquireLock takes or refreshes this pod's ownership of an output target
+func (s *OutputService) acquireLock(targetID uint) bool {
+	ctx := context.Background()
+	key := fmt.Sprintf("output:%d:lock", targetID)
+
+	acquired, err := s.redis.SetNX(ctx, key, s.podID, outputLockTTL).Result()
+	if err != nil {
+		logrus.Errorf("Failed to acquire output lock for target %d: %v", targetID, err)
+		return false
+	}
+	if acquired {
+		return true
+	}
+
+	owner, err := s.redis.Get(ctx, key).Result()
+	if err != nil || owner != s.podID {
+		return false
+	}
+
+	s.redis.Expire(ctx, key, outputLockTTL)
+	return true
+}
+
+// releaseLock gives up this pod's ownership of an output target
+func (s *OutputService) releaseLock(targetID uint) {
+	ctx := context.Background()
+	key := fmt.Sprintf("output:%d:lock", targetID)
+	if owner, err := s.redis.Get(ctx, key).Result(); err == nil && owner == s.podID {
+		s.redis.Del(ctx, key)
+	}
+}
+
+// setStatus records the state of a target's process without touching updated_at,
+// which tells reconcile the target's settings changed
+func (s *OutputService) setStatus(targetID uint, updates map[string]interface{}) {
+	if err := s.db.Model(&models.OutputTarget{}).Where("id = ?", targetID).UpdateColumns(updates).Error; err != nil {
+		logrus.Errorf("Failed to update status of output target %d: %v", targetID, err)
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestOutputService returns an output service running the fake FFmpeg
+func newTestOutputService(t *testing.T) (*OutputService, *testDB) {
+	t.Helper()
+	t.Setenv("FAKE_FFMPEG", "1")
+
+	db, store := newTestDB(t)
+	cfg := &config.Config{}
+	cfg.Server.Port = "8080"
+	cfg.FFmpeg.FFmpegPath = os.Args[0]
+	cfg.Output.RestartDelay = 1
+	cfg.Output.MaxRestartDelay = 1
+	return NewOutputService(db, cfg, nil), store
+}
+
+// receiver is a local stand-in for a partner's ingest endpoint
+type receiver struct {
+	addr    string
+	payload chan string
+}
+
+// listenUDP receives datagrams, as UDP and SRT receivers do
+func listenUDP(t *testing.T) *receiver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	r := &receiver{addr: conn.LocalAddr().String(), payload: make(chan string, 100)}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case r.payload <- string(buf[:n]):
+			default:
+			}
+		}
+	}()
+	return r
+}
+
+// listenTCP accepts connections, as RTMP servers do
+func listenTCP(t *testing.T) *receiver {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	r := &receiver{addr: listener.Addr().String(), payload: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1500)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					select {
+					case r.payload <- string(buf[:n]):
+					default:
+					}
+				}
+			}()
+		}
+	}()
+	return r
+}
+
+func (r *receiver) receive(t *testing.T) string {
+	t.Helper()
+	select {
+	case payload := <-r.payload:
+		return payload
+	case <-time.After(10 * time.Second):
+		t.Fatal("receiver got nothing")
+		return ""
+	}
+}
+
+// outputStatuses returns the statuses recorded for output targets, in order
+func outputStatuses(store *testDB) []string {
+	known := map[string]bool{
+		OutputStopped: true, OutputStarting: true, OutputRunning: true, OutputRestarting: true, OutputFailed: true,
+	}
+	var statuses []string
+	for _, statement := range store.statements("UPDATE `output_targets`") {
+		for _, arg := range statement.args {
+			if status, ok := arg.(string); ok && known[status] {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses
+}
+
+func TestOutputRunPushesToReceiver(t *testing.T) {
+	tests := []struct {
+		protocol string
+		listen   func(*testing.T) *receiver
+		url      string
+		format   string
+	}{
+		{protocol: "udp", listen: listenUDP, url: "udp://%s?pkt_size=1316", format: "mpegts"},
+		{protocol: "srt", listen: listenUDP, url: "srt://%s?mode=caller", format: "mpegts"},
+		{protocol: "rtmp", listen: listenTCP, url: "rtmp://%s/live/stream", format: "flv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			s, store := newTestOutputService(t)
+			r := tt.listen(t)
+			channelID := uint(3)
+			target := models.OutputTarget{
+				ID:        1,
+				Name:      "partner",
+				ChannelID: &channelID,
+				Protocol:  tt.protocol,
+				URL:       strings.Replace(tt.url, "%s", r.addr, 1),
+			}
+
+			stop := make(chan struct{})
+			exited := make(chan error, 1)
+			go func() { exited <- s.run(&target, stop) }()
+
+			if got := r.receive(t); got != tt.format {
+				t.Errorf("receiver got %q, want %q", got, tt.format)
+			}
+			close(stop)
+			if err := <-exited; err != nil {
+				t.Errorf("run() = %v, want nil after stop", err)
+			}
+
+			if got := outputStatuses(store); len(got) != 1 || got[0] != OutputRunning {
+				t.Errorf("statuses = %v, want [%s]", got, OutputRunning)
+			}
+		})
+	}
+}
+
+func TestOutputSuperviseRestartsFailedProcess(t *testing.T) {
+	s, store := newTestOutputService(t)
+	t.Setenv("FAKE_FFMPEG_FAIL_ONCE", filepath.Join(t.TempDir(), "failed"))
+	r := listenUDP(t)
+	channelID := uint(3)
+	target := models.OutputTarget{ID: 1, Name: "partner", ChannelID: &channelID, Protocol: "udp", URL: "udp://" + r.addr}
+
+	process := &outputProcess{stop: make(chan struct{}), done: make(chan struct{})}
+	go s.supervise(target, process)
+
+	if got := r.receive(t); got != "mpegts" {
+		t.Errorf("receiver got %q, want %q", got, "mpegts")
+	}
+	close(process.stop)
+	<-process.done
+
+	want := []string{OutputStarting, OutputRunning, OutputRestarting, OutputStarting, OutputRunning}
+	if got := outputStatuses(store); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+
+	restarts := store.statements("`restarts`=restarts + 1")
+	if len(restarts) != 1 {
+		t.Fatalf("got %d restart updates, want 1", len(restarts))
+	}
+	var lastError string
+	for _, arg := range restarts[0].args {
+		if message, ok := arg.(string); ok && strings.Contains(message, "exit status 1") {
+			lastError = message
+		}
+	}
+	if !strings.Contains(lastError, "Connection refused") {
+		t.Errorf("last_error = %q, want the receiver failure", lastError)
+	}
+}
@@ -26,10 +26,19 @@ func NewPlaylistService(db *gorm.DB, cfg *config.Config) *PlaylistService {
 
 // CreatePlaylist creates a new playlist
 func (s *PlaylistService) CreatePlaylist(req *models.CreatePlaylistRequest) (*models.Playlist, error) {
+	mode := req.PlaybackMode
+	if mode == "" {
+		mode = PlaybackSequential
+	}
+
 	playlist := &models.Playlist{
-		Name:        req.Name,
-		Description: req.Description,
-		IsActive:    true,
+		Name:            req.Name,
+		Description:     req.Description,
+		IsActive:        true,
+		PlaybackMode:    mode,
+		ShuffleSeed:     req.ShuffleSeed,
+		NoRepeatItems:   req.NoRepeatItems,
+		NoRepeatMinutes: req.NoRepeatMinutes,
 	}
 
 	if err := s.db.Create(playlist).Error; err != nil {
@@ -43,6 +52,7 @@ func (s *PlaylistService) CreatePlaylist(req *models.CreatePlaylistRequest) (*mo
 				PlaylistID: playlist.ID,
 				VideoID:    uint(videoID),
 				SortOrder:  i + 1,
+				Weight:     1,
 			}
 			s.db.Create(playlistVideo)
 		}
@@ -69,6 +79,7 @@ func (s *PlaylistService) GetPlaylist(id uint) (*models.PlaylistResponse, error)
 			Duration:  video.Duration,
 			Status:    video.Status,
 			SortOrder: pv.SortOrder,
+			Weight:    pv.Weight,
 		})
 	}
 
@@ -78,12 +89,16 @@ func (s *PlaylistService) GetPlaylist(id uint) (*models.PlaylistResponse, error)
 	})
 
 	return &models.PlaylistResponse{
-		ID:          playlist.ID,
-		Name:        playlist.Name,
-		Description: playlist.Description,
-		IsActive:    playlist.IsActive,
-		Videos:      videos,
-		CreatedAt:   playlist.CreatedAt,
+		ID:              playlist.ID,
+		Name:            playlist.Name,
+		Description:     playlist.Description,
+		IsActive:        playlist.IsActive,
+		PlaybackMode:    playlist.PlaybackMode,
+		ShuffleSeed:     playlist.ShuffleSeed,
+		NoRepeatItems:   playlist.NoRepeatItems,
+		NoRepeatMinutes: playlist.NoRepeatMinutes,
+		Videos:          videos,
+		CreatedAt:       playlist.CreatedAt,
 	}, nil
 }
 
@@ -108,6 +123,7 @@ func (s *PlaylistService) GetPlaylists() ([]models.PlaylistResponse, error) {
 				Duration:  video.Duration,
 				Status:    video.Status,
 				SortOrder: pv.SortOrder,
+				Weight:    pv.Weight,
 			})
 		}
 
@@ -117,12 +133,16 @@ func (s *PlaylistService) GetPlaylists() ([]models.PlaylistResponse, error) {
 		})
 
 		responses = append(responses, models.PlaylistResponse{
-			ID:          playlist.ID,
-			Name:        playlist.Name,
-			Description: playlist.Description,
-			IsActive:    playlist.IsActive,
-			Videos:      videos,
-			CreatedAt:   playlist.CreatedAt,
+			ID:              playlist.ID,
+			Name:            playlist.Name,
+			Description:     playlist.Description,
+			IsActive:        playlist.IsActive,
+			PlaybackMode:    playlist.PlaybackMode,
+			ShuffleSeed:     playlist.ShuffleSeed,
+			NoRepeatItems:   playlist.NoRepeatItems,
+			NoRepeatMinutes: playlist.NoRepeatMinutes,
+			Videos:          videos,
+			CreatedAt:       playlist.CreatedAt,
 		})
 	}
 
@@ -149,25 +169,120 @@ func (s *PlaylistService) AddVideoToPlaylist(playlistID uint, req *models.AddVid
 		sortOrder = maxOrder + 1
 	}
 
+	weight := req.Weight
+	if weight == 0 {
+		weight = 1
+	}
+
 	playlistVideo := &models.PlaylistVideo{
 		PlaylistID: playlistID,
 		VideoID:    req.VideoID,
 		SortOrder:  sortOrder,
+		Weight:     weight,
 	}
 
 	return s.db.Create(playlistVideo).Error
 }
 
+// UpdatePlayback sets how a playlist is rotated by playout and guides
+func (s *PlaylistService) UpdatePlayback(id uint, req *models.PlaybackRequest) error {
+	mode := req.PlaybackMode
+	if mode == "" {
+		mode = PlaybackSequential
+	}
+
+	var playlist models.Playlist
+	if err := s.db.First(&playlist, id).Error; err != nil {
+		return err
+	}
+
+	return s.db.Model(&playlist).Updates(map[string]interface{}{
+		"playback_mode":     mode,
+		"shuffle_seed":      req.ShuffleSeed,
+		"no_repeat_items":   req.NoRepeatItems,
+		"no_repeat_minutes": req.NoRepeatMinutes,
+	}).Error
+}
+
 // RemoveVideoFromPlaylist removes a video from a playlist
 func (s *PlaylistService) RemoveVideoFromPlaylist(playlistID, videoID uint) error {
+	// Ad breaks belong to the playlist item, so they go with it
+	if err := s.db.Where("playlist_id = ? AND video_id = ?", playlistID, videoID).
+		Delete(&models.AdBreak{}).Error; err != nil {
+		return err
+	}
+
 	return s.db.Where("playlist_id = ? AND video_id = ?", playlistID, videoID).
 		Delete(&models.PlaylistVideo{}).Error
 }
 
+// GetAdBreaks retrieves the ad breaks of a playlist
+func (s *PlaylistService) GetAdBreaks(playlistID uint) ([]models.AdBreak, error) {
+	breaks := []models.AdBreak{}
+	if err := s.db.Where("playlist_id = ?", playlistID).
+		Order("video_id ASC, position ASC, offset ASC").
+		Find(&breaks).Error; err != nil {
+		return nil, err
+	}
+	return breaks, nil
+}
+
+// AddAdBreak adds an ad break after or inside a video of a playlist
+func (s *PlaylistService) AddAdBreak(playlistID, videoID uint, req *models.AdBreakRequest) (*models.AdBreak, error) {
+	var playlistVideo models.PlaylistVideo
+	if err := s.db.Preload("Video").
+		Where("playlist_id = ? AND video_id = ?", playlistID, videoID).
+		First(&playlistVideo).Error; err != nil {
+		return nil, fmt.Errorf("%w: video %d is not in playlist %d", ErrInvalidInput, videoID, playlistID)
+	}
+
+	if req.Position == "midroll" {
+		if req.Offset <= 0 {
+			return nil, fmt.Errorf("%w: mid-roll offset must be greater than zero", ErrInvalidInput)
+		}
+		if playlistVideo.Video.Duration > 0 && req.Offset >= playlistVideo.Video.Duration {
+			return nil, fmt.Errorf("%w: mid-roll offset must be within the video duration of %ds", ErrInvalidInput, playlistVideo.Video.Duration)
+		}
+	} else if req.Offset != 0 {
+		return nil, fmt.Errorf("%w: offset is only supported for mid-roll breaks", ErrInvalidInput)
+	}
+
+	if req.PlaceholderVideoID != nil {
+		var placeholder models.Video
+		if err := s.db.First(&placeholder, *req.PlaceholderVideoID).Error; err != nil {
+			return nil, fmt.Errorf("%w: placeholder video %d not found", ErrInvalidInput, *req.PlaceholderVideoID)
+		}
+		if placeholder.Status != "completed" {
+			return nil, fmt.Errorf("%w: placeholder video %d is not completed", ErrInvalidInput, placeholder.ID)
+		}
+	}
+
+	adBreak := &models.AdBreak{
+		PlaylistID:         playlistID,
+		VideoID:            videoID,
+		Position:           req.Position,
+		Offset:             req.Offset,
+		Duration:           req.Duration,
+		PlaceholderVideoID: req.PlaceholderVideoID,
+	}
+
+	if err := s.db.Create(adBreak).Error; err != nil {
+		return nil, err
+	}
+
+	return adBreak, nil
+}
+
+// DeleteAdBreak removes an ad break from a playlist
+func (s *PlaylistService) DeleteAdBreak(playlistID, breakID uint) error {
+	return s.db.Where("id = ? AND playlist_id = ?", breakID, playlistID).
+		Delete(&models.AdBreak{}).Error
+}
+
 // GenerateHLSPlaylist generates HLS playlist for a video
 func (s *PlaylistService) GenerateHLSPlaylist(videoID uint) (*models.HLSPlaylistResponse, error) {
 	var video models.Video
-	if err := s.db.Preload("VideoProfiles").
+	if err := s.db.Preload("VideoProfiles").Preload("Subtitles").Preload("AudioTracks").
 		First(&video, videoID).Error; err != nil {
 		return nil, err
 	}
@@ -188,7 +303,7 @@ func (s *PlaylistService) GenerateHLSPlaylist(videoID uint) (*models.HLSPlaylist
 		masterPlaylist = string(content)
 	} else {
 		// Fallback: generate master playlist dynamically
-		masterPlaylist = s.generateMasterPlaylist(videoID, video.VideoProfiles)
+		masterPlaylist = s.generateMasterPlaylist(&video)
 	}
 
 	// Build profiles info
@@ -198,7 +313,7 @@ func (s *PlaylistService) GenerateHLSPlaylist(videoID uint) (*models.HLSPlaylist
 			profiles = append(profiles, models.HLSProfile{
 				Resolution:  profile.Resolution,
 				Bitrate:     profile.Bitrate,
-				PlaylistURL: fmt.Sprintf("/videos/%d/%s/playlist.m3u8", videoID, profile.Resolution),
+				PlaylistURL: fmt.Sprintf("/videos/%d/%s/playlist.m3u8", videoID, renditionName(&profile)),
 				Status:      profile.Status,
 			})
 		}
@@ -210,41 +325,51 @@ func (s *PlaylistService) GenerateHLSPlaylist(videoID uint) (*models.HLSPlaylist
 	}, nil
 }
 
+// GetDASHManifest returns the DASH manifest of a video, generating it for videos
+// transcoded before manifests were saved
+func (s *PlaylistService) GetDASHManifest(videoID uint) (string, error) {
+	var video models.Video
+	if err := s.db.Preload("VideoProfiles").First(&video, videoID).Error; err != nil {
+		return "", err
+	}
+	if video.Status != "completed" {
+		return "", fmt.Errorf("video is not completed yet")
+	}
+
+	if video.ManifestPath != "" {
+		content, err := ioutil.ReadFile(filepath.Join(s.config.Storage.TranscodedPath, video.ManifestPath))
+		if err != nil {
+			return "", fmt.Errorf("failed to read DASH manifest: %v", err)
+		}
+		return string(content), nil
+	}
+	return videoManifest(s.config.Storage.TranscodedPath, &video)
+}
+
 // generateMasterPlaylist generates the master HLS playlist content
-func (s *PlaylistService) generateMasterPlaylist(videoID uint, profiles []models.VideoProfile) string {
+func (s *PlaylistService) generateMasterPlaylist(video *models.Video) string {
 	var lines []string
 	lines = append(lines, "#EXTM3U")
-	lines = append(lines, "#EXT-X-VERSION:3")
+	lines = append(lines, fmt.Sprintf("#EXT-X-VERSION:%d", hlsVersion(video.VideoProfiles)))
+
+	media, groups := alternateMedia(video)
+	lines = append(lines, media...)
 
-	for _, profile := range profiles {
+	for _, profile := range video.VideoProfiles {
 		if profile.Status == "completed" {
 			bandwidth := profile.Bitrate * 1000 // Convert to bits per second
-			width, height := s.getVideoDimensions(profile.Resolution)
+			width, height := profileDimensions(&profile)
 
-			line := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d",
-				bandwidth, width, height)
+			line := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"%s",
+				bandwidth, width, height, codecsAttribute(&profile), groups)
 			lines = append(lines, line)
-			lines = append(lines, fmt.Sprintf("%s/playlist.m3u8", profile.Resolution))
+			lines = append(lines, fmt.Sprintf("%s/playlist.m3u8", renditionName(&profile)))
 		}
 	}
 
 	return strings.Join(lines, "\n")
 }
 
-// getVideoDimensions returns video dimensions based on resolution
-func (s *PlaylistService) getVideoDimensions(resolution string) (int, int) {
-	switch resolution {
-	case "720p":
-		return 1280, 720
-	case "480p":
-		return 854, 480
-	case "360p":
-		return 640, 360
-	default:
-		return 640, 360
-	}
-}
-
 // SaveMasterPlaylist saves the master playlist to file
 func (s *PlaylistService) SaveMasterPlaylist(videoID uint, content string) error {
 	// Get video filename for directory name
@@ -269,11 +394,26 @@ func (s *PlaylistService) SaveMasterPlaylist(videoID uint, content string) error
 	return ioutil.WriteFile(masterPath, []byte(content), 0644)
 }
 
+// findRendition returns the profile of a video served under a rendition name
+func (s *PlaylistService) findRendition(videoID uint, name string) (*models.VideoProfile, error) {
+	resolution, _ := parseRenditionName(name)
+	var profiles []models.VideoProfile
+	if err := s.db.Where("video_id = ? AND resolution = ?", videoID, resolution).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		if renditionName(&profiles[i]) == name {
+			return &profiles[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
 // GetPlaylistFile returns the content of a playlist file
 func (s *PlaylistService) GetPlaylistFile(videoID uint, resolution string) (string, error) {
 	// Get video profile with playlist path
-	var profile models.VideoProfile
-	if err := s.db.Where("video_id = ? AND resolution = ?", videoID, resolution).First(&profile).Error; err != nil {
+	profile, err := s.findRendition(videoID, resolution)
+	if err != nil {
 		return "", fmt.Errorf("failed to get video profile: %v", err)
 	}
 
@@ -291,8 +431,8 @@ func (s *PlaylistService) GetPlaylistFile(videoID uint, resolution string) (stri
 // GetSegmentFile returns the content of a segment file
 func (s *PlaylistService) GetSegmentFile(videoID uint, resolution, segment string) ([]byte, error) {
 	// Get video profile with playlist path
-	var profile models.VideoProfile
-	if err := s.db.Where("video_id = ? AND resolution = ?", videoID, resolution).First(&profile).Error; err != nil {
+	profile, err := s.findRendition(videoID, resolution)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get video profile: %v", err)
 	}
 
@@ -303,6 +443,26 @@ func (s *PlaylistService) GetSegmentFile(videoID uint, resolution, segment strin
 	return ioutil.ReadFile(segmentPath)
 }
 
+// GetThumbnailFile returns a preview file of a video and its content type
+func (s *PlaylistService) GetThumbnailFile(videoID uint, name string) ([]byte, string, error) {
+	var video models.Video
+	if err := s.db.First(&video, videoID).Error; err != nil {
+		return nil, "", err
+	}
+	if video.ThumbnailPath == "" {
+		return nil, "", fmt.Errorf("video %d has no thumbnails", videoID)
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, "", fmt.Errorf("invalid thumbnail file %q", name)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.config.Storage.TranscodedPath, video.ThumbnailPath, name))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, thumbnailContentType(name), nil
+}
+
 // DeletePlaylist deletes a playlist
 func (s *PlaylistService) DeletePlaylist(id uint) error {
 	return s.db.Delete(&models.Playlist{}, id).Error
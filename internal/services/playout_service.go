@@ -0,0 +1,1711 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// playoutLockTTL is how long a pod keeps ownership of a channel without refreshing it
+const playoutLockTTL = 5 * time.Second
+
+// playoutPlanAhead is how far past a lookup the engine plans a channel's timeline
+const playoutPlanAhead = time.Minute
+
+// renditionCacheTTL is how long a resolved rendition is reused before the video's
+// profiles are queried again, picking up renditions completed meanwhile
+const renditionCacheTTL = 10 * time.Second
+
+// PlayoutService turns active channels into live sliding-window HLS streams.
+// A single pod advances each channel (guarded by a Redis lock) and appends the
+// aired segments to a Redis list; every pod renders playlists from that list.
+type PlayoutService struct {
+	db       *gorm.DB
+	config   *config.Config
+	redis    *redis.Client
+	asRun    *AsRunService
+	ads      *AdService
+	podID    string
+	stopChan chan bool
+
+	cacheMu      sync.RWMutex
+	segmentCache map[string]cachedSegments
+	profileCache map[string]cachedProfile
+}
+
+// cachedSegments holds the parsed segments of a rendition playlist
+type cachedSegments struct {
+	modTime  time.Time
+	segments []utils.HLSSegment
+}
+
+// cachedProfile holds the profile a rendition name resolved to for a video
+type cachedProfile struct {
+	resolvedAt time.Time
+	profile    *models.VideoProfile
+}
+
+// channelPlan is a channel's timeline planned for one tick of the engine. It is
+// planned on first use and again only for times it does not cover.
+type channelPlan struct {
+	channel  *models.Channel
+	fill     *fillSource
+	from, to time.Time
+	items    []timelineItem
+}
+
+// timelineItem is a contiguous block of a single asset on a channel's wall-clock timeline
+type timelineItem struct {
+	VideoID    uint
+	Start      time.Time
+	Duration   time.Duration
+	InPoint    time.Duration // offset into the asset where playback begins
+	OutPoint   time.Duration // offset into the asset where playback ends, 0 for the end of the asset
+	Loop       bool          // repeat the asset until the item's end
+	Break      *breakInfo    // set when the item fills an ad break with its placeholder
+	Splice     *breakInfo    // splice-only ad break signalled as the item goes on air
+	Substitute *substitution // set when filler or slate stands in for what was planned
+}
+
+// Substitution reasons recorded when filler or slate stands in
+const (
+	SubstitutionGap              = "gap"               // nothing planned
+	SubstitutionAssetNotReady    = "asset_not_ready"   // planned video has not finished transcoding
+	SubstitutionAssetFailed      = "asset_failed"      // planned video failed to transcode
+	SubstitutionAssetUnavailable = "asset_unavailable" // planned video has no playable segments
+)
+
+// substitution records what filler or slate stands in for
+type substitution struct {
+	VideoID uint // video that should have aired, 0 for a gap
+	Reason  string
+}
+
+// fillSource is what stands in for gaps and unavailable assets on a channel: its
+// filler playlist looping from the channel's creation, or else its slate on repeat
+type fillSource struct {
+	epoch  time.Time
+	filler *rotation
+	slate  *timelineItem // template playing the slate video through once
+}
+
+// breakInfo identifies the ad break an item belongs to
+type breakInfo struct {
+	ID       uint
+	Duration time.Duration
+}
+
+// End returns the wall-clock time at which the item is planned to finish
+func (i *timelineItem) End() time.Time {
+	return i.Start.Add(i.Duration)
+}
+
+// key identifies the item on the timeline
+func (i *timelineItem) key() string {
+	return fmt.Sprintf("%d@%d", i.VideoID, i.Start.UnixNano())
+}
+
+// playoutState is the persisted cursor of a channel's playout engine
+type playoutState struct {
+	NextSequence          int64     `json:"next_sequence"`
+	DiscontinuitySequence int64     `json:"discontinuity_sequence"`
+	NextAirTime           time.Time `json:"next_air_time"`
+	ItemKey               string    `json:"item_key"`
+	ItemVideoID           uint      `json:"item_video_id"`
+	ItemEnd               time.Time `json:"item_end"`
+	NextIndex             int       `json:"next_index"`
+	EndIndex              int       `json:"end_index"`
+	ItemLoop              bool      `json:"item_loop"`
+	AsRunID               uint      `json:"as_run_id"`
+	BreakID               string    `json:"break_id"`
+	BreakEventID          uint32    `json:"break_event_id"`
+	BreakStart            time.Time `json:"break_start"`
+	BreakDuration         float64   `json:"break_duration"`
+	LoopVideoID           uint      `json:"loop_video_id"`
+	BreakAds              []breakAd `json:"break_ads,omitempty"`
+	BreakAdIndex          int       `json:"break_ad_index"` // ads of the break already aired or skipped
+	AdOnAir               bool      `json:"ad_on_air"`      // whether BreakAds[BreakAdIndex] is on air
+	AdPosition            float64   `json:"ad_position"`    // seconds of the ad on air already aired
+	AdMilestone           int       `json:"ad_milestone"`   // tracking events of the ad on air already fired
+}
+
+// playoutSegment is one aired segment of a channel's generated sequence
+type playoutSegment struct {
+	Sequence              int64      `json:"sequence"`
+	DiscontinuitySequence int64      `json:"discontinuity_sequence"`
+	Discontinuity         bool       `json:"discontinuity"`
+	VideoID               uint       `json:"video_id"`
+	Index                 int        `json:"index"`
+	Duration              float64    `json:"duration"`
+	AirTime               time.Time  `json:"air_time"`
+	Markers               []adMarker `json:"markers,omitempty"`
+}
+
+// Ad marker types, following the SCTE-35 cue-out/cue-in model
+const (
+	adMarkerOut  = "out"
+	adMarkerCont = "cont"
+	adMarkerIn   = "in"
+)
+
+// adMarker signals an ad break boundary or progress on the segment it is attached to
+type adMarker struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	EventID   uint32    `json:"event_id"`
+	StartDate time.Time `json:"start_date"`
+	Duration  float64   `json:"duration"`
+	Elapsed   float64   `json:"elapsed,omitempty"`
+}
+
+func NewPlayoutService(db *gorm.DB, cfg *config.Config) *PlayoutService {
+	podID := cfg.Kubernetes.PodName
+	if podID == "" {
+		podID, _ = os.Hostname()
+	}
+
+	return &PlayoutService{
+		db:           db,
+		config:       cfg,
+		podID:        podID,
+		stopChan:     make(chan bool),
+		segmentCache: make(map[string]cachedSegments),
+		profileCache: make(map[string]cachedProfile),
+	}
+}
+
+// SetRedisClient sets the Redis client for the service
+func (s *PlayoutService) SetRedisClient(redis *redis.Client) {
+	s.redis = redis
+}
+
+// SetAsRunService sets the service recording what aired on each channel
+func (s *PlayoutService) SetAsRunService(asRun *AsRunService) {
+	s.asRun = asRun
+}
+
+// SetAdService sets the service filling ad breaks with decided ads
+func (s *PlayoutService) SetAdService(ads *AdService) {
+	s.ads = ads
+}
+
+// Start runs the playout engine until Stop is called
+func (s *PlayoutService) Start() {
+	if s.redis == nil {
+		logrus.Warn("Playout engine disabled: Redis client is not configured")
+		return
+	}
+
+	logrus.Info("Starting playout engine...")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			logrus.Info("Stopping playout engine...")
+			return
+		case <-ticker.C:
+			s.tick(time.Now())
+		}
+	}
+}
+
+// Stop stops the playout engine
+func (s *PlayoutService) Stop() {
+	close(s.stopChan)
+}
+
+// tick advances every active channel owned by this pod up to now
+func (s *PlayoutService) tick(now time.Time) {
+	var channels []models.Channel
+	if err := s.db.Where("is_active = ?", true).Find(&channels).Error; err != nil {
+		logrus.Errorf("Playout engine failed to load channels: %v", err)
+		return
+	}
+
+	for i := range channels {
+		channel := &channels[i]
+		if !s.acquireLock(channel.ID) {
+			continue
+		}
+		if err := s.advance(channel, now); err != nil {
+			logrus.Errorf("Playout engine failed to advance channel %d: %v", channel.ID, err)
+		}
+	}
+}
+
+// acquireLock takes or refreshes this pod's ownership of a channel
+func (s *PlayoutService) acquireLock(channelID uint) bool {
+	ctx := context.Background()
+	key := fmt.Sprintf("channel:%d:lock", channelID)
+
+	acquired, err := s.redis.SetNX(ctx, key, s.podID, playoutLockTTL).Result()
+	if err != nil {
+		logrus.Errorf("Failed to acquire playout lock for channel %d: %v", channelID, err)
+		return false
+	}
+	if acquired {
+		return true
+	}
+
+	owner, err := s.redis.Get(ctx, key).Result()
+	if err != nil || owner != s.podID {
+		return false
+	}
+
+	s.redis.Expire(ctx, key, playoutLockTTL)
+	return true
+}
+
+// advance appends every segment whose air time has been reached to the channel's sequence
+func (s *PlayoutService) advance(channel *models.Channel, now time.Time) error {
+	channelID := channel.ID
+	state, err := s.loadState(channelID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &playoutState{NextAirTime: now}
+	}
+
+	// After downtime, rejoin the timeline at the current time instead of
+	// flooding the sequence with everything that should have aired meanwhile
+	if now.Sub(state.NextAirTime) > s.windowDuration() {
+		s.recordItemEnd(state, state.NextAirTime, AsRunInterrupted)
+		state.NextAirTime = now
+		state.ItemKey = ""
+		state.ItemVideoID = 0
+	}
+
+	var aired []playoutSegment
+	plan := &channelPlan{channel: channel}
+	for !state.NextAirTime.After(now) {
+		segment, ok := s.nextSegment(plan, state)
+		if !ok {
+			break
+		}
+		aired = append(aired, segment)
+	}
+
+	if len(aired) > 0 {
+		if err := s.appendSegments(channelID, aired); err != nil {
+			return err
+		}
+	}
+
+	return s.saveState(channelID, state)
+}
+
+// nextSegment picks the segment that airs at state.NextAirTime and moves the cursor past it
+func (s *PlayoutService) nextSegment(plan *channelPlan, state *playoutState) (playoutSegment, bool) {
+	channelID := plan.channel.ID
+	airTime := state.NextAirTime
+	tolerance := s.switchTolerance()
+
+	if state.ItemKey != "" {
+		if state.ItemLoop {
+			// Looping items run until their planned end; an ad on air may finish slightly past it
+			if airTime.Before(state.ItemEnd) || (state.AdOnAir && airTime.Before(state.ItemEnd.Add(tolerance))) {
+				if segment, ok := s.nextLoopSegment(state, airTime); ok {
+					return segment, true
+				}
+			}
+		} else if state.NextIndex < state.EndIndex && airTime.Before(state.ItemEnd.Add(tolerance)) {
+			// Keep playing the current item until it runs out of segments, unless the
+			// timeline has moved on to something else well past the item's planned end
+			segments := s.renditionSegments(state.ItemVideoID, "")
+			if state.NextIndex < len(segments) {
+				return s.emitSegment(state, segments[state.NextIndex], state.NextIndex, false, s.breakProgress(state, airTime)), true
+			}
+		}
+	}
+
+	item := s.itemAt(plan, airTime)
+	if item != nil && item.key() == state.ItemKey {
+		// The current item finished early; move on to the one planned after it
+		item = s.itemAt(plan, item.End())
+	}
+	if item == nil || item.key() == state.ItemKey {
+		return playoutSegment{}, false
+	}
+
+	key := item.key()
+	segments := s.renditionSegments(item.VideoID, "")
+	if len(segments) == 0 {
+		// The asset has no playable segments; the slate stands in until the item's end
+		item = s.slateFor(plan, item)
+		if item == nil {
+			return playoutSegment{}, false
+		}
+		segments = s.renditionSegments(item.VideoID, "")
+		if len(segments) == 0 {
+			return playoutSegment{}, false
+		}
+	}
+
+	offset := airTime.Sub(item.Start)
+	if offset < tolerance {
+		offset = 0
+	}
+	position := (item.InPoint + offset).Seconds()
+	if item.Loop {
+		position = math.Mod(position, utils.TotalDuration(segments))
+	}
+	startIndex := segmentIndexAt(segments, position)
+	endIndex := len(segments)
+	if item.OutPoint > 0 && !item.Loop {
+		endIndex = segmentIndexAt(segments, item.OutPoint.Seconds())
+	}
+	if startIndex >= endIndex {
+		return playoutSegment{}, false
+	}
+
+	reason := ""
+	if !state.ItemLoop && state.NextIndex < state.EndIndex {
+		reason = AsRunPreempted
+	}
+	s.recordItemEnd(state, airTime, reason)
+	if state.ItemLoop {
+		s.finishAd(state, state.NextIndex >= len(s.renditionSegments(state.ItemVideoID, "")))
+		state.BreakAds = nil
+		state.BreakAdIndex = 0
+	}
+
+	// Parts of a video split by a mid-roll splice continue without a discontinuity
+	contiguous := item.VideoID == state.ItemVideoID && startIndex == state.NextIndex
+	discontinuity := state.NextSequence > 0 && !contiguous
+
+	var markers []adMarker
+	if state.BreakID != "" {
+		markers = append(markers, s.breakIn(state, airTime))
+	}
+	if item.Splice != nil {
+		markers = append(markers, s.breakOut(state, item.Splice.ID, item.Splice.Duration, airTime))
+		markers = append(markers, s.breakIn(state, airTime))
+	}
+	if item.Break != nil {
+		markers = append(markers, s.breakOut(state, item.Break.ID, item.End().Sub(airTime), airTime))
+	}
+
+	state.ItemKey = key
+	state.ItemVideoID = item.VideoID
+	state.ItemEnd = item.End()
+	state.EndIndex = endIndex
+	state.ItemLoop = item.Loop
+	s.recordItemStart(channelID, state, item, airTime)
+
+	// Ads replace the placeholder from the start of a break; joining a break late
+	// airs the placeholder rather than partial ads
+	state.LoopVideoID = item.VideoID
+	if item.Break != nil {
+		if s.ads != nil && offset == 0 {
+			state.BreakAds = s.ads.BreakAds(channelID, item)
+		}
+		if len(state.BreakAds) > 0 {
+			s.selectBreakVideo(state, airTime)
+			if state.AdOnAir {
+				segments = s.renditionSegments(state.ItemVideoID, "")
+				startIndex = 0
+			}
+		}
+	}
+
+	return s.emitSegment(state, segments[startIndex], startIndex, discontinuity, markers), true
+}
+
+// slateFor returns the channel's slate on repeat in place of an unplayable item, nil without a slate
+func (s *PlayoutService) slateFor(plan *channelPlan, item *timelineItem) *timelineItem {
+	fill := s.planFill(plan)
+	if fill.slate == nil {
+		logrus.Warnf("Channel %d: video %d has no playable segments and no slate is available", plan.channel.ID, item.VideoID)
+		return nil
+	}
+
+	slate := *item
+	if slate.Substitute == nil {
+		slate.Substitute = &substitution{VideoID: item.VideoID, Reason: SubstitutionAssetUnavailable}
+	}
+	slate.VideoID = fill.slate.VideoID
+	slate.InPoint = 0
+	slate.OutPoint = 0
+	slate.Loop = true
+	return &slate
+}
+
+// nextLoopSegment fills a looping item with its video on repeat; ad breaks
+// first air their decided ads in order while they fit
+func (s *PlayoutService) nextLoopSegment(state *playoutState, airTime time.Time) (playoutSegment, bool) {
+	markers := s.breakProgress(state, airTime)
+	segments := s.renditionSegments(state.ItemVideoID, "")
+
+	discontinuity := false
+	if state.NextIndex >= len(segments) {
+		s.finishAd(state, true)
+		if !airTime.Before(state.ItemEnd) {
+			return playoutSegment{}, false
+		}
+		s.selectBreakVideo(state, airTime)
+		segments = s.renditionSegments(state.ItemVideoID, "")
+		discontinuity = true
+	}
+	if len(segments) == 0 {
+		return playoutSegment{}, false
+	}
+
+	segment := s.emitSegment(state, segments[state.NextIndex], state.NextIndex, discontinuity, markers)
+	s.trackAd(state, segment.Duration)
+	return segment, true
+}
+
+// selectBreakVideo puts the next ad that fits in the rest of the break on air,
+// or the item's looping video once no ad is left
+func (s *PlayoutService) selectBreakVideo(state *playoutState, airTime time.Time) {
+	remaining := state.ItemEnd.Sub(airTime).Seconds()
+	state.NextIndex = 0
+
+	for state.BreakAdIndex < len(state.BreakAds) {
+		ad := state.BreakAds[state.BreakAdIndex]
+		if ad.Duration <= remaining && len(s.renditionSegments(ad.VideoID, "")) > 0 {
+			state.ItemVideoID = ad.VideoID
+			state.AdOnAir = true
+			state.AdPosition = 0
+			state.AdMilestone = 0
+			return
+		}
+		state.BreakAdIndex++
+	}
+
+	state.ItemVideoID = state.LoopVideoID
+}
+
+// trackAd fires the tracking events of the ad on air reached by the segment just aired
+func (s *PlayoutService) trackAd(state *playoutState, duration float64) {
+	if !state.AdOnAir || s.ads == nil {
+		return
+	}
+
+	ad := state.BreakAds[state.BreakAdIndex]
+	// Completion is only reported once the last segment has aired, see finishAd
+	for state.AdMilestone < len(adMilestones)-1 && adMilestones[state.AdMilestone].Fraction*ad.Duration <= state.AdPosition {
+		s.ads.TrackAdEvent(ad.DecisionAdID, adMilestones[state.AdMilestone].Event)
+		state.AdMilestone++
+	}
+	state.AdPosition += duration
+}
+
+// finishAd takes the ad on air off air, reporting its completion if it aired in full
+func (s *PlayoutService) finishAd(state *playoutState, completed bool) {
+	if !state.AdOnAir {
+		return
+	}
+
+	if completed && s.ads != nil {
+		ad := state.BreakAds[state.BreakAdIndex]
+		for ; state.AdMilestone < len(adMilestones); state.AdMilestone++ {
+			s.ads.TrackAdEvent(ad.DecisionAdID, adMilestones[state.AdMilestone].Event)
+		}
+	}
+
+	state.AdOnAir = false
+	state.BreakAdIndex++
+}
+
+// breakOut opens an ad break on the channel and returns its cue-out marker
+func (s *PlayoutService) breakOut(state *playoutState, breakID uint, duration time.Duration, airTime time.Time) adMarker {
+	state.BreakID = fmt.Sprintf("break-%d-%d", breakID, airTime.Unix())
+	state.BreakEventID = uint32(state.NextSequence)
+	state.BreakStart = airTime
+	state.BreakDuration = duration.Seconds()
+
+	return adMarker{
+		Type:      adMarkerOut,
+		ID:        state.BreakID,
+		EventID:   state.BreakEventID,
+		StartDate: state.BreakStart,
+		Duration:  state.BreakDuration,
+	}
+}
+
+// breakIn closes the open ad break on the channel and returns its cue-in marker
+func (s *PlayoutService) breakIn(state *playoutState, airTime time.Time) adMarker {
+	marker := adMarker{
+		Type:      adMarkerIn,
+		ID:        state.BreakID,
+		EventID:   state.BreakEventID,
+		StartDate: state.BreakStart,
+		Duration:  state.BreakDuration,
+		Elapsed:   airTime.Sub(state.BreakStart).Seconds(),
+	}
+
+	state.BreakID = ""
+	state.BreakEventID = 0
+	state.BreakStart = time.Time{}
+	state.BreakDuration = 0
+	return marker
+}
+
+// breakProgress returns the cue-out continuation marker while an ad break is on air
+func (s *PlayoutService) breakProgress(state *playoutState, airTime time.Time) []adMarker {
+	if state.BreakID == "" {
+		return nil
+	}
+
+	return []adMarker{{
+		Type:      adMarkerCont,
+		ID:        state.BreakID,
+		EventID:   state.BreakEventID,
+		StartDate: state.BreakStart,
+		Duration:  state.BreakDuration,
+		Elapsed:   airTime.Sub(state.BreakStart).Seconds(),
+	}}
+}
+
+// recordItemStart opens the as-run entry of an item going on air
+func (s *PlayoutService) recordItemStart(channelID uint, state *playoutState, item *timelineItem, airTime time.Time) {
+	if s.asRun == nil {
+		return
+	}
+
+	var replacedVideoID uint
+	reason := ""
+	if item.Substitute != nil {
+		replacedVideoID = item.Substitute.VideoID
+		reason = item.Substitute.Reason
+		logrus.Warnf("Channel %d: video %d stands in for video %d (%s)", channelID, item.VideoID, replacedVideoID, reason)
+	}
+
+	id, err := s.asRun.StartEntry(channelID, item.VideoID, item.Start, airTime, replacedVideoID, reason)
+	if err != nil {
+		logrus.Errorf("Failed to record as-run start for channel %d, video %d: %v", channelID, item.VideoID, err)
+		return
+	}
+	state.AsRunID = id
+}
+
+// recordItemEnd closes the open as-run entry of a channel
+func (s *PlayoutService) recordItemEnd(state *playoutState, endedAt time.Time, reason string) {
+	if s.asRun == nil || state.AsRunID == 0 {
+		return
+	}
+
+	if err := s.asRun.FinishEntry(state.AsRunID, endedAt, reason); err != nil {
+		logrus.Errorf("Failed to record as-run end for entry %d: %v", state.AsRunID, err)
+	}
+	state.AsRunID = 0
+}
+
+// emitSegment builds the aired segment entry and advances the cursor
+func (s *PlayoutService) emitSegment(state *playoutState, segment utils.HLSSegment, index int, discontinuity bool, markers []adMarker) playoutSegment {
+	duration := segment.Duration
+	if duration <= 0 {
+		duration = float64(s.config.Transcode.SegmentTime)
+	}
+
+	if discontinuity {
+		state.DiscontinuitySequence++
+	}
+
+	aired := playoutSegment{
+		Sequence:              state.NextSequence,
+		DiscontinuitySequence: state.DiscontinuitySequence,
+		Discontinuity:         discontinuity,
+		VideoID:               state.ItemVideoID,
+		Index:                 index,
+		Duration:              duration,
+		AirTime:               state.NextAirTime,
+		Markers:               markers,
+	}
+
+	state.NextSequence++
+	state.NextIndex = index + 1
+	state.NextAirTime = state.NextAirTime.Add(time.Duration(duration * float64(time.Second)))
+
+	return aired
+}
+
+// itemAt returns the item planned to be on air on a channel at the given time
+func (s *PlayoutService) itemAt(plan *channelPlan, t time.Time) *timelineItem {
+	if t.Before(plan.from) || !t.Before(plan.to) {
+		plan.from, plan.to = t, t.Add(playoutPlanAhead)
+		plan.items = s.planTimeline(plan.channel, s.planFill(plan), plan.from, plan.to)
+	}
+
+	for _, item := range plan.items {
+		if !t.Before(item.Start) && t.Before(item.End()) {
+			found := item
+			return &found
+		}
+	}
+	return nil
+}
+
+// buildTimeline plans a channel's items between from and to: schedule entries at
+// their start times, with the channel's loop playlist filling everything in between.
+// A later entry cuts off an earlier one that is still running. Filler or slate
+// covers gaps the loop cannot fill and assets that are not playable.
+func (s *PlayoutService) buildTimeline(channel *models.Channel, from, to time.Time) []timelineItem {
+	return s.planTimeline(channel, s.loadFill(channel), from, to)
+}
+
+// planTimeline plans a channel's items between from and to with its fill already loaded
+func (s *PlayoutService) planTimeline(channel *models.Channel, fill *fillSource, from, to time.Time) []timelineItem {
+	entries, err := loadScheduleWindow(s.db, channel.ID, from, to)
+	if err != nil {
+		logrus.Errorf("Failed to load schedule of channel %d: %v", channel.ID, err)
+		return nil
+	}
+
+	loop := s.loadLoop(channel.PlaylistID)
+	if loop.empty() {
+		loop = fill.entries(&substitution{Reason: SubstitutionGap})
+	}
+
+	var items []timelineItem
+	var restartAt *time.Time // nil while the loop still runs from the channel's creation
+	cursor := from
+
+	for i := range entries {
+		entry := &entries[i]
+		var next time.Time
+		if i+1 < len(entries) {
+			next = entries[i+1].StartTime
+		}
+		entryItems := s.scheduleItems(entry, next)
+
+		end := entry.StartTime
+		if len(entryItems) > 0 {
+			end = entryItems[len(entryItems)-1].End()
+		}
+		if i+1 < len(entries) && entries[i+1].StartTime.Before(end) {
+			end = entries[i+1].StartTime
+		}
+
+		if entry.StartTime.After(cursor) {
+			gap := fillLoop(loop, channel.CreatedAt, restartAt, cursor, entry.StartTime)
+			items = append(items, clipItems(gap, entry.StartTime)...)
+		}
+		items = append(items, clipItems(entryItems, end)...)
+
+		entryEnd := end
+		restartAt = &entryEnd
+		if end.After(cursor) {
+			cursor = end
+		}
+	}
+
+	if cursor.Before(to) {
+		items = append(items, fillLoop(loop, channel.CreatedAt, restartAt, cursor, to)...)
+	}
+
+	return fill.cover(items)
+}
+
+// planFill returns the filler and slate of a planned channel, loading them on first use
+func (s *PlayoutService) planFill(plan *channelPlan) *fillSource {
+	if plan.fill == nil {
+		plan.fill = s.loadFill(plan.channel)
+	}
+	return plan.fill
+}
+
+// loadLoop returns the rotation of an optional playlist
+func (s *PlayoutService) loadLoop(playlistID *uint) *rotation {
+	if playlistID == nil {
+		return nil
+	}
+
+	var playlist models.Playlist
+	if err := s.db.Preload("PlaylistVideos.Video").Preload("AdBreaks.PlaceholderVideo").First(&playlist, *playlistID).Error; err != nil {
+		return nil
+	}
+	return s.playlistRotation(&playlist)
+}
+
+// loadFill loads the filler playlist and slate of a channel. Unplayable filler
+// assets are covered by the slate, or dropped without one.
+func (s *PlayoutService) loadFill(channel *models.Channel) *fillSource {
+	fill := &fillSource{epoch: channel.CreatedAt}
+
+	if channel.SlateID != nil {
+		var slate models.Slate
+		if err := s.db.Preload("Video").First(&slate, *channel.SlateID).Error; err == nil &&
+			slate.Video != nil && slate.Video.Status == "completed" {
+			if duration := s.videoDuration(slate.Video); duration > 0 {
+				fill.slate = &timelineItem{VideoID: slate.Video.ID, Duration: duration}
+			}
+		}
+	}
+
+	if filler := s.loadLoop(channel.FillerPlaylistID); filler != nil {
+		fill.filler = filler.mapItems(func(item timelineItem) (timelineItem, bool) {
+			if item.Substitute != nil {
+				if fill.slate == nil {
+					return item, false
+				}
+				item.VideoID = fill.slate.VideoID
+				item.Loop = true
+			}
+			return item, true
+		})
+	}
+
+	return fill
+}
+
+// entries returns the rotation looped over gaps: the filler playlist, or else the slate
+func (f *fillSource) entries(sub *substitution) *rotation {
+	entries := f.filler
+	if entries.empty() {
+		if f.slate == nil {
+			return nil
+		}
+		entries = singleRotation(*f.slate)
+	}
+
+	return entries.mapItems(func(item timelineItem) (timelineItem, bool) {
+		item.Substitute = sub
+		return item, true
+	})
+}
+
+// cover replaces unplayable items with filler restarting at their start, or with
+// the slate on repeat, keeping their slot on the timeline
+func (f *fillSource) cover(items []timelineItem) []timelineItem {
+	var covered []timelineItem
+	for _, item := range items {
+		if item.VideoID != 0 || item.Substitute == nil {
+			covered = append(covered, item)
+			continue
+		}
+
+		if !f.filler.empty() {
+			restartAt := item.Start
+			fillers := clipItems(fillLoop(f.filler, f.epoch, &restartAt, item.Start, item.End()), item.End())
+			for i, filler := range fillers {
+				if i == 0 && item.Splice != nil {
+					filler.Splice = item.Splice
+				}
+				filler.Substitute = item.Substitute
+				covered = append(covered, filler)
+			}
+		} else if f.slate != nil {
+			item.VideoID = f.slate.VideoID
+			item.InPoint = 0
+			item.OutPoint = 0
+			item.Loop = true
+			covered = append(covered, item)
+		}
+	}
+	return covered
+}
+
+// scheduleItems expands a schedule entry into timeline items starting at its start
+// time. Looping playlists repeat until next, the start of the following entry.
+func (s *PlayoutService) scheduleItems(entry *models.ScheduleEntry, next time.Time) []timelineItem {
+	if entry.Video != nil {
+		inPoint := time.Duration(entry.InPoint) * time.Second
+		outPoint := time.Duration(entry.OutPoint) * time.Second
+		end := outPoint
+		if end == 0 {
+			end = s.videoDuration(entry.Video)
+		}
+		if end <= inPoint {
+			return nil
+		}
+
+		item := timelineItem{
+			VideoID:  entry.Video.ID,
+			Start:    entry.StartTime,
+			Duration: end - inPoint,
+			InPoint:  inPoint,
+			OutPoint: outPoint,
+		}
+		if sub := unavailable(entry.Video); sub != nil {
+			// Keep the slot so filler or slate can stand in for it
+			item.VideoID = 0
+			item.Substitute = sub
+		}
+		return []timelineItem{item}
+	}
+
+	var items []timelineItem
+	if entry.Playlist != nil {
+		r := s.playlistRotation(entry.Playlist)
+		if entry.Playlist.PlaybackMode == PlaybackLoop && !next.IsZero() {
+			return fillLoop(r, entry.StartTime, nil, entry.StartTime, next)
+		}
+
+		start := entry.StartTime
+		for _, item := range r.once(entry.StartTime.Unix()) {
+			item.Start = start
+			items = append(items, item)
+			start = start.Add(item.Duration)
+		}
+	}
+	return items
+}
+
+// clipItems drops items starting at or after end and shortens the one running past it
+func clipItems(items []timelineItem, end time.Time) []timelineItem {
+	var clipped []timelineItem
+	for _, item := range items {
+		if !item.Start.Before(end) {
+			break
+		}
+		if item.End().After(end) {
+			item.Duration = end.Sub(item.Start)
+			item.OutPoint = item.InPoint + item.Duration
+		}
+		clipped = append(clipped, item)
+	}
+	return clipped
+}
+
+// playlistRotation returns the rotation of a playlist following its playback mode
+func (s *PlayoutService) playlistRotation(playlist *models.Playlist) *rotation {
+	weights := make(map[uint]int)
+	for _, pv := range playlist.PlaylistVideos {
+		weights[pv.VideoID] = pv.Weight
+	}
+	return newRotation(playlist, s.playlistUnits(playlist), weights)
+}
+
+// playlistUnits returns the playable assets of a playlist in sort order as units
+// of item templates without start times. Mid-roll breaks split a video into
+// parts, and breaks with a placeholder become items of their own.
+func (s *PlayoutService) playlistUnits(playlist *models.Playlist) []rotationUnit {
+	playlistVideos := make([]models.PlaylistVideo, len(playlist.PlaylistVideos))
+	copy(playlistVideos, playlist.PlaylistVideos)
+	sort.SliceStable(playlistVideos, func(i, j int) bool {
+		return playlistVideos[i].SortOrder < playlistVideos[j].SortOrder
+	})
+
+	breaks := make(map[uint][]models.AdBreak)
+	for _, adBreak := range playlist.AdBreaks {
+		breaks[adBreak.VideoID] = append(breaks[adBreak.VideoID], adBreak)
+	}
+	for _, videoBreaks := range breaks {
+		sort.SliceStable(videoBreaks, func(i, j int) bool {
+			return videoBreaks[i].Offset < videoBreaks[j].Offset
+		})
+	}
+
+	var units []rotationUnit
+	for _, pv := range playlistVideos {
+		duration := s.videoDuration(&pv.Video)
+		if duration <= 0 {
+			continue
+		}
+		if sub := unavailable(&pv.Video); sub != nil {
+			// Keep the slot so filler or slate can stand in for it
+			units = append(units, rotationUnit{
+				VideoID: pv.VideoID,
+				Items:   []timelineItem{{Duration: duration, Substitute: sub}},
+			})
+			continue
+		}
+
+		var items []timelineItem
+		var splice *breakInfo // splice-only break waiting for the next item
+		var afterBreaks []models.AdBreak
+		position := time.Duration(0)
+		for _, adBreak := range breaks[pv.VideoID] {
+			if adBreak.Position != "midroll" {
+				afterBreaks = append(afterBreaks, adBreak)
+				continue
+			}
+
+			offset := time.Duration(adBreak.Offset) * time.Second
+			if offset < position || offset >= duration {
+				continue
+			}
+			if offset > position {
+				items = append(items, timelineItem{
+					VideoID:  pv.VideoID,
+					Duration: offset - position,
+					InPoint:  position,
+					OutPoint: offset,
+					Splice:   splice,
+				})
+				splice = nil
+				position = offset
+			}
+			items, splice = appendBreak(items, splice, &adBreak)
+		}
+
+		items = append(items, timelineItem{
+			VideoID:  pv.VideoID,
+			Duration: duration - position,
+			InPoint:  position,
+			Splice:   splice,
+		})
+		splice = nil
+
+		for i := range afterBreaks {
+			items, splice = appendBreak(items, splice, &afterBreaks[i])
+		}
+
+		units = append(units, rotationUnit{VideoID: pv.VideoID, Items: items, Splice: splice})
+	}
+
+	return units
+}
+
+// unavailable returns why a video cannot air, nil if it is playable
+func unavailable(video *models.Video) *substitution {
+	switch video.Status {
+	case "completed":
+		return nil
+	case "failed":
+		return &substitution{VideoID: video.ID, Reason: SubstitutionAssetFailed}
+	default:
+		return &substitution{VideoID: video.ID, Reason: SubstitutionAssetNotReady}
+	}
+}
+
+// appendBreak adds an ad break to a playlist's items. Breaks with a playable
+// placeholder become items filling their duration; the others are returned as
+// the splice to signal on the next item, merged with any splice still pending.
+func appendBreak(items []timelineItem, splice *breakInfo, adBreak *models.AdBreak) ([]timelineItem, *breakInfo) {
+	info := &breakInfo{ID: adBreak.ID, Duration: time.Duration(adBreak.Duration) * time.Second}
+
+	placeholder := adBreak.PlaceholderVideo
+	if placeholder == nil || placeholder.Status != "completed" {
+		if splice != nil {
+			splice.Duration += info.Duration
+			return items, splice
+		}
+		return items, info
+	}
+
+	return append(items, timelineItem{
+		VideoID:  placeholder.ID,
+		Duration: info.Duration,
+		Loop:     true,
+		Break:    info,
+		Splice:   splice,
+	}), nil
+}
+
+// fillLoop lays a looping playlist over [from, to). The loop runs endlessly from
+// epoch; when restartAt is set it restarts there with the asset that would have
+// been on air at that moment, as it does after a scheduled program ends.
+func fillLoop(r *rotation, epoch time.Time, restartAt *time.Time, from, to time.Time) []timelineItem {
+	if restartAt != nil {
+		_, _, start, ok := r.position(epoch, *restartAt)
+		if !ok {
+			return nil
+		}
+		// Shift the loop so that asset starts over at restartAt
+		epoch = epoch.Add(restartAt.Sub(start))
+	}
+
+	n, index, start, ok := r.position(epoch, from)
+	if !ok {
+		return nil
+	}
+
+	var items []timelineItem
+	pass := r.pass(n)
+	for start.Before(to) {
+		if index == len(pass) {
+			n++
+			pass = r.pass(n)
+			index = 0
+		}
+		item := pass[index]
+		item.Start = start
+		items = append(items, item)
+		start = start.Add(item.Duration)
+		index++
+	}
+	return items
+}
+
+// videoDuration returns the planned duration of a video, falling back to its segments
+func (s *PlayoutService) videoDuration(video *models.Video) time.Duration {
+	if video.Duration > 0 {
+		return time.Duration(video.Duration) * time.Second
+	}
+	total := utils.TotalDuration(s.renditionSegments(video.ID, ""))
+	return time.Duration(total * float64(time.Second))
+}
+
+// segmentIndexAt returns the index of the segment starting closest to position (in seconds)
+func segmentIndexAt(segments []utils.HLSSegment, position float64) int {
+	if position >= utils.TotalDuration(segments) {
+		return len(segments)
+	}
+
+	best := 0
+	for i, segment := range segments {
+		if math.Abs(segment.Start-position) < math.Abs(segments[best].Start-position) {
+			best = i
+		}
+		if segment.Start > position {
+			break
+		}
+	}
+	return best
+}
+
+// switchTolerance is how far the engine may drift from the timeline at item boundaries
+func (s *PlayoutService) switchTolerance() time.Duration {
+	return 2 * time.Duration(s.config.Transcode.SegmentTime) * time.Second
+}
+
+// windowDuration returns the nominal duration of the live sliding window
+func (s *PlayoutService) windowDuration() time.Duration {
+	return time.Duration(s.config.Transcode.HLSWindow*s.config.Transcode.SegmentTime) * time.Second
+}
+
+// retainedSegments returns how many aired segments are kept for the live window and catch-up
+func (s *PlayoutService) retainedSegments() int {
+	count := (s.config.Transcode.DVRWindow + s.config.Transcode.SegmentTime - 1) / s.config.Transcode.SegmentTime
+	if count < s.config.Transcode.HLSWindow {
+		return s.config.Transcode.HLSWindow
+	}
+	return count
+}
+
+// loadState reads the engine cursor of a channel, nil if the channel never aired
+func (s *PlayoutService) loadState(channelID uint) (*playoutState, error) {
+	ctx := context.Background()
+	data, err := s.redis.Get(ctx, fmt.Sprintf("channel:%d:state", channelID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state playoutState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return nil, fmt.Errorf("invalid playout state: %w", err)
+	}
+	return &state, nil
+}
+
+// saveState persists the engine cursor of a channel
+func (s *PlayoutService) saveState(channelID uint, state *playoutState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return s.redis.Set(ctx, fmt.Sprintf("channel:%d:state", channelID), data, 0).Err()
+}
+
+// appendSegments adds aired segments to the channel's sequence and trims it to the DVR window
+func (s *PlayoutService) appendSegments(channelID uint, segments []playoutSegment) error {
+	ctx := context.Background()
+	key := fmt.Sprintf("channel:%d:segments", channelID)
+
+	values := make([]interface{}, 0, len(segments))
+	for _, segment := range segments {
+		data, err := json.Marshal(segment)
+		if err != nil {
+			return err
+		}
+		values = append(values, data)
+	}
+
+	if err := s.redis.RPush(ctx, key, values...).Err(); err != nil {
+		return err
+	}
+	return s.redis.LTrim(ctx, key, int64(-s.retainedSegments()), -1).Err()
+}
+
+// recentSegments returns the last count aired segments of a channel
+func (s *PlayoutService) recentSegments(channelID uint, count int) ([]playoutSegment, error) {
+	if s.redis == nil {
+		return nil, fmt.Errorf("playout engine is not available")
+	}
+
+	ctx := context.Background()
+	values, err := s.redis.LRange(ctx, fmt.Sprintf("channel:%d:segments", channelID), int64(-count), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]playoutSegment, 0, len(values))
+	for _, value := range values {
+		var segment playoutSegment
+		if err := json.Unmarshal([]byte(value), &segment); err != nil {
+			return nil, fmt.Errorf("invalid playout segment: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// GetChannelMasterPlaylist generates the master playlist of a channel; a time shift
+// is carried over to the variant playlists
+func (s *PlayoutService) GetChannelMasterPlaylist(channelID uint, shift *models.TimeShiftQuery) (string, error) {
+	var channel models.Channel
+	if err := s.db.First(&channel, channelID).Error; err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	from := now.Add(-s.windowDuration())
+	if shift != nil {
+		from = now.Add(-time.Duration(s.config.Transcode.DVRWindow) * time.Second)
+	}
+
+	videoIDs := make(map[uint]bool)
+	for _, item := range s.buildTimeline(&channel, from, now.Add(24*time.Hour)) {
+		videoIDs[item.VideoID] = true
+	}
+
+	ids := make([]uint, 0, len(videoIDs))
+	for id := range videoIDs {
+		ids = append(ids, id)
+	}
+
+	var profiles []models.VideoProfile
+	if len(ids) > 0 {
+		if err := s.db.Where("video_id IN ? AND status = ?", ids, "completed").Find(&profiles).Error; err != nil {
+			return "", err
+		}
+	}
+
+	// Advertise every rendition carried by the channel at its highest bitrate
+	bandwidths := make(map[string]int)
+	sizes := make(map[string][2]int)
+	codecs := make(map[string]string)
+	for i := range profiles {
+		profile := &profiles[i]
+		name := renditionName(profile)
+		if profile.Bitrate > bandwidths[name] {
+			bandwidths[name] = profile.Bitrate
+			width, height := profileDimensions(profile)
+			sizes[name] = [2]int{width, height}
+			codecs[name] = codecsAttribute(profile)
+		}
+	}
+
+	if len(bandwidths) == 0 {
+		return "", fmt.Errorf("channel has no playable videos")
+	}
+
+	resolutions := make([]string, 0, len(bandwidths))
+	for resolution := range bandwidths {
+		resolutions = append(resolutions, resolution)
+	}
+	sort.Slice(resolutions, func(i, j int) bool {
+		return bandwidths[resolutions[i]] > bandwidths[resolutions[j]]
+	})
+
+	var lines []string
+	lines = append(lines, "#EXTM3U")
+	lines = append(lines, fmt.Sprintf("#EXT-X-VERSION:%d", hlsVersion(profiles)))
+
+	for _, resolution := range resolutions {
+		lines = append(lines, fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"",
+			bandwidths[resolution]*1000, sizes[resolution][0], sizes[resolution][1], codecs[resolution]))
+		lines = append(lines, fmt.Sprintf("%s/playlist.m3u8%s", resolution, timeShiftParams(shift)))
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// timeShiftParams renders a time shift as the query string of a variant playlist URL
+func timeShiftParams(shift *models.TimeShiftQuery) string {
+	if shift == nil {
+		return ""
+	}
+	if shift.ProgramID != 0 {
+		return fmt.Sprintf("?program=%d", shift.ProgramID)
+	}
+	return "?start=" + url.QueryEscape(shift.Start.UTC().Format(time.RFC3339))
+}
+
+// GetChannelMediaPlaylist generates the live sliding-window media playlist of a channel,
+// or with a time shift an event playlist from that point (VOD once the program has ended)
+func (s *PlayoutService) GetChannelMediaPlaylist(channelID uint, resolution string, shift *models.TimeShiftQuery) (string, error) {
+	var segments []playoutSegment
+	var err error
+	playlistType := ""
+	if shift != nil {
+		var ended bool
+		segments, ended, err = s.timeShiftSegments(channelID, shift)
+		playlistType = "EVENT"
+		if ended {
+			playlistType = "VOD"
+		}
+	} else {
+		segments, err = s.recentSegments(channelID, s.config.Transcode.HLSWindow)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("channel %d is not on air", channelID)
+	}
+
+	renditions := s.newRenditionResolver(resolution)
+	resolved := make([]utils.HLSSegment, 0, len(segments))
+	targetDuration := s.config.Transcode.SegmentTime
+	version := 3
+	for _, segment := range segments {
+		media, err := renditions.segmentURI(segment)
+		if err != nil {
+			return "", err
+		}
+		if d := int(math.Ceil(media.Duration)); d > targetDuration {
+			targetDuration = d
+		}
+		if media.Map != "" {
+			version = 7
+		}
+		resolved = append(resolved, media)
+	}
+
+	var content strings.Builder
+	content.WriteString("#EXTM3U\n")
+	content.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", version))
+	content.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", targetDuration))
+	content.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].Sequence))
+	content.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", segments[0].DiscontinuitySequence))
+	if playlistType != "" {
+		content.WriteString(fmt.Sprintf("#EXT-X-PLAYLIST-TYPE:%s\n", playlistType))
+	}
+
+	initMap, key := "", ""
+	for i, segment := range segments {
+		if segment.Discontinuity && i > 0 {
+			content.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		// Encrypted assets carry explicit IVs, so their keys hold at any media sequence
+		if resolved[i].Key != key {
+			key = resolved[i].Key
+			if key == "" {
+				content.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+			} else {
+				content.WriteString("#EXT-X-KEY:" + key + "\n")
+			}
+		}
+		// Fragmented MP4 assets each bring their own initialization section. A map
+		// applies until the next one, so channels should not mix in MPEG-TS assets.
+		if resolved[i].Map != "" && resolved[i].Map != initMap {
+			initMap = resolved[i].Map
+			content.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", initMap))
+		}
+		// Date ranges need a program date; anchor it at the start and after each discontinuity
+		if segment.Discontinuity || i == 0 {
+			content.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", formatDateRangeTime(segment.AirTime)))
+		}
+		for _, marker := range segment.Markers {
+			writeAdMarker(&content, marker, segment.AirTime, i == 0)
+		}
+		content.WriteString(fmt.Sprintf("#EXTINF:%.6f,\n", resolved[i].Duration))
+		content.WriteString(resolved[i].URI + "\n")
+	}
+
+	if playlistType == "VOD" {
+		content.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return content.String(), nil
+}
+
+// GetChannelManifest generates the dynamic DASH manifest of a channel's live window.
+// Each aired item is a period of its own, as its timestamps restart; items without
+// fragmented MP4 renditions, or encrypted for HLS, cannot be carried and are left out.
+func (s *PlayoutService) GetChannelManifest(channelID uint) (string, error) {
+	if err := s.db.First(&models.Channel{}, channelID).Error; err != nil {
+		return "", err
+	}
+
+	// Periods are anchored on the earliest retained segment of each item, so their
+	// start does not move as the window slides
+	retained, err := s.recentSegments(channelID, s.retainedSegments())
+	if err != nil {
+		return "", err
+	}
+	if len(retained) == 0 {
+		return "", fmt.Errorf("channel %d is not on air", channelID)
+	}
+	window := len(retained) - s.config.Transcode.HLSWindow
+	if window < 0 {
+		window = 0
+	}
+
+	var periods []utils.DASHPeriod
+	for start := 0; start < len(retained); {
+		end := start + 1
+		for end < len(retained) && !retained[end].Discontinuity {
+			end++
+		}
+		if end > window {
+			first := start
+			if first < window {
+				first = window
+			}
+			period, err := s.channelPeriod(retained[start], retained[first:end])
+			if err != nil {
+				return "", err
+			}
+			if period != nil {
+				periods = append(periods, *period)
+			}
+		}
+		start = end
+	}
+	if len(periods) == 0 {
+		return "", fmt.Errorf("channel %d has no fragmented MP4 content on air", channelID)
+	}
+
+	segmentTime := time.Duration(s.config.Transcode.SegmentTime) * time.Second
+	return utils.MarshalMPD(&utils.MPD{
+		Type:                       utils.DASHDynamic,
+		AvailabilityStartTime:      utils.DASHTime(time.Unix(0, 0)),
+		PublishTime:                utils.DASHTime(time.Now()),
+		MinimumUpdatePeriod:        utils.DASHDuration(segmentTime),
+		TimeShiftBufferDepth:       utils.DASHDuration(s.windowDuration()),
+		SuggestedPresentationDelay: utils.DASHDuration(3 * segmentTime),
+		MinBufferTime:              utils.DASHDuration(2 * segmentTime),
+		Periods:                    periods,
+	})
+}
+
+// channelPeriod builds the DASH period of an aired item from its segments in the live
+// window; anchor is its earliest retained segment. It returns nil when the item has
+// no fragmented MP4 renditions in the clear.
+func (s *PlayoutService) channelPeriod(anchor playoutSegment, segments []playoutSegment) (*utils.DASHPeriod, error) {
+	var profiles []models.VideoProfile
+	if err := s.db.Where("video_id = ? AND status = ? AND segment_format = ?", anchor.VideoID, "completed", SegmentFormatFMP4).
+		Order("bitrate DESC").
+		Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+
+	representations := make([]utils.DASHRepresentation, 0, len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		renditions := s.newRenditionResolver(renditionName(profile))
+		origin, err := renditions.segmentURI(anchor)
+		if err != nil {
+			return nil, err
+		}
+		if origin.Key != "" {
+			// Whole-segment AES-128 is HLS only
+			continue
+		}
+
+		media := make([]utils.HLSSegment, 0, len(segments))
+		for _, segment := range segments {
+			resolved, err := renditions.segmentURI(segment)
+			if err != nil {
+				return nil, err
+			}
+			media = append(media, resolved)
+		}
+
+		width, height := profileDimensions(profile)
+		representations = append(representations, utils.DASHRepresentation{
+			ID:          renditionName(profile),
+			Bandwidth:   profile.Bitrate * 1000,
+			Width:       width,
+			Height:      height,
+			Codecs:      codecsAttribute(profile),
+			SegmentList: utils.NewDASHSegmentList(media, "", origin.Start),
+		})
+	}
+	if len(representations) == 0 {
+		return nil, nil
+	}
+
+	return &utils.DASHPeriod{
+		ID:             strconv.FormatInt(anchor.DiscontinuitySequence, 10),
+		Start:          utils.DASHDuration(anchor.AirTime.Sub(time.Unix(0, 0))),
+		AdaptationSets: codecAdaptationSets(representations),
+	}, nil
+}
+
+// timeShiftSegments returns the aired segments from a time shift's start up to the live
+// edge, or up to the end of the program once it has ended
+func (s *PlayoutService) timeShiftSegments(channelID uint, shift *models.TimeShiftQuery) ([]playoutSegment, bool, error) {
+	start := shift.Start
+	var end time.Time
+	if shift.ProgramID != 0 {
+		var entry models.AsRunEntry
+		if err := s.db.Where("id = ? AND channel_id = ?", shift.ProgramID, channelID).First(&entry).Error; err != nil {
+			return nil, false, err
+		}
+		start = entry.ActualStart
+		if entry.EndedAt != nil {
+			end = *entry.EndedAt
+		}
+	}
+
+	if start.After(time.Now()) {
+		return nil, false, fmt.Errorf("%w: start is in the future", ErrInvalidInput)
+	}
+
+	segments, err := s.recentSegments(channelID, s.retainedSegments())
+	if err != nil {
+		return nil, false, err
+	}
+	if len(segments) == 0 {
+		return nil, false, fmt.Errorf("channel %d is not on air", channelID)
+	}
+	if start.Before(segments[0].AirTime) {
+		return nil, false, fmt.Errorf("%w: start is outside the %ds DVR window", ErrInvalidInput, s.config.Transcode.DVRWindow)
+	}
+
+	// Begin at the segment on air at the start; the stored as-run times are truncated
+	// to the millisecond, so a segment ending within a millisecond of it is skipped
+	first := len(segments) - 1
+	for i, segment := range segments {
+		segmentEnd := segment.AirTime.Add(time.Duration(segment.Duration * float64(time.Second)))
+		if segmentEnd.After(start.Add(time.Millisecond)) {
+			first = i
+			break
+		}
+	}
+	segments = segments[first:]
+
+	if end.IsZero() {
+		return segments, false, nil
+	}
+
+	last := 0
+	for last < len(segments) && segments[last].AirTime.Before(end) {
+		last++
+	}
+	if last == 0 {
+		last = 1
+	}
+	return segments[:last], true, nil
+}
+
+// writeAdMarker renders an ad marker as EXT-X-DATERANGE with SCTE-35 payloads
+// and the matching EXT-X-CUE tags. A break already in progress at the top of the
+// window repeats its opening date range so players joining late still see it.
+func writeAdMarker(content *strings.Builder, marker adMarker, airTime time.Time, first bool) {
+	switch marker.Type {
+	case adMarkerOut:
+		content.WriteString(dateRangeOut(marker))
+		content.WriteString(fmt.Sprintf("#EXT-X-CUE-OUT:DURATION=%.3f\n", marker.Duration))
+	case adMarkerCont:
+		if first {
+			content.WriteString(dateRangeOut(marker))
+		}
+		content.WriteString(fmt.Sprintf("#EXT-X-CUE-OUT-CONT:ElapsedTime=%.3f,Duration=%.3f\n", marker.Elapsed, marker.Duration))
+	case adMarkerIn:
+		content.WriteString(fmt.Sprintf("#EXT-X-DATERANGE:ID=\"%s\",START-DATE=\"%s\",END-DATE=\"%s\",SCTE35-IN=%s\n",
+			marker.ID, formatDateRangeTime(marker.StartDate), formatDateRangeTime(airTime),
+			utils.SpliceInsertHex(marker.EventID, false, 0)))
+		content.WriteString("#EXT-X-CUE-IN\n")
+	}
+}
+
+// dateRangeOut renders the opening EXT-X-DATERANGE of an ad break
+func dateRangeOut(marker adMarker) string {
+	return fmt.Sprintf("#EXT-X-DATERANGE:ID=\"%s\",START-DATE=\"%s\",PLANNED-DURATION=%.3f,SCTE35-OUT=%s\n",
+		marker.ID, formatDateRangeTime(marker.StartDate), marker.Duration,
+		utils.SpliceInsertHex(marker.EventID, true, marker.Duration))
+}
+
+// formatDateRangeTime formats a timestamp as the ISO 8601 date used by HLS date tags
+func formatDateRangeTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// renditionResolver resolves aired segments to one rendition for the length of a
+// request, looking up the profile and segments of each video once
+type renditionResolver struct {
+	service    *PlayoutService
+	resolution string
+	videos     map[uint]*resolvedRendition
+}
+
+// resolvedRendition is the profile of a video matching a resolver's rendition, with its segments
+type resolvedRendition struct {
+	profile  *models.VideoProfile
+	segments []utils.HLSSegment
+	err      error
+}
+
+func (s *PlayoutService) newRenditionResolver(resolution string) *renditionResolver {
+	return &renditionResolver{service: s, resolution: resolution, videos: make(map[uint]*resolvedRendition)}
+}
+
+// rendition returns the profile and segments of a video's rendition
+func (r *renditionResolver) rendition(videoID uint) *resolvedRendition {
+	if resolved, ok := r.videos[videoID]; ok {
+		return resolved
+	}
+
+	resolved := &resolvedRendition{}
+	resolved.profile, resolved.err = r.service.resolveRendition(videoID, r.resolution)
+	if resolved.err == nil {
+		resolved.segments = r.service.profileSegments(resolved.profile)
+		if len(resolved.segments) == 0 {
+			resolved.err = fmt.Errorf("no segments for video %d at %s", videoID, renditionName(resolved.profile))
+		}
+	}
+	r.videos[videoID] = resolved
+	return resolved
+}
+
+// segmentURI resolves an aired segment to the URLs of the rendition's segment and,
+// for fragmented MP4, its initialization section
+func (r *renditionResolver) segmentURI(segment playoutSegment) (utils.HLSSegment, error) {
+	resolved := r.rendition(segment.VideoID)
+	if resolved.err != nil {
+		return utils.HLSSegment{}, resolved.err
+	}
+
+	// Renditions are cut on the same keyframe interval; clamp in case one is shorter
+	index := segment.Index
+	if index >= len(resolved.segments) {
+		index = len(resolved.segments) - 1
+	}
+
+	base := fmt.Sprintf("/api/v1/stream/%d/%s/", segment.VideoID, renditionName(resolved.profile))
+	media := resolved.segments[index]
+	media.URI = base + media.URI
+	if media.Map != "" {
+		media.Map = base + media.Map
+	}
+	return media, nil
+}
+
+// resolveRendition returns the completed profile matching a rendition name, or the closest
+// one in resolution, preferring the codec the name asks for. Resolved profiles are
+// reused for renditionCacheTTL.
+func (s *PlayoutService) resolveRendition(videoID uint, name string) (*models.VideoProfile, error) {
+	key := fmt.Sprintf("%d:%s", videoID, name)
+	s.cacheMu.RLock()
+	cached, ok := s.profileCache[key]
+	s.cacheMu.RUnlock()
+	if ok && time.Since(cached.resolvedAt) < renditionCacheTTL {
+		return cached.profile, nil
+	}
+
+	profile, err := s.queryRendition(videoID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cacheMu.Lock()
+	s.profileCache[key] = cachedProfile{resolvedAt: time.Now(), profile: profile}
+	s.cacheMu.Unlock()
+	return profile, nil
+}
+
+// queryRendition looks up the profile a rendition name resolves to
+func (s *PlayoutService) queryRendition(videoID uint, name string) (*models.VideoProfile, error) {
+	var profiles []models.VideoProfile
+	if err := s.db.Where("video_id = ? AND status = ?", videoID, "completed").
+		Order("bitrate DESC").
+		Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("video %d has no completed profiles", videoID)
+	}
+
+	// An empty name selects the reference (highest bitrate) rendition
+	if name == "" {
+		return &profiles[0], nil
+	}
+
+	resolution, codec := parseRenditionName(name)
+	candidates := make([]models.VideoProfile, 0, len(profiles))
+	for i := range profiles {
+		if videoCodec(&profiles[i]) == codec {
+			candidates = append(candidates, profiles[i])
+		}
+	}
+	if len(candidates) > 0 {
+		profiles = candidates
+	}
+
+	target := resolutionHeight(resolution)
+	best := 0
+	for i, profile := range profiles {
+		if renditionName(&profiles[i]) == name {
+			return &profiles[i], nil
+		}
+		diff := math.Abs(float64(resolutionHeight(profile.Resolution) - target))
+		bestDiff := math.Abs(float64(resolutionHeight(profiles[best].Resolution) - target))
+		if diff < bestDiff {
+			best = i
+		}
+	}
+	return &profiles[best], nil
+}
+
+// renditionSegments returns the parsed segments of a video's rendition, empty if unavailable
+func (s *PlayoutService) renditionSegments(videoID uint, resolution string) []utils.HLSSegment {
+	profile, err := s.resolveRendition(videoID, resolution)
+	if err != nil {
+		return nil
+	}
+	return s.profileSegments(profile)
+}
+
+// profileSegments returns the parsed segments of a profile's playlist, empty if unavailable.
+// Playlists are parsed again only once they change.
+func (s *PlayoutService) profileSegments(profile *models.VideoProfile) []utils.HLSSegment {
+	if profile.PlaylistPath == "" {
+		return nil
+	}
+
+	fullPlaylistPath := filepath.Join(s.config.Storage.TranscodedPath, profile.PlaylistPath)
+	info, err := os.Stat(fullPlaylistPath)
+	if err != nil {
+		return nil
+	}
+
+	s.cacheMu.RLock()
+	cached, ok := s.segmentCache[fullPlaylistPath]
+	s.cacheMu.RUnlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.segments
+	}
+
+	content, err := ioutil.ReadFile(fullPlaylistPath)
+	if err != nil {
+		return nil
+	}
+	segments := utils.ParseMediaPlaylist(string(content))
+
+	s.cacheMu.Lock()
+	s.segmentCache[fullPlaylistPath] = cachedSegments{modTime: info.ModTime(), segments: segments}
+	s.cacheMu.Unlock()
+
+	return segments
+}
+
+// resolutionHeight parses the pixel height out of a resolution label such as "720p"
+func resolutionHeight(resolution string) int {
+	height, err := strconv.Atoi(strings.TrimSuffix(resolution, "p"))
+	if err != nil {
+		return 0
+	}
+	return height
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"database/sql/driver"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenditionResolverResolvesEachVideoOnce(t *testing.T) {
+	transcoded := t.TempDir()
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+		"#EXTINF:6.000000,\nsegment_000.ts\n#EXTINF:6.000000,\nsegment_001.ts\n#EXTINF:4.000000,\nsegment_002.ts\n#EXT-X-ENDLIST\n"
+	if err := os.MkdirAll(filepath.Join(transcoded, "video_1", "720p"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(transcoded, "video_1", "720p", "playlist.m3u8"), []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, store := newTestDB(t)
+	store.answer("FROM `video_profiles`", testResult{
+		columns: []string{"id", "video_id", "resolution", "codec_video", "bitrate", "status", "playlist_path"},
+		rows: [][]driver.Value{
+			{int64(1), int64(1), "720p", "libx264", int64(3000), "completed", "video_1/720p/playlist.m3u8"},
+		},
+	})
+	cfg := &config.Config{}
+	cfg.Storage.TranscodedPath = transcoded
+	s := NewPlayoutService(db, cfg)
+
+	// Half an hour of DVR window alternating between two videos
+	renditions := s.newRenditionResolver("720p")
+	for i := 0; i < 1800; i++ {
+		segment := playoutSegment{VideoID: uint(i%2 + 1), Index: i % 4}
+		media, err := renditions.segmentURI(segment)
+		if err != nil {
+			t.Fatalf("segmentURI(%+v) error = %v", segment, err)
+		}
+		// The last index is past the end of the playlist and clamps to its last segment
+		want := []string{"segment_000.ts", "segment_001.ts", "segment_002.ts", "segment_002.ts"}[segment.Index]
+		if !strings.HasSuffix(media.URI, "/720p/"+want) {
+			t.Fatalf("segmentURI(%+v) = %q, want %s", segment, media.URI, want)
+		}
+	}
+
+	if got := len(store.statements("FROM `video_profiles`")); got != 2 {
+		t.Errorf("got %d profile queries, want one per video", got)
+	}
+}
+
+func TestNextSegmentPlansTimelineOncePerTick(t *testing.T) {
+	transcoded := t.TempDir()
+	// Half a minute in five segments, so a minute of air time crosses an item boundary
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n" + strings.Repeat("#EXTINF:6.000000,\nsegment.ts\n", 5) + "#EXT-X-ENDLIST\n"
+	if err := os.MkdirAll(filepath.Join(transcoded, "video_1", "720p"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(transcoded, "video_1", "720p", "playlist.m3u8"), []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, store := newTestDB(t)
+	store.answer("FROM `playlists`", testResult{
+		columns: []string{"id", "playback_mode"},
+		rows:    [][]driver.Value{{int64(1), PlaybackLoop}},
+	})
+	store.answer("FROM `playlist_videos`", testResult{
+		columns: []string{"playlist_id", "video_id", "sort_order", "weight"},
+		rows:    [][]driver.Value{{int64(1), int64(1), int64(0), int64(1)}},
+	})
+	store.answer("FROM `videos`", videoRows([]driver.Value{int64(1), "completed", int64(30)}))
+	store.answer("FROM `video_profiles`", testResult{
+		columns: []string{"id", "video_id", "resolution", "codec_video", "bitrate", "status", "playlist_path"},
+		rows: [][]driver.Value{
+			{int64(1), int64(1), "720p", "libx264", int64(3000), "completed", "video_1/720p/playlist.m3u8"},
+		},
+	})
+	cfg := &config.Config{}
+	cfg.Storage.TranscodedPath = transcoded
+	cfg.Transcode.SegmentTime = 6
+	s := NewPlayoutService(db, cfg)
+
+	// A tick catching up on a minute of air time
+	epoch := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	playlistID := uint(1)
+	plan := &channelPlan{channel: &models.Channel{ID: 1, PlaylistID: &playlistID, CreatedAt: epoch}}
+	state := &playoutState{NextAirTime: epoch}
+	for i := 0; i < 10; i++ {
+		segment, ok := s.nextSegment(plan, state)
+		if !ok {
+			t.Fatalf("segment %d did not air", i)
+		}
+		if want := epoch.Add(time.Duration(i) * 6 * time.Second); !segment.AirTime.Equal(want) || segment.Index != i%5 {
+			t.Fatalf("segment %d = index %d at %s, want index %d at %s", i, segment.Index, segment.AirTime, i%5, want)
+		}
+	}
+
+	if got := len(store.statements("FROM `channels`")); got != 0 {
+		t.Errorf("got %d channel queries, want the ticked channel reused", got)
+	}
+	if got := len(store.statements("FROM `playlists`")); got != 1 {
+		t.Errorf("planned the timeline %d times, want once", got)
+	}
+	if got := len(store.statements("FROM `video_profiles`")); got != 1 {
+		t.Errorf("got %d profile queries, want the rendition resolved once", got)
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"fmt"
+	"linier-channel/internal/models"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// HLS segment containers
+const (
+	SegmentFormatTS   = "mpegts"
+	SegmentFormatFMP4 = "fmp4"
+)
+
+// defaultPresetName is the name of the preset created when none exists
+const defaultPresetName = "default"
+
+// defaultPresetRungs is the ladder of the built-in preset. Rungs above the source
+// are dropped at ingest, so the 1080p and 2160p rungs only apply to sources that big.
+var defaultPresetRungs = []models.EncodingRung{
+	{Resolution: "2160p", Bitrate: 14000, MaxRate: 21000, BufSize: 28000, EncoderProfile: "high", EncoderLevel: "5.1"},
+	{Resolution: "1080p", Bitrate: 5000, MaxRate: 7500, BufSize: 10000, EncoderProfile: "high", EncoderLevel: "4.1"},
+	{Resolution: "720p", Bitrate: 2000, MaxRate: 3000, BufSize: 4000, EncoderProfile: "high", EncoderLevel: "3.1"},
+	{Resolution: "480p", Bitrate: 1000, MaxRate: 1500, BufSize: 2000, EncoderProfile: "main", EncoderLevel: "3.0"},
+	{Resolution: "360p", Bitrate: 500, MaxRate: 750, BufSize: 1000, EncoderProfile: "main", EncoderLevel: "3.0"},
+}
+
+type PresetService struct {
+	db *gorm.DB
+}
+
+func NewPresetService(db *gorm.DB) *PresetService {
+	return &PresetService{db: db}
+}
+
+// EnsureDefaultPreset creates the built-in preset when no preset exists yet
+func (s *PresetService) EnsureDefaultPreset() error {
+	var count int64
+	if err := s.db.Model(&models.EncodingPreset{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	preset := &models.EncodingPreset{
+		Name:          defaultPresetName,
+		Description:   "Built-in H.264 ladder from 2160p down to 360p",
+		IsDefault:     true,
+		SegmentFormat: SegmentFormatTS,
+	}
+	for i, rung := range defaultPresetRungs {
+		rung.CodecVideo = "h264"
+		rung.CodecAudio = "aac"
+		rung.AudioBitrate = 128
+		rung.SegmentTime = 4
+		rung.EncoderPreset = "medium"
+		rung.SortOrder = i
+		preset.Rungs = append(preset.Rungs, rung)
+	}
+
+	if err := s.db.Create(preset).Error; err != nil {
+		return err
+	}
+	logrus.Infof("Created default encoding preset %d", preset.ID)
+	return nil
+}
+
+// CreatePreset creates an encoding preset
+func (s *PresetService) CreatePreset(req *models.EncodingPresetRequest) (*models.EncodingPreset, error) {
+	rungs, err := buildRungs(req.Rungs, segmentFormat(req.SegmentFormat))
+	if err != nil {
+		return nil, err
+	}
+	if req.KeyRotation > 0 && !req.Encrypt {
+		return nil, fmt.Errorf("%w: key_rotation needs encrypt", ErrInvalidInput)
+	}
+
+	preset := &models.EncodingPreset{
+		Name:           req.Name,
+		Description:    req.Description,
+		IsDefault:      req.IsDefault,
+		SinglePass:     req.SinglePass,
+		SegmentFormat:  segmentFormat(req.SegmentFormat),
+		Encrypt:        req.Encrypt,
+		KeyRotation:    req.KeyRotation,
+		LoudnessTarget: req.LoudnessTarget,
+		Rungs:          rungs,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if preset.IsDefault {
+			if err := tx.Model(&models.EncodingPreset{}).Where("is_default = ?", true).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(preset).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+// GetPresets retrieves all encoding presets with their rungs
+func (s *PresetService) GetPresets() ([]models.EncodingPreset, error) {
+	var presets []models.EncodingPreset
+	if err := s.db.Preload("Rungs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC")
+	}).Order("id ASC").Find(&presets).Error; err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// GetPreset retrieves an encoding preset by ID
+func (s *PresetService) GetPreset(id uint) (*models.EncodingPreset, error) {
+	var preset models.EncodingPreset
+	if err := s.db.Preload("Rungs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC")
+	}).First(&preset, id).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+// UpdatePreset replaces the settings and rungs of an encoding preset. Videos already
+// ingested keep the profiles they were created with.
+func (s *PresetService) UpdatePreset(id uint, req *models.EncodingPresetRequest) (*models.EncodingPreset, error) {
+	var preset models.EncodingPreset
+	if err := s.db.First(&preset, id).Error; err != nil {
+		return nil, err
+	}
+
+	rungs, err := buildRungs(req.Rungs, segmentFormat(req.SegmentFormat))
+	if err != nil {
+		return nil, err
+	}
+	if req.KeyRotation > 0 && !req.Encrypt {
+		return nil, fmt.Errorf("%w: key_rotation needs encrypt", ErrInvalidInput)
+	}
+	if preset.IsDefault && !req.IsDefault {
+		return nil, fmt.Errorf("%w: make another preset the default instead", ErrInvalidInput)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if req.IsDefault && !preset.IsDefault {
+			if err := tx.Model(&models.EncodingPreset{}).Where("is_default = ?", true).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&preset).Updates(map[string]interface{}{
+			"name":            req.Name,
+			"description":     req.Description,
+			"is_default":      req.IsDefault,
+			"single_pass":     req.SinglePass,
+			"segment_format":  segmentFormat(req.SegmentFormat),
+			"encrypt":         req.Encrypt,
+			"key_rotation":    req.KeyRotation,
+			"loudness_target": req.LoudnessTarget,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("preset_id = ?", id).Delete(&models.EncodingRung{}).Error; err != nil {
+			return err
+		}
+		for i := range rungs {
+			rungs[i].PresetID = id
+		}
+		return tx.Create(&rungs).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetPreset(id)
+}
+
+// DeletePreset deletes an encoding preset; the default preset cannot be deleted
+func (s *PresetService) DeletePreset(id uint) error {
+	var preset models.EncodingPreset
+	if err := s.db.First(&preset, id).Error; err != nil {
+		return err
+	}
+	if preset.IsDefault {
+		return fmt.Errorf("%w: the default preset cannot be deleted", ErrInvalidInput)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Video{}).Where("preset_id = ?", id).Update("preset_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&preset).Error
+	})
+}
+
+// Ladder returns the profiles of a preset, or of the default preset when presetID
+// is nil, ready to be fitted to a source. The preset used is returned too.
+func (s *PresetService) Ladder(presetID *uint) (*models.EncodingPreset, []models.VideoProfile, error) {
+	var preset models.EncodingPreset
+	query := s.db.Preload("Rungs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC")
+	})
+	if presetID != nil {
+		if err := query.First(&preset, *presetID).Error; err != nil {
+			return nil, nil, fmt.Errorf("%w: encoding preset %d not found", ErrInvalidInput, *presetID)
+		}
+	} else if err := query.Where("is_default = ?", true).First(&preset).Error; err != nil {
+		return nil, nil, fmt.Errorf("no default encoding preset: %w", err)
+	}
+
+	ladder := make([]models.VideoProfile, 0, len(preset.Rungs))
+	for _, rung := range preset.Rungs {
+		ladder = append(ladder, models.VideoProfile{
+			Resolution:     rung.Resolution,
+			CodecVideo:     rung.CodecVideo,
+			CodecAudio:     rung.CodecAudio,
+			Bitrate:        rung.Bitrate,
+			MaxRate:        rung.MaxRate,
+			BufSize:        rung.BufSize,
+			AudioBitrate:   rung.AudioBitrate,
+			FrameRate:      rung.FrameRate,
+			SegmentTime:    rung.SegmentTime,
+			EncoderPreset:  rung.EncoderPreset,
+			EncoderProfile: rung.EncoderProfile,
+			EncoderLevel:   rung.EncoderLevel,
+			SegmentFormat:  preset.SegmentFormat,
+		})
+	}
+	return &preset, ladder, nil
+}
+
+// segmentFormat returns the requested segment container, MPEG-TS by default
+func segmentFormat(format string) string {
+	if format == "" {
+		return SegmentFormatTS
+	}
+	return format
+}
+
+// buildRungs validates the rungs of a preset request, whose segments use format, and
+// fills in defaults
+func buildRungs(requests []models.EncodingRungRequest, format string) ([]models.EncodingRung, error) {
+	rungs := make([]models.EncodingRung, 0, len(requests))
+	seen := make(map[string]bool)
+	segmentTime := 0
+
+	for i, req := range requests {
+		rung := models.EncodingRung{
+			Resolution:     req.Resolution,
+			CodecVideo:     req.CodecVideo,
+			CodecAudio:     req.CodecAudio,
+			Bitrate:        req.Bitrate,
+			MaxRate:        req.MaxRate,
+			BufSize:        req.BufSize,
+			AudioBitrate:   req.AudioBitrate,
+			FrameRate:      req.FrameRate,
+			SegmentTime:    req.SegmentTime,
+			EncoderPreset:  req.EncoderPreset,
+			EncoderProfile: req.EncoderProfile,
+			EncoderLevel:   req.EncoderLevel,
+			SortOrder:      i,
+		}
+		if rung.CodecVideo == "" {
+			rung.CodecVideo = CodecH264
+		}
+		if rung.CodecAudio == "" {
+			rung.CodecAudio = "aac"
+		}
+		if rung.AudioBitrate == 0 {
+			rung.AudioBitrate = 128
+		}
+		if rung.SegmentTime == 0 {
+			rung.SegmentTime = 4
+		}
+		if rung.MaxRate > 0 && rung.BufSize == 0 {
+			rung.BufSize = rung.MaxRate * 2
+		}
+
+		codec, ok := normalizeVideoCodec(rung.CodecVideo)
+		if !ok {
+			return nil, fmt.Errorf("%w: codec_video %q must be h264, hevc, av1 or vp9", ErrInvalidInput, rung.CodecVideo)
+		}
+		rung.CodecVideo = codec
+		if codec != CodecH264 && format != SegmentFormatFMP4 {
+			return nil, fmt.Errorf("%w: %s rungs need the fmp4 segment_format", ErrInvalidInput, codec)
+		}
+		if err := checkEncoderProfile(codec, rung.EncoderProfile, rung.EncoderLevel); err != nil {
+			return nil, err
+		}
+
+		if resolutionHeight(rung.Resolution) == 0 {
+			return nil, fmt.Errorf("%w: resolution %q must look like 720p", ErrInvalidInput, rung.Resolution)
+		}
+		key := rung.Resolution + "/" + rung.CodecVideo
+		if seen[key] {
+			return nil, fmt.Errorf("%w: duplicate %s %s rung", ErrInvalidInput, rung.CodecVideo, rung.Resolution)
+		}
+		seen[key] = true
+		if rung.MaxRate > 0 && rung.MaxRate < rung.Bitrate {
+			return nil, fmt.Errorf("%w: max_rate of the %s rung is below its bitrate", ErrInvalidInput, rung.Resolution)
+		}
+
+		// Renditions must switch on the same boundaries
+		if segmentTime == 0 {
+			segmentTime = rung.SegmentTime
+		} else if rung.SegmentTime != segmentTime {
+			return nil, fmt.Errorf("%w: all rungs must use the same segment_time", ErrInvalidInput)
+		}
+
+		rungs = append(rungs, rung)
+	}
+
+	return rungs, nil
+}
+
+// checkEncoderProfile validates the encoder profile and level of a rung against its codec
+func checkEncoderProfile(codec, profile, level string) error {
+	switch codec {
+	case CodecH264:
+		if profile == "main10" {
+			return fmt.Errorf("%w: main10 is an hevc profile", ErrInvalidInput)
+		}
+	case CodecHEVC:
+		if profile != "" && profile != "main" && profile != "main10" {
+			return fmt.Errorf("%w: hevc rungs use the main or main10 profile", ErrInvalidInput)
+		}
+	default:
+		if profile != "" || level != "" {
+			return fmt.Errorf("%w: encoder_profile and encoder_level apply to h264 and hevc rungs only", ErrInvalidInput)
+		}
+	}
+	return nil
+}
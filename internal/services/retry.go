@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"linier-channel/internal/models"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// transcodeRetrySet is the Redis sorted set of queue entries waiting to be retried,
+// scored by the Unix time they are due
+const transcodeRetrySet = "transcode_retry"
+
+// retryPollInterval is how often due retries are queued
+const retryPollInterval = time.Second
+
+// Failure classes of transcode jobs; only transient failures are retried
+const (
+	failureTransient        = "transient"
+	failureMissingSource    = "missing_source"
+	failureUnsupportedCodec = "unsupported_codec"
+)
+
+// unsupportedCodecLogs are FFmpeg log messages of sources it cannot decode or
+// renditions it cannot encode
+var unsupportedCodecLogs = []string{
+	"decoder (codec",
+	"unknown decoder",
+	"unknown encoder",
+	"encoder not found",
+	"unsupported codec",
+	"could not find codec parameters",
+	"invalid data found when processing input",
+}
+
+// ffmpegError is the failure of an FFmpeg process, with the end of its log
+type ffmpegError struct {
+	err error
+	log string
+}
+
+// Error reports the last line FFmpeg logged before its generic conclusion
+func (e *ffmpegError) Error() string {
+	lines := strings.Split(e.log, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line != "" && line != "Conversion failed!" {
+			return fmt.Sprintf("ffmpeg failed: %v: %s", e.err, line)
+		}
+	}
+	return fmt.Sprintf("ffmpeg failed: %v", e.err)
+}
+
+func (e *ffmpegError) Unwrap() error {
+	return e.err
+}
+
+// classifyFailure returns the failure class of a transcode job's error
+func classifyFailure(err error) string {
+	// The source file, or the video or profile of the job, is gone
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return failureMissingSource
+	}
+
+	var ffmpegErr *ffmpegError
+	if errors.As(err, &ffmpegErr) {
+		log := strings.ToLower(ffmpegErr.log)
+		if strings.Contains(log, "no such file or directory") {
+			return failureMissingSource
+		}
+		for _, message := range unsupportedCodecLogs {
+			if strings.Contains(log, message) {
+				return failureUnsupportedCodec
+			}
+		}
+	}
+	return failureTransient
+}
+
+// RetryFailedJob schedules a failed job to run again after a backoff when its failure
+// is transient and it has retries left, and moves it to the dead-letter list otherwise
+func (s *TranscodeService) RetryFailedJob(videoID, profileID uint, jobErr error) {
+	var job models.TranscodeJob
+	if err := s.db.Where("video_id = ? AND profile_id = ?", videoID, profileID).
+		Order("id DESC").First(&job).Error; err != nil {
+		logrus.Errorf("Failed to find the job of video %d profile %d: %v", videoID, profileID, err)
+		return
+	}
+	if job.Status == "cancelled" {
+		return
+	}
+
+	class := classifyFailure(jobErr)
+	updates := map[string]interface{}{
+		"failure_class": class,
+		"error_message": jobErr.Error(),
+	}
+
+	if class == failureTransient && job.RetryCount < job.MaxRetries && s.redis != nil {
+		next := time.Now().Add(s.retryDelay(job.RetryCount))
+		updates["status"] = "retrying"
+		updates["retry_count"] = job.RetryCount + 1
+		updates["next_retry_at"] = &next
+		if err := s.updateFailedJob(&job, updates); err != nil {
+			logrus.Errorf("Failed to schedule the retry of job %d: %v", job.ID, err)
+			return
+		}
+
+		entry := fmt.Sprintf("%d:%d", videoID, profileID)
+		if err := s.redis.ZAdd(context.Background(), transcodeRetrySet, &redis.Z{
+			Score:  float64(next.Unix()),
+			Member: entry,
+		}).Err(); err != nil {
+			logrus.Errorf("Failed to schedule the retry of job %d: %v", job.ID, err)
+			return
+		}
+		logrus.Warnf("Job %d failed (%s), retry %d of %d at %s: %v",
+			job.ID, class, job.RetryCount+1, job.MaxRetries, next.Format(time.RFC3339), jobErr)
+		return
+	}
+
+	updates["status"] = "dead"
+	updates["next_retry_at"] = nil
+	if err := s.updateFailedJob(&job, updates); err != nil {
+		logrus.Errorf("Failed to move job %d to the dead-letter list: %v", job.ID, err)
+		return
+	}
+	logrus.Errorf("Job %d moved to the dead-letter list (%s) after %d retries: %v", job.ID, class, job.RetryCount, jobErr)
+}
+
+// updateFailedJob records the outcome of a failed job, unless it was cancelled
+// meanwhile; a retried job's profiles are pending again
+func (s *TranscodeService) updateFailedJob(job *models.TranscodeJob, updates map[string]interface{}) error {
+	result := s.db.Model(&models.TranscodeJob{}).
+		Where("id = ? AND status <> ?", job.ID, "cancelled").
+		Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 || updates["status"] != "retrying" {
+		return result.Error
+	}
+	return s.resetFailedProfiles(job)
+}
+
+// resetFailedProfiles returns the failed profiles a job encodes to pending: its own, or
+// every failed profile of a single-pass video
+func (s *TranscodeService) resetFailedProfiles(job *models.TranscodeJob) error {
+	var video models.Video
+	if err := s.db.First(&video, job.VideoID).Error; err != nil {
+		return err
+	}
+
+	query := s.db.Model(&models.VideoProfile{}).Where("video_id = ? AND status = ?", job.VideoID, "failed")
+	if !video.SinglePass {
+		query = query.Where("id = ?", job.ProfileID)
+	}
+	return query.Updates(map[string]interface{}{
+		"status":              "pending",
+		"progress_percentage": 0,
+	}).Error
+}
+
+// retryDelay returns the backoff before a job's next retry: the base delay doubled for
+// every earlier retry, up to the maximum, with its upper half jittered so jobs that
+// failed together are not retried together
+func (s *TranscodeService) retryDelay(retryCount int) time.Duration {
+	base := time.Duration(s.config.Transcode.RetryBaseDelay) * time.Second
+	maximum := time.Duration(s.config.Transcode.RetryMaxDelay) * time.Second
+
+	delay := maximum
+	if retryCount < 32 && base<<retryCount < maximum {
+		delay = base << retryCount
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// ScheduleRetries queues retries once they are due, until stop is closed
+func (s *TranscodeService) ScheduleRetries(stop <-chan bool) {
+	if s.redis == nil {
+		return
+	}
+
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.queueDueRetries()
+		}
+	}
+}
+
+// queueDueRetries moves the retries that are due to the transcode queue
+func (s *TranscodeService) queueDueRetries() {
+	ctx := context.Background()
+	due, err := s.redis.ZRangeByScore(ctx, transcodeRetrySet, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		logrus.Errorf("Failed to read due transcode retries: %v", err)
+		return
+	}
+
+	for _, entry := range due {
+		// Whoever removes an entry queues it, so each retry is queued once
+		removed, err := s.redis.ZRem(ctx, transcodeRetrySet, entry).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+
+		var videoID, profileID uint
+		if _, err := fmt.Sscanf(entry, "%d:%d", &videoID, &profileID); err != nil {
+			logrus.Warnf("Invalid transcode retry %q", entry)
+			continue
+		}
+		s.db.Model(&models.TranscodeJob{}).
+			Where("video_id = ? AND profile_id = ? AND status = ?", videoID, profileID, "retrying").
+			Updates(map[string]interface{}{
+				"status":        "queued",
+				"next_retry_at": nil,
+			})
+		if err := s.redis.LPush(ctx, "transcode_queue", entry).Err(); err != nil {
+			logrus.Errorf("Failed to queue the retry of video %d profile %d: %v", videoID, profileID, err)
+		}
+	}
+}
+
+// GetDeadLetterJobs returns the jobs that failed permanently or ran out of retries
+func (s *TranscodeService) GetDeadLetterJobs() ([]models.TranscodeJob, error) {
+	var jobs []models.TranscodeJob
+	if err := s.db.Preload("Video").Preload("Profile").
+		Where("status = ?", "dead").
+		Order("completed_at DESC").
+		Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetDeadLetterJob returns a dead-lettered job
+func (s *TranscodeService) GetDeadLetterJob(id uint) (*models.TranscodeJob, error) {
+	var job models.TranscodeJob
+	if err := s.db.Preload("Video").Preload("Profile").
+		Where("status = ?", "dead").
+		First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ReplayDeadLetterJob queues a dead-lettered job again with its retries reset
+func (s *TranscodeService) ReplayDeadLetterJob(id uint) (*models.TranscodeJob, error) {
+	job, err := s.GetDeadLetterJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(&models.TranscodeJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":        "queued",
+		"retry_count":   0,
+		"error_message": "",
+		"failure_class": "",
+		"next_retry_at": nil,
+		"started_at":    nil,
+		"completed_at":  nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := s.resetFailedProfiles(job); err != nil {
+		return nil, err
+	}
+
+	if s.redis != nil {
+		entry := fmt.Sprintf("%d:%d", job.VideoID, job.ProfileID)
+		if err := s.redis.LPush(context.Background(), "transcode_queue", entry).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("Replayed dead-lettered job %d", job.ID)
+	if err := s.db.Preload("Video").Preload("Profile").First(job, job.ID).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
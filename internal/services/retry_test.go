@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"linier-channel/internal/config"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	_, statErr := os.Stat("/nonexistent/source.mp4")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "source file gone", err: statErr, want: failureMissingSource},
+		{name: "wrapped source file gone", err: fmt.Errorf("failed to probe: %w", statErr), want: failureMissingSource},
+		{name: "video or profile gone", err: gorm.ErrRecordNotFound, want: failureMissingSource},
+		{name: "FFmpeg cannot open the source", err: &ffmpegError{err: exitErr, log: "source.mp4: No such file or directory\n"}, want: failureMissingSource},
+		{name: "unknown decoder", err: &ffmpegError{err: exitErr, log: "Stream #0:0 -> #0:0\nUnknown decoder 'xyz'\nConversion failed!\n"}, want: failureUnsupportedCodec},
+		{name: "unknown encoder", err: &ffmpegError{err: exitErr, log: "Unknown encoder 'libsvtav1'\n"}, want: failureUnsupportedCodec},
+		{name: "corrupt input", err: &ffmpegError{err: exitErr, log: "source.mp4: Invalid data found when processing input\n"}, want: failureUnsupportedCodec},
+		{name: "wrapped FFmpeg failure", err: fmt.Errorf("encode: %w", &ffmpegError{err: exitErr, log: "Encoder not found\n"}), want: failureUnsupportedCodec},
+		{name: "FFmpeg killed", err: &ffmpegError{err: exitErr, log: "frame= 100 fps=25\n"}, want: failureTransient},
+		{name: "FFmpeg failed without a log", err: &ffmpegError{err: exitErr}, want: failureTransient},
+		{name: "FFmpeg missing is not the source missing", err: &exec.Error{Name: "ffmpeg", Err: exec.ErrNotFound}, want: failureTransient},
+		{name: "timed out", err: fmt.Errorf("%w: no progress", ErrTimedOut), want: failureTransient},
+		{name: "database error", err: errors.New("Error 1205: Lock wait timeout exceeded"), want: failureTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(tt.err); got != tt.want {
+				t.Errorf("classifyFailure(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       int
+		maximum    int
+		retryCount int
+		want       time.Duration // the delay before jitter; the jitter keeps its upper half
+	}{
+		{name: "first retry", base: 30, maximum: 1800, retryCount: 0, want: 30 * time.Second},
+		{name: "doubles", base: 30, maximum: 1800, retryCount: 1, want: 60 * time.Second},
+		{name: "doubles again", base: 30, maximum: 1800, retryCount: 3, want: 240 * time.Second},
+		{name: "capped", base: 30, maximum: 1800, retryCount: 6, want: 1800 * time.Second},
+		{name: "shift overflow is capped", base: 30, maximum: 1800, retryCount: 40, want: 1800 * time.Second},
+		{name: "base above maximum", base: 3600, maximum: 1800, retryCount: 0, want: 1800 * time.Second},
+		{name: "no delay", base: 0, maximum: 0, retryCount: 2, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Transcode.RetryBaseDelay = tt.base
+			cfg.Transcode.RetryMaxDelay = tt.maximum
+			s := &TranscodeService{config: cfg}
+
+			for i := 0; i < 50; i++ {
+				got := s.retryDelay(tt.retryCount)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("retryDelay(%d) = %s, want between %s and %s", tt.retryCount, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"linier-channel/internal/models"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Playlist playback modes
+const (
+	PlaybackSequential = "sequential" // sort order; a single pass when scheduled
+	PlaybackLoop       = "loop"       // sort order; repeats until the next schedule entry when scheduled
+	PlaybackShuffle    = "shuffle"    // seeded shuffle, different on every pass
+	PlaybackWeighted   = "weighted"   // every video airs its weight times per pass, in seeded order
+)
+
+// rotationUnit is a playlist video with its mid-roll parts and ad breaks, which
+// stay together when a pass is reordered
+type rotationUnit struct {
+	VideoID  uint
+	Items    []timelineItem
+	Splice   *breakInfo // splice-only break signalled as the next unit goes on air
+	Duration time.Duration
+}
+
+// rotation lays a playlist on the timeline pass after pass. Every pass airs the
+// same units, so passes have a fixed length and the pass on air at any time is
+// known without replaying the ones before it. Orders are derived from the seed
+// and the pass number only, so every pod computes the same timeline.
+type rotation struct {
+	units         []rotationUnit // one pass in sort order, weighted units repeated
+	mode          string
+	seed          int64
+	noRepeatItems int
+	noRepeat      time.Duration
+	total         time.Duration
+	orders        map[int64][]rotationUnit
+}
+
+// newRotation builds the rotation of playlist units following the playlist's playback mode
+func newRotation(playlist *models.Playlist, units []rotationUnit, weights map[uint]int) *rotation {
+	seed := playlist.ShuffleSeed
+	if seed == 0 {
+		seed = int64(playlist.ID)
+	}
+
+	r := &rotation{
+		mode:          playlist.PlaybackMode,
+		seed:          seed,
+		noRepeatItems: playlist.NoRepeatItems,
+		noRepeat:      time.Duration(playlist.NoRepeatMinutes) * time.Minute,
+	}
+	for _, unit := range units {
+		count := 1
+		if r.mode == PlaybackWeighted && weights[unit.VideoID] > 1 {
+			count = weights[unit.VideoID]
+		}
+		for i := 0; i < count; i++ {
+			r.units = append(r.units, unit)
+		}
+	}
+	return r.init()
+}
+
+// singleRotation builds a rotation repeating a single item
+func singleRotation(item timelineItem) *rotation {
+	r := &rotation{mode: PlaybackSequential}
+	r.units = []rotationUnit{{VideoID: item.VideoID, Items: []timelineItem{item}}}
+	return r.init()
+}
+
+func (r *rotation) init() *rotation {
+	r.total = 0
+	for i := range r.units {
+		r.units[i].Duration = 0
+		for _, item := range r.units[i].Items {
+			r.units[i].Duration += item.Duration
+		}
+		r.total += r.units[i].Duration
+	}
+	r.orders = make(map[int64][]rotationUnit)
+	return r
+}
+
+// empty reports whether the rotation has nothing to air
+func (r *rotation) empty() bool {
+	return r == nil || r.total <= 0
+}
+
+// mapItems returns a copy of the rotation with every item passed through fn;
+// items fn rejects are dropped, and units left without items with them
+func (r *rotation) mapItems(fn func(item timelineItem) (timelineItem, bool)) *rotation {
+	mapped := &rotation{
+		mode:          r.mode,
+		seed:          r.seed,
+		noRepeatItems: r.noRepeatItems,
+		noRepeat:      r.noRepeat,
+	}
+	for _, unit := range r.units {
+		var items []timelineItem
+		for _, item := range unit.Items {
+			if item, ok := fn(item); ok {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		unit.Items = items
+		mapped.units = append(mapped.units, unit)
+	}
+	return mapped.init()
+}
+
+// once returns the items of a single pass, as aired by a schedule entry. The salt
+// varies shuffled orders between airings.
+func (r *rotation) once(salt int64) []timelineItem {
+	items, _ := flattenUnits(r.orderWith(salt, nil), nil)
+	return items
+}
+
+// pass returns the items of a pass of the loop. The splice-only break closing the
+// previous pass is signalled as this one starts.
+func (r *rotation) pass(n int64) []timelineItem {
+	var pending *breakInfo
+	if previous := r.order(n - 1); len(previous) > 0 {
+		pending = previous[len(previous)-1].Splice
+	}
+	items, _ := flattenUnits(r.order(n), pending)
+	return items
+}
+
+// position returns the pass, index and start time of the item on air at t in a
+// loop repeating forever from epoch
+func (r *rotation) position(epoch time.Time, t time.Time) (int64, int, time.Time, bool) {
+	if r.empty() {
+		return 0, 0, time.Time{}, false
+	}
+
+	elapsed := t.Sub(epoch)
+	n := int64(elapsed / r.total)
+	if elapsed < 0 && elapsed%r.total != 0 {
+		n--
+	}
+	start := epoch.Add(time.Duration(n) * r.total)
+
+	for i, item := range r.pass(n) {
+		if t.Before(start.Add(item.Duration)) {
+			return n, i, start, true
+		}
+		start = start.Add(item.Duration)
+	}
+	return 0, 0, time.Time{}, false
+}
+
+// order returns the units of a pass of the loop in airing order
+func (r *rotation) order(n int64) []rotationUnit {
+	if order, ok := r.orders[n]; ok {
+		return order
+	}
+
+	// The tail of the previous pass is arranged without looking further back, so
+	// honouring it here needs no history beyond one pass
+	var previous []rotationUnit
+	if r.spreads() {
+		previous = r.orderWith(n-1, nil)
+	}
+	order := r.orderWith(n, previous)
+	r.orders[n] = order
+	return order
+}
+
+// orderWith orders the units for a pass number, keeping repeats apart from the
+// end of the previous pass when it is given
+func (r *rotation) orderWith(n int64, previous []rotationUnit) []rotationUnit {
+	if r.mode != PlaybackShuffle && r.mode != PlaybackWeighted {
+		return r.units
+	}
+
+	units := make([]rotationUnit, len(r.units))
+	copy(units, r.units)
+	rng := rand.New(rand.NewSource(r.seed*1000003 + n))
+	rng.Shuffle(len(units), func(i, j int) {
+		units[i], units[j] = units[j], units[i]
+	})
+
+	if !r.spreads() {
+		return units
+	}
+	return r.spread(units, previous, rng)
+}
+
+// spreads reports whether repeats have to be kept apart
+func (r *rotation) spreads() bool {
+	return (r.mode == PlaybackShuffle || r.mode == PlaybackWeighted) && (r.noRepeatItems > 0 || r.noRepeat > 0)
+}
+
+// spreadAttempts is how many arrangements of a pass are tried before settling for
+// the one with the fewest repeats too close together
+const spreadAttempts = 8
+
+// spread arranges shuffled units so copies of a video stay apart, preferring the
+// videos with the most copies left so they do not bunch up. The tail of the pass
+// is arranged first and on its own, so the next pass can keep clear of it by
+// arranging this pass without history; the rest is then filled from the start,
+// keeping clear of the previous pass and of the tail. The constraint is best
+// effort: when no arrangement fits, the one with the fewest conflicts airs.
+func (r *rotation) spread(units []rotationUnit, previous []rotationUnit, rng *rand.Rand) []rotationUnit {
+	remaining := append([]rotationUnit{}, units...)
+	copies := make(map[uint]int)
+	for _, unit := range units {
+		copies[unit.VideoID]++
+	}
+
+	// Tail, from the last slot backwards
+	tail := make([]rotationUnit, r.tailLength(units))
+	for slot := len(tail) - 1; slot >= 0; slot-- {
+		pick, _ := r.pick(remaining, copies, func(candidate rotationUnit) spacing {
+			return r.distance(candidate, nil, tail[slot+1:], 0, 0)
+		})
+		tail[slot] = remaining[pick]
+		copies[tail[slot].VideoID]--
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+
+	// Head and middle, from the first slot forwards
+	var best []rotationUnit
+	bestConflicts := -1
+	for attempt := 0; attempt < spreadAttempts && bestConflicts != 0; attempt++ {
+		if attempt > 0 {
+			rng.Shuffle(len(remaining), func(i, j int) {
+				remaining[i], remaining[j] = remaining[j], remaining[i]
+			})
+		}
+
+		arranged, conflicts := r.fill(remaining, previous, tail)
+		if bestConflicts < 0 || conflicts < bestConflicts {
+			best, bestConflicts = arranged, conflicts
+		}
+	}
+
+	return append(best, tail...)
+}
+
+// fill arranges units from the first slot forwards ahead of the tail, returning
+// the arrangement and how many units had to air too close to a copy
+func (r *rotation) fill(units []rotationUnit, previous []rotationUnit, tail []rotationUnit) ([]rotationUnit, int) {
+	remaining := append([]rotationUnit{}, units...)
+	copies := make(map[uint]int)
+	var remainingDuration time.Duration
+	for _, unit := range remaining {
+		copies[unit.VideoID]++
+		remainingDuration += unit.Duration
+	}
+
+	before := append([]rotationUnit{}, previous...)
+	arranged := make([]rotationUnit, 0, len(remaining))
+	conflicts := 0
+	for len(remaining) > 0 {
+		gapItems := len(remaining) - 1
+		pick, fits := r.pick(remaining, copies, func(candidate rotationUnit) spacing {
+			return r.distance(candidate, before, tail, gapItems, remainingDuration-candidate.Duration)
+		})
+		if !fits {
+			conflicts++
+		}
+
+		unit := remaining[pick]
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+		copies[unit.VideoID]--
+		remainingDuration -= unit.Duration
+		before = append(before, unit)
+		arranged = append(arranged, unit)
+	}
+	return arranged, conflicts
+}
+
+// spacing is the distance between a unit and the nearest copy of its video
+type spacing struct {
+	items   int           // units airing in between
+	elapsed time.Duration // time between their starts
+}
+
+// fits reports whether copies are far enough apart
+func (r *rotation) fits(gap spacing) bool {
+	return gap.items >= r.noRepeatItems && gap.elapsed >= r.noRepeat
+}
+
+// pick returns the index of the fitting unit whose video has the most copies
+// left; when none fits, the unit farthest from a copy is returned instead
+func (r *rotation) pick(units []rotationUnit, copies map[uint]int, measure func(candidate rotationUnit) spacing) (int, bool) {
+	pick, farthest := -1, -1
+	var farthestGap spacing
+	for i, candidate := range units {
+		gap := measure(candidate)
+		if r.fits(gap) {
+			if pick < 0 || copies[candidate.VideoID] > copies[units[pick].VideoID] {
+				pick = i
+			}
+			continue
+		}
+		if farthest < 0 || gap.items > farthestGap.items ||
+			(gap.items == farthestGap.items && gap.elapsed > farthestGap.elapsed) {
+			farthest, farthestGap = i, gap
+		}
+	}
+	if pick < 0 {
+		return farthest, false
+	}
+	return pick, true
+}
+
+// tailLength returns how many units at the end of a pass the next pass has to
+// keep clear of, at most half the pass
+func (r *rotation) tailLength(units []rotationUnit) int {
+	length := r.noRepeatItems
+	var duration time.Duration
+	for i := len(units) - 1; i >= 0 && duration < r.noRepeat; i-- {
+		duration += units[i].Duration
+		if len(units)-i > length {
+			length = len(units) - i
+		}
+	}
+	if length > len(units)/2 {
+		length = len(units) / 2
+	}
+	return length
+}
+
+// distance measures how far a unit airs from the nearest copy of its video among
+// the units airing right before it and the units airing after a gap of gapItems
+// units lasting gap
+func (r *rotation) distance(unit rotationUnit, before []rotationUnit, after []rotationUnit, gapItems int, gap time.Duration) spacing {
+	nearest := spacing{items: math.MaxInt, elapsed: math.MaxInt64}
+	closer := func(candidate spacing) {
+		if candidate.items < nearest.items {
+			nearest.items = candidate.items
+		}
+		if candidate.elapsed < nearest.elapsed {
+			nearest.elapsed = candidate.elapsed
+		}
+	}
+
+	var elapsed time.Duration
+	for i := len(before) - 1; i >= 0; i-- {
+		elapsed += before[i].Duration
+		if before[i].VideoID == unit.VideoID {
+			closer(spacing{items: len(before) - 1 - i, elapsed: elapsed})
+			break
+		}
+	}
+
+	elapsed = unit.Duration + gap
+	for i, next := range after {
+		if next.VideoID == unit.VideoID {
+			closer(spacing{items: gapItems + i, elapsed: elapsed})
+			break
+		}
+		elapsed += next.Duration
+	}
+	return nearest
+}
+
+// flattenUnits lays units end to end, signalling each splice-only break as the
+// following unit goes on air. It returns the splice left after the last unit.
+func flattenUnits(units []rotationUnit, pending *breakInfo) ([]timelineItem, *breakInfo) {
+	var items []timelineItem
+	for _, unit := range units {
+		for i, item := range unit.Items {
+			if i == 0 && pending != nil {
+				item.Splice = mergeSplice(pending, item.Splice)
+				pending = nil
+			}
+			items = append(items, item)
+		}
+		pending = mergeSplice(pending, unit.Splice)
+	}
+	return items, pending
+}
+
+// mergeSplice combines two splice-only breaks signalled at the same point
+func mergeSplice(first, second *breakInfo) *breakInfo {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+	return &breakInfo{ID: first.ID, Duration: first.Duration + second.Duration}
+}
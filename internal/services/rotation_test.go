@@ -0,0 +1,195 @@
+package services
+
+import (
+	"linier-channel/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testUnits returns one-item units of the given videos, each lasting a minute
+func testUnits(videoIDs ...uint) []rotationUnit {
+	units := make([]rotationUnit, len(videoIDs))
+	for i, id := range videoIDs {
+		units[i] = rotationUnit{VideoID: id, Items: []timelineItem{{VideoID: id, Duration: time.Minute}}}
+	}
+	return units
+}
+
+// passVideos returns the videos of a pass of the loop in airing order
+func passVideos(r *rotation, n int64) []uint {
+	var videos []uint
+	for _, unit := range r.order(n) {
+		videos = append(videos, unit.VideoID)
+	}
+	return videos
+}
+
+func TestRotationOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		playlist     models.Playlist
+		videos       []uint
+		weights      map[uint]int
+		wantCounts   map[uint]int // copies of each video in every pass
+		wantFixed    bool         // every pass airs in sort order
+		wantGapItems int          // fewest units between copies of a video, across passes
+		wantGapTime  time.Duration
+	}{
+		{
+			name:       "sequential",
+			playlist:   models.Playlist{ID: 1, PlaybackMode: PlaybackSequential},
+			videos:     []uint{1, 2, 3, 4},
+			wantCounts: map[uint]int{1: 1, 2: 1, 3: 1, 4: 1},
+			wantFixed:  true,
+		},
+		{
+			name:       "loop ignores weights",
+			playlist:   models.Playlist{ID: 1, PlaybackMode: PlaybackLoop},
+			videos:     []uint{1, 2, 3},
+			weights:    map[uint]int{1: 3},
+			wantCounts: map[uint]int{1: 1, 2: 1, 3: 1},
+			wantFixed:  true,
+		},
+		{
+			name:       "shuffle",
+			playlist:   models.Playlist{ID: 1, PlaybackMode: PlaybackShuffle},
+			videos:     []uint{1, 2, 3, 4, 5, 6},
+			wantCounts: map[uint]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1},
+		},
+		{
+			name:       "weighted",
+			playlist:   models.Playlist{ID: 1, PlaybackMode: PlaybackWeighted, ShuffleSeed: 99},
+			videos:     []uint{1, 2, 3},
+			weights:    map[uint]int{1: 3, 2: 2},
+			wantCounts: map[uint]int{1: 3, 2: 2, 3: 1},
+		},
+		{
+			name:         "shuffle keeps repeats apart across passes",
+			playlist:     models.Playlist{ID: 1, PlaybackMode: PlaybackShuffle, NoRepeatItems: 3},
+			videos:       []uint{1, 2, 3, 4, 5, 6, 7, 8},
+			wantCounts:   map[uint]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1, 7: 1, 8: 1},
+			wantGapItems: 3,
+		},
+		{
+			name:         "weighted keeps copies apart",
+			playlist:     models.Playlist{ID: 7, PlaybackMode: PlaybackWeighted, NoRepeatItems: 1},
+			videos:       []uint{1, 2, 3, 4, 5},
+			weights:      map[uint]int{1: 3},
+			wantCounts:   map[uint]int{1: 3, 2: 1, 3: 1, 4: 1, 5: 1},
+			wantGapItems: 1,
+		},
+		{
+			name:        "weighted keeps copies minutes apart",
+			playlist:    models.Playlist{ID: 7, PlaybackMode: PlaybackWeighted, NoRepeatMinutes: 3},
+			videos:      []uint{1, 2, 3, 4, 5, 6},
+			weights:     map[uint]int{1: 2},
+			wantCounts:  map[uint]int{1: 2, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1},
+			wantGapTime: 3 * time.Minute,
+		},
+	}
+
+	const passes = 20
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playlist := tt.playlist
+			r := newRotation(&playlist, testUnits(tt.videos...), tt.weights)
+
+			var aired []uint
+			for n := int64(0); n < passes; n++ {
+				videos := passVideos(r, n)
+				counts := make(map[uint]int)
+				for _, id := range videos {
+					counts[id]++
+				}
+				if !reflect.DeepEqual(counts, tt.wantCounts) {
+					t.Fatalf("pass %d airs %v, want counts %v", n, videos, tt.wantCounts)
+				}
+				if tt.wantFixed && !reflect.DeepEqual(videos, tt.videos) {
+					t.Errorf("pass %d = %v, want sort order %v", n, videos, tt.videos)
+				}
+
+				// Every pod derives the same order from the seed and the pass number
+				again := newRotation(&playlist, testUnits(tt.videos...), tt.weights)
+				if got := passVideos(again, n); !reflect.DeepEqual(got, videos) {
+					t.Errorf("pass %d = %v on another pod, want %v", n, got, videos)
+				}
+				aired = append(aired, videos...)
+			}
+
+			if !tt.wantFixed && distinctOrders(r, passes) < 2 {
+				t.Errorf("every pass airs in the same order %v", passVideos(r, 0))
+			}
+
+			last := make(map[uint]int)
+			for i, id := range aired {
+				if previous, ok := last[id]; ok {
+					if gap := i - previous - 1; gap < tt.wantGapItems {
+						t.Errorf("video %d airs again after %d units at %d, want at least %d", id, gap, i, tt.wantGapItems)
+					}
+					if elapsed := time.Duration(i-previous) * time.Minute; elapsed < tt.wantGapTime {
+						t.Errorf("video %d airs again after %s at %d, want at least %s", id, elapsed, i, tt.wantGapTime)
+					}
+				}
+				last[id] = i
+			}
+		})
+	}
+}
+
+// distinctOrders counts the different orders among the first passes of a rotation
+func distinctOrders(r *rotation, passes int64) int {
+	var seen [][]uint
+	for n := int64(0); n < passes; n++ {
+		videos := passVideos(r, n)
+		found := false
+		for _, order := range seen {
+			if reflect.DeepEqual(order, videos) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			seen = append(seen, videos)
+		}
+	}
+	return len(seen)
+}
+
+func TestRotationPosition(t *testing.T) {
+	playlist := models.Playlist{ID: 1, PlaybackMode: PlaybackSequential}
+	r := newRotation(&playlist, testUnits(1, 2, 3), nil)
+	epoch := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		at        time.Duration // after the epoch
+		wantPass  int64
+		wantIndex int
+		wantStart time.Duration // of the item, after the epoch
+	}{
+		{name: "epoch", at: 0, wantPass: 0, wantIndex: 0, wantStart: 0},
+		{name: "second item", at: 90 * time.Second, wantPass: 0, wantIndex: 1, wantStart: time.Minute},
+		{name: "next pass", at: 3 * time.Minute, wantPass: 1, wantIndex: 0, wantStart: 3 * time.Minute},
+		{name: "later pass", at: 10*time.Minute + time.Second, wantPass: 3, wantIndex: 1, wantStart: 10 * time.Minute},
+		{name: "before the epoch", at: -30 * time.Second, wantPass: -1, wantIndex: 2, wantStart: -time.Minute},
+		{name: "pass boundary before the epoch", at: -3 * time.Minute, wantPass: -1, wantIndex: 0, wantStart: -3 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, index, start, ok := r.position(epoch, epoch.Add(tt.at))
+			if !ok {
+				t.Fatal("position() found nothing on air")
+			}
+			if n != tt.wantPass || index != tt.wantIndex || !start.Equal(epoch.Add(tt.wantStart)) {
+				t.Errorf("position() = pass %d, item %d, from %s; want pass %d, item %d, from %s",
+					n, index, start.Sub(epoch), tt.wantPass, tt.wantIndex, tt.wantStart)
+			}
+		})
+	}
+
+	if _, _, _, ok := (&rotation{}).position(epoch, epoch); ok {
+		t.Error("position() found an item in an empty rotation")
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// defaultSlateDuration is how long a rendered slate runs before it loops
+const defaultSlateDuration = 10
+
+// slateImageFormats are the image types a slate can be rendered from
+var slateImageFormats = []string{".png", ".jpg", ".jpeg"}
+
+// SlateService renders slates and hands them to the regular transcode pipeline
+type SlateService struct {
+	db            *gorm.DB
+	config        *config.Config
+	uploadService *UploadService
+}
+
+func NewSlateService(db *gorm.DB, cfg *config.Config, uploadService *UploadService) *SlateService {
+	return &SlateService{db: db, config: cfg, uploadService: uploadService}
+}
+
+// CreateSlate renders a slate from an optional image and queues it for transcoding
+// into every rendition of the ladder
+func (s *SlateService) CreateSlate(req *models.SlateRequest, image *multipart.FileHeader) (*models.Slate, error) {
+	audio := req.Audio
+	if audio == "" {
+		audio = "silence"
+	}
+	duration := req.Duration
+	if duration == 0 {
+		duration = defaultSlateDuration
+	}
+	if duration < s.config.Transcode.SegmentTime {
+		return nil, fmt.Errorf("%w: duration must be at least one segment (%ds)", ErrInvalidInput, s.config.Transcode.SegmentTime)
+	}
+
+	slatesDir := filepath.Join(s.config.Storage.UploadPath, "slates")
+	if err := os.MkdirAll(slatesDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create slates directory: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	imagePath := ""
+	if image != nil {
+		ext := strings.ToLower(filepath.Ext(image.Filename))
+		if !isSlateImage(ext) {
+			return nil, fmt.Errorf("%w: unsupported image format, allowed formats: %v", ErrInvalidInput, slateImageFormats)
+		}
+
+		imagePath = filepath.Join(slatesDir, fmt.Sprintf("%d_slate%s", timestamp, ext))
+		if err := saveUploadedFile(image, imagePath); err != nil {
+			return nil, err
+		}
+	}
+
+	outputPath := filepath.Join(slatesDir, fmt.Sprintf("%d_slate.mp4", timestamp))
+	if err := s.renderSlate(imagePath, audio, duration, outputPath); err != nil {
+		if imagePath != "" {
+			os.Remove(imagePath)
+		}
+		return nil, err
+	}
+
+	video, err := s.uploadService.IngestFile(outputPath, req.Name+".mp4", nil)
+	if err != nil {
+		os.Remove(outputPath)
+		return nil, err
+	}
+
+	slate := &models.Slate{
+		Name:      req.Name,
+		ImagePath: strings.TrimPrefix(imagePath, s.config.Storage.UploadPath+"/"),
+		Audio:     audio,
+		Duration:  duration,
+		VideoID:   &video.ID,
+	}
+	if err := s.db.Create(slate).Error; err != nil {
+		return nil, err
+	}
+
+	slate.Video = video
+	return slate, nil
+}
+
+// GetSlates retrieves all slates
+func (s *SlateService) GetSlates() ([]models.Slate, error) {
+	slates := []models.Slate{}
+	if err := s.db.Preload("Video").Order("created_at DESC").Find(&slates).Error; err != nil {
+		return nil, err
+	}
+	return slates, nil
+}
+
+// GetSlate retrieves a slate by ID
+func (s *SlateService) GetSlate(id uint) (*models.Slate, error) {
+	var slate models.Slate
+	if err := s.db.Preload("Video").First(&slate, id).Error; err != nil {
+		return nil, err
+	}
+	return &slate, nil
+}
+
+// DeleteSlate deletes a slate; channels using it fall back to filler only
+func (s *SlateService) DeleteSlate(id uint) error {
+	return s.db.Delete(&models.Slate{}, id).Error
+}
+
+// renderSlate encodes a still card with silent or tone audio using FFmpeg
+func (s *SlateService) renderSlate(imagePath, audio string, duration int, outputPath string) error {
+	var args []string
+	if imagePath != "" {
+		args = append(args, "-loop", "1", "-framerate", "25", "-i", imagePath)
+	} else {
+		args = append(args, "-f", "lavfi", "-i", "color=c=black:s=1920x1080:r=25")
+	}
+
+	if audio == "tone" {
+		args = append(args, "-f", "lavfi", "-i", "sine=frequency=1000:sample_rate=48000")
+	} else {
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=48000")
+	}
+
+	args = append(args,
+		"-t", strconv.Itoa(duration),
+		"-vf", "scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,format=yuv420p",
+		"-c:v", "libx264",
+		"-tune", "stillimage",
+		"-c:a", "aac",
+		"-ac", "2",
+		"-y",
+		outputPath,
+	)
+
+	cmd := exec.Command(s.config.FFmpeg.FFmpegPath, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logrus.Errorf("Slate render failed: %s", string(output))
+		return fmt.Errorf("failed to render slate: %w", err)
+	}
+	return nil
+}
+
+// isSlateImage reports whether a file extension is a supported slate image
+func isSlateImage(ext string) bool {
+	for _, format := range slateImageFormats {
+		if ext == format {
+			return true
+		}
+	}
+	return false
+}
+
+// saveUploadedFile copies an uploaded file to a path on disk
+func saveUploadedFile(file *multipart.FileHeader, path string) error {
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
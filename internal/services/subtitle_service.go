@@ -0,0 +1,404 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"math"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// subtitleTimestampOffset is the MPEG-TS timestamp FFmpeg starts transport stream
+// renditions at (1.4s); subtitle cue times are mapped onto it
+const subtitleTimestampOffset = 126000
+
+// subtitleGroupID is the EXT-X-MEDIA group of a video's subtitle renditions
+const subtitleGroupID = "subs"
+
+// subtitleFormats are the sidecar types accepted, by extension
+var subtitleFormats = map[string]string{
+	".srt": "srt",
+	".vtt": "vtt",
+	".ass": "ass",
+	".ssa": "ass",
+}
+
+// languagePattern matches the BCP 47 tags subtitles are stored under, such as "en" or "pt-BR"
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// SubtitleService ingests sidecar subtitles and converts them into segmented WebVTT
+// renditions next to a video's other renditions
+type SubtitleService struct {
+	db               *gorm.DB
+	config           *config.Config
+	transcodeService *TranscodeService
+}
+
+func NewSubtitleService(db *gorm.DB, cfg *config.Config) *SubtitleService {
+	return &SubtitleService{db: db, config: cfg}
+}
+
+// SetTranscodeService sets the service rewriting master playlists when subtitles change
+func (s *SubtitleService) SetTranscodeService(transcodeService *TranscodeService) {
+	s.transcodeService = transcodeService
+}
+
+// AddSubtitle stores an uploaded sidecar as the video's subtitles in a language,
+// replacing earlier ones
+func (s *SubtitleService) AddSubtitle(videoID uint, req *models.SubtitleRequest, file *multipart.FileHeader) (*models.Subtitle, error) {
+	if _, ok := subtitleFormats[strings.ToLower(filepath.Ext(file.Filename))]; !ok {
+		return nil, fmt.Errorf("%w: unsupported subtitle format %s", ErrInvalidInput, filepath.Ext(file.Filename))
+	}
+	if !languagePattern.MatchString(req.Language) {
+		return nil, fmt.Errorf("%w: invalid language tag %q", ErrInvalidInput, req.Language)
+	}
+	if err := s.db.First(&models.Video{}, videoID).Error; err != nil {
+		return nil, err
+	}
+
+	subtitlesDir := filepath.Join(s.config.Storage.UploadPath, "subtitles")
+	if err := os.MkdirAll(subtitlesDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create subtitles directory: %w", err)
+	}
+
+	filename := fmt.Sprintf("%d_%d_%s%s", time.Now().Unix(), videoID, req.Language, strings.ToLower(filepath.Ext(file.Filename)))
+	filePath := filepath.Join(subtitlesDir, filename)
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	relativePath := strings.TrimPrefix(filePath, s.config.Storage.UploadPath+"/")
+	subtitle, err := s.AttachSubtitle(videoID, req.Language, req.Name, relativePath, req.Default)
+	if err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+	return subtitle, nil
+}
+
+// AttachSubtitle records a sidecar already in the upload path (relative path) as the
+// video's subtitles in a language. Subtitles of transcoded videos are converted right
+// away; the others when the video completes.
+func (s *SubtitleService) AttachSubtitle(videoID uint, language, name, path string, isDefault bool) (*models.Subtitle, error) {
+	format, ok := subtitleFormats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported subtitle format %s", ErrInvalidInput, filepath.Ext(path))
+	}
+	if !languagePattern.MatchString(language) {
+		return nil, fmt.Errorf("%w: invalid language tag %q", ErrInvalidInput, language)
+	}
+	if name == "" {
+		name = language
+	}
+
+	var subtitle models.Subtitle
+	err := s.db.Where("video_id = ? AND language = ?", videoID, language).First(&subtitle).Error
+	switch {
+	case err == nil:
+		if subtitle.FilePath != path {
+			os.Remove(filepath.Join(s.config.Storage.UploadPath, subtitle.FilePath))
+		}
+		if err := s.db.Model(&subtitle).Updates(map[string]interface{}{
+			"name":          name,
+			"format":        format,
+			"file_path":     path,
+			"playlist_path": "",
+			"is_default":    isDefault,
+			"status":        "pending",
+			"error_message": "",
+		}).Error; err != nil {
+			return nil, err
+		}
+	case err == gorm.ErrRecordNotFound:
+		subtitle = models.Subtitle{
+			VideoID:   videoID,
+			Language:  language,
+			Name:      name,
+			Format:    format,
+			FilePath:  path,
+			IsDefault: isDefault,
+			Status:    "pending",
+		}
+		if err := s.db.Create(&subtitle).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// Players select a single default track
+	if isDefault {
+		s.db.Model(&models.Subtitle{}).
+			Where("video_id = ? AND id <> ?", videoID, subtitle.ID).
+			Update("is_default", false)
+	}
+
+	var video models.Video
+	if err := s.db.Preload("VideoProfiles").First(&video, videoID).Error; err != nil {
+		return nil, err
+	}
+	if video.Status == "completed" {
+		s.convert(&subtitle, &video)
+		if s.transcodeService != nil {
+			s.transcodeService.generateAndSaveMasterPlaylist(videoID)
+		}
+	}
+
+	if err := s.db.First(&subtitle, subtitle.ID).Error; err != nil {
+		return nil, err
+	}
+	return &subtitle, nil
+}
+
+// GetSubtitles returns the subtitles of a video
+func (s *SubtitleService) GetSubtitles(videoID uint) ([]models.Subtitle, error) {
+	if err := s.db.First(&models.Video{}, videoID).Error; err != nil {
+		return nil, err
+	}
+	var subtitles []models.Subtitle
+	if err := s.db.Where("video_id = ?", videoID).Order("language").Find(&subtitles).Error; err != nil {
+		return nil, err
+	}
+	return subtitles, nil
+}
+
+// DeleteSubtitle removes a video's subtitles in a language with their files
+func (s *SubtitleService) DeleteSubtitle(videoID uint, language string) error {
+	var subtitle models.Subtitle
+	if err := s.db.Where("video_id = ? AND language = ?", videoID, language).First(&subtitle).Error; err != nil {
+		return err
+	}
+	if err := s.db.Delete(&subtitle).Error; err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(s.config.Storage.UploadPath, subtitle.FilePath))
+	if subtitle.PlaylistPath != "" {
+		os.RemoveAll(filepath.Dir(filepath.Join(s.config.Storage.TranscodedPath, subtitle.PlaylistPath)))
+		if s.transcodeService != nil {
+			s.transcodeService.generateAndSaveMasterPlaylist(videoID)
+		}
+	}
+	return nil
+}
+
+// ConvertPending converts the subtitles of a video waiting for its renditions
+func (s *SubtitleService) ConvertPending(videoID uint) {
+	var video models.Video
+	if err := s.db.Preload("VideoProfiles").First(&video, videoID).Error; err != nil {
+		return
+	}
+	var subtitles []models.Subtitle
+	s.db.Where("video_id = ? AND status = ?", videoID, "pending").Find(&subtitles)
+	for i := range subtitles {
+		s.convert(&subtitles[i], &video)
+	}
+}
+
+// GetSubtitleFile returns the playlist or a segment of a subtitle rendition and its content type
+func (s *SubtitleService) GetSubtitleFile(videoID uint, language, name string) ([]byte, string, error) {
+	var subtitle models.Subtitle
+	if err := s.db.Where("video_id = ? AND language = ?", videoID, language).First(&subtitle).Error; err != nil {
+		return nil, "", err
+	}
+	if subtitle.PlaylistPath == "" {
+		return nil, "", fmt.Errorf("subtitles %s of video %d are not converted", language, videoID)
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, "", fmt.Errorf("invalid subtitle file %q", name)
+	}
+
+	dir := filepath.Dir(filepath.Join(s.config.Storage.TranscodedPath, subtitle.PlaylistPath))
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, "", err
+	}
+	if filepath.Ext(name) == ".m3u8" {
+		return data, "application/vnd.apple.mpegurl", nil
+	}
+	return data, "text/vtt", nil
+}
+
+// convert writes a subtitle rendition into the video's output directory and records
+// the outcome on the subtitle
+func (s *SubtitleService) convert(subtitle *models.Subtitle, video *models.Video) {
+	playlistPath, err := s.writeRendition(subtitle, video)
+	if err != nil {
+		logrus.Errorf("Failed to convert %s subtitles of video %d: %v", subtitle.Language, video.ID, err)
+		s.db.Model(subtitle).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": err.Error(),
+		})
+		return
+	}
+	s.db.Model(subtitle).Updates(map[string]interface{}{
+		"status":        "completed",
+		"playlist_path": playlistPath,
+		"error_message": "",
+	})
+}
+
+// writeRendition converts a sidecar to WebVTT with FFmpeg and splits it into segments
+// of the video's segment duration under subtitles/<language>. It returns the media
+// playlist path relative to the transcoded path.
+func (s *SubtitleService) writeRendition(subtitle *models.Subtitle, video *models.Video) (string, error) {
+	baseDir := videoOutputDir(video.VideoProfiles)
+	if baseDir == "" {
+		return "", fmt.Errorf("video %d has no renditions", video.ID)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.config.FFmpeg.FFmpegPath,
+		"-i", filepath.Join(s.config.Storage.UploadPath, subtitle.FilePath),
+		"-map", "0:s:0",
+		"-f", "webvtt",
+		"pipe:1")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		logrus.Errorf("Subtitle conversion failed: %s", stderr.String())
+		return "", fmt.Errorf("failed to convert subtitles: %w", err)
+	}
+	cues := utils.ParseWebVTT(string(output))
+
+	// Segments follow the renditions' segment duration and timestamps; fragmented MP4
+	// renditions start at zero
+	segmentTime := s.config.Transcode.SegmentTime
+	mpegts := int64(subtitleTimestampOffset)
+	if len(video.VideoProfiles) > 0 {
+		profile := video.VideoProfiles[0]
+		if profile.SegmentTime > 0 {
+			segmentTime = profile.SegmentTime
+		}
+		if profile.SegmentFormat == SegmentFormatFMP4 {
+			mpegts = 0
+		}
+	}
+	if segmentTime <= 0 {
+		return "", fmt.Errorf("invalid segment time %d", segmentTime)
+	}
+
+	duration := float64(video.Duration)
+	for _, cue := range cues {
+		duration = math.Max(duration, cue.End)
+	}
+
+	relativeDir := filepath.Join(baseDir, "subtitles", subtitle.Language)
+	dir := filepath.Join(s.config.Storage.TranscodedPath, relativeDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create subtitle directory: %w", err)
+	}
+
+	segments := subtitleSegments(cues, duration, segmentTime, mpegts)
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	playlist.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", segmentTime))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, content := range segments {
+		name := fmt.Sprintf("%06d.vtt", i)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return "", err
+		}
+		length := math.Min(float64(segmentTime), duration-float64(i*segmentTime))
+		playlist.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", length, name))
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "playlist.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		return "", err
+	}
+	return filepath.Join(relativeDir, "playlist.m3u8"), nil
+}
+
+// subtitleSegments splits cues into WebVTT segments of segmentTime seconds covering
+// duration. Cues spanning a segment boundary are repeated in every segment they
+// overlap; cue times map onto the renditions' MPEG-TS timestamps through mpegts.
+func subtitleSegments(cues []utils.WebVTTCue, duration float64, segmentTime int, mpegts int64) []string {
+	count := int(math.Ceil(duration / float64(segmentTime)))
+	if count == 0 {
+		count = 1
+	}
+
+	segments := make([]string, count)
+	for i := range segments {
+		start := float64(i * segmentTime)
+		end := start + float64(segmentTime)
+
+		var content strings.Builder
+		content.WriteString(fmt.Sprintf("WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", mpegts))
+		for _, cue := range cues {
+			if cue.End <= start || cue.Start >= end {
+				continue
+			}
+			timing := utils.WebVTTTimestamp(cue.Start) + " --> " + utils.WebVTTTimestamp(cue.End)
+			if cue.Settings != "" {
+				timing += " " + cue.Settings
+			}
+			content.WriteString(fmt.Sprintf("\n%s\n%s\n", timing, cue.Text))
+		}
+		segments[i] = content.String()
+	}
+	return segments
+}
+
+// subtitleMedia returns the EXT-X-MEDIA tags of a video's converted subtitles and the
+// STREAM-INF attribute referencing their group, both empty without subtitles
+func subtitleMedia(subtitles []models.Subtitle) ([]string, string) {
+	var tags []string
+	for _, subtitle := range subtitles {
+		if subtitle.Status != "completed" {
+			continue
+		}
+		isDefault := "NO"
+		if subtitle.IsDefault {
+			isDefault = "YES"
+		}
+		tags = append(tags, fmt.Sprintf("#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,URI=\"subtitles/%s/playlist.m3u8\"",
+			subtitleGroupID, strings.ReplaceAll(subtitle.Name, "\"", "'"), subtitle.Language, isDefault, subtitle.Language))
+	}
+	if len(tags) == 0 {
+		return nil, ""
+	}
+	return tags, fmt.Sprintf(",SUBTITLES=\"%s\"", subtitleGroupID)
+}
+
+// videoOutputDir returns the directory, relative to the transcoded path, holding a
+// video's renditions and master playlist; empty before any rendition completed
+func videoOutputDir(profiles []models.VideoProfile) string {
+	for _, profile := range profiles {
+		if profile.PlaylistPath != "" {
+			return filepath.Dir(filepath.Dir(profile.PlaylistPath))
+		}
+	}
+	return ""
+}
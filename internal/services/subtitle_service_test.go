@@ -0,0 +1,150 @@
+package services
+
+import (
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubtitleSegments(t *testing.T) {
+	const tsHeader = "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n"
+
+	tests := []struct {
+		name     string
+		cues     []utils.WebVTTCue
+		duration float64
+		mpegts   int64
+		want     []string
+	}{
+		{
+			name:     "no cues",
+			duration: 0,
+			mpegts:   subtitleTimestampOffset,
+			want:     []string{tsHeader},
+		},
+		{
+			name:     "cues in their segments",
+			cues:     []utils.WebVTTCue{{Start: 1, End: 2, Text: "One"}, {Start: 7, End: 8.5, Text: "Two"}},
+			duration: 12,
+			mpegts:   subtitleTimestampOffset,
+			want: []string{
+				tsHeader + "\n00:00:01.000 --> 00:00:02.000\nOne\n",
+				tsHeader + "\n00:00:07.000 --> 00:00:08.500\nTwo\n",
+			},
+		},
+		{
+			name:     "cue spanning a segment boundary is repeated",
+			cues:     []utils.WebVTTCue{{Start: 5, End: 13, Text: "Long"}},
+			duration: 18,
+			mpegts:   subtitleTimestampOffset,
+			want: []string{
+				tsHeader + "\n00:00:05.000 --> 00:00:13.000\nLong\n",
+				tsHeader + "\n00:00:05.000 --> 00:00:13.000\nLong\n",
+				tsHeader + "\n00:00:05.000 --> 00:00:13.000\nLong\n",
+			},
+		},
+		{
+			name:     "cue ending on a segment boundary",
+			cues:     []utils.WebVTTCue{{Start: 4, End: 6, Text: "Edge"}},
+			duration: 12,
+			mpegts:   subtitleTimestampOffset,
+			want: []string{
+				tsHeader + "\n00:00:04.000 --> 00:00:06.000\nEdge\n",
+				tsHeader,
+			},
+		},
+		{
+			name:     "cue settings and multi-line text",
+			cues:     []utils.WebVTTCue{{Start: 1, End: 2, Settings: "line:0 align:start", Text: "Top\nline"}},
+			duration: 6,
+			mpegts:   subtitleTimestampOffset,
+			want:     []string{tsHeader + "\n00:00:01.000 --> 00:00:02.000 line:0 align:start\nTop\nline\n"},
+		},
+		{
+			name:     "fragmented MP4 starts at zero",
+			cues:     []utils.WebVTTCue{{Start: 1, End: 2, Text: "One"}},
+			duration: 4,
+			mpegts:   0,
+			want:     []string{"WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:02.000\nOne\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subtitleSegments(tt.cues, tt.duration, 6, tt.mpegts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subtitleSegments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRenditionSegmentsConvertedSubtitles(t *testing.T) {
+	t.Setenv("FAKE_FFMPEG", "1")
+	uploads := t.TempDir()
+	transcoded := t.TempDir()
+
+	srt := "1\r\n00:00:01,000 --> 00:00:03,000\r\nHello\r\n\r\n2\r\n00:00:03,500 --> 00:00:05,250\r\nAcross\r\nthe boundary\r\n\r\n3\r\n00:00:08,500 --> 00:00:09,500\r\nLast\r\n"
+	if err := os.WriteFile(filepath.Join(uploads, "en.srt"), []byte(srt), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.FFmpeg.FFmpegPath = os.Args[0]
+	cfg.Storage.UploadPath = uploads
+	cfg.Storage.TranscodedPath = transcoded
+	cfg.Transcode.SegmentTime = 6
+	s := NewSubtitleService(nil, cfg)
+
+	subtitle := &models.Subtitle{VideoID: 1, Language: "en", Format: "srt", FilePath: "en.srt"}
+	video := &models.Video{
+		ID:            1,
+		Duration:      10,
+		VideoProfiles: []models.VideoProfile{{PlaylistPath: "video_1/720p/playlist.m3u8", SegmentTime: 4}},
+	}
+	playlistPath, err := s.writeRendition(subtitle, video)
+	if err != nil {
+		t.Fatalf("writeRendition() error = %v", err)
+	}
+	if want := filepath.Join("video_1", "subtitles", "en", "playlist.m3u8"); playlistPath != want {
+		t.Errorf("writeRendition() = %q, want %q", playlistPath, want)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(transcoded, playlistPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Segments follow the renditions' segment duration, the last one cut at the video's end
+	segments := utils.ParseMediaPlaylist(string(playlist))
+	wantSegments := []utils.HLSSegment{
+		{URI: "000000.vtt", Duration: 4},
+		{URI: "000001.vtt", Duration: 4, Start: 4},
+		{URI: "000002.vtt", Duration: 2, Start: 8},
+	}
+	if !reflect.DeepEqual(segments, wantSegments) {
+		t.Fatalf("playlist segments = %+v, want %+v", segments, wantSegments)
+	}
+
+	wantCues := [][]string{{"Hello", "Across\nthe boundary"}, {"Across\nthe boundary"}, {"Last"}}
+	for i, segment := range segments {
+		content, err := os.ReadFile(filepath.Join(transcoded, "video_1", "subtitles", "en", segment.URI))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "X-TIMESTAMP-MAP=MPEGTS:126000,") {
+			t.Errorf("segment %d has no MPEG-TS timestamp map:\n%s", i, content)
+		}
+
+		var texts []string
+		for _, cue := range utils.ParseWebVTT(string(content)) {
+			texts = append(texts, cue.Text)
+		}
+		if !reflect.DeepEqual(texts, wantCues[i]) {
+			t.Errorf("segment %d cues = %q, want %q", i, texts, wantCues[i])
+		}
+	}
+}
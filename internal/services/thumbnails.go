@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"linier-channel/internal/config"
+	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Preview files in a video's thumbnails directory
+const (
+	thumbnailDir     = "thumbnails"
+	posterFile       = "poster.jpg"
+	thumbnailPattern = "thumb_%04d.jpg"
+	spritePattern    = "sprite_%03d.jpg"
+	thumbnailTrack   = "thumbnails.vtt"
+	thumbnailURLBase = "/api/v1/stream/%d/" + thumbnailDir + "/"
+)
+
+// generateThumbnails extracts a poster, interval thumbnails and sprite sheets from a
+// video's source into a thumbnails directory next to its master playlist, with a WebVTT
+// track mapping playback time to sprite tiles. It returns the video columns to update,
+// none when thumbnails are disabled by a zero setting.
+func (s *TranscodeService) generateThumbnails(video *models.Video, masterPath string) (map[string]interface{}, error) {
+	cfg := s.config.Thumbnails
+	if cfg.Interval <= 0 || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Columns <= 0 || cfg.Rows <= 0 {
+		return nil, nil
+	}
+
+	relativeDir := filepath.Join(filepath.Dir(masterPath), thumbnailDir)
+	dir := filepath.Join(s.config.Storage.TranscodedPath, relativeDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	sourcePath := filepath.Join(s.config.Storage.UploadPath, video.FilePath)
+
+	// The poster is taken a tenth into the video, past most fades from black
+	poster := exec.Command(s.config.FFmpeg.FFmpegPath,
+		"-ss", strconv.FormatFloat(float64(video.Duration)/10, 'f', 3, 64),
+		"-i", sourcePath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", filepath.Join(dir, posterFile))
+	if output, err := poster.CombinedOutput(); err != nil {
+		logrus.Errorf("Poster extraction failed: %s", string(output))
+		return nil, fmt.Errorf("failed to extract poster: %w", err)
+	}
+
+	// Thumbnails are letterboxed to the same size so sprite tiles sit on a fixed grid
+	filter := fmt.Sprintf("[0:v]fps=1/%d,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,split[thumbs][tiles];[tiles]tile=%dx%d[sprites]",
+		cfg.Interval, cfg.Width, cfg.Height, cfg.Width, cfg.Height, cfg.Columns, cfg.Rows)
+	previews := exec.Command(s.config.FFmpeg.FFmpegPath,
+		"-i", sourcePath,
+		"-filter_complex", filter,
+		"-map", "[thumbs]", "-q:v", "4", "-y", filepath.Join(dir, thumbnailPattern),
+		"-map", "[sprites]", "-q:v", "4", "-y", filepath.Join(dir, spritePattern))
+	if output, err := previews.CombinedOutput(); err != nil {
+		logrus.Errorf("Thumbnail extraction failed: %s", string(output))
+		return nil, fmt.Errorf("failed to extract thumbnails: %w", err)
+	}
+
+	thumbnails, _ := filepath.Glob(filepath.Join(dir, "thumb_*.jpg"))
+	sprites, _ := filepath.Glob(filepath.Join(dir, "sprite_*.jpg"))
+	track := thumbnailTrackContent(len(thumbnails), float64(video.Duration), cfg)
+	if err := ioutil.WriteFile(filepath.Join(dir, thumbnailTrack), []byte(track), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save thumbnail track: %w", err)
+	}
+
+	return map[string]interface{}{
+		"thumbnail_path":  relativeDir,
+		"thumbnail_count": len(thumbnails),
+		"sprite_count":    len(sprites),
+	}, nil
+}
+
+// thumbnailTrackContent returns a WebVTT track with a cue per thumbnail interval,
+// pointing at the interval's tile of a sprite sheet as a media fragment
+func thumbnailTrackContent(count int, duration float64, cfg config.ThumbnailConfig) string {
+	var content strings.Builder
+	content.WriteString("WEBVTT\n")
+
+	perSprite := cfg.Columns * cfg.Rows
+	for i := 0; i < count; i++ {
+		start := float64(i * cfg.Interval)
+		end := start + float64(cfg.Interval)
+		if duration > start && duration < end {
+			end = duration
+		}
+
+		tile := i % perSprite
+		content.WriteString(fmt.Sprintf("\n%s --> %s\n"+spritePattern+"#xywh=%d,%d,%d,%d\n",
+			utils.WebVTTTimestamp(start), utils.WebVTTTimestamp(end),
+			i/perSprite+1, tile%cfg.Columns*cfg.Width, tile/cfg.Columns*cfg.Height, cfg.Width, cfg.Height))
+	}
+	return content.String()
+}
+
+// setThumbnailURLs fills in the preview URLs of a video whose thumbnails were extracted
+func setThumbnailURLs(video *models.Video) {
+	if video.ThumbnailPath == "" {
+		return
+	}
+
+	base := fmt.Sprintf(thumbnailURLBase, video.ID)
+	thumbnails := &models.VideoThumbnails{
+		Poster:     base + posterFile,
+		Track:      base + thumbnailTrack,
+		Sprites:    make([]string, video.SpriteCount),
+		Thumbnails: make([]string, video.ThumbnailCount),
+	}
+	for i := range thumbnails.Sprites {
+		thumbnails.Sprites[i] = base + fmt.Sprintf(spritePattern, i+1)
+	}
+	for i := range thumbnails.Thumbnails {
+		thumbnails.Thumbnails[i] = base + fmt.Sprintf(thumbnailPattern, i+1)
+	}
+	video.Thumbnails = thumbnails
+}
+
+// thumbnailContentType returns the MIME type of a preview file
+func thumbnailContentType(name string) string {
+	if filepath.Ext(name) == ".vtt" {
+		return "text/vtt"
+	}
+	return "image/jpeg"
+}
@@ -2,17 +2,21 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"linier-channel/internal/config"
 	"linier-channel/internal/models"
 	"linier-channel/internal/utils"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -20,16 +24,31 @@ import (
 	"gorm.io/gorm"
 )
 
+// Progress of running encodes is published to Redis often for status polling, and
+// written to the database less often
+const (
+	progressRedisInterval = time.Second
+	progressDBInterval    = 5 * time.Second
+	progressTTL           = time.Minute // outlives a crashed worker only briefly
+)
+
 type TranscodeService struct {
-	db     *gorm.DB
-	config *config.Config
-	redis  *redis.Client
+	db              *gorm.DB
+	config          *config.Config
+	redis           *redis.Client
+	keyService      *KeyService
+	subtitleService *SubtitleService
+	audioService    *AudioService
+
+	encodesMu sync.Mutex
+	encodes   map[uint]*runningEncode // encodes running on this worker, by profile
 }
 
 func NewTranscodeService(db *gorm.DB, cfg *config.Config) *TranscodeService {
 	return &TranscodeService{
-		db:     db,
-		config: cfg,
+		db:      db,
+		config:  cfg,
+		encodes: make(map[uint]*runningEncode),
 	}
 }
 
@@ -38,6 +57,21 @@ func (s *TranscodeService) SetRedisClient(redis *redis.Client) {
 	s.redis = redis
 }
 
+// SetKeyService sets the key service encrypted videos get their keys from
+func (s *TranscodeService) SetKeyService(keyService *KeyService) {
+	s.keyService = keyService
+}
+
+// SetSubtitleService sets the service converting subtitles once renditions complete
+func (s *TranscodeService) SetSubtitleService(subtitleService *SubtitleService) {
+	s.subtitleService = subtitleService
+}
+
+// SetAudioService sets the service encoding alternate audio tracks once renditions complete
+func (s *TranscodeService) SetAudioService(audioService *AudioService) {
+	s.audioService = audioService
+}
+
 // QueueTranscodeJob queues a transcoding job
 func (s *TranscodeService) QueueTranscodeJob(videoID, profileID uint, priority int) error {
 	job := &models.TranscodeJob{
@@ -61,6 +95,36 @@ func (s *TranscodeService) QueueTranscodeJob(videoID, profileID uint, priority i
 	return nil
 }
 
+// QueueVideoJobs queues the pending profiles of a video: one job per profile, or a
+// single job for all of them when the video is encoded in a single pass
+func (s *TranscodeService) QueueVideoJobs(videoID uint, priority int) error {
+	var video models.Video
+	if err := s.db.First(&video, videoID).Error; err != nil {
+		return err
+	}
+
+	var profiles []models.VideoProfile
+	if err := s.db.Where("video_id = ? AND status = ?", videoID, "pending").
+		Order("bitrate DESC").Find(&profiles).Error; err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	// A single-pass job is tracked by its first profile
+	if video.SinglePass {
+		profiles = profiles[:1]
+	}
+
+	for _, profile := range profiles {
+		if err := s.QueueTranscodeJob(videoID, profile.ID, priority); err != nil {
+			return fmt.Errorf("failed to queue profile %d: %w", profile.ID, err)
+		}
+	}
+	return nil
+}
+
 // ProcessTranscodeJob processes a transcoding job
 func (s *TranscodeService) ProcessTranscodeJob(videoID, profileID uint) error {
 	// Get video and profile information
@@ -74,6 +138,14 @@ func (s *TranscodeService) ProcessTranscodeJob(videoID, profileID uint) error {
 		return err
 	}
 
+	if profile.Status == "cancelled" {
+		return ErrCancelled
+	}
+
+	if video.SinglePass {
+		return s.processSinglePass(&video, profileID)
+	}
+
 	// Update job status to processing
 	s.updateJobStatus(videoID, profileID, "processing", "")
 
@@ -81,14 +153,41 @@ func (s *TranscodeService) ProcessTranscodeJob(videoID, profileID uint) error {
 	s.updateProfileStatus(profileID, "processing", 0, "")
 
 	// Create output directory with date structure
-	outputDir := utils.GenerateTranscodedPath(s.config.Storage.TranscodedPath, video.OriginalFilename, profile.Resolution)
+	outputDir := utils.GenerateTranscodedPath(s.config.Storage.TranscodedPath, video.OriginalFilename, renditionName(&profile))
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	keys, err := s.keyRotation(&video, profile.SegmentTime)
+	if err != nil {
+		s.updateJobStatus(videoID, profileID, "failed", err.Error())
+		s.updateProfileStatus(profileID, "failed", 0, err.Error())
+		return err
+	}
+	if keys != nil {
+		defer keys.Close()
+	}
+
 	// Generate FFmpeg command (construct full path from relative path)
 	fullFilePath := filepath.Join(s.config.Storage.UploadPath, video.FilePath)
-	cmd, err := s.generateFFmpegCommand(fullFilePath, outputDir, &profile)
+	if _, err := os.Stat(fullFilePath); err != nil {
+		s.updateJobStatus(videoID, profileID, "failed", err.Error())
+		s.updateProfileStatus(profileID, "failed", 0, err.Error())
+		return err
+	}
+
+	audioFilter, err := s.loudnessFilter(&video, fullFilePath, []uint{profileID})
+	if err != nil {
+		if errors.Is(err, ErrCancelled) {
+			os.RemoveAll(outputDir)
+			return err
+		}
+		s.updateJobStatus(videoID, profileID, "failed", err.Error())
+		s.updateProfileStatus(profileID, "failed", 0, err.Error())
+		return err
+	}
+
+	cmd, err := s.generateFFmpegCommand(fullFilePath, outputDir, &profile, audioFilter, keys)
 	if err != nil {
 		s.updateJobStatus(videoID, profileID, "failed", err.Error())
 		s.updateProfileStatus(profileID, "failed", 0, err.Error())
@@ -96,7 +195,11 @@ func (s *TranscodeService) ProcessTranscodeJob(videoID, profileID uint) error {
 	}
 
 	// Execute FFmpeg command
-	if err := s.executeFFmpegCommand(cmd, uint(profileID)); err != nil {
+	if err := s.executeFFmpegCommand(cmd, []uint{profileID}, float64(video.Duration), keys); err != nil {
+		if errors.Is(err, ErrCancelled) {
+			os.RemoveAll(outputDir)
+			return err
+		}
 		s.updateJobStatus(videoID, profileID, "failed", err.Error())
 		s.updateProfileStatus(profileID, "failed", 0, err.Error())
 		return err
@@ -104,90 +207,481 @@ func (s *TranscodeService) ProcessTranscodeJob(videoID, profileID uint) error {
 
 	// Update job and profile status to completed
 	s.updateJobStatus(videoID, profileID, "completed", "")
+	s.completeProfile(profileID, outputDir)
+
+	// Check if all profiles are completed
+	s.checkVideoCompletion(videoID)
+
+	return nil
+}
+
+// processSinglePass decodes the source once and encodes every unfinished profile of
+// the video in one FFmpeg process, so renditions share keyframe positions. The job
+// is tracked by the profile it was queued for.
+func (s *TranscodeService) processSinglePass(video *models.Video, jobProfileID uint) error {
+	var profiles []models.VideoProfile
+	for _, profile := range video.VideoProfiles {
+		if profile.Status != "completed" && profile.Status != "cancelled" {
+			profiles = append(profiles, profile)
+		}
+	}
+	if len(profiles) == 0 {
+		s.updateJobStatus(video.ID, jobProfileID, "completed", "")
+		return nil
+	}
+
+	profileIDs := make([]uint, len(profiles))
+	for i, profile := range profiles {
+		profileIDs[i] = profile.ID
+	}
+
+	fail := func(err error) error {
+		s.updateJobStatus(video.ID, jobProfileID, "failed", err.Error())
+		for _, id := range profileIDs {
+			s.updateProfileStatus(id, "failed", 0, err.Error())
+		}
+		return err
+	}
+
+	s.updateJobStatus(video.ID, jobProfileID, "processing", "")
+	for _, id := range profileIDs {
+		s.updateProfileStatus(id, "processing", 0, "")
+	}
+
+	// Renditions are written side by side, as separate jobs would write them
+	baseDir := filepath.Dir(utils.GenerateTranscodedPath(s.config.Storage.TranscodedPath, video.OriginalFilename, renditionName(&profiles[0])))
+	for i := range profiles {
+		if err := os.MkdirAll(filepath.Join(baseDir, renditionName(&profiles[i])), 0755); err != nil {
+			return fail(fmt.Errorf("failed to create output directory: %w", err))
+		}
+	}
+
+	fullFilePath := filepath.Join(s.config.Storage.UploadPath, video.FilePath)
+	if _, err := os.Stat(fullFilePath); err != nil {
+		return fail(err)
+	}
+	media, err := utils.ProbeMedia(s.config.FFmpeg.FFprobePath, fullFilePath)
+	if err != nil {
+		return fail(err)
+	}
+
+	keys, err := s.keyRotation(video, profiles[0].SegmentTime)
+	if err != nil {
+		return fail(err)
+	}
+	if keys != nil {
+		defer keys.Close()
+	}
+
+	audioFilter, err := s.loudnessFilter(video, fullFilePath, profileIDs)
+	if err != nil {
+		if errors.Is(err, ErrCancelled) {
+			for i := range profiles {
+				os.RemoveAll(filepath.Join(baseDir, renditionName(&profiles[i])))
+			}
+			return err
+		}
+		return fail(err)
+	}
+
+	cmd := s.generateSinglePassCommand(fullFilePath, baseDir, profiles, media.Audio > 0, audioFilter, keys)
+	if err := s.executeFFmpegCommand(cmd, profileIDs, float64(video.Duration), keys); err != nil {
+		if errors.Is(err, ErrCancelled) {
+			for i := range profiles {
+				os.RemoveAll(filepath.Join(baseDir, renditionName(&profiles[i])))
+			}
+			return err
+		}
+		return fail(err)
+	}
+
+	s.updateJobStatus(video.ID, jobProfileID, "completed", "")
+	for i := range profiles {
+		s.completeProfile(profiles[i].ID, filepath.Join(baseDir, renditionName(&profiles[i])))
+	}
+
+	s.checkVideoCompletion(video.ID)
+	return nil
+}
+
+// keyRotation creates the first encryption key of an encrypted video's transcode,
+// nil for videos in the clear
+func (s *TranscodeService) keyRotation(video *models.Video, segmentTime int) (*keyRotation, error) {
+	if !video.Encrypted {
+		return nil, nil
+	}
+	if s.keyService == nil {
+		return nil, fmt.Errorf("video %d is encrypted but no key service is configured", video.ID)
+	}
+	return s.keyService.newKeyRotation(video, segmentTime)
+}
+
+// completeProfile records a profile's finished rendition written to outputDir
+func (s *TranscodeService) completeProfile(profileID uint, outputDir string) {
 	s.updateProfileStatus(profileID, "completed", 100, "")
+	s.updateSegmentCount(profileID, s.countSegments(outputDir))
 
 	// Update playlist path with relative path
 	playlistPath := filepath.Join(outputDir, "playlist.m3u8")
 	relativePlaylistPath := strings.TrimPrefix(playlistPath, s.config.Storage.TranscodedPath+"/")
 	s.updatePlaylistPath(profileID, relativePlaylistPath)
+	s.updateCodecs(profileID, outputDir)
+}
 
-	// Check if all profiles are completed
-	s.checkVideoCompletion(videoID)
+// updateCodecs records the RFC 6381 codecs of a finished rendition, probed from its
+// first segment, or from its initialization section for fragmented MP4
+func (s *TranscodeService) updateCodecs(profileID uint, outputDir string) {
+	content, err := ioutil.ReadFile(filepath.Join(outputDir, "playlist.m3u8"))
+	if err != nil {
+		return
+	}
+	segments := utils.ParseMediaPlaylist(string(content))
+	if len(segments) == 0 {
+		return
+	}
+	file := segments[0].URI
+	if segments[0].Map != "" {
+		file = segments[0].Map
+	}
 
-	return nil
+	streams, err := utils.ProbeStreams(s.config.FFmpeg.FFprobePath, filepath.Join(outputDir, file))
+	if err != nil {
+		logrus.Warnf("Failed to probe codecs of profile %d: %v", profileID, err)
+		return
+	}
+	if codecs := probedCodecs(streams); codecs != "" {
+		s.db.Model(&models.VideoProfile{}).
+			Where("id = ?", profileID).
+			Update("codecs", codecs)
+	}
 }
 
-// generateFFmpegCommand generates FFmpeg command for transcoding
-func (s *TranscodeService) generateFFmpegCommand(inputPath, outputDir string, profile *models.VideoProfile) (*exec.Cmd, error) {
-	// Get video dimensions based on resolution
-	width, height := s.getVideoDimensions(profile.Resolution)
-
+// generateFFmpegCommand generates FFmpeg command for transcoding; audioFilter, when
+// set, normalises the audio loudness
+func (s *TranscodeService) generateFFmpegCommand(inputPath, outputDir string, profile *models.VideoProfile, audioFilter string, keys *keyRotation) (*exec.Cmd, error) {
 	// Generate output path
 	outputPath := filepath.Join(outputDir, "playlist.m3u8")
 
 	// Build FFmpeg command
+	encoder := videoEncoder(profile, s.config.FFmpeg.AV1Encoder)
 	args := []string{
+		"-progress", "pipe:1",
+		"-nostats",
 		"-i", inputPath,
-		"-c:v", profile.CodecVideo,
+		// The first audio stream, as in single-pass renditions; the others become
+		// alternate audio renditions
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c:v", encoder,
 		"-c:a", profile.CodecAudio,
-		"-b:v", fmt.Sprintf("%dk", profile.Bitrate),
+	}
+	args = append(args, videoEncoderArgs(profile, encoder, "v")...)
+	args = append(args,
 		"-b:a", fmt.Sprintf("%dk", profile.AudioBitrate),
-		"-s", fmt.Sprintf("%dx%d", width, height),
+		"-vf", videoFilter(profile),
+	)
+	if audioFilter != "" {
+		args = append(args, "-af", audioFilter)
+	}
+	args = append(args,
 		"-f", "hls",
 		"-hls_time", strconv.Itoa(profile.SegmentTime),
 		"-hls_list_size", "0",
-		"-hls_segment_filename", filepath.Join(outputDir, "%06d.ts"),
-		"-hls_flags", "independent_segments+split_by_time",
+	)
+	args = append(args, segmentArgs(profile, outputDir)...)
+	args = append(args, hlsFlagArgs(keys)...)
+	args = append(args,
 		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", profile.SegmentTime),
 		"-segment_time_metadata", "1",
 		outputPath,
-	}
+	)
 
 	cmd := exec.Command(s.config.FFmpeg.FFmpegPath, args...)
 	return cmd, nil
 }
 
-// getVideoDimensions returns video dimensions based on resolution
-func (s *TranscodeService) getVideoDimensions(resolution string) (int, int) {
-	switch resolution {
-	case "720p":
-		return 1280, 720
-	case "480p":
-		return 854, 480
-	case "360p":
-		return 640, 360
-	default:
-		return 640, 360
+// generateSinglePassCommand generates one FFmpeg command encoding every profile:
+// the decoded picture is split and scaled per rendition, and the HLS muxer writes
+// each rendition to <baseDir>/<rendition>/playlist.m3u8
+func (s *TranscodeService) generateSinglePassCommand(inputPath, baseDir string, profiles []models.VideoProfile, hasAudio bool, audioFilter string, keys *keyRotation) *exec.Cmd {
+	var graph strings.Builder
+	graph.WriteString(fmt.Sprintf("[0:v]split=%d", len(profiles)))
+	for i := range profiles {
+		graph.WriteString(fmt.Sprintf("[s%d]", i))
+	}
+	for i := range profiles {
+		graph.WriteString(fmt.Sprintf(";[s%d]%s[v%d]", i, videoFilter(&profiles[i]), i))
+	}
+
+	args := []string{
+		"-progress", "pipe:1",
+		"-nostats",
+		"-i", inputPath,
+		"-filter_complex", graph.String(),
+	}
+
+	streams := make([]string, len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+		encoder := videoEncoder(profile, s.config.FFmpeg.AV1Encoder)
+		args = append(args, fmt.Sprintf("-c:v:%d", i), encoder)
+		args = append(args, videoEncoderArgs(profile, encoder, fmt.Sprintf("v:%d", i))...)
+		streams[i] = fmt.Sprintf("v:%d", i)
+
+		// Each rendition carries its own encode of the first audio track
+		if hasAudio {
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), profile.CodecAudio,
+				fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", profile.AudioBitrate),
+			)
+			if audioFilter != "" {
+				args = append(args, fmt.Sprintf("-filter:a:%d", i), audioFilter)
+			}
+			streams[i] += fmt.Sprintf(",a:%d", i)
+		}
+		streams[i] += ",name:" + renditionName(profile)
+	}
+
+	segmentTime := profiles[0].SegmentTime
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentTime),
+		"-hls_list_size", "0",
+	)
+	args = append(args, segmentArgs(&profiles[0], filepath.Join(baseDir, "%v"))...)
+	args = append(args, hlsFlagArgs(keys)...)
+	args = append(args,
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentTime),
+		"-var_stream_map", strings.Join(streams, " "),
+		filepath.Join(baseDir, "%v", "playlist.m3u8"),
+	)
+
+	return exec.Command(s.config.FFmpeg.FFmpegPath, args...)
+}
+
+// segmentArgs returns the HLS muxer arguments writing a profile's segments to dir,
+// as MPEG-TS or as fragmented MP4 with an initialization section
+func segmentArgs(profile *models.VideoProfile, dir string) []string {
+	if profile.SegmentFormat == SegmentFormatFMP4 {
+		return []string{
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, "%06d.m4s"),
+		}
+	}
+	return []string{"-hls_segment_filename", filepath.Join(dir, "%06d.ts")}
+}
+
+// hlsFlagArgs returns the HLS muxer flags and, for encrypted videos, the key info file;
+// FFmpeg rereads the file at every segment when keys rotate
+func hlsFlagArgs(keys *keyRotation) []string {
+	flags := "independent_segments+split_by_time"
+	var args []string
+	if keys != nil {
+		args = append(args, "-hls_key_info_file", keys.InfoFile())
+		if keys.Rotates() {
+			flags += "+periodic_rekey"
+		}
+	}
+	return append(args, "-hls_flags", flags)
+}
+
+// hlsVersion returns the protocol version a master playlist over the profiles needs:
+// 7 when any rendition uses fragmented MP4 segments, 3 otherwise
+func hlsVersion(profiles []models.VideoProfile) int {
+	for _, profile := range profiles {
+		if profile.SegmentFormat == SegmentFormatFMP4 {
+			return 7
+		}
 	}
+	return 3
 }
 
-// executeFFmpegCommand executes the FFmpeg command
-func (s *TranscodeService) executeFFmpegCommand(cmd *exec.Cmd, profileID uint) error {
+// videoFilter returns the filter scaling the source to the size fitted to it when
+// the profile was created, and converting the frame rate when the profile sets one
+func videoFilter(profile *models.VideoProfile) string {
+	width, height := profileDimensions(profile)
+	filter := fmt.Sprintf("scale=%d:%d,setsar=1", width, height)
+	if profile.FrameRate > 0 {
+		filter += ",fps=" + strconv.FormatFloat(profile.FrameRate, 'f', -1, 64)
+	}
+	return filter
+}
+
+// videoEncoderArgs returns the rate control and encoder tuning arguments of a profile
+// for the output stream selected by stream ("v", or "v:1" in a multi-rendition command)
+func videoEncoderArgs(profile *models.VideoProfile, encoder, stream string) []string {
+	args := []string{"-b:" + stream, fmt.Sprintf("%dk", profile.Bitrate)}
+	if profile.MaxRate > 0 {
+		bufSize := profile.BufSize
+		if bufSize == 0 {
+			bufSize = profile.MaxRate * 2
+		}
+		args = append(args,
+			"-maxrate:"+stream, fmt.Sprintf("%dk", profile.MaxRate),
+			"-bufsize:"+stream, fmt.Sprintf("%dk", bufSize),
+		)
+	}
+	return append(args, codecEncoderArgs(profile, encoder, stream)...)
+}
+
+// executeFFmpegCommand executes the FFmpeg command, reporting its progress against
+// the source duration (in seconds) on every profile it encodes while it runs, and
+// rotating the encryption key of encrypted videos as the output grows. It fails like
+// runFFmpeg.
+func (s *TranscodeService) executeFFmpegCommand(cmd *exec.Cmd, profileIDs []uint, duration float64, keys *keyRotation) error {
+	var lastDB, lastRedis time.Time
+	_, err := s.runFFmpeg(cmd, profileIDs, duration, func(report utils.FFmpegProgress) {
+		progress := transcodeProgress(report, duration)
+		now := time.Now()
+		if keys != nil {
+			keys.advance(report.OutTime)
+		}
+
+		if now.Sub(lastRedis) >= progressRedisInterval {
+			lastRedis = now
+			for _, profileID := range profileIDs {
+				s.publishProgress(profileID, progress)
+			}
+		}
+		if now.Sub(lastDB) >= progressDBInterval {
+			lastDB = now
+			s.db.Model(&models.VideoProfile{}).Where("id IN ?", profileIDs).
+				Update("progress_percentage", progress.Progress)
+		}
+	})
+
+	for _, profileID := range profileIDs {
+		s.clearProgress(profileID)
+	}
+	return err
+}
+
+// runFFmpeg runs an FFmpeg command writing its progress to stdout as an encode of the
+// given profiles, passing each progress report to report when set, and returns the
+// end of its log. It returns ErrCancelled when the profiles are cancelled before or
+// while it runs, and kills it when it runs out of time for a source of the given
+// duration (in seconds) or stalls. Other failures return an *ffmpegError with the end
+// of FFmpeg's log.
+func (s *TranscodeService) runFFmpeg(cmd *exec.Cmd, profileIDs []uint, duration float64, report func(utils.FFmpegProgress)) (string, error) {
+	encode, err := s.registerEncode(cmd, profileIDs)
+	if err != nil {
+		return "", err
+	}
+
+	stderr := &logTail{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.unregisterEncode(encode, profileIDs)
+		return "", err
+	}
+
 	// Start the command
-	if err := cmd.Start(); err != nil {
-		return err
+	if err := encode.start(); err != nil {
+		s.unregisterEncode(encode, profileIDs)
+		return "", err
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+	go s.watchEncode(encode, duration, done)
+
+	// Follow progress until FFmpeg closes its output
+	var outTime float64
+	utils.ReadFFmpegProgress(stdout, func(progress utils.FFmpegProgress) {
+		if progress.OutTime > outTime {
+			outTime = progress.OutTime
+			encode.progressed()
+		}
+		if report != nil {
+			report(progress)
+		}
+	})
+
 	// Wait for completion
-	if err := cmd.Wait(); err != nil {
-		return err
+	err = cmd.Wait()
+	if killed := s.unregisterEncode(encode, profileIDs); killed != nil {
+		return "", killed
 	}
+	if err != nil {
+		return "", &ffmpegError{err: err, log: stderr.String()}
+	}
+	return stderr.String(), nil
+}
 
-	// Update total segments count
-	outputDir := filepath.Dir(cmd.Args[len(cmd.Args)-1])
-	segmentCount := s.countSegments(outputDir)
-	s.updateSegmentCount(uint(profileID), segmentCount)
+// transcodeProgress converts an FFmpeg progress report into a percentage and ETA.
+// The percentage stays below 100 until the job is marked completed.
+func transcodeProgress(report utils.FFmpegProgress, duration float64) models.TranscodeProgress {
+	progress := models.TranscodeProgress{
+		OutTime:   report.OutTime,
+		Speed:     report.Speed,
+		UpdatedAt: time.Now(),
+	}
+	if duration <= 0 {
+		return progress
+	}
 
-	return nil
+	progress.Progress = int(report.OutTime / duration * 100)
+	if progress.Progress > 99 {
+		progress.Progress = 99
+	}
+	if progress.Progress < 0 {
+		progress.Progress = 0
+	}
+
+	if report.Speed > 0 {
+		remaining := duration - report.OutTime
+		if remaining < 0 {
+			remaining = 0
+		}
+		progress.ETA = int(math.Ceil(remaining / report.Speed))
+	}
+	return progress
 }
 
-// countSegments counts the number of .ts segments in a directory
-func (s *TranscodeService) countSegments(dir string) int {
-	files, err := filepath.Glob(filepath.Join(dir, "*.ts"))
+// publishProgress shares the live progress of a profile's encode through Redis
+func (s *TranscodeService) publishProgress(profileID uint, progress models.TranscodeProgress) {
+	if s.redis == nil {
+		return
+	}
+
+	data, err := json.Marshal(progress)
 	if err != nil {
-		return 0
+		return
 	}
-	return len(files)
+	ctx := context.Background()
+	if err := s.redis.Set(ctx, transcodeProgressKey(profileID), data, progressTTL).Err(); err != nil {
+		logrus.Warnf("Failed to publish progress of profile %d: %v", profileID, err)
+	}
+}
+
+// clearProgress removes the live progress of a profile once its encode has ended
+func (s *TranscodeService) clearProgress(profileID uint) {
+	if s.redis == nil {
+		return
+	}
+	s.redis.Del(context.Background(), transcodeProgressKey(profileID))
+}
+
+// transcodeProgressKey is the Redis key holding the live progress of a profile's encode
+func transcodeProgressKey(profileID uint) string {
+	return fmt.Sprintf("transcode:progress:%d", profileID)
+}
+
+// countSegments counts the number of .ts or .m4s segments in a directory
+func (s *TranscodeService) countSegments(dir string) int {
+	count := 0
+	for _, pattern := range []string{"*.ts", "*.m4s"} {
+		files, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err == nil {
+			count += len(files)
+		}
+	}
+	return count
 }
 
 // updateJobStatus updates the job status
@@ -208,8 +702,9 @@ func (s *TranscodeService) updateJobStatus(videoID, profileID uint, status, erro
 		updates["completed_at"] = &now
 	}
 
+	// Cancelled jobs stay cancelled
 	s.db.Model(&models.TranscodeJob{}).
-		Where("video_id = ? AND profile_id = ?", videoID, profileID).
+		Where("video_id = ? AND profile_id = ? AND status <> ?", videoID, profileID, "cancelled").
 		Updates(updates)
 }
 
@@ -232,7 +727,10 @@ func (s *TranscodeService) updateProfileStatus(profileID uint, status string, pr
 		updates["completed_at"] = &now
 	}
 
-	s.db.Model(&models.VideoProfile{}).Where("id = ?", profileID).Updates(updates)
+	// Cancelled profiles stay cancelled
+	s.db.Model(&models.VideoProfile{}).
+		Where("id = ? AND status <> ?", profileID, "cancelled").
+		Updates(updates)
 }
 
 // updatePlaylistPath updates the playlist path
@@ -263,16 +761,41 @@ func (s *TranscodeService) checkVideoCompletion(videoID uint) {
 	}
 
 	if allCompleted {
+		// Audio tracks and subtitles are written next to the renditions and listed in
+		// the master playlist
+		if s.audioService != nil {
+			s.audioService.EncodeTracks(videoID)
+		}
+		if s.subtitleService != nil {
+			s.subtitleService.ConvertPending(videoID)
+		}
+
 		// Generate and save master playlist
 		masterPath := s.generateAndSaveMasterPlaylist(videoID)
+		manifestPath := s.generateAndSaveManifest(videoID, masterPath)
+
+		updates := map[string]interface{}{
+			"status":        "completed",
+			"video_path":    masterPath,
+			"manifest_path": manifestPath,
+		}
+
+		// Previews are taken from the source before it is archived
+		var video models.Video
+		if masterPath != "" && s.db.First(&video, videoID).Error == nil {
+			thumbnails, err := s.generateThumbnails(&video, masterPath)
+			if err != nil {
+				logrus.Errorf("Failed to generate thumbnails for video %d: %v", videoID, err)
+			}
+			for column, value := range thumbnails {
+				updates[column] = value
+			}
+		}
 
 		// Update video status to completed and set video path
 		s.db.Model(&models.Video{}).
 			Where("id = ?", videoID).
-			Updates(map[string]interface{}{
-				"status":     "completed",
-				"video_path": masterPath,
-			})
+			Updates(updates)
 
 		// Archive original file
 		s.archiveOriginalFile(videoID)
@@ -283,12 +806,12 @@ func (s *TranscodeService) checkVideoCompletion(videoID uint) {
 func (s *TranscodeService) generateAndSaveMasterPlaylist(videoID uint) string {
 	// Get video and profiles
 	var video models.Video
-	if err := s.db.Preload("VideoProfiles").First(&video, videoID).Error; err != nil {
+	if err := s.db.Preload("VideoProfiles").Preload("Subtitles").Preload("AudioTracks").First(&video, videoID).Error; err != nil {
 		return ""
 	}
 
 	// Generate master playlist content
-	masterPlaylist := s.generateMasterPlaylistContent(video.VideoProfiles)
+	masterPlaylist := s.generateMasterPlaylistContent(&video)
 
 	// Save master playlist to file using the same path structure as transcoded files
 	// Use the same base directory as the first profile
@@ -319,17 +842,52 @@ func (s *TranscodeService) generateAndSaveMasterPlaylist(videoID uint) string {
 	return relativePath
 }
 
+// generateAndSaveManifest saves the DASH manifest of a video next to its master playlist;
+// it returns an empty path when the video has no fragmented MP4 renditions
+func (s *TranscodeService) generateAndSaveManifest(videoID uint, masterPath string) string {
+	var video models.Video
+	if masterPath == "" || s.db.Preload("VideoProfiles").First(&video, videoID).Error != nil {
+		return ""
+	}
+	if video.Encrypted || !hasFMP4Renditions(video.VideoProfiles) {
+		return ""
+	}
+
+	manifest, err := videoManifest(s.config.Storage.TranscodedPath, &video)
+	if err != nil {
+		logrus.Errorf("Failed to generate DASH manifest for video %d: %v", videoID, err)
+		return ""
+	}
+
+	relativePath := filepath.Join(filepath.Dir(masterPath), "manifest.mpd")
+	if err := ioutil.WriteFile(filepath.Join(s.config.Storage.TranscodedPath, relativePath), []byte(manifest), 0644); err != nil {
+		logrus.Errorf("Failed to save DASH manifest for video %d: %v", videoID, err)
+		return ""
+	}
+	return relativePath
+}
+
 // generateMasterPlaylistContent generates the master HLS playlist content
-func (s *TranscodeService) generateMasterPlaylistContent(profiles []models.VideoProfile) string {
+func (s *TranscodeService) generateMasterPlaylistContent(video *models.Video) string {
 	var content strings.Builder
 	content.WriteString("#EXTM3U\n")
-	content.WriteString("#EXT-X-VERSION:3\n\n")
+	content.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n\n", hlsVersion(video.VideoProfiles)))
 
-	for _, profile := range profiles {
+	media, groups := alternateMedia(video)
+	for _, tag := range media {
+		content.WriteString(tag + "\n")
+	}
+	if len(media) > 0 {
+		content.WriteString("\n")
+	}
+
+	for _, profile := range video.VideoProfiles {
 		if profile.Status == "completed" {
 			bandwidth := profile.Bitrate * 1000 // Convert to bits per second
-			content.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%s\n", bandwidth, profile.Resolution))
-			content.WriteString(fmt.Sprintf("%s/playlist.m3u8\n\n", profile.Resolution))
+			width, height := profileDimensions(&profile)
+			content.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"%s\n",
+				bandwidth, width, height, codecsAttribute(&profile), groups))
+			content.WriteString(fmt.Sprintf("%s/playlist.m3u8\n\n", renditionName(&profile)))
 		}
 	}
 
@@ -5,11 +5,10 @@ import (
 	"io"
 	"linier-channel/internal/config"
 	"linier-channel/internal/models"
+	"linier-channel/internal/utils"
 	"mime/multipart"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +20,8 @@ type UploadService struct {
 	db               *gorm.DB
 	config           *config.Config
 	transcodeService *TranscodeService
+	presetService    *PresetService
+	keyService       *KeyService
 }
 
 func NewUploadService(db *gorm.DB, cfg *config.Config) *UploadService {
@@ -35,13 +36,32 @@ func (s *UploadService) SetTranscodeService(transcodeService *TranscodeService)
 	s.transcodeService = transcodeService
 }
 
-// UploadVideo handles video file upload
-func (s *UploadService) UploadVideo(file *multipart.FileHeader) (*models.UploadVideoResponse, error) {
+// SetPresetService sets the encoding preset service the profiles of new videos come from
+func (s *UploadService) SetPresetService(presetService *PresetService) {
+	s.presetService = presetService
+}
+
+// SetKeyService sets the key service encrypted videos are checked against
+func (s *UploadService) SetKeyService(keyService *KeyService) {
+	s.keyService = keyService
+}
+
+// UploadVideo handles video file upload, transcoding it with the given encoding
+// preset or the default one when presetID is nil. The segments are encrypted when
+// encrypt is set or the preset asks for it.
+func (s *UploadService) UploadVideo(file *multipart.FileHeader, presetID *uint, encrypt bool) (*models.UploadVideoResponse, error) {
 	// Validate file
 	if err := s.validateFile(file); err != nil {
 		return nil, err
 	}
 
+	// Validate preset before anything is written
+	if presetID != nil {
+		if _, err := s.presetService.GetPreset(*presetID); err != nil {
+			return nil, fmt.Errorf("%w: encoding preset %d not found", ErrInvalidInput, *presetID)
+		}
+	}
+
 	// Create upload directory (no date structure for API uploads)
 	uploadDir := s.config.Storage.UploadPath
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -76,16 +96,15 @@ func (s *UploadService) UploadVideo(file *multipart.FileHeader) (*models.UploadV
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// Get video duration using FFprobe
-	duration, err := s.getVideoDuration(filePath)
+	// Probe duration and picture size using FFprobe
+	media, err := utils.ProbeMedia(s.config.FFmpeg.FFprobePath, filePath)
 	if err != nil {
-		logrus.Warnf("Failed to get video duration: %v", err)
-		duration = 0
+		logrus.Warnf("Failed to probe video: %v", err)
 	}
 
 	// Create video record in database with relative path
 	relativeFilePath := strings.TrimPrefix(filePath, s.config.Storage.UploadPath+"/")
-	video, err := s.createVideoRecord(file.Filename, relativeFilePath, fileInfo.Size(), duration)
+	video, err := s.createVideoRecord(file.Filename, relativeFilePath, fileInfo.Size(), media, presetID, nil, encrypt)
 	if err != nil {
 		// Clean up uploaded file if database operation fails
 		os.Remove(filePath)
@@ -98,6 +117,28 @@ func (s *UploadService) UploadVideo(file *multipart.FileHeader) (*models.UploadV
 	}, nil
 }
 
+// IngestFile registers a file already written under the upload path and queues it
+// for transcoding. A non-empty ladder overrides the default profiles.
+func (s *UploadService) IngestFile(filePath, originalFilename string, ladder []models.VideoProfile) (*models.Video, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	media, err := utils.ProbeMedia(s.config.FFmpeg.FFprobePath, filePath)
+	if err != nil {
+		logrus.Warnf("Failed to probe video: %v", err)
+	}
+
+	relativeFilePath := strings.TrimPrefix(filePath, s.config.Storage.UploadPath+"/")
+	video, err := s.createVideoRecord(originalFilename, relativeFilePath, fileInfo.Size(), media, nil, ladder, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create video record: %w", err)
+	}
+
+	return video, nil
+}
+
 // validateFile validates the uploaded file
 func (s *UploadService) validateFile(file *multipart.FileHeader) error {
 	// Check file size
@@ -124,37 +165,53 @@ func (s *UploadService) validateFile(file *multipart.FileHeader) error {
 	return nil
 }
 
-// getVideoDuration gets video duration using FFprobe
-func (s *UploadService) getVideoDuration(filePath string) (int, error) {
-	cmd := exec.Command(s.config.FFmpeg.FFprobePath,
-		"-v", "quiet",
-		"-show_entries", "format=duration",
-		"-of", "csv=p=0",
-		filePath)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
+// createVideoRecord creates a video record in the database. Without an explicit
+// ladder the profiles come from the encoding preset (the default one when presetID
+// is nil), fitted to the probed source without upscaling; an explicit ladder keeps
+// all of its rungs so the renditions line up with the content the video is stitched into.
+func (s *UploadService) createVideoRecord(filename, filePath string, fileSize int64, media *utils.MediaInfo, presetID *uint, ladder []models.VideoProfile, encrypt bool) (*models.Video, error) {
+	upscale := len(ladder) > 0
+	singlePass := false
+	keyRotation := 0
+	loudnessTarget := 0.0
+	if len(ladder) == 0 {
+		preset, presetLadder, err := s.presetService.Ladder(presetID)
+		if err != nil {
+			return nil, err
+		}
+		presetID, ladder, singlePass = &preset.ID, presetLadder, preset.SinglePass
+		encrypt = encrypt || preset.Encrypt
+		keyRotation = preset.KeyRotation
+		loudnessTarget = preset.LoudnessTarget
+	} else if preset, _, err := s.presetService.Ladder(nil); err == nil {
+		// Content stitched into channels matches the loudness of the default preset
+		loudnessTarget = preset.LoudnessTarget
 	}
-
-	durationStr := strings.TrimSpace(string(output))
-	duration, err := strconv.ParseFloat(durationStr, 64)
-	if err != nil {
-		return 0, err
+	if encrypt {
+		if s.keyService == nil {
+			return nil, fmt.Errorf("%w: encryption is not available", ErrInvalidInput)
+		}
+		if err := s.keyService.CheckConfigured(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
 	}
 
-	// Round to nearest integer
-	return int(duration + 0.5), nil
-}
+	duration := 0
+	if media != nil {
+		duration = int(media.Duration + 0.5)
+	}
 
-// createVideoRecord creates a video record in the database
-func (s *UploadService) createVideoRecord(filename, filePath string, fileSize int64, duration int) (*models.Video, error) {
 	video := &models.Video{
 		OriginalFilename: filename,
 		FilePath:         filePath,
 		FileSize:         fileSize,
 		Duration:         duration,
 		Status:           "uploaded",
+		PresetID:         presetID,
+		SinglePass:       singlePass,
+		Encrypted:        encrypt,
+		KeyRotation:      keyRotation,
+		LoudnessTarget:   loudnessTarget,
 	}
 
 	if err := s.db.Create(video).Error; err != nil {
@@ -162,28 +219,20 @@ func (s *UploadService) createVideoRecord(filename, filePath string, fileSize in
 	}
 
 	// Create video profiles for the video
-	profiles := []string{"720p", "480p", "360p"}
-	bitrates := []int{2000, 1000, 500}
-
-	for i, profile := range profiles {
-		videoProfile := &models.VideoProfile{
-			VideoID:      video.ID,
-			Resolution:   profile,
-			Bitrate:      bitrates[i],
-			AudioBitrate: 128,
-			SegmentTime:  4,
-			Status:       "pending",
-		}
+	for _, rung := range fitLadder(ladder, media, upscale) {
+		videoProfile := rung
+		videoProfile.VideoID = video.ID
+		videoProfile.Status = "pending"
 
-		if err := s.db.Create(videoProfile).Error; err != nil {
+		if err := s.db.Create(&videoProfile).Error; err != nil {
 			return nil, err
 		}
+	}
 
-		// Queue transcoding job for this profile
-		if s.transcodeService != nil {
-			if err := s.transcodeService.QueueTranscodeJob(video.ID, videoProfile.ID, 1); err != nil {
-				logrus.Errorf("Failed to queue transcoding job for video %d, profile %d: %v", video.ID, videoProfile.ID, err)
-			}
+	// Queue transcoding jobs for the profiles
+	if s.transcodeService != nil {
+		if err := s.transcodeService.QueueVideoJobs(video.ID, 1); err != nil {
+			logrus.Errorf("Failed to queue transcoding jobs for video %d: %v", video.ID, err)
 		}
 	}
 
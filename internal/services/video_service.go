@@ -1,62 +1,36 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"linier-channel/internal/models"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
 type VideoService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	redis *redis.Client
 }
 
 func NewVideoService(db *gorm.DB) *VideoService {
 	return &VideoService{db: db}
 }
 
-// CreateVideo creates a new video record
-func (s *VideoService) CreateVideo(filename, filePath string, fileSize int64, duration int) (*models.Video, error) {
-	video := &models.Video{
-		OriginalFilename: filename,
-		FilePath:         filePath,
-		FileSize:         fileSize,
-		Duration:         duration,
-		Status:           "uploaded",
-	}
-
-	if err := s.db.Create(video).Error; err != nil {
-		return nil, err
-	}
-
-	// Create video profiles for the video
-	profiles := []string{"720p", "480p", "360p"}
-	bitrates := []int{2000, 1000, 500}
-
-	for i, profile := range profiles {
-		videoProfile := &models.VideoProfile{
-			VideoID:      video.ID,
-			Resolution:   profile,
-			Bitrate:      bitrates[i],
-			AudioBitrate: 128,
-			SegmentTime:  4,
-			Status:       "pending",
-		}
-
-		if err := s.db.Create(videoProfile).Error; err != nil {
-			return nil, err
-		}
-	}
-
-	return video, nil
+// SetRedisClient sets the Redis client live transcode progress is read from
+func (s *VideoService) SetRedisClient(redis *redis.Client) {
+	s.redis = redis
 }
 
 // GetVideoByID retrieves a video by ID
 func (s *VideoService) GetVideoByID(id uint) (*models.Video, error) {
 	var video models.Video
-	if err := s.db.Preload("VideoProfiles").First(&video, id).Error; err != nil {
+	if err := s.db.Preload("VideoProfiles").Preload("Subtitles").Preload("AudioTracks").First(&video, id).Error; err != nil {
 		return nil, err
 	}
+	setThumbnailURLs(&video)
 	return &video, nil
 }
 
@@ -73,19 +47,34 @@ func (s *VideoService) GetVideoStatus(id uint) (*models.VideoStatusResponse, err
 	overallProgress := 0
 
 	var profiles []models.VideoProfileStatus
+	eta := 0
 	for _, profile := range video.VideoProfiles {
 		if profile.Status == "completed" {
 			completedProfiles++
 		}
-		overallProgress += profile.ProgressPercentage
 
-		profiles = append(profiles, models.VideoProfileStatus{
+		status := models.VideoProfileStatus{
 			ProfileID:  profile.ID,
 			Resolution: profile.Resolution,
 			Status:     profile.Status,
 			Progress:   profile.ProgressPercentage,
 			Error:      profile.ErrorMessage,
-		})
+		}
+
+		// Running encodes publish fresher progress than the database holds
+		if profile.Status == "processing" {
+			if live := s.liveProgress(profile.ID); live != nil {
+				status.Progress = live.Progress
+				status.Speed = live.Speed
+				status.ETA = live.ETA
+				if live.ETA > eta {
+					eta = live.ETA
+				}
+			}
+		}
+
+		overallProgress += status.Progress
+		profiles = append(profiles, status)
 	}
 
 	if totalProfiles > 0 {
@@ -104,11 +93,30 @@ func (s *VideoService) GetVideoStatus(id uint) (*models.VideoStatusResponse, err
 		VideoID:   video.ID,
 		Status:    overallStatus,
 		Progress:  overallProgress,
+		ETA:       eta,
 		Profiles:  profiles,
 		CreatedAt: video.CreatedAt,
 	}, nil
 }
 
+// liveProgress returns the progress a running encode published to Redis, if any
+func (s *VideoService) liveProgress(profileID uint) *models.TranscodeProgress {
+	if s.redis == nil {
+		return nil
+	}
+
+	data, err := s.redis.Get(context.Background(), transcodeProgressKey(profileID)).Bytes()
+	if err != nil {
+		return nil
+	}
+
+	var progress models.TranscodeProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil
+	}
+	return &progress
+}
+
 // UpdateVideoStatus updates the video status
 func (s *VideoService) UpdateVideoStatus(id uint, status string, errorMessage string) error {
 	updates := map[string]interface{}{
@@ -171,6 +179,9 @@ func (s *VideoService) GetVideos(limit, offset int) ([]models.Video, error) {
 		Find(&videos).Error; err != nil {
 		return nil, err
 	}
+	for i := range videos {
+		setThumbnailURLs(&videos[i])
+	}
 	return videos, nil
 }
 
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchdogInterval is how often running encodes are checked for stalls
+const watchdogInterval = time.Second
+
+// encodeTimeLimit returns how long an encode of a source of the given duration, in
+// seconds, may run: the configured multiple of the duration, but at least the minimum
+// timeout. It is 0 when encodes are not limited.
+func (s *TranscodeService) encodeTimeLimit(duration float64) time.Duration {
+	transcode := s.config.Transcode
+	if transcode.TimeoutMultiplier <= 0 {
+		return 0
+	}
+	limit := time.Duration(duration * transcode.TimeoutMultiplier * float64(time.Second))
+	if minimum := time.Duration(transcode.MinTimeout) * time.Second; limit < minimum {
+		limit = minimum
+	}
+	return limit
+}
+
+// watchEncode kills an encode that runs past its time limit or whose output stops
+// growing for the stall timeout, until done is closed
+func (s *TranscodeService) watchEncode(encode *runningEncode, duration float64, done <-chan struct{}) {
+	var deadline <-chan time.Time
+	limit := s.encodeTimeLimit(duration)
+	if limit > 0 {
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	stall := time.Duration(s.config.Transcode.StallTimeout) * time.Second
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			err := fmt.Errorf("%w: still running after %s, the limit for a %.0fs source", ErrTimedOut, limit, duration)
+			logrus.Warnf("Killing encode: %v", err)
+			encode.kill(err)
+			return
+		case <-ticker.C:
+			if stall <= 0 {
+				continue
+			}
+			if stalled := encode.stalledFor(); stalled >= stall {
+				err := fmt.Errorf("%w: no progress for %s", ErrStalled, stalled.Round(time.Second))
+				logrus.Warnf("Killing encode: %v", err)
+				encode.kill(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"linier-channel/internal/config"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestEncodeTimeLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		multiplier float64
+		minimum    int
+		duration   float64
+		want       time.Duration
+	}{
+		{name: "multiple of the duration", multiplier: 3, minimum: 60, duration: 600, want: 1800 * time.Second},
+		{name: "fractional multiplier", multiplier: 1.5, minimum: 60, duration: 100, want: 150 * time.Second},
+		{name: "short source gets the minimum", multiplier: 3, minimum: 60, duration: 10, want: 60 * time.Second},
+		{name: "unprobed source gets the minimum", multiplier: 3, minimum: 60, duration: 0, want: 60 * time.Second},
+		{name: "no minimum", multiplier: 3, duration: 10, want: 30 * time.Second},
+		{name: "zero multiplier disables the limit", multiplier: 0, minimum: 60, duration: 600, want: 0},
+		{name: "negative multiplier disables the limit", multiplier: -1, minimum: 60, duration: 600, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Transcode.TimeoutMultiplier = tt.multiplier
+			cfg.Transcode.MinTimeout = tt.minimum
+			s := &TranscodeService{config: cfg}
+
+			if got := s.encodeTimeLimit(tt.duration); got != tt.want {
+				t.Errorf("encodeTimeLimit(%v) = %s, want %s", tt.duration, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchEncode(t *testing.T) {
+	tests := []struct {
+		name       string
+		slow       string // FAKE_FFMPEG_SLOW of the encode, which runs until it is killed
+		multiplier float64
+		minimum    int
+		stall      int
+		wantErr    error // nil when the watchdog leaves the encode running
+		wantAfter  time.Duration
+		wantBefore time.Duration
+	}{
+		{
+			name: "stalled encode", slow: "stall", stall: 1,
+			wantErr: ErrStalled, wantAfter: time.Second, wantBefore: 3 * time.Second,
+		},
+		{
+			name: "encode past its minimum time limit", slow: "1", multiplier: 0.001, minimum: 2, stall: 1,
+			wantErr: ErrTimedOut, wantAfter: 2 * time.Second, wantBefore: 4 * time.Second,
+		},
+		{
+			name: "limits disabled", slow: "1", multiplier: 0, minimum: 1, stall: 1,
+			wantBefore: 3 * time.Second,
+		},
+		{
+			name: "stall timeout disabled", slow: "stall", multiplier: 0, stall: 0,
+			wantBefore: 3 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := newTestDB(t)
+			cfg := &config.Config{}
+			cfg.Transcode.TimeoutMultiplier = tt.multiplier
+			cfg.Transcode.MinTimeout = tt.minimum
+			cfg.Transcode.StallTimeout = tt.stall
+			s := NewTranscodeService(db, cfg)
+
+			cmd := exec.Command(os.Args[0], "-i", "source.mp4", "-af", "loudnorm=print_format=json",
+				"-progress", "pipe:1", "-f", "null", "-")
+			cmd.Env = append(os.Environ(), "FAKE_FFMPEG=1", "FAKE_FFMPEG_SLOW="+tt.slow)
+
+			started := time.Now()
+			result := make(chan error, 1)
+			go func() {
+				_, err := s.runFFmpeg(cmd, []uint{5}, 60, nil)
+				result <- err
+			}()
+
+			select {
+			case err := <-result:
+				elapsed := time.Since(started)
+				if tt.wantErr == nil {
+					t.Fatalf("runFFmpeg() = %v after %s, want the encode left running", err, elapsed)
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("runFFmpeg() error = %v, want %v", err, tt.wantErr)
+				}
+				if elapsed < tt.wantAfter {
+					t.Errorf("killed after %s, want at least %s", elapsed, tt.wantAfter)
+				}
+			case <-time.After(tt.wantBefore):
+				s.killEncode(5)
+				err := <-result
+				if tt.wantErr != nil {
+					t.Fatalf("encode still running after %s, want %v", tt.wantBefore, tt.wantErr)
+				}
+				if !errors.Is(err, ErrCancelled) {
+					t.Errorf("runFFmpeg() error = %v, want ErrCancelled", err)
+				}
+			}
+		})
+	}
+}
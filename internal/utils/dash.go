@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DASH manifest types and profiles
+const (
+	DASHStatic       = "static"
+	DASHDynamic      = "dynamic"
+	DASHMainProfile  = "urn:mpeg:dash:profile:isoff-main:2011" // allows segment lists
+	dashNamespace    = "urn:mpeg:dash:schema:mpd:2011"
+	dashTimescale    = 1000
+	dashTimeRFC3339Z = "2006-01-02T15:04:05.000Z"
+)
+
+// MPD is an MPEG-DASH media presentation description
+type MPD struct {
+	XMLName                    xml.Name     `xml:"MPD"`
+	Xmlns                      string       `xml:"xmlns,attr"`
+	Profiles                   string       `xml:"profiles,attr"`
+	Type                       string       `xml:"type,attr"`
+	MediaPresentationDuration  string       `xml:"mediaPresentationDuration,attr,omitempty"`
+	AvailabilityStartTime      string       `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                string       `xml:"publishTime,attr,omitempty"`
+	MinimumUpdatePeriod        string       `xml:"minimumUpdatePeriod,attr,omitempty"`
+	TimeShiftBufferDepth       string       `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay string       `xml:"suggestedPresentationDelay,attr,omitempty"`
+	MinBufferTime              string       `xml:"minBufferTime,attr"`
+	Periods                    []DASHPeriod `xml:"Period"`
+}
+
+// DASHPeriod is a stretch of the presentation sharing one set of encodings
+type DASHPeriod struct {
+	ID             string              `xml:"id,attr"`
+	Start          string              `xml:"start,attr,omitempty"`
+	AdaptationSets []DASHAdaptationSet `xml:"AdaptationSet"`
+}
+
+// DASHAdaptationSet groups interchangeable representations
+type DASHAdaptationSet struct {
+	MimeType         string               `xml:"mimeType,attr"`
+	SegmentAlignment bool                 `xml:"segmentAlignment,attr"`
+	Representations  []DASHRepresentation `xml:"Representation"`
+}
+
+// DASHRepresentation is one encoding of the content
+type DASHRepresentation struct {
+	ID          string           `xml:"id,attr"`
+	Bandwidth   int              `xml:"bandwidth,attr"`
+	Width       int              `xml:"width,attr,omitempty"`
+	Height      int              `xml:"height,attr,omitempty"`
+	Codecs      string           `xml:"codecs,attr,omitempty"`
+	SegmentList *DASHSegmentList `xml:"SegmentList"`
+}
+
+// DASHSegmentList lists the segments of a representation with their timeline
+type DASHSegmentList struct {
+	Timescale              int              `xml:"timescale,attr"`
+	PresentationTimeOffset int64            `xml:"presentationTimeOffset,attr,omitempty"`
+	Initialization         *DASHURL         `xml:"Initialization"`
+	Timeline               []DASHTimelineS  `xml:"SegmentTimeline>S"`
+	SegmentURLs            []DASHSegmentURL `xml:"SegmentURL"`
+}
+
+// DASHURL references the initialization section of a representation
+type DASHURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+// DASHTimelineS is one entry of a segment timeline, in timescale units
+type DASHTimelineS struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+}
+
+// DASHSegmentURL references a media segment
+type DASHSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// NewDASHSegmentList builds the segment list of fragmented MP4 HLS segments, prefixing
+// their URIs. The timeline starts at the media time of the first segment; offset is
+// the media time, in seconds, at which the period starts.
+func NewDASHSegmentList(segments []HLSSegment, prefix string, offset float64) *DASHSegmentList {
+	list := &DASHSegmentList{
+		Timescale:              dashTimescale,
+		PresentationTimeOffset: int64(math.Round(offset * dashTimescale)),
+	}
+	if len(segments) == 0 {
+		return list
+	}
+
+	if segments[0].Map != "" {
+		list.Initialization = &DASHURL{SourceURL: prefix + segments[0].Map}
+	}
+	start := int64(math.Round(segments[0].Start * dashTimescale))
+	for i, segment := range segments {
+		entry := DASHTimelineS{D: int64(math.Round(segment.Duration * dashTimescale))}
+		if i == 0 {
+			entry.T = &start
+		}
+		list.Timeline = append(list.Timeline, entry)
+		list.SegmentURLs = append(list.SegmentURLs, DASHSegmentURL{Media: prefix + segment.URI})
+	}
+	return list
+}
+
+// DASHDuration formats a duration as an ISO 8601 duration in seconds
+func DASHDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// DASHTime formats a wall clock time for MPD attributes
+func DASHTime(t time.Time) string {
+	return t.UTC().Format(dashTimeRFC3339Z)
+}
+
+// MarshalMPD renders a manifest as an XML document
+func MarshalMPD(mpd *MPD) (string, error) {
+	mpd.Xmlns = dashNamespace
+	if mpd.Profiles == "" {
+		mpd.Profiles = DASHMainProfile
+	}
+	data, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data) + "\n", nil
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bufio"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HLSSegment represents a single media segment entry of an HLS media playlist
+type HLSSegment struct {
+	URI      string
+	Duration float64 // in seconds
+	Start    float64 // offset from the start of the playlist, in seconds
+	Map      string  // URI of the EXT-X-MAP initialization section, empty for MPEG-TS
+	Key      string  // attributes of the EXT-X-KEY the segment is encrypted with, empty in the clear
+}
+
+// ParseMediaPlaylist extracts the segments of an HLS media playlist in order
+func ParseMediaPlaylist(content string) []HLSSegment {
+	var segments []HLSSegment
+	var duration float64
+	var offset float64
+	var initMap string
+	var key string
+	pending := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			if idx := strings.Index(value, ","); idx >= 0 {
+				value = value[:idx]
+			}
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				d = 0
+			}
+			duration = d
+			pending = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			initMap = AttributeValue(strings.TrimPrefix(line, "#EXT-X-MAP:"), "URI")
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-KEY:") {
+			key = strings.TrimPrefix(line, "#EXT-X-KEY:")
+			if AttributeValue(key, "METHOD") == "NONE" {
+				key = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if pending {
+			segments = append(segments, HLSSegment{
+				URI:      line,
+				Duration: duration,
+				Start:    offset,
+				Map:      initMap,
+				Key:      key,
+			})
+			offset += duration
+			pending = false
+		}
+	}
+
+	return segments
+}
+
+// TotalDuration returns the summed duration of the given segments in seconds
+func TotalDuration(segments []HLSSegment) float64 {
+	total := 0.0
+	for _, segment := range segments {
+		total += segment.Duration
+	}
+	return total
+}
+
+// AttributeValue returns an attribute of an HLS tag attribute list, unquoted
+func AttributeValue(attributes, name string) string {
+	for len(attributes) > 0 {
+		key, rest, ok := strings.Cut(attributes, "=")
+		if !ok {
+			return ""
+		}
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				return ""
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		if strings.TrimSpace(key) == name {
+			return value
+		}
+		attributes = strings.TrimPrefix(rest, ",")
+	}
+	return ""
+}
+
+// SegmentContentType returns the MIME type of an HLS segment or initialization section by its extension
+func SegmentContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	default:
+		return "video/mp2t"
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMediaPlaylist(t *testing.T) {
+	const key = `METHOD=AES-128,URI="/api/v1/keys/3",IV=0x0102`
+
+	tests := []struct {
+		name    string
+		content string
+		want    []HLSSegment
+	}{
+		{
+			name:    "empty",
+			content: "",
+			want:    nil,
+		},
+		{
+			name:    "MPEG-TS segments",
+			content: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000000,\nsegment_000.ts\n#EXTINF:4.5,\nsegment_001.ts\n#EXT-X-ENDLIST\n",
+			want: []HLSSegment{
+				{URI: "segment_000.ts", Duration: 6},
+				{URI: "segment_001.ts", Duration: 4.5, Start: 6},
+			},
+		},
+		{
+			name:    "CRLF line endings and blank lines",
+			content: "#EXTM3U\r\n\r\n#EXTINF:2.0,title\r\n\r\nsegment_000.ts\r\n",
+			want:    []HLSSegment{{URI: "segment_000.ts", Duration: 2}},
+		},
+		{
+			name:    "fragmented MP4 with initialization section",
+			content: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6,\nsegment_000.m4s\n#EXTINF:6,\nsegment_001.m4s\n",
+			want: []HLSSegment{
+				{URI: "segment_000.m4s", Duration: 6, Map: "init.mp4"},
+				{URI: "segment_001.m4s", Duration: 6, Start: 6, Map: "init.mp4"},
+			},
+		},
+		{
+			name:    "key applies until the next key",
+			content: "#EXTM3U\n#EXT-X-KEY:" + key + "\n#EXTINF:6,\nsegment_000.ts\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:6,\nsegment_001.ts\n",
+			want: []HLSSegment{
+				{URI: "segment_000.ts", Duration: 6, Key: key},
+				{URI: "segment_001.ts", Duration: 6, Start: 6},
+			},
+		},
+		{
+			name:    "URI without EXTINF is ignored",
+			content: "#EXTM3U\nstray.ts\n#EXTINF:6,\nsegment_000.ts\nstray.ts\n",
+			want:    []HLSSegment{{URI: "segment_000.ts", Duration: 6}},
+		},
+		{
+			name:    "invalid duration",
+			content: "#EXTINF:abc,\nsegment_000.ts\n#EXTINF:6,\nsegment_001.ts\n",
+			want: []HLSSegment{
+				{URI: "segment_000.ts"},
+				{URI: "segment_001.ts", Duration: 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMediaPlaylist(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMediaPlaylist() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttributeValue(t *testing.T) {
+	const attributes = `METHOD=AES-128,URI="/api/v1/keys/3?expires=1&token=a,b",IV=0x0102,KEYFORMAT="identity"`
+
+	tests := []struct {
+		name       string
+		attributes string
+		attribute  string
+		want       string
+	}{
+		{name: "unquoted first", attributes: attributes, attribute: "METHOD", want: "AES-128"},
+		{name: "quoted with comma", attributes: attributes, attribute: "URI", want: "/api/v1/keys/3?expires=1&token=a,b"},
+		{name: "unquoted after quoted", attributes: attributes, attribute: "IV", want: "0x0102"},
+		{name: "quoted last", attributes: attributes, attribute: "KEYFORMAT", want: "identity"},
+		{name: "missing", attributes: attributes, attribute: "KEYFORMATVERSIONS", want: ""},
+		{name: "name is not a suffix match", attributes: `XURI="a",URI="b"`, attribute: "URI", want: "b"},
+		{name: "empty quoted value", attributes: `URI="",IV=1`, attribute: "IV", want: "1"},
+		{name: "unterminated quote", attributes: `URI="init.mp4`, attribute: "URI", want: ""},
+		{name: "no attributes", attributes: "", attribute: "URI", want: ""},
+		{name: "no value", attributes: "METHOD", attribute: "METHOD", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AttributeValue(tt.attributes, tt.attribute); got != tt.want {
+				t.Errorf("AttributeValue(%q, %q) = %q, want %q", tt.attributes, tt.attribute, got, tt.want)
+			}
+		})
+	}
+}
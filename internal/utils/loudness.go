@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrSilentAudio is returned when audio has no measurable loudness
+var ErrSilentAudio = errors.New("audio is silent")
+
+// LoudnessMeasurement is the analysis of FFmpeg's loudnorm filter over an audio stream
+type LoudnessMeasurement struct {
+	Integrated float64 // integrated loudness, in LUFS
+	TruePeak   float64 // in dBTP
+	LRA        float64 // loudness range, in LU
+	Threshold  float64 // gating threshold, in LUFS
+	Offset     float64 // gain offset the normalisation pass applies, in LU
+}
+
+// LoudnessCommand returns the FFmpeg command running loudnorm's first pass over an
+// audio stream of a file (an index among its audio streams), against the integrated
+// loudness, true peak and loudness range targets. Progress is written to stdout; the
+// summary ParseLoudnormOutput reads ends the log.
+func LoudnessCommand(ffmpegPath, path string, stream int, target, truePeak, lra float64) *exec.Cmd {
+	return exec.Command(ffmpegPath,
+		"-hide_banner",
+		"-progress", "pipe:1",
+		"-nostats",
+		"-i", path,
+		"-map", fmt.Sprintf("0:a:%d", stream),
+		"-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=json", target, truePeak, lra),
+		"-f", "null",
+		"-")
+}
+
+// ParseLoudnormOutput reads the JSON summary loudnorm prints at the end of FFmpeg's log.
+// Silent audio, whose loudness is -inf, cannot be normalised and returns ErrSilentAudio.
+func ParseLoudnormOutput(output string) (*LoudnessMeasurement, error) {
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudnorm summary in FFmpeg output")
+	}
+
+	var summary map[string]string
+	if err := json.Unmarshal([]byte(output[start:end+1]), &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm summary: %w", err)
+	}
+
+	values := make(map[string]float64)
+	for _, key := range []string{"input_i", "input_tp", "input_lra", "input_thresh", "target_offset"} {
+		value, err := strconv.ParseFloat(strings.TrimSpace(summary[key]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid loudnorm %s %q", key, summary[key])
+		}
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, ErrSilentAudio
+		}
+		values[key] = value
+	}
+
+	return &LoudnessMeasurement{
+		Integrated: values["input_i"],
+		TruePeak:   values["input_tp"],
+		LRA:        values["input_lra"],
+		Threshold:  values["input_thresh"],
+		Offset:     values["target_offset"],
+	}, nil
+}
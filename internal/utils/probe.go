@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo describes a source file as reported by ffprobe
+type MediaInfo struct {
+	Duration float64 // in seconds
+	Width    int     // coded width of the first video stream
+	Height   int     // coded height of the first video stream
+	Rotation int     // display rotation in degrees, a multiple of 90
+	Audio    int     // number of audio streams
+
+	// DisplayAspect is the width/height ratio the picture is shown at, after the
+	// sample aspect ratio and rotation are applied
+	DisplayAspect float64
+}
+
+// DisplaySize returns the picture size as shown, after sample aspect ratio and rotation
+func (m *MediaInfo) DisplaySize() (int, int) {
+	if m.Width == 0 || m.Height == 0 {
+		return 0, 0
+	}
+	height := m.Height
+	if m.Rotation%180 != 0 {
+		height = m.Width
+	}
+	return int(math.Round(float64(height) * m.DisplayAspect)), height
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType         string            `json:"codec_type"`
+		Width             int               `json:"width"`
+		Height            int               `json:"height"`
+		SampleAspectRatio string            `json:"sample_aspect_ratio"`
+		Tags              map[string]string `json:"tags"`
+		SideDataList      []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// ProbeMedia reads the duration, picture geometry and audio streams of a source file with ffprobe
+func ProbeMedia(ffprobePath, path string) (*MediaInfo, error) {
+	cmd := exec.Command(ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_entries", "stream=codec_type,width,height,sample_aspect_ratio:stream_tags=rotate:stream_side_data=rotation:format=duration",
+		path)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	video := -1
+	for i, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "audio":
+			info.Audio++
+		case "video":
+			if video < 0 {
+				video = i
+			}
+		}
+	}
+	if video < 0 {
+		return info, nil
+	}
+	stream := probe.Streams[video]
+	info.Width = stream.Width
+	info.Height = stream.Height
+
+	// Rotation comes from the display matrix, or the rotate tag of older muxers
+	rotation := 0.0
+	for _, sideData := range stream.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = sideData.Rotation
+		}
+	}
+	if rotation == 0 {
+		rotation, _ = strconv.ParseFloat(stream.Tags["rotate"], 64)
+	}
+	info.Rotation = ((int(math.Round(rotation/90))*90)%360 + 360) % 360
+
+	if info.Width > 0 && info.Height > 0 {
+		aspect := float64(info.Width) * parseRatio(stream.SampleAspectRatio) / float64(info.Height)
+		if info.Rotation%180 != 0 {
+			aspect = 1 / aspect
+		}
+		info.DisplayAspect = aspect
+	}
+
+	return info, nil
+}
+
+// parseRatio parses an ffprobe ratio such as "4:3", returning 1 when it is unset or invalid
+func parseRatio(value string) float64 {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 1
+	}
+	num, err1 := strconv.ParseFloat(parts[0], 64)
+	den, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+		return 1
+	}
+	return num / den
+}
+
+// StreamInfo describes the codec of a stream as reported by ffprobe
+type StreamInfo struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	CodecTag  string `json:"codec_tag_string"`
+	Profile   string `json:"profile"`
+	Level     int    `json:"level"` // in the codec's own scale, negative when unknown
+	PixFmt    string `json:"pix_fmt"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// BitDepth returns the luma bit depth of a video stream, from its pixel format
+func (s *StreamInfo) BitDepth() int {
+	switch {
+	case strings.Contains(s.PixFmt, "p10"):
+		return 10
+	case strings.Contains(s.PixFmt, "p12"):
+		return 12
+	default:
+		return 8
+	}
+}
+
+// ProbeStreams reads the codecs of the streams of a media file with ffprobe
+func ProbeStreams(ffprobePath, path string) ([]StreamInfo, error) {
+	cmd := exec.Command(ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_entries", "stream=codec_type,codec_name,codec_tag_string,profile,level,pix_fmt,width,height",
+		path)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe struct {
+		Streams []StreamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	return probe.Streams, nil
+}
+
+// AudioStreamInfo describes an audio stream of a source file as reported by ffprobe
+type AudioStreamInfo struct {
+	Channels    int               `json:"channels"`
+	Tags        map[string]string `json:"tags"` // language and title, when the muxer set them
+	Disposition struct {
+		Default int `json:"default"`
+	} `json:"disposition"`
+}
+
+// Language returns the language tag of the stream, "und" when it is unset
+func (a *AudioStreamInfo) Language() string {
+	if language := strings.TrimSpace(a.Tags["language"]); language != "" {
+		return language
+	}
+	return "und"
+}
+
+// ProbeAudioStreams reads the audio streams of a source file, in stream order, with ffprobe
+func ProbeAudioStreams(ffprobePath, path string) ([]AudioStreamInfo, error) {
+	cmd := exec.Command(ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-select_streams", "a",
+		"-show_entries", "stream=channels:stream_tags=language,title:stream_disposition=default",
+		path)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe struct {
+		Streams []AudioStreamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	return probe.Streams, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FFmpegProgress is one block of the key=value report FFmpeg writes with -progress
+type FFmpegProgress struct {
+	OutTime float64 // seconds of output written so far
+	Speed   float64 // encode speed relative to real time, 0 when unknown
+	Done    bool    // the final report of the run
+}
+
+// ReadFFmpegProgress parses the -progress output of FFmpeg until the stream ends,
+// calling report after each block
+func ReadFFmpegProgress(r io.Reader, report func(FFmpegProgress)) {
+	var progress FFmpegProgress
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// Both are in microseconds; out_time_ms is misnamed by FFmpeg
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				progress.OutTime = float64(us) / 1e6
+			}
+		case "speed":
+			speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+			if err != nil {
+				speed = 0
+			}
+			progress.Speed = speed
+		case "progress":
+			progress.Done = value == "end"
+			report(progress)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// SpliceInsertHex encodes an immediate SCTE-35 splice_insert command as the
+// 0x-prefixed hex string used by the SCTE35-OUT/SCTE35-IN DATERANGE attributes.
+// A positive duration (in seconds) is carried as an auto-return break duration.
+func SpliceInsertHex(eventID uint32, outOfNetwork bool, duration float64) string {
+	// splice_insert()
+	command := []byte{
+		byte(eventID >> 24), byte(eventID >> 16), byte(eventID >> 8), byte(eventID),
+		0x7F, // splice_event_cancel_indicator = 0, reserved
+	}
+
+	flags := byte(0x40 | 0x10 | 0x0F) // program_splice_flag, splice_immediate_flag, reserved
+	if outOfNetwork {
+		flags |= 0x80
+	}
+	hasDuration := duration > 0
+	if hasDuration {
+		flags |= 0x20
+	}
+	command = append(command, flags)
+
+	if hasDuration {
+		ticks := uint64(duration*90000) & 0x1FFFFFFFF // 90kHz clock, 33 bits
+		command = append(command,
+			0x80|0x7E|byte(ticks>>32), // auto_return = 1, reserved, top bit of duration
+			byte(ticks>>24), byte(ticks>>16), byte(ticks>>8), byte(ticks),
+		)
+	}
+
+	command = append(command,
+		0x00, 0x00, // unique_program_id
+		0x00, // avail_num
+		0x00, // avails_expected
+	)
+
+	// splice_info_section() without descriptors
+	section := []byte{
+		0xFC,       // table_id
+		0x00, 0x00, // section_syntax_indicator, private_indicator, sap_type, section_length (filled below)
+		0x00,                         // protocol_version
+		0x00, 0x00, 0x00, 0x00, 0x00, // encrypted_packet, encryption_algorithm, pts_adjustment
+		0x00,                               // cw_index
+		0xFF, 0xF0 | byte(len(command)>>8), // tier = 0xFFF, splice_command_length
+		byte(len(command)),
+		0x05, // splice_command_type = splice_insert
+	}
+	section = append(section, command...)
+	section = append(section, 0x00, 0x00) // descriptor_loop_length
+
+	// section_length counts everything after the field itself, including the CRC
+	sectionLength := len(section) - 3 + 4
+	section[1] = 0x30 | byte(sectionLength>>8) // sap_type = 3 (not specified)
+	section[2] = byte(sectionLength)
+
+	crc := crc32MPEG2(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	return "0x" + strings.ToUpper(hex.EncodeToString(section))
+}
+
+// crc32MPEG2 computes the CRC-32/MPEG-2 checksum used by SCTE-35 sections
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
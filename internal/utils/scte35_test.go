@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSpliceInsertHex(t *testing.T) {
+	tests := []struct {
+		name         string
+		eventID      uint32
+		outOfNetwork bool
+		duration     float64
+		wantFlags    byte
+		wantTicks    uint64 // break duration on the 90 kHz clock, 0 without one
+	}{
+		{name: "break out with duration", eventID: 1, outOfNetwork: true, duration: 30, wantFlags: 0xFF, wantTicks: 2700000},
+		{name: "break out with fractional duration", eventID: 0xDEADBEEF, outOfNetwork: true, duration: 15.5, wantFlags: 0xFF, wantTicks: 1395000},
+		{name: "break out without duration", eventID: 42, outOfNetwork: true, wantFlags: 0xDF},
+		{name: "break in", eventID: 42, wantFlags: 0x5F},
+		{name: "negative duration is ignored", eventID: 7, outOfNetwork: true, duration: -5, wantFlags: 0xDF},
+		{name: "duration past 33 bits wraps", eventID: 9, outOfNetwork: true, duration: 100000, wantFlags: 0xFF, wantTicks: 9000000000 & 0x1FFFFFFFF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := SpliceInsertHex(tt.eventID, tt.outOfNetwork, tt.duration)
+			if !strings.HasPrefix(encoded, "0x") || strings.ToUpper(encoded[2:]) != encoded[2:] {
+				t.Fatalf("SpliceInsertHex() = %q, want 0x-prefixed upper-case hex", encoded)
+			}
+			section, err := hex.DecodeString(encoded[2:])
+			if err != nil {
+				t.Fatalf("SpliceInsertHex() = %q is not hex: %v", encoded, err)
+			}
+
+			if section[0] != 0xFC {
+				t.Errorf("table_id = %#x, want 0xfc", section[0])
+			}
+			if length := int(section[1]&0x0F)<<8 | int(section[2]); length != len(section)-3 {
+				t.Errorf("section_length = %d, want %d", length, len(section)-3)
+			}
+			// The CRC of a section including its own CRC is zero
+			if crc := crc32MPEG2(section); crc != 0 {
+				t.Errorf("CRC mismatch: residue %#x", crc)
+			}
+
+			commandLength := int(section[11]&0x0F)<<8 | int(section[12])
+			if section[13] != 0x05 {
+				t.Fatalf("splice_command_type = %#x, want splice_insert", section[13])
+			}
+			command := section[14 : 14+commandLength]
+			if len(section) != 14+commandLength+2+4 {
+				t.Errorf("section is %d bytes, want %d", len(section), 14+commandLength+2+4)
+			}
+
+			if id := uint32(command[0])<<24 | uint32(command[1])<<16 | uint32(command[2])<<8 | uint32(command[3]); id != tt.eventID {
+				t.Errorf("splice_event_id = %d, want %d", id, tt.eventID)
+			}
+			if command[5] != tt.wantFlags {
+				t.Errorf("flags = %#x, want %#x", command[5], tt.wantFlags)
+			}
+
+			wantLength := 10
+			if tt.wantTicks > 0 {
+				wantLength = 15
+				if command[6]&0x80 == 0 {
+					t.Error("auto_return is not set")
+				}
+				ticks := uint64(command[6]&0x01)<<32 | uint64(command[7])<<24 | uint64(command[8])<<16 | uint64(command[9])<<8 | uint64(command[10])
+				if ticks != tt.wantTicks {
+					t.Errorf("break_duration = %d ticks, want %d", ticks, tt.wantTicks)
+				}
+			}
+			if commandLength != wantLength {
+				t.Errorf("splice_command_length = %d, want %d", commandLength, wantLength)
+			}
+		})
+	}
+}
+
+func TestCRC32MPEG2(t *testing.T) {
+	// The standard check value of CRC-32/MPEG-2
+	if got := crc32MPEG2([]byte("123456789")); got != 0x0376E6E7 {
+		t.Errorf("crc32MPEG2(\"123456789\") = %#x, want 0x376e6e7", got)
+	}
+}
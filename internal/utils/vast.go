@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VAST is a VAST 3/4 ad response
+type VAST struct {
+	XMLName xml.Name `xml:"VAST"`
+	Version string   `xml:"version,attr"`
+	Ads     []VASTAd `xml:"Ad"`
+}
+
+// VASTAd is a single ad of a VAST response, either inline or a wrapper
+type VASTAd struct {
+	ID       string       `xml:"id,attr"`
+	Sequence int          `xml:"sequence,attr"`
+	InLine   *VASTInLine  `xml:"InLine"`
+	Wrapper  *VASTWrapper `xml:"Wrapper"`
+}
+
+// VASTInLine carries the creatives of an ad
+type VASTInLine struct {
+	AdSystem    string         `xml:"AdSystem"`
+	AdTitle     string         `xml:"AdTitle"`
+	Impressions []string       `xml:"Impression"`
+	Creatives   []VASTCreative `xml:"Creatives>Creative"`
+}
+
+// VASTWrapper points to another VAST document and adds its own tracking
+type VASTWrapper struct {
+	AdSystem     string         `xml:"AdSystem"`
+	VASTAdTagURI string         `xml:"VASTAdTagURI"`
+	Impressions  []string       `xml:"Impression"`
+	Creatives    []VASTCreative `xml:"Creatives>Creative"`
+}
+
+// VASTCreative is a creative of an ad; only linear creatives are used
+type VASTCreative struct {
+	ID     string      `xml:"id,attr"`
+	AdID   string      `xml:"AdID,attr"`
+	Linear *VASTLinear `xml:"Linear"`
+}
+
+// VASTLinear is a linear (in-stream) creative
+type VASTLinear struct {
+	Duration       string          `xml:"Duration"`
+	TrackingEvents []VASTTracking  `xml:"TrackingEvents>Tracking"`
+	MediaFiles     []VASTMediaFile `xml:"MediaFiles>MediaFile"`
+}
+
+// VASTTracking is a tracking beacon fired on a playback event
+type VASTTracking struct {
+	Event string `xml:"event,attr"`
+	URL   string `xml:",chardata"`
+}
+
+// VASTMediaFile is one encoding of a linear creative
+type VASTMediaFile struct {
+	Delivery string `xml:"delivery,attr"`
+	Type     string `xml:"type,attr"`
+	Width    int    `xml:"width,attr"`
+	Height   int    `xml:"height,attr"`
+	Bitrate  int    `xml:"bitrate,attr"`
+	URL      string `xml:",chardata"`
+}
+
+// ParseVAST parses a VAST document, trimming whitespace around URLs
+func ParseVAST(data []byte) (*VAST, error) {
+	var vast VAST
+	if err := xml.Unmarshal(data, &vast); err != nil {
+		return nil, fmt.Errorf("invalid VAST response: %w", err)
+	}
+
+	for i := range vast.Ads {
+		ad := &vast.Ads[i]
+		if ad.InLine != nil {
+			trimStrings(ad.InLine.Impressions)
+			trimCreatives(ad.InLine.Creatives)
+		}
+		if ad.Wrapper != nil {
+			ad.Wrapper.VASTAdTagURI = strings.TrimSpace(ad.Wrapper.VASTAdTagURI)
+			trimStrings(ad.Wrapper.Impressions)
+			trimCreatives(ad.Wrapper.Creatives)
+		}
+	}
+
+	return &vast, nil
+}
+
+// ParseVASTDuration parses a VAST HH:MM:SS(.mmm) duration into seconds
+func ParseVASTDuration(value string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid VAST duration: %q", value)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid VAST duration: %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid VAST duration: %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid VAST duration: %q", value)
+	}
+
+	return float64(hours*3600+minutes*60) + seconds, nil
+}
+
+func trimStrings(values []string) {
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+}
+
+func trimCreatives(creatives []VASTCreative) {
+	for i := range creatives {
+		linear := creatives[i].Linear
+		if linear == nil {
+			continue
+		}
+		for j := range linear.TrackingEvents {
+			linear.TrackingEvents[j].URL = strings.TrimSpace(linear.TrackingEvents[j].URL)
+		}
+		for j := range linear.MediaFiles {
+			linear.MediaFiles[j].URL = strings.TrimSpace(linear.MediaFiles[j].URL)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WebVTTCue is a cue of a WebVTT file
+type WebVTTCue struct {
+	Start    float64 // in seconds
+	End      float64 // in seconds
+	Settings string  // cue settings following the timings, such as "line:0 align:start"
+	Text     string
+}
+
+// ParseWebVTT returns the cues of a WebVTT file; cue identifiers and NOTE, STYLE and
+// REGION blocks are dropped
+func ParseWebVTT(content string) []WebVTTCue {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	var cues []WebVTTCue
+	for _, block := range strings.Split(content, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[1] != "-->" {
+				break
+			}
+			start, err := ParseWebVTTTimestamp(fields[0])
+			if err != nil {
+				break
+			}
+			end, err := ParseWebVTTTimestamp(fields[2])
+			if err != nil {
+				break
+			}
+			cues = append(cues, WebVTTCue{
+				Start:    start,
+				End:      end,
+				Settings: strings.Join(fields[3:], " "),
+				Text:     strings.Join(lines[i+1:], "\n"),
+			})
+			break
+		}
+	}
+	return cues
+}
+
+// ParseWebVTTTimestamp parses a WebVTT cue timestamp (hh:mm:ss.ttt or mm:ss.ttt) into seconds
+func ParseWebVTTTimestamp(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid WebVTT timestamp %q", value)
+	}
+
+	seconds := 0.0
+	for _, part := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WebVTT timestamp %q", value)
+		}
+		seconds = seconds*60 + float64(n)
+	}
+	last, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid WebVTT timestamp %q", value)
+	}
+	return seconds*60 + last, nil
+}
+
+// WebVTTTimestamp formats a time in seconds as a WebVTT cue timestamp (hh:mm:ss.ttt)
+func WebVTTTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWebVTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []WebVTTCue
+	}{
+		{
+			name:    "empty",
+			content: "WEBVTT\n",
+			want:    nil,
+		},
+		{
+			name:    "hours, minutes and seconds",
+			content: "WEBVTT\n\n01:02:03.500 --> 01:02:05.000\nHello\n",
+			want:    []WebVTTCue{{Start: 3723.5, End: 3725, Text: "Hello"}},
+		},
+		{
+			name:    "minutes and seconds",
+			content: "WEBVTT\n\n00:01.000 --> 00:04.250\nHello\n\n01:00.000 --> 01:02.000\nWorld\n",
+			want: []WebVTTCue{
+				{Start: 1, End: 4.25, Text: "Hello"},
+				{Start: 60, End: 62, Text: "World"},
+			},
+		},
+		{
+			name:    "CRLF line endings",
+			content: "WEBVTT\r\n\r\n00:00:01.000 --> 00:00:02.000\r\nFirst line\r\nSecond line\r\n\r\n00:00:03.000 --> 00:00:04.000\r\nNext\r\n",
+			want: []WebVTTCue{
+				{Start: 1, End: 2, Text: "First line\nSecond line"},
+				{Start: 3, End: 4, Text: "Next"},
+			},
+		},
+		{
+			name:    "cue settings",
+			content: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000 line:0 align:start\n<i>Top</i>\n",
+			want:    []WebVTTCue{{Start: 1, End: 2, Settings: "line:0 align:start", Text: "<i>Top</i>"}},
+		},
+		{
+			name:    "identifiers and NOTE, STYLE and REGION blocks are dropped",
+			content: "WEBVTT\n\nNOTE a comment\n\nSTYLE\n::cue { color: yellow }\n\nREGION\nid:top\n\ncue-1\n00:00:01.000 --> 00:00:02.000\nHello\n",
+			want:    []WebVTTCue{{Start: 1, End: 2, Text: "Hello"}},
+		},
+		{
+			name:    "invalid timings are skipped",
+			content: "WEBVTT\n\n00:00:xx.000 --> 00:00:02.000\nBad start\n\n00:00:01.000 -> 00:00:02.000\nBad arrow\n\n00:00:03.000 --> 00:00:04.000\nGood\n",
+			want:    []WebVTTCue{{Start: 3, End: 4, Text: "Good"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseWebVTT(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWebVTT() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWebVTTTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "00:00:00.000", want: 0},
+		{value: "00:00:01.500", want: 1.5},
+		{value: "01:02:03.456", want: 3723.456},
+		{value: "100:00:00.000", want: 360000},
+		{value: "02:03.250", want: 123.25},
+		{value: "00:59.999", want: 59.999},
+		{value: "3.000", wantErr: true},
+		{value: "00:00:00:01.000", wantErr: true},
+		{value: "aa:00.000", wantErr: true},
+		{value: "00:00:bb", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseWebVTTTimestamp(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWebVTTTimestamp(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseWebVTTTimestamp(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebVTTTimestamp(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{seconds: 0, want: "00:00:00.000"},
+		{seconds: 1.5, want: "00:00:01.500"},
+		{seconds: 3723.456, want: "01:02:03.456"},
+		{seconds: 59.9996, want: "00:01:00.000"},
+		{seconds: 360000, want: "100:00:00.000"},
+		{seconds: -2, want: "00:00:00.000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := WebVTTTimestamp(tt.seconds)
+			if got != tt.want {
+				t.Fatalf("WebVTTTimestamp(%v) = %q, want %q", tt.seconds, got, tt.want)
+			}
+
+			// Formatted timestamps parse back to the millisecond
+			parsed, err := ParseWebVTTTimestamp(got)
+			if err != nil {
+				t.Fatalf("ParseWebVTTTimestamp(%q) error = %v", got, err)
+			}
+			if again := WebVTTTimestamp(parsed); again != got {
+				t.Errorf("round trip of %q = %q", got, again)
+			}
+		})
+	}
+}
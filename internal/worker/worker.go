@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"linier-channel/internal/config"
 	"linier-channel/internal/services"
@@ -40,6 +41,12 @@ func NewWorkerManager(transcodeService *services.TranscodeService, redis *redis.
 func (wm *WorkerManager) Start() {
 	logrus.Info("Starting transcode workers...")
 
+	// Kill the encodes of cancelled jobs
+	go wm.transcodeService.WatchCancellations(wm.stopChan)
+
+	// Queue failed jobs again once their backoff has passed
+	go wm.transcodeService.ScheduleRetries(wm.stopChan)
+
 	// Create workers
 	for i := 0; i < wm.config.Transcode.Workers; i++ {
 		worker := &Worker{
@@ -146,8 +153,13 @@ func (w *Worker) processJob(job *JobData) {
 
 	// Process the transcoding job
 	err := w.transcodeService.ProcessTranscodeJob(job.VideoID, job.ProfileID)
+	if errors.Is(err, services.ErrCancelled) {
+		logrus.Infof("Worker %d cancelled job: video_id=%d, profile_id=%d", w.ID, job.VideoID, job.ProfileID)
+		return
+	}
 	if err != nil {
 		logrus.Errorf("Worker %d failed to process job: %v", w.ID, err)
+		w.transcodeService.RetryFailedJob(job.VideoID, job.ProfileID, err)
 		return
 	}
 